@@ -0,0 +1,276 @@
+package accesslog
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"hypervisor/internal/env"
+	"hypervisor/internal/paths"
+)
+
+// FileName is the active access log under paths.HypervisorLogsDir. Rotated files
+// are suffixed .1 (newest) to .N (oldest).
+const FileName = "access.log"
+
+// maxLineSize bounds a single log line read back by Search.
+const maxLineSize = 1 << 20
+
+// Entry is one proxied request, written as a single JSON line.
+type Entry struct {
+	Time         time.Time `json:"time"`
+	RequestID    string    `json:"requestId"`
+	Method       string    `json:"method"`
+	Host         string    `json:"host"`
+	Path         string    `json:"path"`
+	Status       int       `json:"status"`
+	LatencyMs    float64   `json:"latencyMs"`
+	BytesIn      int       `json:"bytesIn"`
+	BytesOut     int       `json:"bytesOut"`
+	ClientIP     string    `json:"clientIp,omitempty"`
+	Route        string    `json:"route"`
+	DeploymentID string    `json:"deploymentId,omitempty"`
+	StageID      string    `json:"stageId,omitempty"`
+	UpstreamPort int       `json:"upstreamPort,omitempty"`
+	Fault        string    `json:"fault,omitempty"` // ID of the fault rule applied to the request
+}
+
+// Query selects entries from the access log. Zero fields match everything.
+type Query struct {
+	DeploymentID string
+	StageID      string
+	RequestID    string
+	Path         string // substring of the request path
+	Status       int    // exact status, or 1-5 for a whole class (5 matches 5xx)
+	Since        time.Time
+	Limit        int // most recent matches returned; required
+}
+
+func (q Query) matches(entry Entry) bool {
+	switch {
+	case q.DeploymentID != "" && entry.DeploymentID != q.DeploymentID:
+		return false
+	case q.StageID != "" && entry.StageID != q.StageID:
+		return false
+	case q.RequestID != "" && entry.RequestID != q.RequestID:
+		return false
+	case q.Path != "" && !strings.Contains(entry.Path, q.Path):
+		return false
+	case !q.Since.IsZero() && entry.Time.Before(q.Since):
+		return false
+	case q.Status > 0 && q.Status < 10 && entry.Status/100 != q.Status:
+		return false
+	case q.Status >= 10 && entry.Status != q.Status:
+		return false
+	}
+	return true
+}
+
+// Logger appends entries to a size-rotated file.
+type Logger struct {
+	mu       sync.Mutex
+	path     string
+	maxSize  int64
+	maxFiles int
+	file     *os.File
+	size     int64
+}
+
+// Default is the access log of proxied traffic. It is nil when the log could not
+// be opened, in which case writes are dropped.
+var Default *Logger
+
+// Init opens the access log under paths.HypervisorLogsDir.
+func Init() error {
+	if err := os.MkdirAll(paths.HypervisorLogsDir, 0o755); err != nil {
+		return err
+	}
+
+	logger, err := Open(filepath.Join(paths.HypervisorLogsDir, FileName), int64(env.ACCESS_LOG_MAX_SIZE), env.ACCESS_LOG_MAX_FILES)
+	if err != nil {
+		return err
+	}
+	Default = logger
+	return nil
+}
+
+// Open appends to the log at path, rotating it once it grows past maxSize bytes and
+// keeping maxFiles rotated files.
+func Open(path string, maxSize int64, maxFiles int) (*Logger, error) {
+	l := &Logger{path: path, maxSize: maxSize, maxFiles: maxFiles}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *Logger) open() error {
+	file, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	l.file = file
+	l.size = info.Size()
+	return nil
+}
+
+// Write appends an entry to the log. Failures are logged and never reach the request.
+func (l *Logger) Write(entry Entry) {
+	if l == nil {
+		return
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		log.Printf("failed to encode access log entry: %v", err)
+		return
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file == nil {
+		return
+	}
+	if l.size > 0 && l.size+int64(len(line)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			log.Printf("failed to rotate access log: %v", err)
+			if l.file == nil {
+				return
+			}
+		}
+	}
+
+	n, err := l.file.Write(line)
+	l.size += int64(n)
+	if err != nil {
+		log.Printf("failed to write access log: %v", err)
+	}
+}
+
+// rotate shifts path.N-1 to path.N down to path to path.1 and starts a new file.
+// Callers must hold l.mu.
+func (l *Logger) rotate() error {
+	if err := l.file.Close(); err != nil {
+		log.Printf("failed to close access log: %v", err)
+	}
+	l.file = nil
+
+	for i := l.maxFiles - 1; i >= 1; i-- {
+		if err := os.Rename(l.rotatedPath(i), l.rotatedPath(i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := os.Rename(l.path, l.rotatedPath(1)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return l.open()
+}
+
+func (l *Logger) rotatedPath(n int) string {
+	return fmt.Sprintf("%s.%d", l.path, n)
+}
+
+// Close closes the active log file.
+func (l *Logger) Close() error {
+	if l == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+// Search returns the most recent entries matching the query, oldest first. Files
+// are read newest first and older files are only opened while more matches are needed.
+func (l *Logger) Search(q Query) ([]Entry, error) {
+	results := []Entry{}
+	if l == nil || q.Limit <= 0 {
+		return results, nil
+	}
+
+	files := []string{l.path}
+	for i := 1; i <= l.maxFiles; i++ {
+		files = append(files, l.rotatedPath(i))
+	}
+
+	for _, path := range files {
+		info, err := os.Stat(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		// Everything in this file and the older ones predates the window
+		if !q.Since.IsZero() && info.ModTime().Before(q.Since) {
+			break
+		}
+
+		matches, err := searchFile(path, q, q.Limit-len(results))
+		if err != nil {
+			return nil, err
+		}
+		results = append(matches, results...)
+		if len(results) >= q.Limit {
+			break
+		}
+	}
+
+	return results, nil
+}
+
+// searchFile returns the last limit matching entries in a file. Lines that are not
+// valid entries, such as one being written concurrently, are skipped.
+func searchFile(path string, q Query, limit int) ([]Entry, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var matches []Entry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineSize)
+	for scanner.Scan() {
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil || !q.matches(entry) {
+			continue
+		}
+
+		matches = append(matches, entry)
+		if len(matches) > limit {
+			matches = matches[1:]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return matches, nil
+}
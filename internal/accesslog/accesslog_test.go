@@ -0,0 +1,98 @@
+package accesslog
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestQueryMatches(t *testing.T) {
+	now := time.Now()
+	entry := Entry{Time: now, RequestID: "req-1", Path: "/api/users/42", Status: 503, DeploymentID: "stage-a-r1", StageID: "stage-a"}
+
+	tests := []struct {
+		name  string
+		query Query
+		want  bool
+	}{
+		{"everything", Query{}, true},
+		{"deployment", Query{DeploymentID: "stage-a-r1"}, true},
+		{"other deployment", Query{DeploymentID: "stage-b-r1"}, false},
+		{"stage", Query{StageID: "stage-a"}, true},
+		{"request ID", Query{RequestID: "req-1"}, true},
+		{"other request ID", Query{RequestID: "req-2"}, false},
+		{"path substring", Query{Path: "/users/"}, true},
+		{"other path", Query{Path: "/teams"}, false},
+		{"status class", Query{Status: 5}, true},
+		{"other status class", Query{Status: 4}, false},
+		{"exact status", Query{Status: 503}, true},
+		{"other exact status", Query{Status: 502}, false},
+		{"since before", Query{Since: now.Add(-time.Minute)}, true},
+		{"since after", Query{Since: now.Add(time.Minute)}, false},
+	}
+
+	for _, tt := range tests {
+		if got := tt.query.matches(entry); got != tt.want {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestRotateAndSearch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), FileName)
+
+	// Every entry is well over 100 bytes, so each write after the first rotates
+	logger, err := Open(path, 100, 2)
+	if err != nil {
+		t.Fatalf("Failed to open access log: %v", err)
+	}
+	defer logger.Close()
+
+	for _, id := range []string{"req-1", "req-2", "req-3", "req-4"} {
+		logger.Write(Entry{Time: time.Now(), RequestID: id, Path: "/api", Status: 200, DeploymentID: "dep"})
+	}
+
+	for _, name := range []string{FileName, FileName + ".1", FileName + ".2"} {
+		if _, err := os.Stat(filepath.Join(filepath.Dir(path), name)); err != nil {
+			t.Errorf("Expected %s to exist: %v", name, err)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("Expected only 2 rotated files to be kept")
+	}
+
+	tests := []struct {
+		name  string
+		query Query
+		want  []string
+	}{
+		{"across rotated files", Query{Limit: 10}, []string{"req-2", "req-3", "req-4"}},
+		{"limit", Query{Limit: 2}, []string{"req-3", "req-4"}},
+		{"filtered", Query{RequestID: "req-2", Limit: 10}, []string{"req-2"}},
+		{"rotated away", Query{RequestID: "req-1", Limit: 10}, nil},
+		{"no limit", Query{}, nil},
+	}
+
+	for _, tt := range tests {
+		entries, err := logger.Search(tt.query)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.name, err)
+		}
+
+		var ids []string
+		for _, entry := range entries {
+			ids = append(ids, entry.RequestID)
+		}
+		if len(ids) != len(tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, ids)
+			continue
+		}
+		for i := range ids {
+			if ids[i] != tt.want[i] {
+				t.Errorf("%s: expected %v, got %v", tt.name, tt.want, ids)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package api
+
+import (
+	"strconv"
+	"time"
+
+	"hypervisor/internal/accesslog"
+	"hypervisor/internal/errmsg"
+	"hypervisor/internal/utils"
+
+	"github.com/gofiber/fiber/v3"
+)
+
+const (
+	defaultAccessLogLimit = 100
+	maxAccessLogLimit     = 1000
+)
+
+// SearchAccessLogHandler returns the most recent proxied requests from this instance's access log.
+// @Summary Tail and search the access log
+// @Description Returns the most recent access log entries, oldest first, optionally filtered. `status` is either an exact code or a class digit (`5` for 5xx). `since` is an RFC 3339 time or a duration back from now (e.g. `15m`). Blue and green each keep their own log.
+// @Tags Hypervisor Logs
+// @Security HyperUserAuth
+// @Produce json
+// @Param limit query int false "Number of entries (default 100, max 1000)"
+// @Param deployment query string false "Deployment ID"
+// @Param stage query string false "Stage ID"
+// @Param requestId query string false "X-Request-ID"
+// @Param path query string false "Substring of the request path"
+// @Param status query int false "Status code or class"
+// @Param since query string false "RFC 3339 time or duration"
+// @Success 200 {array} accesslog.Entry
+// @Failure 400 {object} errmsg._AccessLogInvalidQuery
+// @Failure 503 {object} errmsg._AccessLogUnavailable
+// @Failure 500 {object} errmsg._InternalServerError
+// @Router /hypervisor/logs/access [get]
+func SearchAccessLogHandler(c fiber.Ctx) error {
+	if accesslog.Default == nil {
+		return utils.StatusError(c, errmsg.AccessLogUnavailable)
+	}
+
+	query := accesslog.Query{
+		DeploymentID: c.Query("deployment"),
+		StageID:      c.Query("stage"),
+		RequestID:    c.Query("requestId"),
+		Path:         c.Query("path"),
+		Limit:        defaultAccessLogLimit,
+	}
+
+	if raw := c.Query("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit <= 0 {
+			return utils.StatusError(c, errmsg.AccessLogInvalidQuery)
+		}
+		query.Limit = min(limit, maxAccessLogLimit)
+	}
+
+	if raw := c.Query("status"); raw != "" {
+		status, err := strconv.Atoi(raw)
+		if err != nil || status <= 0 || (status > 5 && status < 100) || status > 599 {
+			return utils.StatusError(c, errmsg.AccessLogInvalidQuery)
+		}
+		query.Status = status
+	}
+
+	if raw := c.Query("since"); raw != "" {
+		if since, err := time.Parse(time.RFC3339, raw); err == nil {
+			query.Since = since
+		} else if window, err := time.ParseDuration(raw); err == nil && window > 0 {
+			query.Since = time.Now().Add(-window)
+		} else {
+			return utils.StatusError(c, errmsg.AccessLogInvalidQuery)
+		}
+	}
+
+	entries, err := accesslog.Default.Search(query)
+	if err != nil {
+		return utils.StatusError(c, errmsg.InternalServerError(err))
+	}
+
+	return c.JSON(entries)
+}
@@ -2,11 +2,15 @@ package api
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
+	"math"
 	"net/http"
 	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
@@ -14,13 +18,15 @@ import (
 	"go.mongodb.org/mongo-driver/mongo"
 
 	"hypervisor/internal/core"
+	"hypervisor/internal/env"
 	"hypervisor/internal/errmsg"
 	"hypervisor/internal/events"
 	"hypervisor/internal/fs"
+	"hypervisor/internal/journal"
 	"hypervisor/internal/models"
 	"hypervisor/internal/paths"
 	"hypervisor/internal/proxy"
-	"hypervisor/internal/systemd"
+	"hypervisor/internal/supervisor"
 	"hypervisor/internal/utils"
 	"hypervisor/internal/ws"
 
@@ -66,10 +72,13 @@ func GetDeploymentHandler(c fiber.Ctx) error {
 
 // GetRoutingMapHandler returns the current routing map.
 // @Summary Get routing map
+// @Description Returns the routing map as formatted text by default, or as JSON when requested with `Accept: application/json` or `?format=json`.
 // @Tags Hypervisor Deployments
 // @Security HyperUserAuth
 // @Produce plain
-// @Success 200 {string} string "Routing map as formatted text"
+// @Produce json
+// @Param format query string false "Response format (text or json)"
+// @Success 200 {object} proxy.RouteSnapshot
 // @Failure 500 {object} errmsg._InternalServerError
 // @Router /hypervisor/routing [get]
 func GetRoutingMapHandler(c fiber.Ctx) error {
@@ -77,53 +86,599 @@ func GetRoutingMapHandler(c fiber.Ctx) error {
 		return utils.StatusError(c, fmt.Errorf("routing map not initialized"))
 	}
 
+	if wantsJSON(c) {
+		return c.JSON(proxy.GlobalRouteMap.Snapshot())
+	}
+
 	routingMap := proxy.GlobalRouteMap.GetRoutingMap()
 	return c.SendString(routingMap)
 }
 
+// ResolveRouteHandler explains which deployment a request would be routed to.
+// @Summary Resolve route
+// @Description Applies the proxy's routing rules to the given host and path and reports the deployment that would serve the request and why. Root paths report both main and the canary with their traffic shares. The hyperuser override header is not considered.
+// @Tags Hypervisor Deployments
+// @Security HyperUserAuth
+// @Produce json
+// @Param path query string false "Request path (defaults to /)"
+// @Param host query string false "Host header (defaults to the host of this request)"
+// @Success 200 {object} proxy.Resolution
+// @Failure 500 {object} errmsg._InternalServerError
+// @Router /hypervisor/routing/resolve [get]
+func ResolveRouteHandler(c fiber.Ctx) error {
+	if proxy.GlobalRouteMap == nil {
+		return utils.StatusError(c, fmt.Errorf("routing map not initialized"))
+	}
+
+	path := strings.TrimSpace(c.Query("path"))
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+
+	host := strings.TrimSpace(c.Query("host"))
+	if host == "" {
+		host = c.Hostname()
+	}
+
+	return c.JSON(proxy.GlobalRouteMap.Resolve(host, path))
+}
+
+// wantsJSON reports whether the client asked for JSON via `?format=` or the Accept header.
+func wantsJSON(c fiber.Ctx) bool {
+	switch strings.ToLower(c.Query("format")) {
+	case "json":
+		return true
+	case "text":
+		return false
+	}
+	return c.Accepts(fiber.MIMETextPlain, fiber.MIMEApplicationJSON) == fiber.MIMEApplicationJSON
+}
+
+type promoteRequest struct {
+	AutoRollbackWindow *string `json:"autoRollbackWindow"` // Go duration; defaults to AUTO_ROLLBACK_WINDOW, "0s" disables
+}
+
 // PromoteDeploymentHandler promotes a deployment to main.
 // @Summary Promote deployment to main
+// @Description Makes the deployment main, demotes the previous main and records the change in the promotion history. Only a ready, healthy deployment that is its stage's current revision can be promoted. If the new main turns unhealthy within the auto-rollback window, main is rolled back to the previous deployment automatically.
 // @Tags Hypervisor Deployments
 // @Security HyperUserAuth
+// @Accept json
 // @Produce json
 // @Param deploymentId path string true "Deployment ID"
+// @Param payload body promoteRequest false "Auto-rollback window"
 // @Success 200 {object} models.Deployment
+// @Failure 400 {object} errmsg._DeploymentInvalidRequest
 // @Failure 404 {object} errmsg._DeploymentNotFound
+// @Failure 409 {object} errmsg._DeploymentNotReady
 // @Failure 500 {object} errmsg._InternalServerError
 // @Router /hypervisor/deployments/{deploymentId}/promote [post]
 func PromoteDeploymentHandler(c fiber.Ctx) error {
+	var req promoteRequest
+	if len(c.Body()) > 0 {
+		if err := json.Unmarshal(c.Body(), &req); err != nil {
+			return utils.StatusError(c, errmsg.DeploymentInvalidRequest)
+		}
+	}
+
+	window := env.AUTO_ROLLBACK_WINDOW
+	if req.AutoRollbackWindow != nil {
+		parsed, err := time.ParseDuration(*req.AutoRollbackWindow)
+		if err != nil || parsed < 0 {
+			return utils.StatusError(c, errmsg.DeploymentInvalidRequest)
+		}
+		window = parsed
+	}
+
+	deploymentID := c.Params("deploymentId")
+	dep, err := models.GetDeploymentByID(context.Background(), deploymentID)
+	if err != nil {
+		return utils.StatusError(c, errmsg.DeploymentNotFound)
+	}
+
+	if err := core.PromoteDeployment(context.Background(), dep, actorName(c), window); err != nil {
+		return utils.StatusError(c, err)
+	}
+
+	return c.JSON(dep)
+}
+
+// RollbackDeploymentHandler re-promotes the previous main deployment.
+// @Summary Roll back main
+// @Description Promotes the most recent earlier main deployment that is still ready and healthy, demoting the current main.
+// @Tags Hypervisor Deployments
+// @Security HyperUserAuth
+// @Produce json
+// @Success 200 {object} models.Deployment
+// @Failure 409 {object} errmsg._NoRollbackTarget
+// @Failure 500 {object} errmsg._InternalServerError
+// @Router /hypervisor/deployments/rollback [post]
+func RollbackDeploymentHandler(c fiber.Ctx) error {
+	dep, err := core.RollbackDeployment(context.Background(), actorName(c), models.PromotionReasonRollback)
+	if err != nil {
+		return utils.StatusError(c, err)
+	}
+
+	return c.JSON(dep)
+}
+
+// ListPromotionsHandler returns the history of main deployments.
+// @Summary List promotion history
+// @Tags Hypervisor Deployments
+// @Security HyperUserAuth
+// @Produce json
+// @Param limit query int false "Number of promotions (default 50)"
+// @Success 200 {array} models.Promotion
+// @Failure 400 {object} errmsg._DeploymentInvalidRequest
+// @Failure 500 {object} errmsg._InternalServerError
+// @Router /hypervisor/promotions [get]
+func ListPromotionsHandler(c fiber.Ctx) error {
+	limit := int64(50)
+	if raw := c.Query("limit"); raw != "" {
+		parsed, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || parsed <= 0 {
+			return utils.StatusError(c, errmsg.DeploymentInvalidRequest)
+		}
+		limit = parsed
+	}
+
+	promotions, err := models.GetPromotions(context.Background(), limit)
+	if err != nil {
+		return utils.StatusError(c, errmsg.InternalServerError(err))
+	}
+
+	return c.JSON(promotions)
+}
+
+type canaryRequest struct {
+	Weight *int `json:"weight"`
+}
+
+// CanaryDeploymentHandler sets the share of root traffic routed to a candidate deployment.
+// @Summary Set canary weight
+// @Description Routes the given percentage of root traffic to the deployment instead of main. A weight of 0 removes the canary. Only one canary exists at a time.
+// @Tags Hypervisor Deployments
+// @Security HyperUserAuth
+// @Accept json
+// @Produce json
+// @Param deploymentId path string true "Deployment ID"
+// @Param payload body canaryRequest true "Canary weight (0-100)"
+// @Success 200 {object} models.Deployment
+// @Failure 400 {object} errmsg._DeploymentInvalidCanaryWeight
+// @Failure 404 {object} errmsg._DeploymentNotFound
+// @Failure 409 {object} errmsg._DeploymentNotReady
+// @Failure 409 {object} errmsg._CannotCanaryMainDeployment
+// @Failure 500 {object} errmsg._InternalServerError
+// @Router /hypervisor/deployments/{deploymentId}/canary [post]
+func CanaryDeploymentHandler(c fiber.Ctx) error {
+	var req canaryRequest
+	if err := json.Unmarshal(c.Body(), &req); err != nil || req.Weight == nil {
+		return utils.StatusError(c, errmsg.DeploymentInvalidRequest)
+	}
+
+	if *req.Weight < 0 || *req.Weight > 100 {
+		return utils.StatusError(c, errmsg.DeploymentInvalidCanaryWeight)
+	}
+
 	deploymentID := c.Params("deploymentId")
 	dep, err := models.GetDeploymentByID(context.Background(), deploymentID)
 	if err != nil {
 		return utils.StatusError(c, errmsg.DeploymentNotFound)
 	}
 
-	// For now, just update PromotedAt
-	now := time.Now()
-	dep.PromotedAt = &now
+	if dep.PromotedAt != nil {
+		return utils.StatusError(c, errmsg.CannotCanaryMainDeployment)
+	}
+
+	if *req.Weight > 0 && dep.Status != models.DeploymentStatusReady {
+		return utils.StatusError(c, errmsg.DeploymentNotReady)
+	}
+
+	// Only one canary may share root traffic at a time
+	if *req.Weight > 0 {
+		if err := models.ClearCanaryWeights(context.Background(), dep.ID); err != nil {
+			return utils.StatusError(c, err)
+		}
+
+		if previous, exists := proxy.GlobalRouteMap.GetCanaryDeployment(); exists && previous.ID != dep.ID {
+			cleared := *previous
+			cleared.CanaryWeight = 0
+			cleared.Touch(actorName(c))
+			proxy.GlobalRouteMap.UpdateDeployment(&cleared)
+		}
+	}
+
+	dep.CanaryWeight = *req.Weight
+	dep.Touch(actorName(c))
 	if err := models.UpdateDeployment(context.Background(), *dep); err != nil {
 		return utils.StatusError(c, err)
 	}
 
-	// Update proxy with promoted deployment
+	// Update proxy with the new traffic split
 	proxy.GlobalRouteMap.UpdateDeployment(dep)
 
-	// Note: main deployment is tracked via PromotedAt in database, no cache needed
+	if events.Em != nil {
+		events.Em.DeploymentCanaryUpdated(*dep)
+	}
+
+	return c.JSON(dep)
+}
+
+type mirrorRequest struct {
+	Percent       *int     `json:"percent"`
+	IncludeUnsafe bool     `json:"includeUnsafe"`
+	Diff          bool     `json:"diff"`
+	IgnoreFields  []string `json:"ignoreFields"`
+}
+
+// MirrorDeploymentHandler replays a share of main's traffic to a candidate deployment.
+// @Summary Set shadow traffic mirror
+// @Description Asynchronously replays the given percentage of requests served by main to the deployment and discards its responses. Only GET, HEAD and OPTIONS requests are mirrored unless includeUnsafe is set. With diff set, the candidate's responses to mirrored GET requests are also compared with main's, ignoring the given JSON fields, and summarised by the diff report. A percent of 0 stops mirroring. Only one deployment receives mirrored traffic at a time, and enabling it resets the collected stats and diffs.
+// @Tags Hypervisor Deployments
+// @Security HyperUserAuth
+// @Accept json
+// @Produce json
+// @Param deploymentId path string true "Deployment ID"
+// @Param payload body mirrorRequest true "Mirror percentage (0-100)"
+// @Success 200 {object} models.Deployment
+// @Failure 400 {object} errmsg._DeploymentInvalidMirrorPercent
+// @Failure 404 {object} errmsg._DeploymentNotFound
+// @Failure 409 {object} errmsg._DeploymentNotReady
+// @Failure 409 {object} errmsg._CannotMirrorMainDeployment
+// @Failure 500 {object} errmsg._InternalServerError
+// @Router /hypervisor/deployments/{deploymentId}/mirror [post]
+func MirrorDeploymentHandler(c fiber.Ctx) error {
+	var req mirrorRequest
+	if err := json.Unmarshal(c.Body(), &req); err != nil || req.Percent == nil {
+		return utils.StatusError(c, errmsg.DeploymentInvalidRequest)
+	}
+
+	if *req.Percent < 0 || *req.Percent > 100 {
+		return utils.StatusError(c, errmsg.DeploymentInvalidMirrorPercent)
+	}
+
+	deploymentID := c.Params("deploymentId")
+	dep, err := models.GetDeploymentByID(context.Background(), deploymentID)
+	if err != nil {
+		return utils.StatusError(c, errmsg.DeploymentNotFound)
+	}
+
+	if dep.PromotedAt != nil {
+		return utils.StatusError(c, errmsg.CannotMirrorMainDeployment)
+	}
+
+	if *req.Percent > 0 && dep.Status != models.DeploymentStatusReady {
+		return utils.StatusError(c, errmsg.DeploymentNotReady)
+	}
+
+	if *req.Percent > 0 {
+		// Only one deployment receives shadow traffic at a time
+		if err := models.ClearMirrors(context.Background(), dep.ID); err != nil {
+			return utils.StatusError(c, err)
+		}
+
+		if previous, exists := proxy.GlobalRouteMap.GetMirrorDeployment(); exists && previous.ID != dep.ID {
+			cleared := *previous
+			cleared.Mirror = nil
+			cleared.Touch(actorName(c))
+			proxy.GlobalRouteMap.UpdateDeployment(&cleared)
+		}
+
+		dep.Mirror = &models.MirrorConfig{
+			Percent:       *req.Percent,
+			IncludeUnsafe: req.IncludeUnsafe,
+			StartedAt:     time.Now(),
+			Diff:          req.Diff,
+			IgnoreFields:  req.IgnoreFields,
+		}
+		proxy.GlobalRouteMap.ResetMirrorReport(dep.ID)
+		if err := models.DeleteMirrorDiffs(context.Background(), dep.ID); err != nil {
+			return utils.StatusError(c, err)
+		}
+	} else {
+		dep.Mirror = nil
+	}
+
+	dep.Touch(actorName(c))
+	if err := models.UpdateDeployment(context.Background(), *dep); err != nil {
+		return utils.StatusError(c, err)
+	}
+
+	// Update proxy with the new mirror target
+	proxy.GlobalRouteMap.UpdateDeployment(dep)
 
 	if events.Em != nil {
-		events.Em.DeploymentPromoted(*dep)
+		events.Em.DeploymentMirrorUpdated(*dep)
+	}
+
+	return c.JSON(dep)
+}
+
+// GetMirrorReportHandler reports how a candidate handled the traffic mirrored to it.
+// @Summary Get shadow traffic report
+// @Description Compares status codes and latency between main and the candidate for every mirrored endpoint. Stats are kept in memory by the hypervisor instance serving traffic.
+// @Tags Hypervisor Deployments
+// @Security HyperUserAuth
+// @Produce json
+// @Param deploymentId path string true "Deployment ID"
+// @Success 200 {object} proxy.MirrorReport
+// @Failure 404 {object} errmsg._DeploymentNotFound
+// @Failure 500 {object} errmsg._InternalServerError
+// @Router /hypervisor/deployments/{deploymentId}/mirror [get]
+func GetMirrorReportHandler(c fiber.Ctx) error {
+	deploymentID := c.Params("deploymentId")
+	if _, err := models.GetDeploymentByID(context.Background(), deploymentID); err != nil {
+		return utils.StatusError(c, errmsg.DeploymentNotFound)
+	}
+
+	return c.JSON(proxy.GlobalRouteMap.GetMirrorReport(deploymentID))
+}
+
+type diffReport struct {
+	CandidateID     string              `json:"candidateId"`
+	MainID          string              `json:"mainId,omitempty"`
+	Compared        int64               `json:"compared"`
+	Matched         int64               `json:"matched"`
+	StatusDiffs     int64               `json:"statusDiffs"`
+	BodyDiffs       int64               `json:"bodyDiffs"`
+	CandidateErrors int64               `json:"candidateErrors"`
+	Clean           bool                `json:"clean"` // responses were compared, none differed unexpectedly and the candidate answered all
+	Endpoints       []models.MirrorDiff `json:"endpoints"`
+}
+
+// GetDiffReportHandler summarises response differences between main and a candidate.
+// @Summary Get response diff report
+// @Description Summarises, per endpoint, how the candidate's responses to mirrored GET requests differed from main's (status codes and JSON bodies, minus ignored fields) and how many it failed to answer. Defaults to the current main deployment.
+// @Tags Hypervisor Deployments
+// @Security HyperUserAuth
+// @Produce json
+// @Param deploymentId path string true "Candidate deployment ID"
+// @Param main query string false "Main deployment ID to compare against"
+// @Success 200 {object} diffReport
+// @Failure 404 {object} errmsg._DeploymentNotFound
+// @Failure 500 {object} errmsg._InternalServerError
+// @Router /hypervisor/deployments/{deploymentId}/diff-report [get]
+func GetDiffReportHandler(c fiber.Ctx) error {
+	deploymentID := c.Params("deploymentId")
+	if _, err := models.GetDeploymentByID(context.Background(), deploymentID); err != nil {
+		return utils.StatusError(c, errmsg.DeploymentNotFound)
+	}
+
+	mainID := strings.TrimSpace(c.Query("main"))
+	if mainID == "" {
+		if main, exists := proxy.GlobalRouteMap.GetMainDeployment(); exists {
+			mainID = main.ID
+		}
+	}
+
+	diffs, err := models.GetMirrorDiffs(context.Background(), mainID, deploymentID)
+	if err != nil {
+		return utils.StatusError(c, err)
+	}
+
+	report := diffReport{CandidateID: deploymentID, MainID: mainID, Endpoints: diffs}
+	for _, diff := range diffs {
+		report.Compared += diff.Compared
+		report.Matched += diff.Matched
+		report.StatusDiffs += diff.StatusDiffs
+		report.BodyDiffs += diff.BodyDiffs
+		report.CandidateErrors += diff.CandidateErrors
+	}
+	report.Clean = report.Compared > 0 && report.StatusDiffs == 0 && report.BodyDiffs == 0 && report.CandidateErrors == 0
+
+	return c.JSON(report)
+}
+
+type hostnamesRequest struct {
+	Hostnames []string `json:"hostnames"`
+}
+
+var hostnamePattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?(\.[a-z0-9]([a-z0-9-]*[a-z0-9])?)+$`)
+
+// UpdateDeploymentHostnamesHandler replaces the custom hostnames routed to a deployment.
+// @Summary Set deployment hostnames
+// @Description Replaces the list of custom Host header values routed to the deployment without a path prefix. An empty list removes them.
+// @Tags Hypervisor Deployments
+// @Security HyperUserAuth
+// @Accept json
+// @Produce json
+// @Param deploymentId path string true "Deployment ID"
+// @Param payload body hostnamesRequest true "Custom hostnames"
+// @Success 200 {object} models.Deployment
+// @Failure 400 {object} errmsg._DeploymentInvalidHostname
+// @Failure 404 {object} errmsg._DeploymentNotFound
+// @Failure 409 {object} errmsg._DeploymentHostnameTaken
+// @Failure 500 {object} errmsg._InternalServerError
+// @Router /hypervisor/deployments/{deploymentId}/hostnames [put]
+func UpdateDeploymentHostnamesHandler(c fiber.Ctx) error {
+	var req hostnamesRequest
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		return utils.StatusError(c, errmsg.DeploymentInvalidRequest)
+	}
+
+	deploymentID := c.Params("deploymentId")
+	dep, err := models.GetDeploymentByID(context.Background(), deploymentID)
+	if err != nil {
+		return utils.StatusError(c, errmsg.DeploymentNotFound)
+	}
+
+	baseDomain := strings.ToLower(strings.TrimSpace(env.PROXY_BASE_DOMAIN))
+	hostnames := make([]string, 0, len(req.Hostnames))
+	seen := make(map[string]bool)
+	for _, raw := range req.Hostnames {
+		hostname := strings.TrimSuffix(strings.ToLower(strings.TrimSpace(raw)), ".")
+		if !hostnamePattern.MatchString(hostname) || hostname == baseDomain {
+			return utils.StatusError(c, errmsg.DeploymentInvalidHostname)
+		}
+		if seen[hostname] {
+			continue
+		}
+		seen[hostname] = true
+
+		owner, err := models.FindDeploymentByHostname(context.Background(), hostname)
+		if err == nil && owner.ID != dep.ID {
+			return utils.StatusError(c, errmsg.DeploymentHostnameTaken)
+		} else if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+			return utils.StatusError(c, err)
+		}
+
+		hostnames = append(hostnames, hostname)
+	}
+
+	dep.Hostnames = hostnames
+	dep.Touch(actorName(c))
+	if err := models.UpdateDeployment(context.Background(), *dep); err != nil {
+		return utils.StatusError(c, err)
+	}
+
+	// Update proxy with the new host routes
+	proxy.GlobalRouteMap.UpdateDeployment(dep)
+
+	return c.JSON(dep)
+}
+
+// UpdateDeploymentPolicyHandler replaces the access policy enforced by the proxy for a deployment.
+// @Summary Set deployment access policy
+// @Description Replaces the per-client rate limit, CIDR allow/deny lists and hyperusers-only flag enforced by the proxy. Client IPs are taken from nginx's X-Forwarded-For. Requests carrying a hyperuser token in X-Hypervisor-Authorization bypass the policy. An empty body removes the policy.
+// @Tags Hypervisor Deployments
+// @Security HyperUserAuth
+// @Accept json
+// @Produce json
+// @Param deploymentId path string true "Deployment ID"
+// @Param payload body models.AccessPolicy true "Access policy"
+// @Success 200 {object} models.Deployment
+// @Failure 400 {object} errmsg._DeploymentInvalidPolicy
+// @Failure 404 {object} errmsg._DeploymentNotFound
+// @Failure 409 {object} errmsg._CannotRestrictMainDeployment
+// @Failure 500 {object} errmsg._InternalServerError
+// @Router /hypervisor/deployments/{deploymentId}/policy [put]
+func UpdateDeploymentPolicyHandler(c fiber.Ctx) error {
+	var policy models.AccessPolicy
+	if len(c.Body()) > 0 {
+		if err := json.Unmarshal(c.Body(), &policy); err != nil {
+			return utils.StatusError(c, errmsg.DeploymentInvalidRequest)
+		}
+	}
+
+	deploymentID := c.Params("deploymentId")
+	dep, err := models.GetDeploymentByID(context.Background(), deploymentID)
+	if err != nil {
+		return utils.StatusError(c, errmsg.DeploymentNotFound)
+	}
+
+	if policy.HyperusersOnly && dep.PromotedAt != nil {
+		return utils.StatusError(c, errmsg.CannotRestrictMainDeployment)
+	}
+
+	for _, list := range []*[]string{&policy.Allow, &policy.Deny} {
+		for i, cidr := range *list {
+			network, err := proxy.ParseCIDR(cidr)
+			if err != nil {
+				return utils.StatusError(c, errmsg.DeploymentInvalidPolicy)
+			}
+			(*list)[i] = network.String()
+		}
+	}
+
+	if limit := policy.RateLimit; limit != nil {
+		if limit.RequestsPerSecond <= 0 || limit.Burst < 0 {
+			return utils.StatusError(c, errmsg.DeploymentInvalidPolicy)
+		}
+		if limit.Burst == 0 {
+			limit.Burst = int(math.Ceil(limit.RequestsPerSecond))
+		}
+	}
+
+	dep.Policy = &policy
+	if policy.RateLimit == nil && len(policy.Allow) == 0 && len(policy.Deny) == 0 && !policy.HyperusersOnly {
+		dep.Policy = nil
+	}
+	dep.Touch(actorName(c))
+	if err := models.UpdateDeployment(context.Background(), *dep); err != nil {
+		return utils.StatusError(c, err)
+	}
+
+	// Update proxy with the new policy
+	proxy.GlobalRouteMap.UpdateDeployment(dep)
+
+	return c.JSON(dep)
+}
+
+// UpdateDeploymentProxySettingsHandler replaces the proxy settings used to reach a deployment's backend.
+// @Summary Set deployment proxy settings
+// @Description Overrides the connect and read timeouts, the largest request body forwarded (larger requests get a 413), how often a failed dial is retried and the keep-alive pool size. Omitted or zero fields use the PROXY_* defaults; an empty body removes the overrides. A backend that does not answer within the read timeout yields a 504.
+// @Tags Hypervisor Deployments
+// @Security HyperUserAuth
+// @Accept json
+// @Produce json
+// @Param deploymentId path string true "Deployment ID"
+// @Param payload body models.ProxySettings true "Proxy settings"
+// @Success 200 {object} models.Deployment
+// @Failure 400 {object} errmsg._DeploymentInvalidProxySettings
+// @Failure 404 {object} errmsg._DeploymentNotFound
+// @Failure 500 {object} errmsg._InternalServerError
+// @Router /hypervisor/deployments/{deploymentId}/proxy [put]
+func UpdateDeploymentProxySettingsHandler(c fiber.Ctx) error {
+	var settings models.ProxySettings
+	if len(c.Body()) > 0 {
+		if err := json.Unmarshal(c.Body(), &settings); err != nil {
+			return utils.StatusError(c, errmsg.DeploymentInvalidRequest)
+		}
+	}
+
+	if !validProxySettings(settings) {
+		return utils.StatusError(c, errmsg.DeploymentInvalidProxySettings)
+	}
+
+	deploymentID := c.Params("deploymentId")
+	dep, err := models.GetDeploymentByID(context.Background(), deploymentID)
+	if err != nil {
+		return utils.StatusError(c, errmsg.DeploymentNotFound)
+	}
+
+	dep.Proxy = &settings
+	if settings == (models.ProxySettings{}) {
+		dep.Proxy = nil
+	}
+	dep.Touch(actorName(c))
+	if err := models.UpdateDeployment(context.Background(), *dep); err != nil {
+		return utils.StatusError(c, err)
 	}
 
+	// Update proxy so the deployment's upstream client is rebuilt
+	proxy.GlobalRouteMap.UpdateDeployment(dep)
+
 	return c.JSON(dep)
 }
 
+// validProxySettings rejects negative values and anything past the hypervisor's own limits.
+func validProxySettings(settings models.ProxySettings) bool {
+	const maxTimeoutMs = int(time.Hour / time.Millisecond)
+
+	switch {
+	case settings.ConnectTimeoutMs < 0 || settings.ConnectTimeoutMs > maxTimeoutMs:
+		return false
+	case settings.ReadTimeoutMs < 0 || settings.ReadTimeoutMs > maxTimeoutMs:
+		return false
+	case settings.MaxBodyBytes < 0 || settings.MaxBodyBytes > env.PROXY_MAX_BODY_LIMIT:
+		return false
+	case settings.ConnectRetries < 0 || settings.ConnectRetries > 10:
+		return false
+	case settings.MaxConns < 0 || settings.MaxConns > 10000:
+		return false
+	}
+	return true
+}
+
 // ShutdownDeploymentHandler stops a deployment.
 // @Summary Shutdown deployment
 // @Tags Hypervisor Deployments
 // @Security HyperUserAuth
 // @Produce json
 // @Param deploymentId path string true "Deployment ID"
+// @Param timeout query string false "Maximum time to wait for in-flight requests, as a Go duration (e.g. 45s)"
 // @Success 200 {object} models.Deployment
+// @Failure 400 {object} errmsg._DeploymentInvalidRequest
 // @Failure 404 {object} errmsg._DeploymentNotFound
 // @Failure 500 {object} errmsg._InternalServerError
 // @Router /hypervisor/deployments/{deploymentId}/shutdown [post]
@@ -134,12 +689,37 @@ func ShutdownDeploymentHandler(c fiber.Ctx) error {
 		return utils.StatusError(c, errmsg.DeploymentNotFound)
 	}
 
-	if err := systemd.StopBackendService(deploymentID); err != nil {
+	timeout, err := drainTimeout(c)
+	if err != nil {
+		return utils.StatusError(c, err)
+	}
+
+	dep.Touch(actorName(c))
+
+	// Let in-flight requests finish before the unit is stopped
+	if dep.Status == models.DeploymentStatusReady {
+		if err := core.DrainDeployment(context.Background(), dep, timeout); err != nil {
+			return utils.StatusError(c, err)
+		}
+	}
+
+	if err := supervisor.Default.Stop(deploymentID); err != nil {
+		// The backend is still running, so it goes back to serving
+		if dep.Status == models.DeploymentStatusDraining {
+			core.UndoDrain(context.Background(), dep, models.DeploymentStatusReady)
+		}
 		return utils.StatusError(c, err)
 	}
 
+	if dep.PromotedAt != nil {
+		if err := models.ClosePromotion(context.Background(), dep.ID, actorName(c), time.Now()); err != nil {
+			log.Printf("failed to close promotion of deployment %s: %v", dep.ID, err)
+		}
+	}
+
 	dep.Status = models.DeploymentStatusStopped
 	dep.PromotedAt = nil // Clear promotion when shutting down
+	dep.Drift = nil
 	if err := models.UpdateDeployment(context.Background(), *dep); err != nil {
 		return utils.StatusError(c, err)
 	}
@@ -156,13 +736,16 @@ func ShutdownDeploymentHandler(c fiber.Ctx) error {
 
 // StartDeploymentHandler starts a stopped deployment.
 // @Summary Start deployment
+// @Description Starts the deployment's unit and waits for it to pass the readiness checks a new revision goes through before routing to it again. A deployment that fails them is stopped again and marked start_failed or unhealthy.
 // @Tags Hypervisor Deployments
 // @Security HyperUserAuth
 // @Produce json
 // @Param deploymentId path string true "Deployment ID"
 // @Success 200 {object} models.Deployment
 // @Failure 404 {object} errmsg._DeploymentNotFound
+// @Failure 409 {object} errmsg._DeploymentSuperseded
 // @Failure 500 {object} errmsg._InternalServerError
+// @Failure 502 {object} errmsg._DeploymentStartFailed
 // @Router /hypervisor/deployments/{deploymentId}/start [post]
 func StartDeploymentHandler(c fiber.Ctx) error {
 	deploymentID := c.Params("deploymentId")
@@ -171,22 +754,16 @@ func StartDeploymentHandler(c fiber.Ctx) error {
 		return utils.StatusError(c, errmsg.DeploymentNotFound)
 	}
 
-	if err := systemd.StartBackendService(deploymentID); err != nil {
-		return utils.StatusError(c, err)
+	// A superseded revision's unit is gone; deploy the stage again instead
+	if dep.Status == models.DeploymentStatusSuperseded {
+		return utils.StatusError(c, errmsg.DeploymentSuperseded)
 	}
 
-	dep.Status = models.DeploymentStatusReady
-	if err := models.UpdateDeployment(context.Background(), *dep); err != nil {
+	// Routed again only once the backend passes its readiness checks
+	if err := core.StartDeployment(context.Background(), dep, actorName(c)); err != nil {
 		return utils.StatusError(c, err)
 	}
 
-	// Update proxy with restarted deployment
-	proxy.GlobalRouteMap.UpdateDeployment(dep)
-
-	if events.Em != nil {
-		events.Em.DeploymentCreated(*dep)
-	}
-
 	return c.JSON(dep)
 }
 
@@ -197,7 +774,9 @@ func StartDeploymentHandler(c fiber.Ctx) error {
 // @Produce json
 // @Param deploymentId path string true "Deployment ID"
 // @Param force query bool false "Force stop before delete, or force delete main deployment"
+// @Param timeout query string false "Maximum time to wait for in-flight requests, as a Go duration (e.g. 45s)"
 // @Success 204
+// @Failure 400 {object} errmsg._DeploymentInvalidRequest
 // @Failure 404 {object} errmsg._DeploymentNotFound
 // @Failure 409 {object} errmsg._CannotDeleteMainDeployment
 // @Failure 500 {object} errmsg._InternalServerError
@@ -216,33 +795,52 @@ func DeleteDeploymentHandler(c fiber.Ctx) error {
 		return utils.StatusError(c, errmsg.CannotDeleteMainDeployment)
 	}
 
+	timeout, err := drainTimeout(c)
+	if err != nil {
+		return utils.StatusError(c, err)
+	}
+
 	if dep.Status == models.DeploymentStatusReady {
-		if err := systemd.StopBackendService(deploymentID); err != nil {
+		// Let in-flight requests finish before the unit is stopped
+		dep.Touch(actorName(c))
+		if err := core.DrainDeployment(context.Background(), dep, timeout); err != nil {
 			return utils.StatusError(c, err)
 		}
-	}
 
-	if err := systemd.DisableBackendService(deploymentID); err != nil {
-		return utils.StatusError(c, err)
+		if err := supervisor.Default.Stop(deploymentID); err != nil {
+			// The backend is still running, so it goes back to serving
+			core.UndoDrain(context.Background(), dep, models.DeploymentStatusReady)
+			return utils.StatusError(c, err)
+		}
 	}
 
-	if err := systemd.RemoveBackendServiceFile(deploymentID); err != nil {
-		return utils.StatusError(c, err)
+	// A superseded revision's unit was already removed when it was retired
+	if dep.Status != models.DeploymentStatusSuperseded {
+		if err := supervisor.Default.Remove(deploymentID); err != nil {
+			return utils.StatusError(c, err)
+		}
 	}
 
 	// Remove from proxy before deleting from database
-	proxy.GlobalRouteMap.RemoveDeployment(deploymentID)
+	proxy.GlobalRouteMap.RemoveDeployment(deploymentID, actorName(c))
 
 	if err := models.DeleteDeployment(context.Background(), deploymentID); err != nil {
 		return utils.StatusError(c, err)
 	}
 
-	// Delete the built binary
-	versionWithoutV := strings.TrimPrefix(dep.Version, "v")
-	binaryPath := filepath.Join(paths.OpenHackBuildsDir, versionWithoutV)
-	if err := fs.Remove(binaryPath); err != nil {
+	if err := models.DeleteMirrorDiffs(context.Background(), deploymentID); err != nil {
 		// Log error but don't fail the deletion
-		fmt.Printf("Warning: failed to remove binary %s: %v\n", binaryPath, err)
+		fmt.Printf("Warning: failed to remove mirror diffs for %s: %v\n", deploymentID, err)
+	}
+
+	// Delete the built binary once no other revision of the version uses it
+	if others, err := models.CountDeploymentsByVersion(context.Background(), dep.Version, deploymentID); err == nil && others == 0 {
+		versionWithoutV := strings.TrimPrefix(dep.Version, "v")
+		binaryPath := filepath.Join(paths.OpenHackBuildsDir, versionWithoutV)
+		if err := fs.Remove(binaryPath); err != nil {
+			// Log error but don't fail the deletion
+			fmt.Printf("Warning: failed to remove binary %s: %v\n", binaryPath, err)
+		}
 	}
 
 	// Reset stage status to ready for redeployment
@@ -297,15 +895,90 @@ func StreamDeploymentLogs(c fiber.Ctx) error {
 	})
 }
 
-// CreateDeploymentHandler creates a new deployment by promoting a stage.
+const (
+	defaultRuntimeLogLines = 100
+	maxRuntimeLogLines     = 5000
+)
+
+// StreamDeploymentRuntimeLogs upgrades the connection and streams what the deployment's
+// backend writes to the journal.
+// @Summary Stream deployment runtime logs
+// @Description Sends the last `lines` journal entries of the deployment's unit, then follows it. Every message is `{"type": "log", "time", "message", "priority", "pid"}` or a status message. `since` is an RFC 3339 time or a duration back from now (e.g. `15m`). `grep` is a regular expression matched against each message; `ignoreCase` and `invert` work like grep's -i and -v. All clients of a deployment share one journal reader.
+// @Tags Hypervisor Deployments
+// @Security HyperUserAuth
+// @Param deploymentId path string true "Deployment ID"
+// @Param lines query int false "Backlog entries sent before following (default 100, max 5000, 0 for none)"
+// @Param since query string false "RFC 3339 time or duration"
+// @Param grep query string false "Regular expression the message must match"
+// @Param ignoreCase query bool false "Match grep case-insensitively"
+// @Param invert query bool false "Keep the messages grep does not match"
+// @Failure 400 {object} errmsg._DeploymentInvalidLogQuery
+// @Failure 404 {object} errmsg._DeploymentNotFound
+// @Router /hypervisor/ws/deployments/{deploymentId}/runtime-logs [get]
+func StreamDeploymentRuntimeLogs(c fiber.Ctx) error {
+	deploymentID := c.Params("deploymentId")
+	if _, err := models.GetDeploymentByID(context.Background(), deploymentID); err != nil {
+		return utils.StatusError(c, errmsg.DeploymentNotFound)
+	}
+
+	query := journal.Query{Lines: defaultRuntimeLogLines}
+	if raw := c.Query("lines"); raw != "" {
+		lines, err := strconv.Atoi(raw)
+		if err != nil || lines < 0 {
+			return utils.StatusError(c, errmsg.DeploymentInvalidLogQuery)
+		}
+		query.Lines = min(lines, maxRuntimeLogLines)
+	}
+
+	if raw := c.Query("since"); raw != "" {
+		if since, err := time.Parse(time.RFC3339, raw); err == nil {
+			query.Since = since
+		} else if window, err := time.ParseDuration(raw); err == nil && window > 0 {
+			query.Since = time.Now().Add(-window)
+		} else {
+			return utils.StatusError(c, errmsg.DeploymentInvalidLogQuery)
+		}
+	}
+
+	var filter journal.Filter
+	if raw := c.Query("grep"); raw != "" {
+		if c.Query("ignoreCase") == "true" {
+			raw = "(?i)" + raw
+		}
+		pattern, err := regexp.Compile(raw)
+		if err != nil {
+			return utils.StatusError(c, errmsg.DeploymentInvalidLogQuery)
+		}
+		filter.Pattern = pattern
+		filter.Invert = c.Query("invert") == "true"
+	}
+
+	return ws.StreamWebSocket(c, func(ctx context.Context, writer *ws.WebsocketLogWriter) error {
+		return core.StreamDeploymentJournal(ctx, deploymentID, query, filter, writer)
+	})
+}
+
+type createDeploymentRequest struct {
+	// Unit overrides the env tag's default resource limits and sandboxing.
+	Unit *models.UnitSettings `json:"unit"`
+}
+
+// CreateDeploymentHandler creates a new deployment revision by promoting a stage.
+// A stage that is already deployed keeps serving from its current revision until
+// the new one is up and healthy; the previous revision is then retired and kept.
 // @Summary Create deployment by promoting a stage
+// @Description The body is optional. `unit` overrides, field by field, the resource limits and sandboxing the revision's systemd unit gets from its env tag's defaults (see `GET /hypervisor/units/defaults/{envTag}`).
 // @Tags Hypervisor Deployments
 // @Security HyperUserAuth
+// @Accept json
 // @Produce json
 // @Param stageId path string true "Stage ID to promote"
+// @Param payload body createDeploymentRequest false "Unit overrides"
 // @Success 201 {object} models.Deployment
 // @Failure 400 {object} errmsg._DeploymentInvalidRequest
+// @Failure 400 {object} errmsg._DeploymentInvalidUnitSettings
 // @Failure 404 {object} errmsg._StageNotFound
+// @Failure 409 {object} errmsg._DeploymentRevisionInProgress
 // @Failure 500 {object} errmsg._InternalServerError
 // @Router /hypervisor/deployments/{stageId} [post]
 func CreateDeploymentHandler(c fiber.Ctx) error {
@@ -314,70 +987,78 @@ func CreateDeploymentHandler(c fiber.Ctx) error {
 		return utils.StatusError(c, errmsg.DeploymentInvalidRequest)
 	}
 
-	// Check if deployment already exists for this stage
-	if existing, err := models.GetDeploymentByID(context.Background(), stageID); err == nil {
-		// Deployment exists. Always perform full redeploy: stop and remove existing service, then create new deployment
-		if existing.Status == models.DeploymentStatusReady {
-			if err := systemd.StopBackendService(existing.ID); err != nil {
-				return utils.StatusError(c, err)
-			}
-		}
-
-		if err := systemd.DisableBackendService(existing.ID); err != nil {
-			return utils.StatusError(c, err)
-		}
-
-		if err := systemd.RemoveBackendServiceFile(existing.ID); err != nil {
-			return utils.StatusError(c, err)
-		}
-
-		// Remove from proxy before deleting from database
-		proxy.GlobalRouteMap.RemoveDeployment(existing.ID)
-
-		if err := models.DeleteDeployment(context.Background(), existing.ID); err != nil {
-			return utils.StatusError(c, err)
+	var req createDeploymentRequest
+	if len(c.Body()) > 0 {
+		if err := json.Unmarshal(c.Body(), &req); err != nil {
+			return utils.StatusError(c, errmsg.DeploymentInvalidRequest)
 		}
+	}
 
-		// Delete the built binary (best-effort)
-		versionWithoutV := strings.TrimPrefix(existing.Version, "v")
-		binaryPath := filepath.Join(paths.OpenHackBuildsDir, versionWithoutV)
-		if err := fs.Remove(binaryPath); err != nil {
-			// Log warning but continue
-			fmt.Printf("Warning: failed to remove binary %s: %v\n", binaryPath, err)
+	var unit models.UnitSettings
+	if req.Unit != nil {
+		if !validUnitSettings(*req.Unit) {
+			return utils.StatusError(c, errmsg.DeploymentInvalidUnitSettings)
 		}
+		unit = *req.Unit
+	}
 
-		// Reset stage status to ready for redeployment
-		stage, err := models.GetStageByID(context.Background(), existing.StageID)
-		if err == nil {
-			stage.Status = models.StageStatusReady
-			stage.UpdatedAt = time.Now()
-			models.UpdateStage(context.Background(), *stage)
-		}
+	deployment, err := core.PromoteStage(context.Background(), stageID, unit)
+	if err != nil {
+		return utils.StatusError(c, err)
+	}
 
-		if events.Em != nil {
-			events.Em.DeploymentDeleted(*existing)
-		}
+	return c.Status(http.StatusCreated).JSON(bson.M{
+		"deployment": deployment,
+		"stageID":    stageID,
+	})
+}
 
-	} else if err != mongo.ErrNoDocuments {
-		// Some other error
-		return utils.StatusError(c, err)
+// ListStageDeploymentsHandler lists every deployment revision of a stage, newest first.
+// @Summary List stage deployment revisions
+// @Tags Hypervisor Stages
+// @Security HyperUserAuth
+// @Produce json
+// @Param stageId path string true "Stage ID"
+// @Success 200 {array} models.Deployment
+// @Failure 404 {object} errmsg._StageNotFound
+// @Failure 500 {object} errmsg._InternalServerError
+// @Router /hypervisor/stages/{stageId}/deployments [get]
+func ListStageDeploymentsHandler(c fiber.Ctx) error {
+	stageID := c.Params("stageId")
+	if _, err := models.GetStageByID(context.Background(), stageID); err != nil {
+		return utils.StatusError(c, errmsg.StageNotFound)
 	}
 
-	// No existing deployment, create new one
-	deployment, err := core.PromoteStage(context.Background(), stageID)
+	deployments, err := models.GetStageDeployments(context.Background(), stageID)
 	if err != nil {
 		return utils.StatusError(c, err)
 	}
 
-	// Update proxy with new deployment
-	proxy.GlobalRouteMap.UpdateDeployment(deployment)
+	return c.JSON(deployments)
+}
 
-	if events.Em != nil {
-		events.Em.DeploymentCreated(*deployment)
+// drainTimeout returns the drain deadline from the `timeout` query parameter,
+// falling back to the configured DEPLOYMENT_DRAIN_TIMEOUT.
+func drainTimeout(c fiber.Ctx) (time.Duration, error) {
+	raw := strings.TrimSpace(c.Query("timeout"))
+	if raw == "" {
+		return env.DEPLOYMENT_DRAIN_TIMEOUT, nil
 	}
 
-	return c.Status(http.StatusCreated).JSON(bson.M{
-		"deployment": deployment,
-		"stageID":    stageID,
-	})
+	timeout, err := time.ParseDuration(raw)
+	if err != nil || timeout < 0 {
+		return 0, errmsg.DeploymentInvalidRequest
+	}
+	return timeout, nil
+}
+
+// actorName returns the username of the hyperuser making the request, or the system
+// actor when the request was not authenticated as a hyperuser.
+func actorName(c fiber.Ctx) string {
+	var user models.HyperUser
+	utils.GetLocals(c, "hyperuser", &user)
+	if user.Username == "" {
+		return events.ActorSystem
+	}
+	return user.Username
 }
@@ -0,0 +1,198 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"hypervisor/internal/errmsg"
+	"hypervisor/internal/events"
+	"hypervisor/internal/models"
+	"hypervisor/internal/proxy"
+	"hypervisor/internal/utils"
+
+	"github.com/gofiber/fiber/v3"
+)
+
+const (
+	// maxFaultLifetime keeps a forgotten fault rule from outliving a test session.
+	maxFaultLifetime = 24 * time.Hour
+	maxFaultDelayMs  = 60 * 1000
+	maxFaultRules    = 20
+)
+
+type createFaultRequest struct {
+	PathPrefix    string   `json:"pathPrefix"`
+	Methods       []string `json:"methods"`
+	Percent       int      `json:"percent"`
+	DelayMs       int      `json:"delayMs"`
+	DelayJitterMs int      `json:"delayJitterMs"`
+	Status        int      `json:"status"` // 200 to 599
+	Drop          bool     `json:"drop"`
+	ExpiresIn     string   `json:"expiresIn"` // Go duration, at most 24h
+}
+
+// AddDeploymentFaultHandler adds a fault rule to a deployment's traffic.
+// @Summary Inject a fault
+// @Description Delays, fails (with `status`) or drops (`drop`) `percent` of the requests to the deployment whose upstream path starts with `pathPrefix` and whose method is in `methods`. Every rule needs `expiresIn` (at most 24h) and stops applying once it expires. Only the first matching rule is considered per request. Faulted responses carry X-Hypervisor-Fault with the rule ID.
+// @Tags Hypervisor Deployments
+// @Security HyperUserAuth
+// @Accept json
+// @Produce json
+// @Param deploymentId path string true "Deployment ID"
+// @Param payload body createFaultRequest true "Fault rule"
+// @Success 200 {object} models.Deployment
+// @Failure 400 {object} errmsg._DeploymentInvalidFault
+// @Failure 404 {object} errmsg._DeploymentNotFound
+// @Failure 500 {object} errmsg._InternalServerError
+// @Router /hypervisor/deployments/{deploymentId}/faults [post]
+func AddDeploymentFaultHandler(c fiber.Ctx) error {
+	var payload createFaultRequest
+	if err := json.Unmarshal(c.Body(), &payload); err != nil {
+		return utils.StatusError(c, errmsg.DeploymentInvalidRequest)
+	}
+
+	rule, ok := newFaultRule(payload, actorName(c))
+	if !ok {
+		return utils.StatusError(c, errmsg.DeploymentInvalidFault)
+	}
+
+	deploymentID := c.Params("deploymentId")
+	dep, err := models.GetDeploymentByID(context.Background(), deploymentID)
+	if err != nil {
+		return utils.StatusError(c, errmsg.DeploymentNotFound)
+	}
+
+	dep.Faults = append(dep.ActiveFaults(time.Now()), rule)
+	if len(dep.Faults) > maxFaultRules {
+		return utils.StatusError(c, errmsg.DeploymentInvalidFault)
+	}
+
+	return saveFaults(c, dep)
+}
+
+// ClearDeploymentFaultsHandler removes every fault rule from a deployment.
+// @Summary Clear faults
+// @Tags Hypervisor Deployments
+// @Security HyperUserAuth
+// @Produce json
+// @Param deploymentId path string true "Deployment ID"
+// @Success 200 {object} models.Deployment
+// @Failure 404 {object} errmsg._DeploymentNotFound
+// @Failure 500 {object} errmsg._InternalServerError
+// @Router /hypervisor/deployments/{deploymentId}/faults [delete]
+func ClearDeploymentFaultsHandler(c fiber.Ctx) error {
+	deploymentID := c.Params("deploymentId")
+	dep, err := models.GetDeploymentByID(context.Background(), deploymentID)
+	if err != nil {
+		return utils.StatusError(c, errmsg.DeploymentNotFound)
+	}
+
+	dep.Faults = nil
+	return saveFaults(c, dep)
+}
+
+// DeleteDeploymentFaultHandler removes one fault rule from a deployment.
+// @Summary Remove a fault
+// @Tags Hypervisor Deployments
+// @Security HyperUserAuth
+// @Produce json
+// @Param deploymentId path string true "Deployment ID"
+// @Param faultId path string true "Fault rule ID"
+// @Success 200 {object} models.Deployment
+// @Failure 404 {object} errmsg._DeploymentFaultNotFound
+// @Failure 500 {object} errmsg._InternalServerError
+// @Router /hypervisor/deployments/{deploymentId}/faults/{faultId} [delete]
+func DeleteDeploymentFaultHandler(c fiber.Ctx) error {
+	deploymentID := c.Params("deploymentId")
+	dep, err := models.GetDeploymentByID(context.Background(), deploymentID)
+	if err != nil {
+		return utils.StatusError(c, errmsg.DeploymentNotFound)
+	}
+
+	faultID := c.Params("faultId")
+	remaining := []models.FaultRule{}
+	found := false
+	for _, rule := range dep.ActiveFaults(time.Now()) {
+		if rule.ID == faultID {
+			found = true
+			continue
+		}
+		remaining = append(remaining, rule)
+	}
+	if !found {
+		return utils.StatusError(c, errmsg.DeploymentFaultNotFound)
+	}
+
+	dep.Faults = remaining
+	return saveFaults(c, dep)
+}
+
+// saveFaults stores a deployment's fault rules and pushes them to the proxy.
+func saveFaults(c fiber.Ctx, dep *models.Deployment) error {
+	if len(dep.Faults) == 0 {
+		dep.Faults = nil
+	}
+
+	actor := actorName(c)
+	dep.Touch(actor)
+	if err := models.UpdateDeployment(context.Background(), *dep); err != nil {
+		return utils.StatusError(c, err)
+	}
+
+	proxy.GlobalRouteMap.UpdateDeployment(dep)
+	if events.Em != nil {
+		events.Em.DeploymentFaultsUpdated(*dep, actor)
+	}
+
+	return c.JSON(dep)
+}
+
+// newFaultRule validates a requested fault rule. A rule must do something, sample
+// a real share of requests and expire within maxFaultLifetime.
+func newFaultRule(payload createFaultRequest, actor string) (models.FaultRule, bool) {
+	lifetime, err := time.ParseDuration(payload.ExpiresIn)
+	if err != nil || lifetime <= 0 || lifetime > maxFaultLifetime {
+		return models.FaultRule{}, false
+	}
+
+	switch {
+	case payload.Percent < 1 || payload.Percent > 100:
+		return models.FaultRule{}, false
+	case payload.DelayMs < 0 || payload.DelayJitterMs < 0 || payload.DelayMs+payload.DelayJitterMs > maxFaultDelayMs:
+		return models.FaultRule{}, false
+	case payload.Status != 0 && (payload.Status < 200 || payload.Status > 599):
+		return models.FaultRule{}, false
+	case payload.Status != 0 && payload.Drop:
+		return models.FaultRule{}, false
+	case payload.DelayMs+payload.DelayJitterMs == 0 && payload.Status == 0 && !payload.Drop:
+		return models.FaultRule{}, false
+	case payload.PathPrefix != "" && !strings.HasPrefix(payload.PathPrefix, "/"):
+		return models.FaultRule{}, false
+	}
+
+	methods := make([]string, 0, len(payload.Methods))
+	for _, method := range payload.Methods {
+		method = strings.ToUpper(strings.TrimSpace(method))
+		if method == "" {
+			return models.FaultRule{}, false
+		}
+		methods = append(methods, method)
+	}
+
+	now := time.Now()
+	return models.FaultRule{
+		ID:            models.NewFaultID(),
+		PathPrefix:    payload.PathPrefix,
+		Methods:       methods,
+		Percent:       payload.Percent,
+		DelayMs:       payload.DelayMs,
+		DelayJitterMs: payload.DelayJitterMs,
+		Status:        payload.Status,
+		Drop:          payload.Drop,
+		ExpiresAt:     now.Add(lifetime),
+		CreatedAt:     now,
+		CreatedBy:     actor,
+	}, true
+}
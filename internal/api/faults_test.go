@@ -0,0 +1,80 @@
+package api
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNewFaultRule(t *testing.T) {
+	payload := createFaultRequest{
+		PathPrefix: "/api/",
+		Methods:    []string{" post", "Get"},
+		Percent:    25,
+		Status:     503,
+		ExpiresIn:  "30m",
+	}
+
+	before := time.Now()
+	rule, ok := newFaultRule(payload, "alice")
+	if !ok {
+		t.Fatalf("Expected the rule to be valid")
+	}
+	if rule.ID == "" {
+		t.Errorf("Expected the rule to get an ID")
+	}
+	if !reflect.DeepEqual(rule.Methods, []string{"POST", "GET"}) {
+		t.Errorf("Expected normalized methods, got %v", rule.Methods)
+	}
+	if rule.Status != 503 || rule.Percent != 25 || rule.PathPrefix != "/api/" || rule.CreatedBy != "alice" {
+		t.Errorf("Expected the payload's values, got %+v", rule)
+	}
+	if rule.ExpiresAt.Before(before.Add(30*time.Minute)) || rule.ExpiresAt.After(time.Now().Add(30*time.Minute)) {
+		t.Errorf("Expected the rule to expire in 30m, got %v", rule.ExpiresAt)
+	}
+}
+
+func TestNewFaultRuleRejects(t *testing.T) {
+	valid := createFaultRequest{Percent: 50, DelayMs: 100, ExpiresIn: "1h"}
+	if _, ok := newFaultRule(valid, "alice"); !ok {
+		t.Fatalf("Expected the base payload to be valid")
+	}
+
+	tests := []struct {
+		name   string
+		change func(*createFaultRequest)
+	}{
+		{"no expiry", func(p *createFaultRequest) { p.ExpiresIn = "" }},
+		{"negative expiry", func(p *createFaultRequest) { p.ExpiresIn = "-1m" }},
+		{"expiry too long", func(p *createFaultRequest) { p.ExpiresIn = "25h" }},
+		{"zero percent", func(p *createFaultRequest) { p.Percent = 0 }},
+		{"over 100 percent", func(p *createFaultRequest) { p.Percent = 101 }},
+		{"negative delay", func(p *createFaultRequest) { p.DelayMs = -1 }},
+		{"negative jitter", func(p *createFaultRequest) { p.DelayJitterMs = -1 }},
+		{"delay too long", func(p *createFaultRequest) { p.DelayMs = maxFaultDelayMs; p.DelayJitterMs = 1 }},
+		{"informational status", func(p *createFaultRequest) { p.Status = 101 }},
+		{"status below range", func(p *createFaultRequest) { p.Status = 199 }},
+		{"status above range", func(p *createFaultRequest) { p.Status = 600 }},
+		{"status and drop", func(p *createFaultRequest) { p.Status = 500; p.Drop = true }},
+		{"no effect", func(p *createFaultRequest) { p.DelayMs = 0 }},
+		{"relative path", func(p *createFaultRequest) { p.PathPrefix = "api" }},
+		{"blank method", func(p *createFaultRequest) { p.Methods = []string{"GET", " "} }},
+	}
+
+	for _, tt := range tests {
+		payload := valid
+		tt.change(&payload)
+		if _, ok := newFaultRule(payload, "alice"); ok {
+			t.Errorf("%s: expected the rule to be rejected", tt.name)
+		}
+	}
+}
+
+func TestNewFaultRuleStatusBounds(t *testing.T) {
+	for _, status := range []int{200, 429, 599} {
+		payload := createFaultRequest{Percent: 10, Status: status, ExpiresIn: "1h"}
+		if _, ok := newFaultRule(payload, "alice"); !ok {
+			t.Errorf("Expected status %d to be accepted", status)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package api
+
+import (
+	"context"
+	"strconv"
+
+	"hypervisor/internal/errmsg"
+	"hypervisor/internal/models"
+	"hypervisor/internal/utils"
+
+	"github.com/gofiber/fiber/v3"
+)
+
+const (
+	defaultJobLimit = 100
+	maxJobLimit     = 1000
+)
+
+// ListJobsHandler lists background jobs, such as provisioning deployments and running tests.
+// @Summary List jobs
+// @Description Returns jobs newest first. A running job is leased by the instance running it; one whose lease expired is resumed by any instance.
+// @Tags Hypervisor Jobs
+// @Security HyperUserAuth
+// @Produce json
+// @Param kind query string false "provision_deployment or run_test"
+// @Param state query string false "queued, running, succeeded or failed"
+// @Param limit query int false "Number of jobs (default 100, max 1000)"
+// @Success 200 {array} models.Job
+// @Failure 400 {object} errmsg._JobInvalidQuery
+// @Failure 500 {object} errmsg._InternalServerError
+// @Router /hypervisor/jobs [get]
+func ListJobsHandler(c fiber.Ctx) error {
+	query := models.JobQuery{
+		Kind:  models.JobKind(c.Query("kind")),
+		State: models.JobState(c.Query("state")),
+		Limit: defaultJobLimit,
+	}
+
+	switch query.Kind {
+	case "", models.JobKindProvisionDeployment, models.JobKindRunTest:
+	default:
+		return utils.StatusError(c, errmsg.JobInvalidQuery)
+	}
+
+	switch query.State {
+	case "", models.JobStateQueued, models.JobStateRunning, models.JobStateSucceeded, models.JobStateFailed:
+	default:
+		return utils.StatusError(c, errmsg.JobInvalidQuery)
+	}
+
+	if raw := c.Query("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit <= 0 {
+			return utils.StatusError(c, errmsg.JobInvalidQuery)
+		}
+		query.Limit = int64(min(limit, maxJobLimit))
+	}
+
+	jobs, err := models.ListJobs(context.Background(), query)
+	if err != nil {
+		return utils.StatusError(c, err)
+	}
+
+	return c.JSON(jobs)
+}
@@ -0,0 +1,98 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"hypervisor/internal/errmsg"
+	"hypervisor/internal/events"
+	"hypervisor/internal/models"
+	"hypervisor/internal/proxy"
+	"hypervisor/internal/utils"
+
+	"github.com/gofiber/fiber/v3"
+)
+
+// maxMaintenanceRetryAfter caps the Retry-After hint at one day.
+const maxMaintenanceRetryAfter = 24 * 60 * 60
+
+type updateMaintenanceRequest struct {
+	Enabled    *bool  `json:"enabled"`
+	Message    string `json:"message"`
+	HTML       string `json:"html"`
+	RetryAfter int    `json:"retryAfter"` // seconds; defaults to 300
+}
+
+// GetMaintenanceHandler returns the maintenance mode settings.
+// @Summary Get maintenance mode
+// @Tags Hypervisor Maintenance
+// @Security HyperUserAuth
+// @Produce json
+// @Success 200 {object} models.Maintenance
+// @Failure 500 {object} errmsg._InternalServerError
+// @Router /hypervisor/maintenance [get]
+func GetMaintenanceHandler(c fiber.Ctx) error {
+	maintenance, err := models.GetMaintenance(context.Background())
+	if err != nil {
+		return utils.StatusError(c, errmsg.InternalServerError(err))
+	}
+
+	return c.JSON(maintenance)
+}
+
+// UpdateMaintenanceHandler switches maintenance mode on or off.
+// @Summary Set maintenance mode
+// @Description While enabled, root traffic gets a 503 with Retry-After instead of reaching main: browsers get the custom HTML (or a default page showing the message) and other clients get JSON. Hyperusers sending X-Hypervisor-Authorization, stage prefixes and custom hostnames are unaffected. The setting is stored in MongoDB and shared by every hypervisor instance.
+// @Tags Hypervisor Maintenance
+// @Security HyperUserAuth
+// @Accept json
+// @Produce json
+// @Param payload body updateMaintenanceRequest true "Maintenance settings"
+// @Success 200 {object} models.Maintenance
+// @Failure 400 {object} errmsg._MaintenanceInvalidRequest
+// @Failure 500 {object} errmsg._InternalServerError
+// @Router /hypervisor/maintenance [put]
+func UpdateMaintenanceHandler(c fiber.Ctx) error {
+	var payload updateMaintenanceRequest
+	if err := json.Unmarshal(c.Body(), &payload); err != nil {
+		return utils.StatusError(c, errmsg.MaintenanceInvalidRequest)
+	}
+
+	if payload.Enabled == nil || payload.RetryAfter < 0 || payload.RetryAfter > maxMaintenanceRetryAfter {
+		return utils.StatusError(c, errmsg.MaintenanceInvalidRequest)
+	}
+
+	current, err := models.GetMaintenance(context.Background())
+	if err != nil {
+		return utils.StatusError(c, errmsg.InternalServerError(err))
+	}
+
+	now := time.Now()
+	maintenance := models.Maintenance{
+		Enabled:    *payload.Enabled,
+		Message:    payload.Message,
+		HTML:       payload.HTML,
+		RetryAfter: payload.RetryAfter,
+		UpdatedAt:  now,
+		UpdatedBy:  actorName(c),
+	}
+	if maintenance.Enabled {
+		// Keep the original start time when an active maintenance window is only reworded
+		maintenance.StartedAt = current.StartedAt
+		if !current.Enabled || maintenance.StartedAt == nil {
+			maintenance.StartedAt = &now
+		}
+	}
+
+	if err := models.SaveMaintenance(context.Background(), maintenance); err != nil {
+		return utils.StatusError(c, errmsg.InternalServerError(err))
+	}
+
+	proxy.GlobalRouteMap.SetMaintenance(maintenance)
+	if events.Em != nil {
+		events.Em.MaintenanceUpdated(maintenance)
+	}
+
+	return c.JSON(maintenance)
+}
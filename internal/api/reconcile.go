@@ -0,0 +1,52 @@
+package api
+
+import (
+	"context"
+
+	"hypervisor/internal/core"
+	"hypervisor/internal/errmsg"
+	"hypervisor/internal/models"
+	"hypervisor/internal/utils"
+
+	"github.com/gofiber/fiber/v3"
+)
+
+// GetReconcileReportHandler returns the findings of the last reconciliation pass.
+// @Summary Get reconciliation report
+// @Description Deployments are reconciled at boot and every RECONCILE_INTERVAL: each deployment's recorded status and port is compared with its systemd unit, its unit file, its binary and the route map. Findings are either fixed or flagged, in which case the deployment is marked drifted unless it still serves.
+// @Tags Hypervisor Reconcile
+// @Security HyperUserAuth
+// @Produce json
+// @Success 200 {object} models.ReconcileReport
+// @Failure 404 {object} errmsg._ReconcileReportNotFound
+// @Failure 500 {object} errmsg._InternalServerError
+// @Router /hypervisor/reconcile [get]
+func GetReconcileReportHandler(c fiber.Ctx) error {
+	report, err := models.GetReconcileReport(context.Background())
+	if err != nil {
+		return utils.StatusError(c, errmsg.InternalServerError(err))
+	}
+	if report == nil {
+		return utils.StatusError(c, errmsg.ReconcileReportNotFound)
+	}
+
+	return c.JSON(report)
+}
+
+// ReconcileHandler runs a reconciliation pass now and returns its report.
+// @Summary Run reconciliation
+// @Tags Hypervisor Reconcile
+// @Security HyperUserAuth
+// @Produce json
+// @Success 200 {object} models.ReconcileReport
+// @Failure 409 {object} errmsg._ReconcileInProgress
+// @Failure 500 {object} errmsg._InternalServerError
+// @Router /hypervisor/reconcile [post]
+func ReconcileHandler(c fiber.Ctx) error {
+	report, err := core.Reconcile(context.Background())
+	if err != nil {
+		return utils.StatusError(c, err)
+	}
+
+	return c.JSON(report)
+}
@@ -21,6 +21,8 @@ type createStageRequest struct {
 	ReleaseID string `json:"releaseId"`
 	EnvTag    string `json:"envTag"`
 	EnvText   string `json:"envText,omitempty"` // Optional - if provided, stage will be marked as ready
+	// Optional - path polled before deployments are marked ready, defaults to DEPLOYMENT_HEALTH_PATH
+	HealthPath string `json:"healthPath,omitempty"`
 }
 
 type StageResponse struct {
@@ -36,6 +38,18 @@ type UpdateStageEnvRequest struct {
 	EnvText *string `json:"envText"`
 }
 
+type UpdateStageHealthPathRequest struct {
+	HealthPath string `json:"healthPath"` // empty restores DEPLOYMENT_HEALTH_PATH
+}
+
+// maxHealthPathLength bounds a stage's health path.
+const maxHealthPathLength = 256
+
+// validHealthPath reports whether path can be requested on a backend as is.
+func validHealthPath(path string) bool {
+	return path == "" || (strings.HasPrefix(path, "/") && len(path) <= maxHealthPathLength && !strings.ContainsAny(path, " \t\r\n#"))
+}
+
 // CreateStageHandler prepares a new stage and returns the seeded template.
 // @Summary Prepare stage
 // @Description Bootstraps a stage by cloning the release repo and seeding the template environment.
@@ -58,7 +72,8 @@ func CreateStageHandler(c fiber.Ctx) error {
 
 	req.ReleaseID = strings.TrimSpace(req.ReleaseID)
 	req.EnvTag = strings.TrimSpace(req.EnvTag)
-	if req.ReleaseID == "" || req.EnvTag == "" {
+	req.HealthPath = strings.TrimSpace(req.HealthPath)
+	if req.ReleaseID == "" || req.EnvTag == "" || !validHealthPath(req.HealthPath) {
 		return utils.StatusError(c, errmsg.StageInvalidRequest)
 	}
 
@@ -67,6 +82,12 @@ func CreateStageHandler(c fiber.Ctx) error {
 		return utils.StatusError(c, err)
 	}
 
+	if req.HealthPath != "" {
+		if stage, err = core.UpdateStageHealthPath(context.Background(), stage.ID, req.HealthPath); err != nil {
+			return utils.StatusError(c, err)
+		}
+	}
+
 	envText := req.EnvText
 	if envText == "" {
 		// If no envText provided, read the template
@@ -204,6 +225,45 @@ func UpdateStageEnvHandler(c fiber.Ctx) error {
 	return c.JSON(StageResponse{Stage: *stage, EnvText: *req.EnvText})
 }
 
+// UpdateStageHealthPathHandler sets the path a stage's deployments are polled on
+// before they are marked ready, and probed on by the proxy afterwards.
+// @Summary Update stage health path
+// @Description Applies to revisions deployed afterwards. An empty path restores DEPLOYMENT_HEALTH_PATH.
+// @Tags Hypervisor Stages
+// @Security HyperUserAuth
+// @Accept json
+// @Produce json
+// @Param stageId path string true "Stage identifier"
+// @Param payload body UpdateStageHealthPathRequest true "Health path"
+// @Success 200 {object} models.Stage
+// @Failure 400 {object} errmsg._StageInvalidRequest
+// @Failure 404 {object} errmsg._StageNotFound
+// @Failure 500 {object} errmsg._InternalServerError
+// @Router /hypervisor/stages/{stageId}/health-path [put]
+func UpdateStageHealthPathHandler(c fiber.Ctx) error {
+	stageID := strings.TrimSpace(c.Params("stageId"))
+	if stageID == "" {
+		return utils.StatusError(c, errmsg.StageInvalidRequest)
+	}
+
+	var req UpdateStageHealthPathRequest
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		return utils.StatusError(c, errmsg.StageInvalidRequest)
+	}
+
+	req.HealthPath = strings.TrimSpace(req.HealthPath)
+	if !validHealthPath(req.HealthPath) {
+		return utils.StatusError(c, errmsg.StageInvalidRequest)
+	}
+
+	stage, err := core.UpdateStageHealthPath(context.Background(), stageID, req.HealthPath)
+	if err != nil {
+		return utils.StatusError(c, err)
+	}
+
+	return c.JSON(stage)
+}
+
 // DeleteStageHandler removes a stage and all associated resources.
 // @Summary Delete stage
 // @Tags Hypervisor Stages
@@ -0,0 +1,143 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"hypervisor/internal/core"
+	"hypervisor/internal/errmsg"
+	"hypervisor/internal/events"
+	"hypervisor/internal/models"
+	"hypervisor/internal/utils"
+
+	"github.com/gofiber/fiber/v3"
+)
+
+// maxReadWritePaths caps the writable paths of one unit.
+const maxReadWritePaths = 16
+
+var (
+	unitMemoryPattern = regexp.MustCompile(`^(infinity|[0-9]+[KMGT]?|[0-9]{1,3}%)$`)
+	unitCPUPattern    = regexp.MustCompile(`^[1-9][0-9]*%$`)
+	unitTasksPattern  = regexp.MustCompile(`^(infinity|[1-9][0-9]*|[0-9]{1,3}%)$`)
+)
+
+type updateUnitDefaultsRequest struct {
+	Tags map[string]models.UnitSettings `json:"tags"`
+}
+
+// GetUnitDefaultsHandler returns the stored per env tag unit defaults.
+// @Summary Get unit defaults
+// @Description Returns the resource limits and sandboxing set per env tag. Fields a tag leaves empty fall back to the built-in defaults: `ProtectSystem=full`, `PrivateTmp` and `NoNewPrivileges` for every tag, plus `MemoryMax=1G`, `CPUQuota=100%` and `TasksMax=512` for every tag but `prod`.
+// @Tags Hypervisor Units
+// @Security HyperUserAuth
+// @Produce json
+// @Success 200 {object} models.UnitDefaults
+// @Failure 500 {object} errmsg._InternalServerError
+// @Router /hypervisor/units/defaults [get]
+func GetUnitDefaultsHandler(c fiber.Ctx) error {
+	defaults, err := models.GetUnitDefaults(context.Background())
+	if err != nil {
+		return utils.StatusError(c, errmsg.InternalServerError(err))
+	}
+
+	return c.JSON(defaults)
+}
+
+// GetEnvTagUnitDefaultsHandler returns the unit settings a new deployment of an env tag starts from.
+// @Summary Get effective unit defaults of an env tag
+// @Tags Hypervisor Units
+// @Security HyperUserAuth
+// @Produce json
+// @Param envTag path string true "Env tag"
+// @Success 200 {object} models.UnitSettings
+// @Failure 500 {object} errmsg._InternalServerError
+// @Router /hypervisor/units/defaults/{envTag} [get]
+func GetEnvTagUnitDefaultsHandler(c fiber.Ctx) error {
+	settings, err := core.UnitDefaults(context.Background(), c.Params("envTag"))
+	if err != nil {
+		return utils.StatusError(c, errmsg.InternalServerError(err))
+	}
+
+	return c.JSON(settings)
+}
+
+// UpdateUnitDefaultsHandler replaces the per env tag unit defaults.
+// @Summary Set unit defaults
+// @Description Applies to deployments created afterwards; existing units keep the settings they were written with.
+// @Tags Hypervisor Units
+// @Security HyperUserAuth
+// @Accept json
+// @Produce json
+// @Param payload body updateUnitDefaultsRequest true "Unit settings per env tag"
+// @Success 200 {object} models.UnitDefaults
+// @Failure 400 {object} errmsg._DeploymentInvalidUnitSettings
+// @Failure 500 {object} errmsg._InternalServerError
+// @Router /hypervisor/units/defaults [put]
+func UpdateUnitDefaultsHandler(c fiber.Ctx) error {
+	var payload updateUnitDefaultsRequest
+	if err := json.Unmarshal(c.Body(), &payload); err != nil {
+		return utils.StatusError(c, errmsg.DeploymentInvalidUnitSettings)
+	}
+
+	defaults := models.UnitDefaults{
+		Tags:      make(map[string]models.UnitSettings, len(payload.Tags)),
+		UpdatedAt: time.Now(),
+		UpdatedBy: actorName(c),
+	}
+	for tag, settings := range payload.Tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" || !validUnitSettings(settings) {
+			return utils.StatusError(c, errmsg.DeploymentInvalidUnitSettings)
+		}
+		defaults.Tags[tag] = settings
+	}
+
+	if err := models.SaveUnitDefaults(context.Background(), defaults); err != nil {
+		return utils.StatusError(c, errmsg.InternalServerError(err))
+	}
+
+	if events.Em != nil {
+		events.Em.UnitDefaultsUpdated(defaults)
+	}
+
+	return c.JSON(defaults)
+}
+
+// validUnitSettings checks that every value is valid systemd syntax, so nothing else
+// can be smuggled into the rendered unit file.
+func validUnitSettings(settings models.UnitSettings) bool {
+	switch {
+	case settings.MemoryMax != "" && !unitMemoryPattern.MatchString(settings.MemoryMax):
+		return false
+	case settings.CPUQuota != "" && !unitCPUPattern.MatchString(settings.CPUQuota):
+		return false
+	case settings.TasksMax != "" && !unitTasksPattern.MatchString(settings.TasksMax):
+		return false
+	case len(settings.ReadWritePaths) > maxReadWritePaths:
+		return false
+	}
+
+	switch settings.ProtectSystem {
+	case "", "yes", "no", "full", "strict":
+	default:
+		return false
+	}
+
+	for _, path := range settings.ReadWritePaths {
+		if !filepath.IsAbs(path) || filepath.Clean(path) != path || strings.ContainsFunc(path, invalidUnitPathRune) {
+			return false
+		}
+	}
+	return true
+}
+
+// invalidUnitPathRune rejects characters systemd would split or unquote in a path list.
+func invalidUnitPathRune(r rune) bool {
+	return r == ' ' || !strconv.IsPrint(r) || strings.ContainsRune(`"'\%$`, r)
+}
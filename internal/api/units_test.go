@@ -0,0 +1,44 @@
+package api
+
+import (
+	"testing"
+
+	"hypervisor/internal/models"
+)
+
+func TestValidUnitSettings(t *testing.T) {
+	tooManyPaths := make([]string, maxReadWritePaths+1)
+	for i := range tooManyPaths {
+		tooManyPaths[i] = "/srv/data"
+	}
+
+	tests := []struct {
+		name     string
+		settings models.UnitSettings
+		valid    bool
+	}{
+		{"empty", models.UnitSettings{}, true},
+		{"limits", models.UnitSettings{MemoryMax: "512M", CPUQuota: "200%", TasksMax: "infinity"}, true},
+		{"memory percent", models.UnitSettings{MemoryMax: "50%"}, true},
+		{"memory unit", models.UnitSettings{MemoryMax: "512MB"}, false},
+		{"memory newline", models.UnitSettings{MemoryMax: "512M\nExecStartPre=/bin/sh"}, false},
+		{"cpu without percent", models.UnitSettings{CPUQuota: "50"}, false},
+		{"cpu zero", models.UnitSettings{CPUQuota: "0%"}, false},
+		{"tasks zero", models.UnitSettings{TasksMax: "0"}, false},
+		{"protect system", models.UnitSettings{ProtectSystem: "strict"}, true},
+		{"protect system unknown", models.UnitSettings{ProtectSystem: "read-only"}, false},
+		{"paths", models.UnitSettings{ReadWritePaths: []string{"/var/lib/openhack", "/tmp/cache"}}, true},
+		{"relative path", models.UnitSettings{ReadWritePaths: []string{"var/lib"}}, false},
+		{"unclean path", models.UnitSettings{ReadWritePaths: []string{"/var/lib/../etc"}}, false},
+		{"path with space", models.UnitSettings{ReadWritePaths: []string{"/srv/a b"}}, false},
+		{"path with specifier", models.UnitSettings{ReadWritePaths: []string{"/srv/%h"}}, false},
+		{"path with newline", models.UnitSettings{ReadWritePaths: []string{"/srv\nUser=root"}}, false},
+		{"too many paths", models.UnitSettings{ReadWritePaths: tooManyPaths}, false},
+	}
+
+	for _, tt := range tests {
+		if got := validUnitSettings(tt.settings); got != tt.valid {
+			t.Errorf("%s: expected valid=%v, got %v", tt.name, tt.valid, got)
+		}
+	}
+}
@@ -1,33 +1,50 @@
 package internal
 
 import (
+	"bytes"
 	"context"
+	"crypto/subtle"
 	"fmt"
+	"hypervisor/internal/accesslog"
 	"hypervisor/internal/api"
+	"hypervisor/internal/core"
 	"hypervisor/internal/db"
 	"hypervisor/internal/env"
+	"hypervisor/internal/errmsg"
 	"hypervisor/internal/events"
 	"hypervisor/internal/hyperusers"
+	"hypervisor/internal/jobs"
+	"hypervisor/internal/metrics"
 	"hypervisor/internal/models"
 	"hypervisor/internal/proxy"
+	"hypervisor/internal/supervisor"
 	"hypervisor/internal/swagger"
+	"hypervisor/internal/utils"
+	"io"
 	"log"
 	"strings"
+	"time"
 
 	"github.com/gofiber/fiber/v3"
 	"github.com/gofiber/fiber/v3/middleware/cors"
 )
 
 func SetupApp(deployment string, envRoot string, appVersion string) *fiber.App {
-	app := fiber.New()
+	env.Init(envRoot, appVersion)
+
+	// The server accepts bodies up to the largest per-deployment limit; each
+	// deployment's own limit is enforced by the proxy, the API's by apiBodyLimit.
+	// Bodies are streamed so the API can refuse a large one before reading it.
+	app := fiber.New(fiber.Config{
+		BodyLimit:         env.PROXY_MAX_BODY_LIMIT,
+		StreamRequestBody: true,
+	})
 
 	// Enable CORS for all origins
 	app.Use(cors.New(cors.Config{
 		AllowOrigins: []string{"*"},
 	}))
 
-	env.Init(envRoot, appVersion)
-
 	deploy := strings.TrimSpace(deployment)
 
 	if err := db.InitDB(deploy); err != nil {
@@ -35,6 +52,11 @@ func SetupApp(deployment string, envRoot string, appVersion string) *fiber.App {
 		return nil
 	}
 
+	if err := models.EnsureDeploymentIndexes(context.Background()); err != nil {
+		log.Fatal("Could not create deployment indexes:", err)
+		return nil
+	}
+
 	if err := db.InitCache(); err != nil {
 		log.Fatal("Could not connect to Redis")
 		return nil
@@ -46,23 +68,54 @@ func SetupApp(deployment string, envRoot string, appVersion string) *fiber.App {
 		events.Em = nil
 	}
 
+	// systemd by default; the native supervisor starts its enabled backends here
+	if err := supervisor.Init(); err != nil {
+		log.Fatal("Could not initialize process supervisor:", err)
+		return nil
+	}
+
 	// Initialize proxy system
 	if err := proxy.InitProxy(context.Background()); err != nil {
 		log.Fatal("Could not initialize proxy system:", err)
 		return nil
 	}
 
+	// The access log is best-effort: the proxy still serves traffic without it
+	if err := accesslog.Init(); err != nil {
+		log.Printf("access log disabled: %v", err)
+	}
+
+	// Provisioning and test runs are persisted jobs, resumed after a restart
+	core.RegisterJobs()
+
+	// Test apps and DISABLE_BACKGROUND_TASKS serve the API without acting on their own:
+	// these tasks promote, build, start and remove real units
+	if runsBackgroundTasks(deploy) {
+		core.StartAutoRollback()
+
+		jobs.Start(context.Background())
+
+		// Bring deployments, their units and the route map back in line after a crash
+		core.StartReconciler(context.Background())
+
+		// Take deployments whose units keep restarting out of routing
+		core.StartCrashLoopWatcher(context.Background())
+	}
+
 	// Set up proxy routes (must be before API routes)
 	proxy.GlobalRouteMap.SetupRoutes(app)
 
 	hypervisor := app.Group("/hypervisor")
+	hypervisor.Use(apiBodyLimit)
 
 	meta := hypervisor.Group("/meta")
 	meta.Get("/ping", hypervisorPingHandler)
 	meta.Get("/version", hypervisorVersionHandler)
 	meta.Get("/drain", hypervisorDrainStatusHandler)
 	meta.Post("/drain", hypervisorDrainHandler)
-	hypervisor.Get("/routing", api.GetRoutingMapHandler)
+	hypervisor.Get("/routing", models.HyperUserMiddleware, api.GetRoutingMapHandler)
+	hypervisor.Get("/routing/resolve", models.HyperUserMiddleware, api.ResolveRouteHandler)
+	hypervisor.Get("/metrics", metricsAuthMiddleware, hypervisorMetricsHandler)
 
 	swagger.Register(hypervisor)
 
@@ -72,6 +125,25 @@ func SetupApp(deployment string, envRoot string, appVersion string) *fiber.App {
 	hypervisor.Get("/releases", models.HyperUserMiddleware, api.ListReleasesHandler)
 	// hypervisor.Get("/releases/webhook", models.HyperUserMiddleware, api.ListReleasesHandler)  for the GitHub webhook integration
 
+	// tailing and searching proxied requests
+	hypervisor.Get("/logs/access", models.HyperUserMiddleware, api.SearchAccessLogHandler)
+
+	// listing background jobs (provisioning, test runs)
+	hypervisor.Get("/jobs", models.HyperUserMiddleware, api.ListJobsHandler)
+
+	// comparing recorded deployments with their systemd units
+	hypervisor.Get("/reconcile", models.HyperUserMiddleware, api.GetReconcileReportHandler)
+	hypervisor.Post("/reconcile", models.HyperUserMiddleware, api.ReconcileHandler)
+
+	// holding back root traffic during maintenance windows
+	hypervisor.Get("/maintenance", models.HyperUserMiddleware, api.GetMaintenanceHandler)
+	hypervisor.Put("/maintenance", models.HyperUserMiddleware, api.UpdateMaintenanceHandler)
+
+	// resource limits and sandboxing of backend units, per env tag
+	hypervisor.Get("/units/defaults", models.HyperUserMiddleware, api.GetUnitDefaultsHandler)
+	hypervisor.Put("/units/defaults", models.HyperUserMiddleware, api.UpdateUnitDefaultsHandler)
+	hypervisor.Get("/units/defaults/:envTag", models.HyperUserMiddleware, api.GetEnvTagUnitDefaultsHandler)
+
 	hypervisor.Get("/env/template", models.HyperUserMiddleware, api.GetEnvTemplateHandler)
 	hypervisor.Put("/env/template", models.HyperUserMiddleware, api.UpdateEnvTemplateHandler)
 
@@ -87,6 +159,9 @@ func SetupApp(deployment string, envRoot string, appVersion string) *fiber.App {
 	hypervisor.Get("/stages/:stageId/env", models.HyperUserMiddleware, api.GetStageEnvHandler)
 	hypervisor.Put("/stages/:stageId/env", models.HyperUserMiddleware, api.UpdateStageEnvHandler)
 
+	// setting the path deployments of a stage are health checked on
+	hypervisor.Put("/stages/:stageId/health-path", models.HyperUserMiddleware, api.UpdateStageHealthPathHandler)
+
 	// getting the list of tests, and starting a test
 	hypervisor.Get("/stages/:stageId/tests", models.HyperUserMiddleware, api.ListTestsHandler)
 	hypervisor.Post("/stages/:stageId/tests", models.HyperUserMiddleware, api.StartTestHandler)
@@ -94,6 +169,13 @@ func SetupApp(deployment string, envRoot string, appVersion string) *fiber.App {
 	// cancelling a test
 	hypervisor.Post("/stages/:stageId/tests/:sequence/cancel", models.HyperUserMiddleware, api.CancelTestHandler)
 
+	// listing the deployment revisions of a stage
+	hypervisor.Get("/stages/:stageId/deployments", models.HyperUserMiddleware, api.ListStageDeploymentsHandler)
+
+	// rolling main back to the previous deployment (before the :stageId route)
+	hypervisor.Post("/deployments/rollback", models.HyperUserMiddleware, api.RollbackDeploymentHandler)
+	hypervisor.Get("/promotions", models.HyperUserMiddleware, api.ListPromotionsHandler)
+
 	// creating a deployment based on a stage ID
 	hypervisor.Post("/deployments/:stageId", models.HyperUserMiddleware, api.CreateDeploymentHandler)
 
@@ -107,6 +189,22 @@ func SetupApp(deployment string, envRoot string, appVersion string) *fiber.App {
 	// either promoting or shutting down a deployment
 	hypervisor.Post("/deployments/:deploymentId/promote", models.HyperUserMiddleware, api.PromoteDeploymentHandler)
 
+	// gradually shifting root traffic to a candidate deployment
+	hypervisor.Post("/deployments/:deploymentId/canary", models.HyperUserMiddleware, api.CanaryDeploymentHandler)
+	hypervisor.Post("/deployments/:deploymentId/mirror", models.HyperUserMiddleware, api.MirrorDeploymentHandler)
+	hypervisor.Get("/deployments/:deploymentId/mirror", models.HyperUserMiddleware, api.GetMirrorReportHandler)
+	hypervisor.Get("/deployments/:deploymentId/diff-report", models.HyperUserMiddleware, api.GetDiffReportHandler)
+
+	// routing custom hostnames to a deployment
+	hypervisor.Put("/deployments/:deploymentId/hostnames", models.HyperUserMiddleware, api.UpdateDeploymentHostnamesHandler)
+	hypervisor.Put("/deployments/:deploymentId/policy", models.HyperUserMiddleware, api.UpdateDeploymentPolicyHandler)
+	hypervisor.Put("/deployments/:deploymentId/proxy", models.HyperUserMiddleware, api.UpdateDeploymentProxySettingsHandler)
+
+	// injecting faults into a deployment's traffic for resilience testing
+	hypervisor.Post("/deployments/:deploymentId/faults", models.HyperUserMiddleware, api.AddDeploymentFaultHandler)
+	hypervisor.Delete("/deployments/:deploymentId/faults", models.HyperUserMiddleware, api.ClearDeploymentFaultsHandler)
+	hypervisor.Delete("/deployments/:deploymentId/faults/:faultId", models.HyperUserMiddleware, api.DeleteDeploymentFaultHandler)
+
 	// shutting down and starting a deployment
 	hypervisor.Post("/deployments/:deploymentId/shutdown", models.HyperUserMiddleware, api.ShutdownDeploymentHandler)
 	hypervisor.Post("/deployments/:deploymentId/start", models.HyperUserMiddleware, api.StartDeploymentHandler)
@@ -116,6 +214,7 @@ func SetupApp(deployment string, envRoot string, appVersion string) *fiber.App {
 	ws.Use(models.HyperUserWebSocketMiddleware)
 	ws.Get("/stages/:stageId/tests/:sequence", api.StreamTestLogs)
 	ws.Get("/deployments/:deploymentId/logs", api.StreamDeploymentLogs)
+	ws.Get("/deployments/:deploymentId/runtime-logs", api.StreamDeploymentRuntimeLogs)
 
 	return app
 }
@@ -147,6 +246,37 @@ func hypervisorVersionHandler(c fiber.Ctx) error {
 	return c.SendString("v" + env.VERSION)
 }
 
+// hypervisorMetricsHandler exposes proxy, process and lifecycle metrics for Prometheus.
+// @Summary Prometheus metrics
+// @Description Per-deployment proxy traffic (requests, status classes, latency histogram, bytes), hypervisor process metrics, and stage/test/deployment counts by status in the Prometheus text format.
+// @Tags Hypervisor Meta
+// @Produce plain
+// @Security HyperUserAuth
+// @Success 200 {string} string "Prometheus text exposition"
+// @Router /hypervisor/metrics [get]
+func hypervisorMetricsHandler(c fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var buf bytes.Buffer
+	metrics.Write(ctx, &buf)
+
+	c.Set(fiber.HeaderContentType, metrics.ContentType)
+	return c.Send(buf.Bytes())
+}
+
+// metricsAuthMiddleware lets a scraper in with METRICS_TOKEN, since hyperuser
+// tokens expire, and otherwise requires a hyperuser.
+func metricsAuthMiddleware(c fiber.Ctx) error {
+	if env.METRICS_TOKEN != "" {
+		token, found := strings.CutPrefix(strings.TrimSpace(c.Get("Authorization")), "Bearer ")
+		if found && subtle.ConstantTimeCompare([]byte(strings.TrimSpace(token)), []byte(env.METRICS_TOKEN)) == 1 {
+			return c.Next()
+		}
+	}
+	return models.HyperUserMiddleware(c)
+}
+
 // hypervisorDrainHandler toggles drain mode for blue-green deployments.
 // @Summary Toggle drain mode
 // @Description Enables or disables drain mode, causing the service to reject new connections while keeping existing ones alive.
@@ -190,3 +320,44 @@ func hypervisorDrainStatusHandler(c fiber.Ctx) error {
 		"drain_mode": env.DRAIN_MODE,
 	})
 }
+
+// runsBackgroundTasks reports whether the app runs job workers, the reconciler, the
+// crash-loop watcher and automatic rollbacks. Test apps ("test" or "<name>_test") never do.
+func runsBackgroundTasks(deployment string) bool {
+	if env.DISABLE_BACKGROUND_TASKS {
+		return false
+	}
+	deployment = strings.ToLower(deployment)
+	return deployment != "test" && !strings.HasSuffix(deployment, "_test")
+}
+
+// apiBodyLimit holds API requests to fiber's default body limit. The server's limit
+// is raised to PROXY_MAX_BODY_LIMIT for proxied traffic only, so a body declared
+// larger is refused before it is read, and a chunked one once the limit is passed.
+func apiBodyLimit(c fiber.Ctx) error {
+	req := c.Request()
+	if req.Header.ContentLength() > fiber.DefaultBodyLimit {
+		return rejectAPIBody(c)
+	}
+
+	if req.IsBodyStream() {
+		body, err := io.ReadAll(io.LimitReader(req.BodyStream(), fiber.DefaultBodyLimit+1))
+		if err != nil {
+			return utils.StatusError(c, errmsg.InternalServerError(err))
+		}
+		if len(body) > fiber.DefaultBodyLimit {
+			return rejectAPIBody(c)
+		}
+		req.SetBody(body)
+	} else if len(req.Body()) > fiber.DefaultBodyLimit {
+		return rejectAPIBody(c)
+	}
+	return c.Next()
+}
+
+// rejectAPIBody answers an oversized API request. The connection is closed since
+// the rest of the body is left unread on it.
+func rejectAPIBody(c fiber.Ctx) error {
+	c.RequestCtx().SetConnectionClose()
+	return utils.StatusError(c, errmsg.RequestBodyTooLarge)
+}
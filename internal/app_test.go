@@ -0,0 +1,63 @@
+package internal
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/gofiber/fiber/v3"
+)
+
+func TestAPIBodyLimit(t *testing.T) {
+	app := fiber.New(fiber.Config{BodyLimit: 16 << 20, StreamRequestBody: true})
+	app.Post("/hypervisor/echo", apiBodyLimit, func(c fiber.Ctx) error {
+		return c.SendString(strconv.Itoa(len(c.Body())))
+	})
+
+	small := bytes.Repeat([]byte("a"), 1024)
+	atLimit := bytes.Repeat([]byte("a"), fiber.DefaultBodyLimit)
+	large := bytes.Repeat([]byte("a"), fiber.DefaultBodyLimit+1)
+
+	tests := []struct {
+		name    string
+		body    []byte
+		chunked bool
+		want    int
+	}{
+		{"small body", small, false, http.StatusOK},
+		{"body at the limit", atLimit, false, http.StatusOK},
+		{"declared over the limit", large, false, http.StatusRequestEntityTooLarge},
+		{"small chunked body", small, true, http.StatusOK},
+		{"chunked body at the limit", atLimit, true, http.StatusOK},
+		{"chunked over the limit", large, true, http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPost, "/hypervisor/echo", bytes.NewReader(tt.body))
+		if tt.chunked {
+			req.ContentLength = 0
+			req.TransferEncoding = []string{"chunked"}
+		}
+
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("%s: request failed: %v", tt.name, err)
+		}
+		got, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode != tt.want {
+			t.Errorf("%s: expected status %d, got %d", tt.name, tt.want, resp.StatusCode)
+			continue
+		}
+		if tt.want == http.StatusOK && string(got) != strconv.Itoa(len(tt.body)) {
+			t.Errorf("%s: expected the handler to read the whole body, got %q", tt.name, got)
+		}
+		if tt.want != http.StatusOK && !resp.Close {
+			t.Errorf("%s: expected the connection to be closed", tt.name)
+		}
+	}
+}
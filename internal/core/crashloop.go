@@ -0,0 +1,131 @@
+package core
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"hypervisor/internal/env"
+	"hypervisor/internal/events"
+	"hypervisor/internal/models"
+	"hypervisor/internal/proxy"
+	"hypervisor/internal/supervisor"
+)
+
+// restartSample is a unit's NRestarts as seen at one check.
+type restartSample struct {
+	at       time.Time
+	restarts int
+}
+
+// StartCrashLoopWatcher checks the units of routed deployments every
+// CRASHLOOP_CHECK_INTERVAL. A deployment whose unit the supervisor restarted
+// CRASHLOOP_RESTARTS times within CRASHLOOP_WINDOW is moved to crashlooping, which
+// takes it out of routing; its unit is left to the supervisor.
+func StartCrashLoopWatcher(ctx context.Context) {
+	if env.CRASHLOOP_CHECK_INTERVAL <= 0 || env.CRASHLOOP_RESTARTS <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(env.CRASHLOOP_CHECK_INTERVAL)
+		defer ticker.Stop()
+
+		// Only this goroutine touches the samples
+		samples := make(map[string][]restartSample)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				checkCrashLoops(ctx, samples)
+			}
+		}
+	}()
+}
+
+func checkCrashLoops(ctx context.Context, samples map[string][]restartSample) {
+	routed := make(map[string]bool)
+	for _, entry := range proxy.GlobalRouteMap.Snapshot().Stages {
+		routed[entry.DeploymentID] = true
+	}
+	for id := range samples {
+		if !routed[id] {
+			delete(samples, id)
+		}
+	}
+
+	// An instance being drained leaves the units to the one replacing it
+	if env.DRAIN_MODE {
+		return
+	}
+
+	now := time.Now()
+	for id := range routed {
+		state, err := supervisor.Default.State(id)
+		if err != nil {
+			log.Printf("failed to read unit state of %s: %v", id, err)
+			continue
+		}
+
+		restarts := recordRestarts(samples, id, restartSample{at: now, restarts: state.NRestarts})
+		if restarts >= env.CRASHLOOP_RESTARTS {
+			delete(samples, id)
+			markCrashLooping(ctx, id, restarts)
+		}
+	}
+}
+
+// recordRestarts adds a sample for a unit and returns how often it restarted within
+// CRASHLOOP_WINDOW. NRestarts starts over when the unit is started by hand, so a
+// drop discards the earlier samples.
+func recordRestarts(samples map[string][]restartSample, id string, sample restartSample) int {
+	kept := samples[id][:0]
+	for _, earlier := range samples[id] {
+		if sample.at.Sub(earlier.at) <= env.CRASHLOOP_WINDOW && earlier.restarts <= sample.restarts {
+			kept = append(kept, earlier)
+		}
+	}
+	samples[id] = append(kept, sample)
+
+	return sample.restarts - samples[id][0].restarts
+}
+
+// markCrashLooping moves a deployment to crashlooping, unless another instance got
+// there first, and appends the unit's journal to its log.
+func markCrashLooping(ctx context.Context, deploymentID string, restarts int) {
+	crashLoop := models.CrashLoopState{
+		Restarts:   restarts,
+		Window:     env.CRASHLOOP_WINDOW.String(),
+		DetectedAt: time.Now(),
+	}
+	marked, err := models.MarkDeploymentCrashLooping(ctx, deploymentID, crashLoop, events.ActorSystem)
+	if err != nil {
+		log.Printf("failed to mark deployment %s crashlooping: %v", deploymentID, err)
+		return
+	}
+	if !marked {
+		return
+	}
+
+	dep, err := models.GetDeploymentByID(ctx, deploymentID)
+	if err != nil {
+		log.Printf("failed to load crashlooping deployment %s: %v", deploymentID, err)
+		return
+	}
+	proxy.GlobalRouteMap.UpdateDeployment(dep)
+	log.Printf("deployment %s restarted %d times within %s; taken out of routing", dep.ID, restarts, env.CRASHLOOP_WINDOW)
+
+	if logFile, err := openDeploymentLog(*dep); err != nil {
+		log.Printf("failed to open log file for deployment %s: %v", dep.ID, err)
+	} else {
+		logger := &deploymentLogger{writer: logFile}
+		logger.Log("Unit restarted %d times within %s; deployment marked crashlooping and taken out of routing", restarts, env.CRASHLOOP_WINDOW)
+		attachJournal(*dep, logger, env.CRASHLOOP_JOURNAL_LINES)
+		logFile.Close()
+	}
+
+	if events.Em != nil {
+		events.Em.DeploymentCrashLoop(*dep)
+	}
+}
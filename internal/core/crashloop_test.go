@@ -0,0 +1,72 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"hypervisor/internal/env"
+)
+
+func TestRecordRestartsWithinWindow(t *testing.T) {
+	window := env.CRASHLOOP_WINDOW
+	env.CRASHLOOP_WINDOW = 5 * time.Minute
+	defer func() { env.CRASHLOOP_WINDOW = window }()
+
+	start := time.Now()
+	samples := make(map[string][]restartSample)
+
+	if got := recordRestarts(samples, "dep", restartSample{at: start, restarts: 2}); got != 0 {
+		t.Errorf("Expected 0 restarts for the first sample, got %d", got)
+	}
+	if got := recordRestarts(samples, "dep", restartSample{at: start.Add(time.Minute), restarts: 4}); got != 2 {
+		t.Errorf("Expected 2 restarts within the window, got %d", got)
+	}
+	if got := recordRestarts(samples, "dep", restartSample{at: start.Add(2 * time.Minute), restarts: 7}); got != 5 {
+		t.Errorf("Expected 5 restarts within the window, got %d", got)
+	}
+
+	// The first sample falls out of the window
+	if got := recordRestarts(samples, "dep", restartSample{at: start.Add(5*time.Minute + time.Second), restarts: 8}); got != 4 {
+		t.Errorf("Expected 4 restarts once the first sample expired, got %d", got)
+	}
+	if len(samples["dep"]) != 3 {
+		t.Errorf("Expected 3 samples kept, got %d", len(samples["dep"]))
+	}
+}
+
+func TestRecordRestartsCounterReset(t *testing.T) {
+	window := env.CRASHLOOP_WINDOW
+	env.CRASHLOOP_WINDOW = 5 * time.Minute
+	defer func() { env.CRASHLOOP_WINDOW = window }()
+
+	start := time.Now()
+	samples := make(map[string][]restartSample)
+	recordRestarts(samples, "dep", restartSample{at: start, restarts: 6})
+	recordRestarts(samples, "dep", restartSample{at: start.Add(time.Minute), restarts: 9})
+
+	// A unit started by hand counts from zero again
+	if got := recordRestarts(samples, "dep", restartSample{at: start.Add(2 * time.Minute), restarts: 1}); got != 0 {
+		t.Errorf("Expected 0 restarts after the counter reset, got %d", got)
+	}
+	if got := recordRestarts(samples, "dep", restartSample{at: start.Add(3 * time.Minute), restarts: 3}); got != 2 {
+		t.Errorf("Expected 2 restarts since the reset, got %d", got)
+	}
+}
+
+func TestRecordRestartsPerUnit(t *testing.T) {
+	window := env.CRASHLOOP_WINDOW
+	env.CRASHLOOP_WINDOW = 5 * time.Minute
+	defer func() { env.CRASHLOOP_WINDOW = window }()
+
+	start := time.Now()
+	samples := make(map[string][]restartSample)
+	recordRestarts(samples, "a", restartSample{at: start, restarts: 0})
+	recordRestarts(samples, "b", restartSample{at: start, restarts: 10})
+
+	if got := recordRestarts(samples, "a", restartSample{at: start.Add(time.Minute), restarts: 3}); got != 3 {
+		t.Errorf("Expected 3 restarts for unit a, got %d", got)
+	}
+	if got := recordRestarts(samples, "b", restartSample{at: start.Add(time.Minute), restarts: 10}); got != 0 {
+		t.Errorf("Expected 0 restarts for unit b, got %d", got)
+	}
+}
@@ -13,34 +13,25 @@ import (
 	"strings"
 	"time"
 
+	"hypervisor/internal/errmsg"
 	"hypervisor/internal/events"
 	"hypervisor/internal/fs"
+	"hypervisor/internal/jobs"
 	"hypervisor/internal/models"
 	"hypervisor/internal/paths"
 	"hypervisor/internal/proxy"
-	"hypervisor/internal/systemd"
+	"hypervisor/internal/supervisor"
 )
 
 // ProvisionDeployment handles the asynchronous provisioning of a deployment.
-// It builds the backend, installs the systemd service, and updates the deployment status.
-// All progress is logged to the deployment's log file.
-func ProvisionDeployment(dep models.Deployment) {
-	ctx := context.Background()
-
-	// Ensure the log directory exists
-	if err := os.MkdirAll(filepath.Dir(dep.LogPath), 0o755); err != nil {
-		log.Printf("Failed to create log directory for deployment %s: %v", dep.ID, err)
-		dep.Status = models.DeploymentStatusProvisionFailed
-		models.UpdateDeployment(ctx, dep)
-		return
-	}
-
-	logFile, err := os.OpenFile(dep.LogPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o666)
+// It builds the backend, installs its service with the supervisor, and updates the deployment status.
+// All progress is logged to the deployment's log file. It runs as a job: failures it
+// records on the deployment are permanent, any other error is retried.
+func ProvisionDeployment(ctx context.Context, dep models.Deployment) error {
+	logFile, err := openDeploymentLog(dep)
 	if err != nil {
 		log.Printf("Failed to open log file for deployment %s: %v", dep.ID, err)
-		dep.Status = models.DeploymentStatusProvisionFailed
-		models.UpdateDeployment(ctx, dep)
-		return
+		return err
 	}
 	defer logFile.Close()
 
@@ -52,60 +43,171 @@ func ProvisionDeployment(dep models.Deployment) {
 	logger.Log("Building backend binary...")
 	repoPath := paths.OpenHackRepoPath(dep.StageID)
 	buildPath := paths.OpenHackBuildsDir
-	versionWithoutV := strings.TrimPrefix(dep.Version, "v")
-	binaryPath := filepath.Join(buildPath, versionWithoutV)
-	if err := buildBackend(repoPath, buildPath, binaryPath, logFile); err != nil {
+	if err := buildBackend(ctx, repoPath, buildPath, binaryPath(dep), logFile); err != nil {
 		logger.Log("Build failed: %v", err)
+		if ctx.Err() != nil {
+			// The job was cancelled, e.g. because another instance took it over
+			return ctx.Err()
+		}
 		dep.Status = models.DeploymentStatusBuildFailed
+		dep.Port = nil // A failed revision never serves; free its port
 		models.UpdateDeployment(ctx, dep)
 		if events.Em != nil {
 			events.Em.DeploymentCreateFailed(dep.ID, err)
 		}
-		return
+		return jobs.Permanent(err)
 	}
 	logger.Log("Build completed successfully")
 
-	// Install systemd service
-	logger.Log("Installing systemd service...")
-	envRoot := paths.OpenHackEnvPath(dep.StageID)
-	cfg := systemd.BackendServiceConfig{
-		DeploymentID: dep.ID,
-		BinaryPath:   binaryPath,
-		EnvTag:       dep.EnvTag,
-		Port:         *dep.Port,
-		EnvRoot:      envRoot,
-		Version:      dep.StageID,
+	if ctx.Err() != nil {
+		return ctx.Err()
 	}
-	if err := systemd.InstallBackendService(cfg, logFile); err != nil {
-		logger.Log("Systemd install failed: %v", err)
+
+	// Install the service with the configured supervisor
+	logger.Log("Installing %s service...", supervisor.Default.Name())
+	if err := supervisor.Default.Install(backendServiceConfig(dep), logFile); err != nil {
+		logger.Log("Service install failed: %v", err)
+		discardRevision(dep, logger)
 		dep.Status = models.DeploymentStatusProvisionFailed
+		dep.Port = nil
+		models.UpdateDeployment(ctx, dep)
+		if events.Em != nil {
+			events.Em.DeploymentCreateFailed(dep.ID, err)
+		}
+		return jobs.Permanent(err)
+	}
+	logger.Log("Service installed and started")
+
+	// The previous revision of the stage keeps serving until this one is verified ready
+	logger.Log("Verifying readiness of revision %d...", dep.Revision)
+	if status, err := verifyReadiness(ctx, &dep, logger); err != nil {
+		logger.Log("Readiness check failed: %v", err)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		attachJournal(dep, logger, readinessJournalLines)
+		discardRevision(dep, logger)
+		dep.Status = status
+		dep.Port = nil
+		dep.Touch(events.ActorSystem)
 		models.UpdateDeployment(ctx, dep)
 		if events.Em != nil {
 			events.Em.DeploymentCreateFailed(dep.ID, err)
 		}
-		return
+		return jobs.Permanent(err)
 	}
-	logger.Log("Systemd service installed and started")
+	logger.Log("Deployment passed its readiness checks")
 
 	// Update deployment to ready. Do NOT auto-promote to main here.
 	// Promotion (making this the main deployment) must be an explicit operator action
 	// so we only mark the deployment as ready and persist it. The Promote API will
 	// handle updating the proxy routing map and marking the stage as promoted.
-	dep.Status = models.DeploymentStatusReady
-	if err := models.UpdateDeployment(ctx, dep); err != nil {
+	// The one exception is a new revision of main's stage, which takes main over.
+	if err := handOver(ctx, &dep, logger); err != nil {
 		logger.Log("Failed to update deployment status: %v", err)
-		return
+		return err
 	}
 
-	// Make the deployment immediately routable at /<stageID>/* by updating the route map.
-	// Do NOT set PromotedAt here — promotion to main must be explicit.
-	proxy.GlobalRouteMap.UpdateDeployment(&dep)
-
-	logger.Log("Deployment is now ready and routable under its stage (not promoted)")
+	logger.Log("Deployment is now ready and routable under its stage")
 
 	if events.Em != nil {
 		events.Em.DeploymentCreated(dep)
 	}
+	return nil
+}
+
+// StartDeployment starts the unit of a stopped deployment and routes to it again once
+// it passes the same readiness checks as a new revision. One that fails them is
+// stopped again and left start_failed or unhealthy; its crash loop record is only
+// cleared once it stayed up. Progress is appended to the deployment's log.
+func StartDeployment(ctx context.Context, dep *models.Deployment, actor string) error {
+	if dep.Port == nil {
+		// Failed revisions give up their port along with their unit
+		return errmsg.DeploymentNoUnit
+	}
+
+	logFile, err := openDeploymentLog(*dep)
+	if err != nil {
+		return err
+	}
+	defer logFile.Close()
+
+	logger := &deploymentLogger{writer: logFile}
+
+	logger.Log("Starting %s service of %s...", supervisor.Default.Name(), dep.ID)
+	if err := supervisor.Default.Start(dep.ID); err != nil {
+		logger.Log("Service start failed: %v", err)
+		return err
+	}
+
+	status, readyErr := verifyReadiness(ctx, dep, logger)
+	if readyErr != nil {
+		logger.Log("Readiness check failed: %v", readyErr)
+		attachJournal(*dep, logger, readinessJournalLines)
+		if err := supervisor.Default.Stop(dep.ID); err != nil {
+			logger.Log("Failed to stop service: %v", err)
+		}
+	} else {
+		logger.Log("Deployment passed its readiness checks")
+		dep.CrashLoop = nil
+	}
+
+	dep.Status = status
+	dep.Touch(actor)
+	if err := models.UpdateDeployment(ctx, *dep); err != nil {
+		return err
+	}
+	proxy.GlobalRouteMap.UpdateDeployment(dep)
+
+	if readyErr != nil {
+		if events.Em != nil {
+			events.Em.DeploymentCreateFailed(dep.ID, readyErr)
+		}
+		return errmsg.DeploymentStartFailed
+	}
+	if events.Em != nil {
+		events.Em.DeploymentCreated(*dep)
+	}
+	return nil
+}
+
+// binaryPath returns where the backend binary of a deployment's version is built.
+func binaryPath(dep models.Deployment) string {
+	return filepath.Join(paths.OpenHackBuildsDir, strings.TrimPrefix(dep.Version, "v"))
+}
+
+// backendServiceConfig returns the values rendered into a deployment's service
+// definition. The deployment must have a port assigned.
+func backendServiceConfig(dep models.Deployment) supervisor.Config {
+	cfg := supervisor.Config{
+		DeploymentID: dep.ID,
+		BinaryPath:   binaryPath(dep),
+		EnvTag:       dep.EnvTag,
+		Port:         *dep.Port,
+		EnvRoot:      paths.OpenHackEnvPath(dep.StageID),
+		Version:      dep.StageID,
+	}
+
+	// Deployments created before unit settings existed keep their unlimited unit
+	if unit := dep.Unit; unit != nil {
+		cfg.MemoryMax = unit.MemoryMax
+		cfg.CPUQuota = unit.CPUQuota
+		cfg.TasksMax = unit.TasksMax
+		cfg.ProtectSystem = unit.ProtectSystem
+		cfg.PrivateTmp = yesNo(unit.PrivateTmp)
+		cfg.NoNewPrivileges = yesNo(unit.NoNewPrivileges)
+		cfg.ReadWritePaths = unit.ReadWritePaths
+	}
+	return cfg
+}
+
+// openDeploymentLog opens a deployment's log for appending, so the output of every
+// provisioning attempt is kept.
+func openDeploymentLog(dep models.Deployment) (*os.File, error) {
+	if err := os.MkdirAll(filepath.Dir(dep.LogPath), 0o755); err != nil {
+		return nil, err
+	}
+	return os.OpenFile(dep.LogPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o666)
 }
 
 // deploymentLogger writes formatted log messages to the writer.
@@ -118,7 +220,7 @@ func (l *deploymentLogger) Log(format string, args ...interface{}) {
 	fmt.Fprintf(l.writer, "[%s] %s\n", time.Now().Format("2006-01-02 15:04:05"), msg)
 }
 
-func buildBackend(repoPath, buildPath, binaryPath string, logWriter io.Writer) error {
+func buildBackend(ctx context.Context, repoPath, buildPath, binaryPath string, logWriter io.Writer) error {
 	if err := fs.EnsureDir(buildPath, 0o755); err != nil {
 		return fmt.Errorf("failed to create build directory: %w", err)
 	}
@@ -136,7 +238,7 @@ func buildBackend(repoPath, buildPath, binaryPath string, logWriter io.Writer) e
 	}
 
 	fmt.Fprintf(logWriter, "[%s] Running ./BUILD command in %s with output %s\n", time.Now().Format("2006-01-02 15:04:05"), repoPath, buildPath)
-	cmd := exec.Command("./BUILD.sh", "--output", buildPath)
+	cmd := exec.CommandContext(ctx, "./BUILD.sh", "--output", buildPath)
 	cmd.Dir = repoPath
 	cmd.Stdout = logWriter
 	cmd.Stderr = logWriter
@@ -208,8 +310,8 @@ func tailDeploymentLogFile(ctx context.Context, file *os.File, deploymentID stri
 
 		// Check if the deployment has finished provisioning.
 		latest, err := models.GetDeploymentByID(context.Background(), deploymentID)
-		if err == nil && latest.Status == "ready" {
-			// Deployment is ready, stop streaming the file.
+		if err == nil && latest.Status != models.DeploymentStatusProvisioning {
+			// Provisioning is over, ready or failed, so stop streaming the file.
 			return nil
 		}
 
@@ -0,0 +1,80 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"hypervisor/internal/events"
+	"hypervisor/internal/models"
+	"hypervisor/internal/proxy"
+)
+
+// DrainDeployment takes a deployment out of routing and waits up to timeout for its
+// in-flight requests and WebSockets to finish, on every hypervisor instance. Progress is persisted on the deployment record so it
+// can be observed while the drain runs. A drain that hits the deadline is not an
+// error: the caller is expected to stop the deployment either way. Callers should
+// Touch the deployment beforehand so the route change is attributed to them. A drain
+// that fails puts the deployment back in routing.
+func DrainDeployment(ctx context.Context, dep *models.Deployment, timeout time.Duration) (err error) {
+	started := time.Now()
+	previous := dep.Status
+	dep.Status = models.DeploymentStatusDraining
+	dep.Drain = &models.DrainState{
+		StartedAt: started,
+		Deadline:  started.Add(timeout),
+		InFlight:  proxy.GlobalRouteMap.TotalInFlight(ctx, dep.ID),
+	}
+	if err := models.UpdateDeployment(ctx, *dep); err != nil {
+		return err
+	}
+
+	// A draining deployment is no longer ready, so this stops new traffic reaching it
+	proxy.GlobalRouteMap.UpdateDeployment(dep)
+	defer func() {
+		if err != nil {
+			UndoDrain(context.Background(), dep, previous)
+		}
+	}()
+
+	drainCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	err = proxy.GlobalRouteMap.WaitForDrain(drainCtx, dep.ID, func(inFlight int64) {
+		dep.Drain.InFlight = inFlight
+		if err := models.UpdateDeploymentDrain(context.Background(), dep.ID, *dep.Drain); err != nil {
+			log.Printf("failed to record drain progress for deployment %s: %v", dep.ID, err)
+		}
+	})
+
+	completed := time.Now()
+	dep.Drain.CompletedAt = &completed
+	dep.Drain.InFlight = proxy.GlobalRouteMap.TotalInFlight(context.Background(), dep.ID)
+	if errors.Is(err, context.DeadlineExceeded) {
+		dep.Drain.TimedOut = true
+	} else if err != nil {
+		return err
+	}
+
+	if err := models.UpdateDeploymentDrain(ctx, dep.ID, *dep.Drain); err != nil {
+		return err
+	}
+
+	if events.Em != nil {
+		events.Em.DeploymentDrained(*dep)
+	}
+
+	return nil
+}
+
+// UndoDrain gives a drained deployment its previous status back and returns it to
+// routing. Callers use it when stopping the deployment failed and it keeps running:
+// nothing else recovers a deployment left draining.
+func UndoDrain(ctx context.Context, dep *models.Deployment, status models.DeploymentStatus) {
+	dep.Status = status
+	if err := models.UpdateDeployment(ctx, *dep); err != nil {
+		log.Printf("failed to restore status of deployment %s after an aborted drain: %v", dep.ID, err)
+	}
+	proxy.GlobalRouteMap.UpdateDeployment(dep)
+}
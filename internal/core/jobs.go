@@ -0,0 +1,112 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"hypervisor/internal/events"
+	"hypervisor/internal/jobs"
+	"hypervisor/internal/models"
+	"hypervisor/internal/paths"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// RegisterJobs sets up the background jobs that provision deployments and run tests.
+func RegisterJobs() {
+	jobs.Register(models.JobKindProvisionDeployment, jobs.Kind{
+		Handler:     provisionJob,
+		MaxAttempts: 3,
+		Backoff:     15 * time.Second,
+		OnFail:      provisionJobFailed,
+	})
+	jobs.Register(models.JobKindRunTest, jobs.Kind{
+		Handler:     testJob,
+		MaxAttempts: 2,
+		Backoff:     5 * time.Second,
+		OnFail:      testJobFailed,
+	})
+}
+
+func provisionJob(ctx context.Context, job models.Job) error {
+	dep, err := models.GetDeploymentByID(ctx, job.TargetID)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return jobs.Permanent(err)
+		}
+		return err
+	}
+
+	// An earlier attempt got as far as recording the outcome
+	if dep.Status != models.DeploymentStatusProvisioning {
+		return nil
+	}
+	return ProvisionDeployment(ctx, *dep)
+}
+
+// provisionJobFailed marks a deployment failed when provisioning gave up without
+// recording an outcome, and stops whatever part of it was started.
+func provisionJobFailed(ctx context.Context, job models.Job, err error) {
+	dep, getErr := models.GetDeploymentByID(ctx, job.TargetID)
+	if getErr != nil || dep.Status != models.DeploymentStatusProvisioning {
+		return
+	}
+
+	var logger *deploymentLogger
+	if logFile, openErr := openDeploymentLog(*dep); openErr == nil {
+		defer logFile.Close()
+		logger = &deploymentLogger{writer: logFile}
+	} else {
+		logger = &deploymentLogger{writer: io.Discard}
+	}
+	logger.Log("Provisioning failed after %d attempts: %v", job.Attempts, err)
+	discardRevision(*dep, logger)
+
+	dep.Status = models.DeploymentStatusProvisionFailed
+	dep.Port = nil // Free the port, as a failed revision never serves
+	dep.Touch(events.ActorSystem)
+	models.UpdateDeployment(ctx, *dep)
+	if events.Em != nil {
+		events.Em.DeploymentCreateFailed(dep.ID, err)
+	}
+}
+
+func testJob(ctx context.Context, job models.Job) error {
+	test, err := models.GetTestByID(ctx, job.TargetID)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return jobs.Permanent(err)
+		}
+		return err
+	}
+
+	// Cancelled, or finished by an earlier attempt
+	if test.Status != models.TestStatusRunning {
+		return nil
+	}
+
+	// Create cancellable context for the test
+	testCancelsMu.Lock()
+	testCtx, cancel := context.WithCancel(ctx)
+	testCancels[test.ID] = cancel
+	testCancelsMu.Unlock()
+
+	runTest(testCtx, paths.OpenHackRepoPath(test.StageID), test.StageID, *test)
+	return nil
+}
+
+// testJobFailed records a test whose run could not be completed as errored.
+func testJobFailed(ctx context.Context, job models.Job, err error) {
+	test, getErr := models.GetTestByID(ctx, job.TargetID)
+	if getErr != nil || test.Status != models.TestStatusRunning {
+		return
+	}
+
+	finish := time.Now()
+	_ = models.UpdateTestStatus(ctx, test.ID, models.TestStatusError, &finish, err.Error())
+	if events.Em != nil {
+		events.Em.TestFailed(test.StageID, test.ID, err.Error())
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"hypervisor/internal/db"
+	"hypervisor/internal/models"
 	"os"
 	"strconv"
 
@@ -21,8 +22,17 @@ func AllocatePort(ctx context.Context) (int, error) {
 		end = 29999
 	}
 
-	// Get all used ports
-	cursor, err := db.Deployments.Find(ctx, bson.M{"port": bson.M{"$ne": nil}})
+	// Get all used ports. Failed revisions recorded before their port was cleared on
+	// failure never serve, so their ports are free again.
+	cursor, err := db.Deployments.Find(ctx, bson.M{
+		"port": bson.M{"$ne": nil},
+		"status": bson.M{"$nin": []models.DeploymentStatus{
+			models.DeploymentStatusBuildFailed,
+			models.DeploymentStatusProvisionFailed,
+			models.DeploymentStatusStartFailed,
+			models.DeploymentStatusUnhealthy,
+		}},
+	})
 	if err != nil {
 		return 0, err
 	}
@@ -0,0 +1,250 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"hypervisor/internal/errmsg"
+	"hypervisor/internal/events"
+	"hypervisor/internal/models"
+	"hypervisor/internal/proxy"
+)
+
+// rollbackHistoryDepth bounds how far back the promotion history is searched for a
+// rollback target.
+const rollbackHistoryDepth = 50
+
+const (
+	// promotionLockLease bounds how long a change of main holds the lock shared by
+	// all instances, so an instance that dies mid-change does not block the others.
+	promotionLockLease = 30 * time.Second
+
+	// promotionLockWait is how long a change of main waits for another instance's.
+	promotionLockWait = 10 * time.Second
+)
+
+// promotionMu serialises changes of main on this instance; the promotion lock in
+// MongoDB serialises them across instances.
+var promotionMu sync.Mutex
+
+// promotionOwner identifies this process as the holder of the promotion lock.
+var promotionOwner = fmt.Sprintf("%s-%d", reconcileHostname(), os.Getpid())
+
+// lockPromotions takes promotionMu and the promotion lock, waiting up to
+// promotionLockWait for another instance to finish changing main. The returned
+// function releases both.
+func lockPromotions(ctx context.Context) (func(), error) {
+	promotionMu.Lock()
+
+	deadline := time.Now().Add(promotionLockWait)
+	for {
+		acquired, err := models.AcquirePromotionLock(ctx, promotionOwner, promotionLockLease, time.Now())
+		if err != nil {
+			promotionMu.Unlock()
+			return nil, err
+		}
+		if acquired {
+			break
+		}
+		if time.Now().After(deadline) {
+			promotionMu.Unlock()
+			return nil, errmsg.PromotionInProgress
+		}
+
+		select {
+		case <-ctx.Done():
+			promotionMu.Unlock()
+			return nil, ctx.Err()
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	return func() {
+		if err := models.ReleasePromotionLock(context.Background(), promotionOwner); err != nil {
+			log.Printf("failed to release promotion lock: %v", err)
+		}
+		promotionMu.Unlock()
+	}, nil
+}
+
+// PromoteDeployment makes dep the main deployment, demotes the previous main and
+// records the promotion. With a positive autoRollbackWindow, main is rolled back
+// automatically if dep turns unhealthy within that window.
+func PromoteDeployment(ctx context.Context, dep *models.Deployment, actor string, autoRollbackWindow time.Duration) error {
+	unlock, err := lockPromotions(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return promote(ctx, dep, actor, models.PromotionReasonPromote, autoRollbackWindow)
+}
+
+// RollbackDeployment re-promotes the most recent earlier main that is still ready
+// and healthy, and returns it.
+func RollbackDeployment(ctx context.Context, actor string, reason models.PromotionReason) (*models.Deployment, error) {
+	unlock, err := lockPromotions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	// Another instance may have changed main before its route update reached this one
+	current, err := models.GetPromotedDeployment(ctx)
+	if err != nil {
+		return nil, err
+	}
+	target, err := rollbackTarget(ctx, current)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := promote(ctx, target, actor, reason, 0); err != nil {
+		return nil, err
+	}
+
+	if events.Em != nil {
+		from := models.Deployment{}
+		if current != nil {
+			from = *current
+		}
+		events.Em.DeploymentRolledBack(from, *target, reason)
+	}
+	return target, nil
+}
+
+// rollbackTarget walks the promotion history, newest first, for a deployment other
+// than the current main that can take traffic right now.
+func rollbackTarget(ctx context.Context, current *models.Deployment) (*models.Deployment, error) {
+	promotions, err := models.GetPromotions(ctx, rollbackHistoryDepth)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, promotion := range promotions {
+		if current != nil && promotion.DeploymentID == current.ID {
+			continue
+		}
+
+		dep, exists := proxy.GlobalRouteMap.GetDeploymentByID(promotion.DeploymentID)
+		if !exists || dep.Port == nil || proxy.GlobalRouteMap.GetHealth(dep.ID).Status == models.HealthStatusUnhealthy {
+			continue
+		}
+
+		// The route map holds a shared copy; promote works on the stored document
+		return models.GetDeploymentByID(ctx, dep.ID)
+	}
+
+	return nil, errmsg.NoRollbackTarget
+}
+
+// checkPromotable rejects a deployment that cannot take root traffic: one that is
+// not ready, was retired by a newer revision of its stage or fails health checks.
+// The route map would never make it main, so promoting it would only demote the
+// deployment serving root traffic.
+func checkPromotable(rm *proxy.RouteMap, dep *models.Deployment) error {
+	if dep.Status != models.DeploymentStatusReady || dep.Port == nil {
+		return errmsg.DeploymentNotReady
+	}
+	if routed, exists := rm.GetDeployment(dep.StageID); exists && routed.ID != dep.ID && routed.Revision > dep.Revision {
+		return errmsg.DeploymentSuperseded
+	}
+	if rm.GetHealth(dep.ID).Status == models.HealthStatusUnhealthy {
+		return errmsg.CannotPromoteUnhealthyDeployment
+	}
+	return nil
+}
+
+// promote switches main to dep. dep is written first so there is a main at every
+// point, then the previous main is demoted. Callers must hold the lock taken by
+// lockPromotions.
+func promote(ctx context.Context, dep *models.Deployment, actor string, reason models.PromotionReason, autoRollbackWindow time.Duration) error {
+	if err := checkPromotable(proxy.GlobalRouteMap, dep); err != nil {
+		return err
+	}
+
+	// The stored main is current under the lock; the route map may still lag behind
+	// a change made by another instance
+	previous, err := models.GetPromotedDeployment(ctx)
+	if err != nil {
+		return err
+	}
+	hadMain := previous != nil
+
+	now := time.Now()
+	dep.PromotedAt = &now
+	dep.CanaryWeight = 0 // A promoted deployment receives all root traffic
+	dep.Mirror = nil
+	dep.Touch(actor)
+	if err := models.UpdateDeployment(ctx, *dep); err != nil {
+		return err
+	}
+	proxy.GlobalRouteMap.UpdateDeployment(dep)
+
+	if err := models.DemoteDeployments(ctx, dep.ID); err != nil {
+		return err
+	}
+
+	promotion := models.Promotion{
+		DeploymentID: dep.ID,
+		StageID:      dep.StageID,
+		Reason:       reason,
+		PromotedBy:   actor,
+		PromotedAt:   now,
+	}
+	if hadMain && previous.ID != dep.ID {
+		demoted := *previous
+		demoted.PromotedAt = nil
+		demoted.Touch(actor)
+		proxy.GlobalRouteMap.UpdateDeployment(&demoted)
+
+		promotion.PreviousID = previous.ID
+		if autoRollbackWindow > 0 {
+			until := now.Add(autoRollbackWindow)
+			promotion.AutoRollbackUntil = &until
+		}
+	}
+
+	if err := models.RecordPromotion(ctx, promotion); err != nil {
+		return err
+	}
+
+	if events.Em != nil {
+		events.Em.DeploymentPromoted(*dep)
+	}
+	return nil
+}
+
+// StartAutoRollback rolls main back when it turns unhealthy inside the grace window
+// of its promotion. Every instance watches, but only the one that claims the
+// rollback acts on it.
+func StartAutoRollback() {
+	proxy.GlobalRouteMap.OnHealthChange(func(dep models.Deployment, health models.DeploymentHealth) {
+		if health.Status != models.HealthStatusUnhealthy || dep.PromotedAt == nil {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		claimed, err := models.ClaimAutoRollback(ctx, dep.ID, time.Now())
+		if err != nil {
+			log.Printf("failed to claim automatic rollback of %s: %v", dep.ID, err)
+			return
+		}
+		if !claimed {
+			return
+		}
+
+		target, err := RollbackDeployment(ctx, events.ActorSystem, models.PromotionReasonAutoRollback)
+		if err != nil {
+			log.Printf("automatic rollback of %s failed: %v", dep.ID, err)
+			return
+		}
+		log.Printf("main deployment %s turned unhealthy during its grace window (%s); rolled back to %s", dep.ID, health.LastError, target.ID)
+	})
+}
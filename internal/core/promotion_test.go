@@ -0,0 +1,38 @@
+package core
+
+import (
+	"errors"
+	"testing"
+
+	"hypervisor/internal/errmsg"
+	"hypervisor/internal/models"
+	"hypervisor/internal/proxy"
+)
+
+func TestCheckPromotable(t *testing.T) {
+	port := 20001
+	rm := proxy.NewRouteMap()
+	rm.UpdateDeployment(&models.Deployment{ID: "stage-a-r2", StageID: "stage-a", Revision: 2, Status: models.DeploymentStatusReady, Port: &port})
+
+	tests := []struct {
+		name string
+		dep  models.Deployment
+		want error
+	}{
+		{"routed revision", models.Deployment{ID: "stage-a-r2", StageID: "stage-a", Revision: 2, Status: models.DeploymentStatusReady, Port: &port}, nil},
+		{"new revision taking over", models.Deployment{ID: "stage-a-r3", StageID: "stage-a", Revision: 3, Status: models.DeploymentStatusReady, Port: &port}, nil},
+		{"other stage", models.Deployment{ID: "stage-b", StageID: "stage-b", Status: models.DeploymentStatusReady, Port: &port}, nil},
+		{"retired revision", models.Deployment{ID: "stage-a-r1", StageID: "stage-a", Revision: 1, Status: models.DeploymentStatusReady, Port: &port}, errmsg.DeploymentSuperseded},
+		{"superseded", models.Deployment{ID: "stage-a-r1", StageID: "stage-a", Revision: 1, Status: models.DeploymentStatusSuperseded}, errmsg.DeploymentNotReady},
+		{"stopped", models.Deployment{ID: "stage-c", StageID: "stage-c", Status: models.DeploymentStatusStopped, Port: &port}, errmsg.DeploymentNotReady},
+		{"build failed", models.Deployment{ID: "stage-c", StageID: "stage-c", Status: models.DeploymentStatusBuildFailed}, errmsg.DeploymentNotReady},
+		{"crashlooping", models.Deployment{ID: "stage-c", StageID: "stage-c", Status: models.DeploymentStatusCrashLooping, Port: &port}, errmsg.DeploymentNotReady},
+		{"ready without port", models.Deployment{ID: "stage-c", StageID: "stage-c", Status: models.DeploymentStatusReady}, errmsg.DeploymentNotReady},
+	}
+
+	for _, tt := range tests {
+		if err := checkPromotable(rm, &tt.dep); !errors.Is(err, tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, err)
+		}
+	}
+}
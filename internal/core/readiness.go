@@ -0,0 +1,113 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"hypervisor/internal/env"
+	"hypervisor/internal/journal"
+	"hypervisor/internal/models"
+	"hypervisor/internal/proxy"
+	"hypervisor/internal/supervisor"
+)
+
+// readinessPollInterval spaces the checks of a deployment's readiness phase. It is
+// shorter than the unit's RestartSec so a crashing backend is caught between restarts.
+// It is a variable so tests can shorten it.
+var readinessPollInterval = 500 * time.Millisecond
+
+// readinessJournalLines is how much of the unit's journal a failed readiness phase
+// copies into the deployment log.
+const readinessJournalLines = 50
+
+// verifyReadiness runs once the unit is started. The backend must answer its health
+// path within DEPLOYMENT_STARTUP_TIMEOUT, then its unit must stay active for
+// DEPLOYMENT_READY_STABLE_PERIOD and the health path must still answer at the end.
+// On failure it returns the status the deployment ends up in: start_failed when the
+// unit would not stay up, unhealthy when it ran but never answered.
+func verifyReadiness(ctx context.Context, dep *models.Deployment, logger *deploymentLogger) (models.DeploymentStatus, error) {
+	ticker := time.NewTicker(readinessPollInterval)
+	defer ticker.Stop()
+
+	logger.Log("Waiting up to %s for the backend to answer %s...", env.DEPLOYMENT_STARTUP_TIMEOUT, proxy.HealthPath(dep))
+	deadline := time.Now().Add(env.DEPLOYMENT_STARTUP_TIMEOUT)
+	for {
+		state, err := serviceActiveState(dep.ID)
+		if err != nil {
+			return models.DeploymentStatusStartFailed, err
+		}
+		if state == "failed" {
+			return models.DeploymentStatusStartFailed, fmt.Errorf("unit failed to start")
+		}
+
+		var probeErr error
+		if state == "active" {
+			if probeErr = proxy.Probe(dep); probeErr == nil {
+				break
+			}
+		}
+
+		if time.Now().After(deadline) {
+			if state != "active" {
+				return models.DeploymentStatusStartFailed, fmt.Errorf("unit still %s after %s", state, env.DEPLOYMENT_STARTUP_TIMEOUT)
+			}
+			return models.DeploymentStatusUnhealthy, fmt.Errorf("no healthy answer after %s: %w", env.DEPLOYMENT_STARTUP_TIMEOUT, probeErr)
+		}
+
+		select {
+		case <-ctx.Done():
+			return models.DeploymentStatusStartFailed, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	logger.Log("Backend answered its health path")
+
+	// A backend that crashes shortly after boot is restarted by the supervisor, so a single
+	// good answer is not enough
+	logger.Log("Checking that the unit stays active for %s...", env.DEPLOYMENT_READY_STABLE_PERIOD)
+	stableUntil := time.Now().Add(env.DEPLOYMENT_READY_STABLE_PERIOD)
+	for time.Now().Before(stableUntil) {
+		select {
+		case <-ctx.Done():
+			return models.DeploymentStatusStartFailed, ctx.Err()
+		case <-ticker.C:
+		}
+
+		state, err := serviceActiveState(dep.ID)
+		if err != nil {
+			return models.DeploymentStatusStartFailed, err
+		}
+		if state != "active" {
+			return models.DeploymentStatusStartFailed, fmt.Errorf("unit became %s during the stable period", state)
+		}
+	}
+
+	if err := proxy.Probe(dep); err != nil {
+		return models.DeploymentStatusUnhealthy, fmt.Errorf("health check failed after the stable period: %w", err)
+	}
+	return models.DeploymentStatusReady, nil
+}
+
+// serviceActiveState returns the ActiveState of a deployment's service.
+func serviceActiveState(deploymentID string) (string, error) {
+	state, err := supervisor.Default.State(deploymentID)
+	if err != nil {
+		return "", err
+	}
+	return state.ActiveState, nil
+}
+
+// attachJournal copies the last lines of the service's output into the deployment log.
+func attachJournal(dep models.Deployment, logger *deploymentLogger, lines int) {
+	entries, err := supervisor.Default.Logs(context.Background(), dep.ID, journal.Query{Lines: lines})
+	if err != nil {
+		logger.Log("Failed to read the unit's journal: %v", err)
+		return
+	}
+
+	logger.Log("Last %d journal lines of %s:", lines, supervisor.Default.ServiceName(dep.ID))
+	for _, entry := range entries {
+		fmt.Fprintf(logger.writer, "%s %s\n", entry.Time.Format(time.RFC3339), entry.Message)
+	}
+}
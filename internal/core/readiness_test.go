@@ -0,0 +1,135 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"hypervisor/internal/env"
+	"hypervisor/internal/models"
+	"hypervisor/internal/supervisor"
+)
+
+// fakeSupervisor reports the given unit states in turn, repeating the last one.
+type fakeSupervisor struct {
+	supervisor.Supervisor
+
+	mu     sync.Mutex
+	states []string
+	err    error
+}
+
+func (f *fakeSupervisor) State(deploymentID string) (supervisor.State, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.err != nil {
+		return supervisor.State{}, f.err
+	}
+	state := f.states[0]
+	if len(f.states) > 1 {
+		f.states = f.states[1:]
+	}
+	return supervisor.State{ActiveState: state}, nil
+}
+
+// withReadinessTimings shortens the readiness phase for the test.
+func withReadinessTimings(t *testing.T) {
+	t.Helper()
+
+	interval, startup, stable, timeout := readinessPollInterval, env.DEPLOYMENT_STARTUP_TIMEOUT, env.DEPLOYMENT_READY_STABLE_PERIOD, env.DEPLOYMENT_HEALTH_TIMEOUT
+	previous := supervisor.Default
+	t.Cleanup(func() {
+		readinessPollInterval, env.DEPLOYMENT_STARTUP_TIMEOUT, env.DEPLOYMENT_READY_STABLE_PERIOD, env.DEPLOYMENT_HEALTH_TIMEOUT = interval, startup, stable, timeout
+		supervisor.Default = previous
+	})
+
+	readinessPollInterval = 10 * time.Millisecond
+	env.DEPLOYMENT_STARTUP_TIMEOUT = 200 * time.Millisecond
+	env.DEPLOYMENT_READY_STABLE_PERIOD = 100 * time.Millisecond
+	env.DEPLOYMENT_HEALTH_TIMEOUT = time.Second
+}
+
+// healthBackend answers the health path with the given statuses in turn, repeating
+// the last one. It returns its port.
+func healthBackend(t *testing.T, statuses ...int) int {
+	t.Helper()
+
+	var requests atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(requests.Add(1))
+		w.WriteHeader(statuses[min(n, len(statuses))-1])
+	}))
+	t.Cleanup(server.Close)
+
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatalf("Failed to parse backend URL: %v", err)
+	}
+	port, err := strconv.Atoi(u.Port())
+	if err != nil {
+		t.Fatalf("Failed to parse backend port: %v", err)
+	}
+	return port
+}
+
+func TestVerifyReadiness(t *testing.T) {
+	withReadinessTimings(t)
+
+	active := []string{"active"}
+	ok := []int{http.StatusOK}
+	tests := []struct {
+		name     string
+		states   []string
+		err      error
+		statuses []int
+		want     models.DeploymentStatus
+	}{
+		{"healthy", active, nil, ok, models.DeploymentStatusReady},
+		{"slow to activate", []string{"activating", "activating", "active"}, nil, ok, models.DeploymentStatusReady},
+		{"answers after a few probes", active, nil, []int{http.StatusServiceUnavailable, http.StatusBadGateway, http.StatusOK}, models.DeploymentStatusReady},
+		{"unit failed", []string{"activating", "failed"}, nil, ok, models.DeploymentStatusStartFailed},
+		{"unit never active", []string{"activating"}, nil, ok, models.DeploymentStatusStartFailed},
+		{"supervisor error", active, errors.New("dbus unavailable"), ok, models.DeploymentStatusStartFailed},
+		{"never answers", active, nil, []int{http.StatusServiceUnavailable}, models.DeploymentStatusUnhealthy},
+		{"restarted during the stable period", []string{"active", "active", "activating", "active"}, nil, ok, models.DeploymentStatusStartFailed},
+		{"stops answering after the stable period", active, nil, []int{http.StatusOK, http.StatusServiceUnavailable}, models.DeploymentStatusUnhealthy},
+	}
+
+	for _, tt := range tests {
+		supervisor.Default = &fakeSupervisor{states: tt.states, err: tt.err}
+		port := healthBackend(t, tt.statuses...)
+		dep := &models.Deployment{ID: "stage-a-r1", StageID: "stage-a", Port: &port, HealthPath: "/meta/ping"}
+
+		status, err := verifyReadiness(context.Background(), dep, &deploymentLogger{writer: io.Discard})
+		if status != tt.want {
+			t.Errorf("%s: expected %s, got %s (%v)", tt.name, tt.want, status, err)
+		}
+		if (err == nil) != (tt.want == models.DeploymentStatusReady) {
+			t.Errorf("%s: expected an error only when not ready, got %v", tt.name, err)
+		}
+	}
+}
+
+func TestVerifyReadinessCancelled(t *testing.T) {
+	withReadinessTimings(t)
+	supervisor.Default = &fakeSupervisor{states: []string{"activating"}}
+	port := healthBackend(t, http.StatusOK)
+	dep := &models.Deployment{ID: "stage-a-r1", StageID: "stage-a", Port: &port, HealthPath: "/meta/ping"}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	status, err := verifyReadiness(ctx, dep, &deploymentLogger{writer: io.Discard})
+	if status != models.DeploymentStatusStartFailed || !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected start_failed with the context error, got %s (%v)", status, err)
+	}
+}
@@ -0,0 +1,476 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"hypervisor/internal/db"
+	"hypervisor/internal/env"
+	"hypervisor/internal/errmsg"
+	"hypervisor/internal/events"
+	"hypervisor/internal/models"
+	"hypervisor/internal/proxy"
+	"hypervisor/internal/supervisor"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// reconcileSettlePeriod leaves recently changed deployments alone: a handler may be
+// between changing the unit and recording the deployment's new status.
+const reconcileSettlePeriod = time.Minute
+
+// reconcileLockTTL bounds how long a pass holds the lock shared by all instances, so
+// an instance that dies mid-pass does not block the others.
+const reconcileLockTTL = 5 * time.Minute
+
+// reconcileMu keeps the periodic pass and one requested over the API apart.
+var reconcileMu sync.Mutex
+
+// reconcileOwner identifies this process as the holder of the reconcile lock.
+var reconcileOwner = fmt.Sprintf("%s-%d", reconcileHostname(), os.Getpid())
+
+func reconcileHostname() string {
+	name, err := os.Hostname()
+	if err != nil {
+		return "unknown"
+	}
+	return name
+}
+
+func reconcileLockKey() string {
+	return fmt.Sprintf("hypervisor:%s:reconcile", db.Name)
+}
+
+// releaseReconcileLock deletes the lock only while this process still holds it: a
+// pass that outlived reconcileLockTTL must not release another instance's lock.
+var releaseReconcileLock = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// StartReconciler runs a reconciliation pass at boot and then every
+// RECONCILE_INTERVAL, unless the interval is zero.
+func StartReconciler(ctx context.Context) {
+	go func() {
+		runReconcile(ctx)
+		if env.RECONCILE_INTERVAL <= 0 {
+			return
+		}
+
+		ticker := time.NewTicker(env.RECONCILE_INTERVAL)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				runReconcile(ctx)
+			}
+		}
+	}()
+}
+
+func runReconcile(ctx context.Context) {
+	// An instance being drained leaves the units to the one replacing it
+	if env.DRAIN_MODE {
+		return
+	}
+
+	report, err := Reconcile(ctx)
+	if errors.Is(err, errmsg.ReconcileInProgress) {
+		return
+	}
+	if err != nil {
+		log.Printf("reconciliation failed: %v", err)
+		return
+	}
+	if len(report.Findings) > 0 {
+		log.Printf("reconciliation checked %d deployments: %d findings", report.Checked, len(report.Findings))
+	}
+}
+
+// Reconcile compares every deployment's recorded status and port with its supervised
+// unit, its unit file, its binary and the route map. What can be brought in line
+// without interrupting traffic is fixed; the rest is flagged and the deployment marked
+// drifted. Only one instance runs a pass at a time; the others get ReconcileInProgress.
+func Reconcile(ctx context.Context) (*models.ReconcileReport, error) {
+	if !reconcileMu.TryLock() {
+		return nil, errmsg.ReconcileInProgress
+	}
+	defer reconcileMu.Unlock()
+
+	if db.RDB != nil {
+		acquired, err := db.RDB.SetNX(ctx, reconcileLockKey(), reconcileOwner, reconcileLockTTL).Result()
+		if err != nil {
+			return nil, err
+		}
+		if !acquired {
+			return nil, errmsg.ReconcileInProgress
+		}
+		defer func() {
+			if err := releaseReconcileLock.Run(context.Background(), db.RDB, []string{reconcileLockKey()}, reconcileOwner).Err(); err != nil {
+				log.Printf("reconcile: failed to release lock: %v", err)
+			}
+		}()
+	}
+
+	r := &reconciler{report: models.ReconcileReport{
+		StartedAt: time.Now(),
+		RanBy:     reconcileOwner,
+		Findings:  []models.ReconcileFinding{},
+	}}
+
+	deployments, err := models.GetAllDeployments(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	known := make(map[string]bool, len(deployments))
+	ports := make(map[int]string)
+	for i := range deployments {
+		dep := &deployments[i]
+		known[supervisor.Default.ServiceName(dep.ID)] = true
+
+		if dep.UpdatedAt != nil && time.Since(*dep.UpdatedAt) < reconcileSettlePeriod {
+			continue
+		}
+		r.report.Checked++
+		r.deployment(ctx, dep, ports)
+	}
+
+	r.orphanUnits(known)
+	r.routeMap(ctx)
+
+	previous, err := models.GetReconcileReport(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	r.report.FinishedAt = time.Now()
+	if err := models.SaveReconcileReport(ctx, r.report); err != nil {
+		return nil, err
+	}
+
+	// Flagged issues are found again by every pass until an operator acts on them
+	if r.changedSince(previous) && events.Em != nil {
+		events.Em.ReconcileCompleted(r.report)
+	}
+	return &r.report, nil
+}
+
+// reconciler collects the findings of one pass.
+type reconciler struct {
+	report models.ReconcileReport
+}
+
+func (r *reconciler) record(dep *models.Deployment, unit, issue string, action models.ReconcileAction, format string, args ...any) {
+	finding := models.ReconcileFinding{
+		Unit:   unit,
+		Issue:  issue,
+		Detail: fmt.Sprintf(format, args...),
+		Action: action,
+	}
+	if dep != nil {
+		finding.DeploymentID = dep.ID
+		finding.Unit = supervisor.Default.ServiceName(dep.ID)
+	}
+	r.report.Findings = append(r.report.Findings, finding)
+}
+
+// changedSince reports whether the pass fixed something or flagged an issue the
+// previous pass had not.
+func (r *reconciler) changedSince(previous *models.ReconcileReport) bool {
+	seen := make(map[models.ReconcileFinding]bool)
+	if previous != nil {
+		for _, finding := range previous.Findings {
+			finding.Detail = ""
+			seen[finding] = true
+		}
+	}
+
+	for _, finding := range r.report.Findings {
+		finding.Detail = ""
+		if finding.Action == models.ReconcileActionFixed || !seen[finding] {
+			return true
+		}
+	}
+	return false
+}
+
+// deployment reconciles one deployment against its unit. A drifted deployment is
+// checked against the status it had before it drifted.
+func (r *reconciler) deployment(ctx context.Context, dep *models.Deployment, ports map[int]string) {
+	expected := dep.Status
+	if dep.Status == models.DeploymentStatusDrifted && dep.Drift != nil {
+		expected = dep.Drift.From
+	}
+
+	var installed bool
+	switch expected {
+	case models.DeploymentStatusReady, models.DeploymentStatusStopped:
+		installed = true
+	case models.DeploymentStatusSuperseded,
+		models.DeploymentStatusBuildFailed,
+		models.DeploymentStatusProvisionFailed,
+		models.DeploymentStatusStartFailed,
+		models.DeploymentStatusUnhealthy:
+	default:
+		// Provisioning and draining deployments are changing under a job or handler,
+		// and a crashlooping one is left to an operator
+		return
+	}
+
+	state, err := supervisor.Default.State(dep.ID)
+	if err != nil {
+		log.Printf("reconcile: cannot inspect unit of %s: %v", dep.ID, err)
+		return
+	}
+
+	var issues []string
+	serving := false
+	if installed {
+		issues, serving = r.installed(dep, expected, state, ports)
+	} else {
+		issues = r.removed(dep, expected, state)
+	}
+
+	r.settle(ctx, dep, expected, issues, serving)
+}
+
+// installed checks a deployment whose unit should be installed, running if it is
+// ready. It returns the issues left unfixed and whether the deployment still serves.
+func (r *reconciler) installed(dep *models.Deployment, expected models.DeploymentStatus, state supervisor.State, ports map[int]string) ([]string, bool) {
+	var issues []string
+	flag := func(issue, format string, args ...any) {
+		r.record(dep, "", issue, models.ReconcileActionFlagged, format, args...)
+		issues = append(issues, issue)
+	}
+
+	if dep.Port == nil {
+		flag("port_missing", "recorded %s without a port", expected)
+		return issues, false
+	}
+	if other, taken := ports[*dep.Port]; taken {
+		flag("port_conflict", "port %d is also recorded for %s", *dep.Port, other)
+	}
+	ports[*dep.Port] = dep.ID
+
+	binary := binaryPath(*dep)
+	_, err := os.Stat(binary)
+	binaryMissing := errors.Is(err, os.ErrNotExist)
+	if binaryMissing {
+		flag("binary_missing", "%s does not exist", binary)
+	}
+
+	// Rewriting the unit file only takes effect on the next restart, so it is safe
+	// while the backend runs
+	cfg := backendServiceConfig(*dep)
+	want, err := supervisor.Default.Render(cfg)
+	if err != nil {
+		log.Printf("reconcile: cannot render unit of %s: %v", dep.ID, err)
+		return issues, false
+	}
+	have, err := supervisor.Default.Definition(dep.ID)
+	if issue := unitFileIssue(have, want, err); issue != "" {
+		if err := supervisor.Default.Rewrite(cfg); err != nil {
+			flag(issue, "rewriting the unit file failed: %v", err)
+		} else {
+			r.record(dep, "", issue, models.ReconcileActionFixed, "unit file rewritten from the recorded port %d and version %s", *dep.Port, dep.Version)
+		}
+	}
+
+	active := state.ActiveState == "active"
+	if expected == models.DeploymentStatusStopped {
+		if active {
+			flag("unit_active", "recorded stopped but the unit is %s", state.SubState)
+		}
+		return issues, false
+	}
+
+	if state.UnitFileState != "enabled" {
+		if err := supervisor.Default.Enable(dep.ID); err != nil {
+			flag("unit_disabled", "enabling the unit failed: %v", err)
+		} else {
+			r.record(dep, "", "unit_disabled", models.ReconcileActionFixed, "unit was %s, enabled it", orUnknown(state.UnitFileState))
+		}
+	}
+
+	// An activating unit is starting or restarting after a crash; the supervisor handles it
+	if state.ActiveState == "inactive" || state.ActiveState == "failed" {
+		switch {
+		case binaryMissing:
+			flag("unit_inactive", "recorded ready but the unit is %s and cannot start without its binary", state.ActiveState)
+		default:
+			if err := supervisor.Default.Start(dep.ID); err != nil {
+				flag("unit_inactive", "recorded ready but the unit is %s; starting it failed: %v", state.ActiveState, err)
+			} else {
+				active = true
+				r.record(dep, "", "unit_inactive", models.ReconcileActionFixed, "unit was %s, started it", state.ActiveState)
+			}
+		}
+	}
+
+	return issues, active || state.ActiveState == "activating"
+}
+
+// unitFileIssue compares an installed unit file with the one the deployment's
+// recorded state renders.
+func unitFileIssue(have, want []byte, readErr error) string {
+	switch {
+	case errors.Is(readErr, os.ErrNotExist):
+		return "unit_missing"
+	case readErr != nil:
+		log.Printf("reconcile: cannot read unit file: %v", readErr)
+		return ""
+	case !bytes.Equal(have, want):
+		return "unit_changed"
+	}
+	return ""
+}
+
+// removed checks that a superseded or failed deployment left no unit behind.
+func (r *reconciler) removed(dep *models.Deployment, expected models.DeploymentStatus, state supervisor.State) []string {
+	_, readErr := supervisor.Default.Definition(dep.ID)
+	running := state.ActiveState == "active" || state.ActiveState == "activating"
+	if errors.Is(readErr, os.ErrNotExist) && !running {
+		return nil
+	}
+
+	err := supervisor.Default.Stop(dep.ID)
+	if err == nil {
+		err = supervisor.Default.Remove(dep.ID)
+	}
+	if err != nil {
+		r.record(dep, "", "unit_lingering", models.ReconcileActionFlagged, "recorded %s but the unit is %s; removing it failed: %v", expected, state.ActiveState, err)
+		return []string{"unit_lingering"}
+	}
+
+	r.record(dep, "", "unit_lingering", models.ReconcileActionFixed, "recorded %s but the unit was %s; stopped and removed it", expected, state.ActiveState)
+	return nil
+}
+
+// settle records the issues left on a deployment. A deployment that is not serving is
+// marked drifted; one whose issues are gone gets its previous status back.
+func (r *reconciler) settle(ctx context.Context, dep *models.Deployment, expected models.DeploymentStatus, issues []string, serving bool) {
+	status := expected
+	var drift *models.DriftState
+	if len(issues) > 0 {
+		drift = &models.DriftState{From: expected, Issues: issues, DetectedAt: time.Now()}
+		if dep.Drift != nil {
+			drift.DetectedAt = dep.Drift.DetectedAt
+		}
+		if !serving {
+			status = models.DeploymentStatusDrifted
+		}
+	}
+
+	if status == dep.Status && slices.Equal(issues, dep.DriftIssues()) {
+		return
+	}
+
+	if drift == nil {
+		r.record(dep, "", "drift_resolved", models.ReconcileActionFixed, "unit matches the recorded %s status again", expected)
+	}
+
+	dep.Status = status
+	dep.Drift = drift
+	dep.Touch(events.ActorSystem)
+	if err := models.UpdateDeployment(ctx, *dep); err != nil {
+		log.Printf("reconcile: failed to record drift of %s: %v", dep.ID, err)
+		return
+	}
+	proxy.GlobalRouteMap.UpdateDeployment(dep)
+}
+
+// orphanUnits flags backend unit files no deployment is recorded for. They are left
+// in place: nothing tells whether they still serve something.
+func (r *reconciler) orphanUnits(known map[string]bool) {
+	units, err := supervisor.Default.List()
+	if err != nil {
+		log.Printf("reconcile: cannot list unit files: %v", err)
+		return
+	}
+
+	for _, unit := range units {
+		if !known[unit] {
+			r.record(nil, unit, "orphan_unit", models.ReconcileActionFlagged, "no deployment is recorded for this unit")
+		}
+	}
+}
+
+// routeMap reloads this instance's route map when it no longer routes each stage to
+// its newest ready revision on the recorded port.
+func (r *reconciler) routeMap(ctx context.Context) {
+	deployments, err := models.GetAllDeployments(ctx)
+	if err != nil {
+		log.Printf("reconcile: cannot load deployments: %v", err)
+		return
+	}
+
+	want := make(map[string]models.Deployment)
+	unsettled := make(map[string]bool)
+	for _, dep := range deployments {
+		if dep.UpdatedAt != nil && time.Since(*dep.UpdatedAt) < reconcileSettlePeriod {
+			unsettled[dep.StageID] = true
+		}
+		if dep.Status != models.DeploymentStatusReady {
+			continue
+		}
+		if current, exists := want[dep.StageID]; exists && current.Revision > dep.Revision {
+			continue
+		}
+		want[dep.StageID] = dep
+	}
+
+	routed := make(map[string]proxy.RouteEntry)
+	for _, entry := range proxy.GlobalRouteMap.Snapshot().Stages {
+		routed[entry.StageID] = entry
+	}
+
+	var stale []string
+	for stageID, dep := range want {
+		entry, exists := routed[stageID]
+		if !unsettled[stageID] && (!exists || entry.DeploymentID != dep.ID || !samePort(entry.Port, dep.Port)) {
+			stale = append(stale, stageID)
+		}
+	}
+	for stageID := range routed {
+		if _, exists := want[stageID]; !exists && !unsettled[stageID] {
+			stale = append(stale, stageID)
+		}
+	}
+	if len(stale) == 0 {
+		return
+	}
+
+	slices.Sort(stale)
+	if err := proxy.GlobalRouteMap.LoadFromDatabase(ctx); err != nil {
+		r.record(nil, "", "route_map_stale", models.ReconcileActionFlagged, "stages %v; reloading the route map failed: %v", stale, err)
+		return
+	}
+	r.record(nil, "", "route_map_stale", models.ReconcileActionFixed, "stages %v were routed differently than recorded; reloaded the route map", stale)
+}
+
+func samePort(a, b *int) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+func orUnknown(s string) string {
+	if s == "" {
+		return "unknown"
+	}
+	return s
+}
@@ -0,0 +1,65 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"testing"
+
+	"hypervisor/internal/models"
+)
+
+func TestUnitFileIssue(t *testing.T) {
+	unit := []byte("[Service]\nExecStart=/opt/bin/app\n")
+
+	tests := []struct {
+		name    string
+		have    []byte
+		readErr error
+		want    string
+	}{
+		{"same", []byte("[Service]\nExecStart=/opt/bin/app\n"), nil, ""},
+		{"changed", []byte("[Service]\nExecStart=/tmp/other\n"), nil, "unit_changed"},
+		{"missing", nil, fmt.Errorf("read unit: %w", os.ErrNotExist), "unit_missing"},
+		{"missing path error", nil, &fs.PathError{Op: "open", Path: "/etc/systemd/system/x.service", Err: fs.ErrNotExist}, "unit_missing"},
+		{"unreadable", nil, errors.New("permission denied"), ""},
+	}
+
+	for _, tt := range tests {
+		if got := unitFileIssue(tt.have, unit, tt.readErr); got != tt.want {
+			t.Errorf("%s: expected %q, got %q", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestReconcilerChangedSince(t *testing.T) {
+	flagged := models.ReconcileFinding{DeploymentID: "dep", Issue: "unit_changed", Detail: "first pass", Action: models.ReconcileActionFlagged}
+	fixed := models.ReconcileFinding{DeploymentID: "dep", Issue: "unit_inactive", Action: models.ReconcileActionFixed}
+	previous := &models.ReconcileReport{Findings: []models.ReconcileFinding{flagged}}
+
+	tests := []struct {
+		name     string
+		findings []models.ReconcileFinding
+		previous *models.ReconcileReport
+		want     bool
+	}{
+		{"clean pass", nil, previous, false},
+		{"first pass with issue", []models.ReconcileFinding{flagged}, nil, true},
+		{"same issue flagged again", []models.ReconcileFinding{withDetail(flagged, "second pass")}, previous, false},
+		{"new issue", []models.ReconcileFinding{flagged, {DeploymentID: "other", Issue: "unit_changed", Action: models.ReconcileActionFlagged}}, previous, true},
+		{"fixed again", []models.ReconcileFinding{fixed}, &models.ReconcileReport{Findings: []models.ReconcileFinding{fixed}}, true},
+	}
+
+	for _, tt := range tests {
+		r := &reconciler{report: models.ReconcileReport{Findings: tt.findings}}
+		if got := r.changedSince(tt.previous); got != tt.want {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+}
+
+func withDetail(finding models.ReconcileFinding, detail string) models.ReconcileFinding {
+	finding.Detail = detail
+	return finding
+}
@@ -0,0 +1,148 @@
+package core
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"hypervisor/internal/env"
+	"hypervisor/internal/events"
+	"hypervisor/internal/models"
+	"hypervisor/internal/proxy"
+	"hypervisor/internal/supervisor"
+)
+
+// handOver makes a freshly provisioned and healthy revision the one serving its stage,
+// then retires the revisions it replaces. The stage's route moves to dep before the
+// previous revision is drained and stopped, so the stage is served throughout.
+func handOver(ctx context.Context, dep *models.Deployment, logger *deploymentLogger) error {
+	revisions, err := models.GetStageDeployments(ctx, dep.StageID)
+	if err != nil {
+		return err
+	}
+
+	previous, routed := takeOver(dep, revisions)
+
+	if routed != nil && routed.PromotedAt != nil {
+		logger.Log("Previous revision %s is main, promoting %s in its place", routed.ID, dep.ID)
+		unlock, err := lockPromotions(ctx)
+		if err != nil {
+			return err
+		}
+		err = promote(ctx, dep, events.ActorSystem, models.PromotionReasonRedeploy, 0)
+		unlock()
+		if err != nil {
+			return err
+		}
+	} else {
+		if err := models.UpdateDeployment(ctx, *dep); err != nil {
+			return err
+		}
+
+		// Make the deployment immediately routable at /<stageID>/* by updating the route map.
+		// Do NOT set PromotedAt here — promotion to main must be explicit.
+		proxy.GlobalRouteMap.UpdateDeployment(dep)
+	}
+
+	for i := range previous {
+		logger.Log("Retiring previous revision %s...", previous[i].ID)
+		if err := retireRevision(ctx, &previous[i], dep); err != nil {
+			// The new revision is already serving; a revision left running is reported, not fatal
+			logger.Log("Failed to retire revision %s: %v", previous[i].ID, err)
+			continue
+		}
+		logger.Log("Revision %s drained, stopped and marked superseded", previous[i].ID)
+	}
+
+	return nil
+}
+
+// takeOver picks the earlier revisions of dep's stage that the hand-over retires,
+// and the ready one among them that routes the stage, whose hostnames, policy,
+// proxy settings and canary weight dep inherits. dep becomes ready either way.
+func takeOver(dep *models.Deployment, revisions []models.Deployment) ([]models.Deployment, *models.Deployment) {
+	var previous []models.Deployment
+	var routed *models.Deployment
+	for _, revision := range revisions {
+		if revision.ID == dep.ID || revision.Revision > dep.Revision {
+			continue
+		}
+
+		switch revision.Status {
+		case models.DeploymentStatusReady, models.DeploymentStatusDraining, models.DeploymentStatusStopped:
+			previous = append(previous, revision)
+			if routed == nil && revision.Status == models.DeploymentStatusReady {
+				routed = &previous[len(previous)-1]
+			}
+		}
+	}
+
+	dep.Status = models.DeploymentStatusReady
+	dep.Touch(events.ActorSystem)
+
+	if routed != nil {
+		// The new revision takes over how the stage is reached
+		dep.Supersedes = routed.ID
+		dep.Hostnames = routed.Hostnames
+		dep.Policy = routed.Policy
+		dep.Proxy = routed.Proxy
+		dep.CanaryWeight = routed.CanaryWeight
+	} else if len(previous) > 0 {
+		dep.Supersedes = previous[0].ID
+	}
+	return previous, routed
+}
+
+// retireRevision drains and stops a revision replaced by next and removes its unit.
+// The deployment document is kept, marked superseded, for audit.
+func retireRevision(ctx context.Context, dep *models.Deployment, next *models.Deployment) error {
+	// Main has already moved to next; do not write the stale promotion back
+	dep.PromotedAt = nil
+	dep.CanaryWeight = 0
+	dep.Mirror = nil
+	dep.Touch(events.ActorSystem)
+
+	if dep.Status == models.DeploymentStatusReady {
+		if err := DrainDeployment(ctx, dep, env.DEPLOYMENT_DRAIN_TIMEOUT); err != nil {
+			return err
+		}
+	}
+
+	if err := supervisor.Default.Stop(dep.ID); err != nil {
+		// Left running, the revision keeps its status rather than draining forever
+		if dep.Status == models.DeploymentStatusDraining {
+			UndoDrain(ctx, dep, models.DeploymentStatusReady)
+		}
+		return err
+	}
+	if err := supervisor.Default.Remove(dep.ID); err != nil {
+		log.Printf("failed to remove service of superseded deployment %s: %v", dep.ID, err)
+	}
+
+	now := time.Now()
+	dep.Status = models.DeploymentStatusSuperseded
+	dep.SupersededBy = next.ID
+	dep.SupersededAt = &now
+	dep.Port = nil // Free the port for later revisions
+	dep.Hostnames = nil
+	if err := models.UpdateDeployment(ctx, *dep); err != nil {
+		return err
+	}
+	proxy.GlobalRouteMap.UpdateDeployment(dep)
+
+	if events.Em != nil {
+		events.Em.DeploymentSuperseded(*dep)
+	}
+	return nil
+}
+
+// discardRevision stops and removes the unit of a revision that failed to come up.
+// Failures are logged since the revision is already being marked failed.
+func discardRevision(dep models.Deployment, logger *deploymentLogger) {
+	if err := supervisor.Default.Stop(dep.ID); err != nil {
+		logger.Log("Failed to stop service: %v", err)
+	}
+	if err := supervisor.Default.Remove(dep.ID); err != nil {
+		logger.Log("Failed to remove service: %v", err)
+	}
+}
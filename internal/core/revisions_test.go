@@ -0,0 +1,103 @@
+package core
+
+import (
+	"testing"
+
+	"hypervisor/internal/models"
+)
+
+func TestTakeOver(t *testing.T) {
+	policy := &models.AccessPolicy{}
+	settings := &models.ProxySettings{}
+
+	tests := []struct {
+		name       string
+		revisions  []models.Deployment
+		previous   []string
+		routed     string
+		supersedes string
+	}{
+		{
+			name:      "first revision",
+			revisions: []models.Deployment{{ID: "a-r1", StageID: "a", Revision: 1, Status: models.DeploymentStatusProvisioning}},
+		},
+		{
+			name: "replaces the routed revision",
+			revisions: []models.Deployment{
+				{ID: "a-r2", StageID: "a", Revision: 2, Status: models.DeploymentStatusProvisioning},
+				{ID: "a-r1", StageID: "a", Revision: 1, Status: models.DeploymentStatusReady, Hostnames: []string{"a.example.com"}, Policy: policy, Proxy: settings, CanaryWeight: 30},
+			},
+			previous:   []string{"a-r1"},
+			routed:     "a-r1",
+			supersedes: "a-r1",
+		},
+		{
+			name: "retires draining and stopped revisions too",
+			revisions: []models.Deployment{
+				{ID: "a-r3", StageID: "a", Revision: 3, Status: models.DeploymentStatusDraining},
+				{ID: "a-r2", StageID: "a", Revision: 2, Status: models.DeploymentStatusReady},
+				{ID: "a-r1", StageID: "a", Revision: 1, Status: models.DeploymentStatusStopped},
+			},
+			previous:   []string{"a-r3", "a-r2", "a-r1"},
+			routed:     "a-r2",
+			supersedes: "a-r2",
+		},
+		{
+			name: "supersedes the newest revision when none is ready",
+			revisions: []models.Deployment{
+				{ID: "a-r2", StageID: "a", Revision: 2, Status: models.DeploymentStatusStopped},
+				{ID: "a-r1", StageID: "a", Revision: 1, Status: models.DeploymentStatusStopped},
+			},
+			previous:   []string{"a-r2", "a-r1"},
+			supersedes: "a-r2",
+		},
+		{
+			name: "leaves failed, superseded and newer revisions alone",
+			revisions: []models.Deployment{
+				{ID: "a-r5", StageID: "a", Revision: 5, Status: models.DeploymentStatusReady},
+				{ID: "a-r3", StageID: "a", Revision: 3, Status: models.DeploymentStatusStartFailed},
+				{ID: "a-r2", StageID: "a", Revision: 2, Status: models.DeploymentStatusSuperseded},
+				{ID: "a-r1", StageID: "a", Revision: 1, Status: models.DeploymentStatusUnhealthy},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		dep := &models.Deployment{ID: "a-r4", StageID: "a", Revision: 4, Status: models.DeploymentStatusProvisioning}
+		previous, routed := takeOver(dep, tt.revisions)
+
+		var ids []string
+		for _, revision := range previous {
+			ids = append(ids, revision.ID)
+		}
+		if len(ids) != len(tt.previous) {
+			t.Errorf("%s: expected previous %v, got %v", tt.name, tt.previous, ids)
+		} else {
+			for i := range ids {
+				if ids[i] != tt.previous[i] {
+					t.Errorf("%s: expected previous %v, got %v", tt.name, tt.previous, ids)
+					break
+				}
+			}
+		}
+
+		routedID := ""
+		if routed != nil {
+			routedID = routed.ID
+		}
+		if routedID != tt.routed {
+			t.Errorf("%s: expected routed %q, got %q", tt.name, tt.routed, routedID)
+		}
+		if dep.Supersedes != tt.supersedes {
+			t.Errorf("%s: expected supersedes %q, got %q", tt.name, tt.supersedes, dep.Supersedes)
+		}
+		if dep.Status != models.DeploymentStatusReady {
+			t.Errorf("%s: expected status ready, got %s", tt.name, dep.Status)
+		}
+		if routed != nil {
+			if len(dep.Hostnames) != len(routed.Hostnames) || dep.Policy != routed.Policy || dep.Proxy != routed.Proxy || dep.CanaryWeight != routed.CanaryWeight {
+				t.Errorf("%s: expected the route settings of %s to be inherited", tt.name, routed.ID)
+			}
+		}
+	}
+}
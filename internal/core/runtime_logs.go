@@ -0,0 +1,87 @@
+package core
+
+import (
+	"context"
+	"fmt"
+
+	"hypervisor/internal/journal"
+	"hypervisor/internal/supervisor"
+)
+
+// journalFilterScanLines is how far back a filtered backlog is searched for its lines.
+const journalFilterScanLines = 10000
+
+// JournalWriter receives journal entries and status messages during log streaming.
+type JournalWriter interface {
+	WriteEntry(entry journal.Entry) error
+	StatusWriter
+}
+
+// StreamDeploymentJournal sends the last q.Lines entries of a deployment's unit
+// matching filter, then follows the unit's output until ctx is done. All clients of
+// a unit share one follower.
+func StreamDeploymentJournal(ctx context.Context, deploymentID string, q journal.Query, filter journal.Filter, w JournalWriter) error {
+	unit := supervisor.Default.ServiceName(deploymentID)
+
+	// Subscribe before reading the backlog so nothing written in between is missed
+	sub := supervisor.Default.Follow(deploymentID)
+	defer sub.Close()
+
+	seen := make(map[string]bool)
+	if q.Lines > 0 {
+		limit := q.Lines
+		if filter.Pattern != nil {
+			q.Lines = max(q.Lines, journalFilterScanLines)
+		}
+
+		backlog, err := supervisor.Default.Logs(ctx, deploymentID, q)
+		if err != nil {
+			w.WriteStatus("error", "failed to read the journal")
+			return err
+		}
+
+		matched := make([]journal.Entry, 0, len(backlog))
+		for _, entry := range backlog {
+			seen[entry.Cursor] = true
+			if filter.Matches(entry) {
+				matched = append(matched, entry)
+			}
+		}
+		if len(matched) > limit {
+			matched = matched[len(matched)-limit:]
+		}
+
+		for _, entry := range matched {
+			if err := w.WriteEntry(entry); err != nil {
+				return err
+			}
+		}
+	}
+
+	w.WriteStatus("info", "following runtime log of "+unit)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case entry := <-sub.C:
+			// The follower may have picked up the end of the backlog too
+			if seen != nil {
+				if seen[entry.Cursor] {
+					continue
+				}
+				seen = nil
+			}
+
+			if dropped := sub.Dropped(); dropped > 0 {
+				w.WriteStatus("warning", fmt.Sprintf("%d lines dropped while the client fell behind", dropped))
+			}
+			if !filter.Matches(entry) {
+				continue
+			}
+			if err := w.WriteEntry(entry); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -15,6 +15,7 @@ import (
 	"hypervisor/internal/events"
 	"hypervisor/internal/fs"
 	"hypervisor/internal/git"
+	"hypervisor/internal/jobs"
 	"hypervisor/internal/models"
 	"hypervisor/internal/paths"
 
@@ -153,8 +154,23 @@ func UpdateStageEnv(ctx context.Context, stageID string, envText string) (*model
 	return stage, nil
 }
 
-// PromoteStage creates a deployment document for the provided stage.
-func PromoteStage(ctx context.Context, stageID string) (*models.Deployment, error) {
+// UpdateStageHealthPath sets the path deployments of the stage are checked on. It
+// applies to revisions deployed afterwards.
+func UpdateStageHealthPath(ctx context.Context, stageID string, healthPath string) (*models.Stage, error) {
+	if err := models.UpdateStageHealthPath(ctx, stageID, healthPath); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, errmsg.StageNotFound
+		}
+		return nil, err
+	}
+
+	return models.GetStageByID(ctx, stageID)
+}
+
+// PromoteStage creates a deployment document for a new revision of the provided stage.
+// Earlier revisions keep serving until the new one is provisioned and healthy. unit
+// overrides the stage's env tag defaults for the revision's systemd unit.
+func PromoteStage(ctx context.Context, stageID string, unit models.UnitSettings) (*models.Deployment, error) {
 	stage, err := models.GetStageByID(ctx, stageID)
 	if err != nil {
 		return nil, errmsg.StageNotFound
@@ -164,13 +180,35 @@ func PromoteStage(ctx context.Context, stageID string) (*models.Deployment, erro
 		return nil, errmsg.StageMissingEnv
 	}
 
+	// One revision of a stage is provisioned at a time. This check answers early;
+	// the unique index on provisioning revisions settles concurrent requests.
+	revisions, err := models.GetStageDeployments(ctx, stage.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, revision := range revisions {
+		if revision.Status == models.DeploymentStatusProvisioning {
+			return nil, errmsg.DeploymentRevisionInProgress
+		}
+	}
+
+	unitSettings, err := resolveUnitSettings(ctx, stage.EnvTag, unit)
+	if err != nil {
+		return nil, err
+	}
+
 	// Allocate port
 	port, err := AllocatePort(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	deploymentID := stageID
+	sequence, err := models.NextDeploymentSequence(ctx, stage.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	deploymentID := models.RevisionID(stage.ID, sequence)
 	logPath := filepath.Join(paths.OpenHackRuntimeLogsDir, fmt.Sprintf("%s-deploy.log", deploymentID))
 	if err := fs.EnsureDir(filepath.Dir(logPath), 0o755); err != nil {
 		return nil, err
@@ -181,22 +219,36 @@ func PromoteStage(ctx context.Context, stageID string) (*models.Deployment, erro
 		Version:    stage.ReleaseID,
 		EnvTag:     stage.EnvTag,
 		StageID:    stage.ID,
+		Revision:   sequence,
 		Port:       &port,
 		Status:     models.DeploymentStatusProvisioning,
 		LogPath:    logPath,
+		HealthPath: stage.HealthPath,
+		Unit:       &unitSettings,
 		CreatedAt:  time.Now(),
 		PromotedAt: nil,
 	}
 
 	if err := models.CreateDeployment(ctx, deployment); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, errmsg.DeploymentRevisionInProgress
+		}
 		if events.Em != nil {
 			events.Em.DeploymentCreateFailed(deploymentID, err)
 		}
 		return nil, err
 	}
 
-	// Launch goroutine for provisioning
-	go ProvisionDeployment(deployment)
+	// Provision in a job so a hypervisor restart does not strand the deployment
+	if _, err := jobs.Enqueue(ctx, models.JobKindProvisionDeployment, deployment.ID); err != nil {
+		deployment.Status = models.DeploymentStatusProvisionFailed
+		deployment.Port = nil
+		models.UpdateDeployment(ctx, deployment)
+		if events.Em != nil {
+			events.Em.DeploymentCreateFailed(deploymentID, err)
+		}
+		return nil, err
+	}
 
 	if events.Em != nil {
 		events.Em.DeploymentCreated(deployment)
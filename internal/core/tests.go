@@ -18,6 +18,7 @@ import (
 	"hypervisor/internal/errmsg"
 	"hypervisor/internal/events"
 	"hypervisor/internal/fs"
+	"hypervisor/internal/jobs"
 	"hypervisor/internal/models"
 	"hypervisor/internal/paths"
 )
@@ -76,15 +77,15 @@ func StartTest(ctx context.Context, stageID string) (*models.Test, error) {
 		events.Em.TestStarted(*stage, test)
 	}
 
-	repoPath := paths.OpenHackRepoPath(stage.ID)
-
-	// Create cancellable context for the test
-	testCancelsMu.Lock()
-	testCtx, cancel := context.WithCancel(context.Background())
-	testCancels[test.ID] = cancel
-	testCancelsMu.Unlock()
-
-	go runTest(testCtx, repoPath, stage.ID, test)
+	// Run in a job so a hypervisor restart does not leave the test running forever
+	if _, err := jobs.Enqueue(ctx, models.JobKindRunTest, test.ID); err != nil {
+		finish := time.Now()
+		_ = models.UpdateTestStatus(ctx, test.ID, models.TestStatusError, &finish, err.Error())
+		if events.Em != nil {
+			events.Em.TestFailed(stage.ID, test.ID, err.Error())
+		}
+		return nil, err
+	}
 
 	return &test, nil
 }
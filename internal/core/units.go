@@ -0,0 +1,56 @@
+package core
+
+import (
+	"context"
+
+	"hypervisor/internal/models"
+)
+
+// prodEnvTag is the env tag of production stages, the only ones run without limits.
+const prodEnvTag = "prod"
+
+// builtinUnitSettings are what a deployment's unit gets unless the stored defaults
+// for its env tag or the deploy request say otherwise. Every backend is sandboxed;
+// all but prod are also capped, so a runaway preview cannot starve main.
+func builtinUnitSettings(envTag string) models.UnitSettings {
+	enabled := true
+	settings := models.UnitSettings{
+		ProtectSystem:   "full",
+		PrivateTmp:      &enabled,
+		NoNewPrivileges: &enabled,
+	}
+	if envTag != prodEnvTag {
+		settings.MemoryMax = "1G"
+		settings.CPUQuota = "100%"
+		settings.TasksMax = "512"
+	}
+	return settings
+}
+
+// resolveUnitSettings layers the stored defaults of envTag and the overrides of a
+// deploy request over the built-in defaults.
+func resolveUnitSettings(ctx context.Context, envTag string, overrides models.UnitSettings) (models.UnitSettings, error) {
+	defaults, err := models.GetUnitDefaults(ctx)
+	if err != nil {
+		return models.UnitSettings{}, err
+	}
+
+	return builtinUnitSettings(envTag).Merge(defaults.Tags[envTag]).Merge(overrides), nil
+}
+
+// UnitDefaults returns the unit settings a new deployment with envTag starts from.
+func UnitDefaults(ctx context.Context, envTag string) (models.UnitSettings, error) {
+	return resolveUnitSettings(ctx, envTag, models.UnitSettings{})
+}
+
+// yesNo renders an optional systemd boolean; nil leaves the directive out.
+func yesNo(value *bool) string {
+	switch {
+	case value == nil:
+		return ""
+	case *value:
+		return "yes"
+	default:
+		return "no"
+	}
+}
@@ -24,6 +24,14 @@ var (
 	Tests       *mongo.Collection
 	Deployments *mongo.Collection
 	Events      *mongo.Collection
+	MirrorDiffs *mongo.Collection
+	Settings    *mongo.Collection
+	Promotions  *mongo.Collection
+	Jobs        *mongo.Collection
+
+	// Name is the MongoDB database selected for the deployment profile. It also
+	// namespaces Redis pub/sub channels, which are shared across logical DBs.
+	Name string
 )
 
 const databaseName = "hypervisor"
@@ -54,6 +62,7 @@ func InitDB(deployment string) error {
 		dbName = "hypervisor_dev"
 	}
 
+	Name = dbName
 	db := Client.Database(dbName)
 	HyperUsers = db.Collection("hyperusers")
 
@@ -63,6 +72,10 @@ func InitDB(deployment string) error {
 	Tests = db.Collection("tests")
 	Deployments = db.Collection("deployments")
 	Events = db.Collection("events")
+	MirrorDiffs = db.Collection("mirror_diffs")
+	Settings = db.Collection("settings")
+	Promotions = db.Collection("promotions")
+	Jobs = db.Collection("jobs")
 
 	return nil
 }
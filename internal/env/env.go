@@ -8,6 +8,7 @@ import (
 	"runtime"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -16,8 +17,41 @@ import (
 var JWT_SECRET []byte
 var MONGO_URI string
 var GITHUB_WEBHOOK_SECRET string
+var METRICS_TOKEN string
 var PREFORK bool
 var DRAIN_MODE bool
+var DISABLE_BACKGROUND_TASKS bool
+var DEPLOYMENT_DRAIN_TIMEOUT time.Duration
+var DEPLOYMENT_STARTUP_TIMEOUT time.Duration
+var DEPLOYMENT_READY_STABLE_PERIOD time.Duration
+var DEPLOYMENT_HEALTH_PATH string
+var DEPLOYMENT_HEALTH_INTERVAL time.Duration
+var DEPLOYMENT_HEALTH_TIMEOUT time.Duration
+var DEPLOYMENT_HEALTH_THRESHOLD int
+var ROUTE_RELOAD_INTERVAL time.Duration
+var PROXY_BASE_DOMAIN string
+var MIRROR_TIMEOUT time.Duration
+var MIRROR_MAX_CONCURRENCY int
+var MIRROR_DIFF_IGNORE_FIELDS []string
+var MIRROR_DIFF_MAX_BODY int
+var ACCESS_LOG_MAX_SIZE int
+var ACCESS_LOG_MAX_FILES int
+var PROXY_CONNECT_TIMEOUT time.Duration
+var PROXY_READ_TIMEOUT time.Duration
+var PROXY_BODY_LIMIT int
+var PROXY_MAX_BODY_LIMIT int
+var PROXY_CONNECT_RETRIES int
+var PROXY_MAX_CONNS int
+var AUTO_ROLLBACK_WINDOW time.Duration
+var JOB_WORKERS int
+var JOB_LEASE_DURATION time.Duration
+var JOB_POLL_INTERVAL time.Duration
+var RECONCILE_INTERVAL time.Duration
+var SUPERVISOR string
+var CRASHLOOP_CHECK_INTERVAL time.Duration
+var CRASHLOOP_RESTARTS int
+var CRASHLOOP_WINDOW time.Duration
+var CRASHLOOP_JOURNAL_LINES int
 
 // this is required
 var VERSION string
@@ -33,9 +67,88 @@ func Init(envRoot string, appVersion string) {
 
 	PREFORK, _ = strconv.ParseBool(os.Getenv("PREFORK"))
 	DRAIN_MODE = false
+	DISABLE_BACKGROUND_TASKS, _ = strconv.ParseBool(os.Getenv("DISABLE_BACKGROUND_TASKS"))
 	MONGO_URI = os.Getenv("MONGO_URI")
 	JWT_SECRET = []byte(os.Getenv("JWT_SECRET"))
 	GITHUB_WEBHOOK_SECRET = strings.TrimSpace(os.Getenv("GITHUB_WEBHOOK_SECRET"))
+	METRICS_TOKEN = strings.TrimSpace(os.Getenv("METRICS_TOKEN"))
+	DEPLOYMENT_DRAIN_TIMEOUT = parseDuration("DEPLOYMENT_DRAIN_TIMEOUT", 30*time.Second)
+	DEPLOYMENT_STARTUP_TIMEOUT = parseDuration("DEPLOYMENT_STARTUP_TIMEOUT", 60*time.Second)
+	DEPLOYMENT_READY_STABLE_PERIOD = parseDuration("DEPLOYMENT_READY_STABLE_PERIOD", 10*time.Second)
+
+	DEPLOYMENT_HEALTH_PATH = strings.TrimSpace(os.Getenv("DEPLOYMENT_HEALTH_PATH"))
+	if DEPLOYMENT_HEALTH_PATH == "" {
+		DEPLOYMENT_HEALTH_PATH = "/meta/ping"
+	}
+	DEPLOYMENT_HEALTH_INTERVAL = parseDuration("DEPLOYMENT_HEALTH_INTERVAL", 5*time.Second)
+	DEPLOYMENT_HEALTH_TIMEOUT = parseDuration("DEPLOYMENT_HEALTH_TIMEOUT", 2*time.Second)
+	DEPLOYMENT_HEALTH_THRESHOLD = parseInt("DEPLOYMENT_HEALTH_THRESHOLD", 3, 1)
+	ROUTE_RELOAD_INTERVAL = parseDuration("ROUTE_RELOAD_INTERVAL", 30*time.Second)
+	PROXY_BASE_DOMAIN = strings.TrimSpace(os.Getenv("PROXY_BASE_DOMAIN"))
+	MIRROR_TIMEOUT = parseDuration("MIRROR_TIMEOUT", 10*time.Second)
+	MIRROR_MAX_CONCURRENCY = parseInt("MIRROR_MAX_CONCURRENCY", 32, 1)
+	MIRROR_DIFF_IGNORE_FIELDS = parseList("MIRROR_DIFF_IGNORE_FIELDS")
+	MIRROR_DIFF_MAX_BODY = parseInt("MIRROR_DIFF_MAX_BODY", 1<<20, 1)
+	ACCESS_LOG_MAX_SIZE = parseInt("ACCESS_LOG_MAX_SIZE", 50<<20, 1)
+	ACCESS_LOG_MAX_FILES = parseInt("ACCESS_LOG_MAX_FILES", 5, 1)
+	PROXY_CONNECT_TIMEOUT = parseDuration("PROXY_CONNECT_TIMEOUT", 5*time.Second)
+	PROXY_READ_TIMEOUT = parseDuration("PROXY_READ_TIMEOUT", 60*time.Second)
+	PROXY_BODY_LIMIT = parseInt("PROXY_BODY_LIMIT", 4<<20, 1)
+	PROXY_MAX_BODY_LIMIT = max(parseInt("PROXY_MAX_BODY_LIMIT", 100<<20, 1), PROXY_BODY_LIMIT)
+	PROXY_CONNECT_RETRIES = parseInt("PROXY_CONNECT_RETRIES", 0, 0)
+	PROXY_MAX_CONNS = parseInt("PROXY_MAX_CONNS", 512, 1)
+	AUTO_ROLLBACK_WINDOW = parseDuration("AUTO_ROLLBACK_WINDOW", 0)
+	JOB_WORKERS = parseInt("JOB_WORKERS", 4, 1)
+	JOB_LEASE_DURATION = parseDuration("JOB_LEASE_DURATION", 30*time.Second)
+	JOB_POLL_INTERVAL = parseDuration("JOB_POLL_INTERVAL", 2*time.Second)
+	RECONCILE_INTERVAL = parseDuration("RECONCILE_INTERVAL", 5*time.Minute)
+	SUPERVISOR = strings.TrimSpace(os.Getenv("SUPERVISOR"))
+	CRASHLOOP_CHECK_INTERVAL = parseDuration("CRASHLOOP_CHECK_INTERVAL", 10*time.Second)
+	CRASHLOOP_RESTARTS = parseInt("CRASHLOOP_RESTARTS", 5, 1)
+	CRASHLOOP_WINDOW = parseDuration("CRASHLOOP_WINDOW", 5*time.Minute)
+	CRASHLOOP_JOURNAL_LINES = parseInt("CRASHLOOP_JOURNAL_LINES", 100, 1)
+}
+
+// parseList reads a comma-separated list from the environment, dropping empty entries.
+func parseList(key string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// parseInt reads an integer no lower than floor from the environment, falling back to def.
+// floor is 0 where 0 turns a feature off, such as PROXY_CONNECT_RETRIES.
+func parseInt(key string, def int, floor int) int {
+	value := strings.TrimSpace(os.Getenv(key))
+	if value == "" {
+		return def
+	}
+
+	parsed, err := strconv.Atoi(value)
+	if err != nil || parsed < floor {
+		log.Printf("invalid %s %q, using %d", key, value, def)
+		return def
+	}
+	return parsed
+}
+
+// parseDuration reads a Go duration (e.g. "30s") from the environment, falling back to def.
+func parseDuration(key string, def time.Duration) time.Duration {
+	value := strings.TrimSpace(os.Getenv(key))
+	if value == "" {
+		return def
+	}
+
+	parsed, err := time.ParseDuration(value)
+	if err != nil || parsed < 0 {
+		log.Printf("invalid %s %q, using %s", key, value, def)
+		return def
+	}
+	return parsed
 }
 
 func loadEnv(envRoot string) {
@@ -0,0 +1,27 @@
+package env
+
+import "testing"
+
+func TestParseInt(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		floor int
+		want  int
+	}{
+		{"unset", "", 1, 7},
+		{"positive", "12", 1, 12},
+		{"surrounding spaces", " 12 ", 1, 12},
+		{"zero where it is meaningful", "0", 0, 0},
+		{"zero where it is not", "0", 1, 7},
+		{"negative", "-1", 0, 7},
+		{"not a number", "three", 0, 7},
+	}
+
+	for _, tt := range tests {
+		t.Setenv("HYPERVISOR_TEST_INT", tt.value)
+		if got := parseInt("HYPERVISOR_TEST_INT", 7, tt.floor); got != tt.want {
+			t.Errorf("%s: expected %d, got %d", tt.name, tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,24 @@
+package errmsg
+
+import "net/http"
+
+var (
+	AccessLogInvalidQuery = NewStatusError(
+		http.StatusBadRequest,
+		"invalid access log query",
+	)
+	AccessLogUnavailable = NewStatusError(
+		http.StatusServiceUnavailable,
+		"the access log is not available on this instance",
+	)
+)
+
+type _AccessLogInvalidQuery struct {
+	StatusCode int    `json:"statusCode" example:"400"`
+	Message    string `json:"message" example:"invalid access log query"`
+}
+
+type _AccessLogUnavailable struct {
+	StatusCode int    `json:"statusCode" example:"503"`
+	Message    string `json:"message" example:"the access log is not available on this instance"`
+}
@@ -9,6 +9,11 @@ func InternalServerError(err error) StatusError {
 	)
 }
 
+var RequestBodyTooLarge = NewStatusError(
+	http.StatusRequestEntityTooLarge,
+	"request body is too large",
+)
+
 type _InternalServerError struct {
 	StatusCode int    `json:"statusCode" example:"500"`
 	Message    string `json:"message" example:"internal server error: <details>"`
@@ -18,3 +23,8 @@ type _GeneralBadRequest struct {
 	StatusCode int    `json:"statusCode" example:"400"`
 	Message    string `json:"message" example:"invalid request payload"`
 }
+
+type _RequestBodyTooLarge struct {
+	StatusCode int    `json:"statusCode" example:"413"`
+	Message    string `json:"message" example:"request body is too large"`
+}
@@ -0,0 +1,15 @@
+package errmsg
+
+import "net/http"
+
+var (
+	JobInvalidQuery = NewStatusError(
+		http.StatusBadRequest,
+		"invalid job query",
+	)
+)
+
+type _JobInvalidQuery struct {
+	StatusCode int    `json:"statusCode" example:"400"`
+	Message    string `json:"message" example:"invalid job query"`
+}
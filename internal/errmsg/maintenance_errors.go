@@ -0,0 +1,25 @@
+package errmsg
+
+import "net/http"
+
+var (
+	MaintenanceInvalidRequest = NewStatusError(
+		http.StatusBadRequest,
+		"invalid maintenance payload",
+	)
+	MaintenanceMode = NewStatusError(
+		http.StatusServiceUnavailable,
+		"service is down for maintenance - please try again later",
+	)
+)
+
+type _MaintenanceInvalidRequest struct {
+	StatusCode int    `json:"statusCode" example:"400"`
+	Message    string `json:"message" example:"invalid maintenance payload"`
+}
+
+type _MaintenanceMode struct {
+	StatusCode int    `json:"statusCode" example:"503"`
+	Message    string `json:"message" example:"service is down for maintenance - please try again later"`
+	RetryAfter int    `json:"retryAfter" example:"300"`
+}
@@ -0,0 +1,25 @@
+package errmsg
+
+import "net/http"
+
+var (
+	ReconcileInProgress = NewStatusError(
+		http.StatusConflict,
+		"a reconciliation pass is already running",
+	)
+
+	ReconcileReportNotFound = NewStatusError(
+		http.StatusNotFound,
+		"no reconciliation pass has completed yet",
+	)
+)
+
+type _ReconcileInProgress struct {
+	StatusCode int    `json:"statusCode" example:"409"`
+	Message    string `json:"message" example:"a reconciliation pass is already running"`
+}
+
+type _ReconcileReportNotFound struct {
+	StatusCode int    `json:"statusCode" example:"404"`
+	Message    string `json:"message" example:"no reconciliation pass has completed yet"`
+}
@@ -39,6 +39,127 @@ var (
 		http.StatusConflict,
 		"cannot delete deployment marked as main - demote it first or use force=true",
 	)
+	DeploymentNotReady = NewStatusError(
+		http.StatusConflict,
+		"deployment is not ready",
+	)
+	DeploymentInvalidCanaryWeight = NewStatusError(
+		http.StatusBadRequest,
+		"canary weight must be between 0 and 100",
+	)
+	CannotCanaryMainDeployment = NewStatusError(
+		http.StatusConflict,
+		"deployment is already main and cannot be used as a canary",
+	)
+	DeploymentInvalidMirrorPercent = NewStatusError(
+		http.StatusBadRequest,
+		"mirror percent must be between 0 and 100",
+	)
+	CannotMirrorMainDeployment = NewStatusError(
+		http.StatusConflict,
+		"deployment is already main and cannot receive mirrored traffic",
+	)
+	OverrideDeploymentNotFound = NewStatusError(
+		http.StatusNotFound,
+		"override deployment not found or not ready",
+	)
+	DeploymentUnavailable = NewStatusError(
+		http.StatusServiceUnavailable,
+		"deployment is unavailable - it is failing health checks",
+	)
+	DeploymentBadGateway = NewStatusError(
+		http.StatusBadGateway,
+		"deployment did not respond",
+	)
+	DeploymentInvalidHostname = NewStatusError(
+		http.StatusBadRequest,
+		"hostnames must be valid DNS names and must not be the base domain",
+	)
+	DeploymentHostnameTaken = NewStatusError(
+		http.StatusConflict,
+		"hostname is already routed to another deployment",
+	)
+	DeploymentInvalidPolicy = NewStatusError(
+		http.StatusBadRequest,
+		"policy must use valid IPs or CIDRs and a positive rate limit",
+	)
+	CannotRestrictMainDeployment = NewStatusError(
+		http.StatusConflict,
+		"main deployment cannot be restricted to hyperusers",
+	)
+	DeploymentForbidden = NewStatusError(
+		http.StatusForbidden,
+		"your address is not allowed to reach this deployment",
+	)
+	DeploymentHyperusersOnly = NewStatusError(
+		http.StatusUnauthorized,
+		"this deployment is only available to hyperusers",
+	)
+	DeploymentRateLimited = NewStatusError(
+		http.StatusTooManyRequests,
+		"too many requests - slow down",
+	)
+	DeploymentInvalidProxySettings = NewStatusError(
+		http.StatusBadRequest,
+		"proxy settings must be non-negative and within the hypervisor's limits",
+	)
+	DeploymentGatewayTimeout = NewStatusError(
+		http.StatusGatewayTimeout,
+		"deployment did not respond in time",
+	)
+	DeploymentBodyTooLarge = NewStatusError(
+		http.StatusRequestEntityTooLarge,
+		"request body is too large for this deployment",
+	)
+	DeploymentInvalidFault = NewStatusError(
+		http.StatusBadRequest,
+		"fault rules need an action, a percent from 1 to 100 and an expiry of at most 24h",
+	)
+	DeploymentFaultNotFound = NewStatusError(
+		http.StatusNotFound,
+		"fault rule not found",
+	)
+	// DeploymentFaultInjected is answered by fault rules; the status code is the rule's.
+	DeploymentFaultInjected = NewStatusError(
+		http.StatusServiceUnavailable,
+		"fault injected by the hypervisor",
+	)
+	CannotPromoteUnhealthyDeployment = NewStatusError(
+		http.StatusConflict,
+		"deployment is failing health checks and cannot be promoted",
+	)
+	PromotionInProgress = NewStatusError(
+		http.StatusConflict,
+		"another change of main is in progress - try again",
+	)
+	NoRollbackTarget = NewStatusError(
+		http.StatusConflict,
+		"no earlier main deployment is still ready to roll back to",
+	)
+	DeploymentRevisionInProgress = NewStatusError(
+		http.StatusConflict,
+		"a revision of this stage is still provisioning",
+	)
+	DeploymentSuperseded = NewStatusError(
+		http.StatusConflict,
+		"deployment revision has been superseded by a newer one",
+	)
+	DeploymentNoUnit = NewStatusError(
+		http.StatusConflict,
+		"deployment has no unit to start - deploy its stage again",
+	)
+	DeploymentStartFailed = NewStatusError(
+		http.StatusBadGateway,
+		"deployment did not become ready after starting - see its log",
+	)
+	DeploymentInvalidUnitSettings = NewStatusError(
+		http.StatusBadRequest,
+		"unit settings must use systemd's syntax and absolute paths",
+	)
+	DeploymentInvalidLogQuery = NewStatusError(
+		http.StatusBadRequest,
+		"invalid runtime log query",
+	)
 	NoDeploymentFound = NewStatusError(
 		http.StatusNotFound,
 		"no deployment found for this request - check that a deployment exists and is promoted to main",
@@ -85,6 +206,21 @@ type _CannotDeleteMainDeployment struct {
 	Message    string `json:"message" example:"cannot delete deployment marked as main - demote it first or use force=true"`
 }
 
+type _DeploymentNotReady struct {
+	StatusCode int    `json:"statusCode" example:"409"`
+	Message    string `json:"message" example:"deployment is not ready"`
+}
+
+type _DeploymentInvalidCanaryWeight struct {
+	StatusCode int    `json:"statusCode" example:"400"`
+	Message    string `json:"message" example:"canary weight must be between 0 and 100"`
+}
+
+type _CannotCanaryMainDeployment struct {
+	StatusCode int    `json:"statusCode" example:"409"`
+	Message    string `json:"message" example:"deployment is already main and cannot be used as a canary"`
+}
+
 type _NoDeploymentFound struct {
 	StatusCode int    `json:"statusCode" example:"404"`
 	Message    string `json:"message" example:"no deployment found for this request - check that a deployment exists and is promoted to main"`
@@ -94,3 +230,145 @@ type _DeploymentAlreadyExists struct {
 	StatusCode int    `json:"statusCode" example:"409"`
 	Message    string `json:"message" example:"deployment already exists"`
 }
+
+type _DeploymentInvalidMirrorPercent struct {
+	StatusCode int    `json:"statusCode" example:"400"`
+	Message    string `json:"message" example:"mirror percent must be between 0 and 100"`
+}
+
+type _CannotMirrorMainDeployment struct {
+	StatusCode int    `json:"statusCode" example:"409"`
+	Message    string `json:"message" example:"deployment is already main and cannot receive mirrored traffic"`
+}
+
+type _OverrideDeploymentNotFound struct {
+	StatusCode int    `json:"statusCode" example:"404"`
+	Message    string `json:"message" example:"override deployment not found or not ready"`
+}
+
+type _DeploymentUnavailable struct {
+	StatusCode   int    `json:"statusCode" example:"503"`
+	Message      string `json:"message" example:"deployment is unavailable - it is failing health checks"`
+	DeploymentID string `json:"deploymentId" example:"v25.10.27.0-prod"`
+	Reason       string `json:"reason" example:"health check returned status 500"`
+}
+
+type _DeploymentBadGateway struct {
+	StatusCode   int    `json:"statusCode" example:"502"`
+	Message      string `json:"message" example:"deployment did not respond"`
+	DeploymentID string `json:"deploymentId" example:"v25.10.27.0-prod"`
+	Reason       string `json:"reason" example:"dial tcp 127.0.0.1:20000: connect: connection refused"`
+}
+
+type _DeploymentInvalidHostname struct {
+	StatusCode int    `json:"statusCode" example:"400"`
+	Message    string `json:"message" example:"hostnames must be valid DNS names and must not be the base domain"`
+}
+
+type _DeploymentHostnameTaken struct {
+	StatusCode int    `json:"statusCode" example:"409"`
+	Message    string `json:"message" example:"hostname is already routed to another deployment"`
+}
+
+type _DeploymentInvalidPolicy struct {
+	StatusCode int    `json:"statusCode" example:"400"`
+	Message    string `json:"message" example:"policy must use valid IPs or CIDRs and a positive rate limit"`
+}
+
+type _CannotRestrictMainDeployment struct {
+	StatusCode int    `json:"statusCode" example:"409"`
+	Message    string `json:"message" example:"main deployment cannot be restricted to hyperusers"`
+}
+
+type _DeploymentForbidden struct {
+	StatusCode   int    `json:"statusCode" example:"403"`
+	Message      string `json:"message" example:"your address is not allowed to reach this deployment"`
+	DeploymentID string `json:"deploymentId" example:"v25.10.27.0-dev"`
+	Reason       string `json:"reason" example:"203.0.113.7 matches deny rule 203.0.113.0/24"`
+}
+
+type _DeploymentHyperusersOnly struct {
+	StatusCode   int    `json:"statusCode" example:"401"`
+	Message      string `json:"message" example:"this deployment is only available to hyperusers"`
+	DeploymentID string `json:"deploymentId" example:"v25.10.27.0-dev"`
+}
+
+type _DeploymentRateLimited struct {
+	StatusCode   int    `json:"statusCode" example:"429"`
+	Message      string `json:"message" example:"too many requests - slow down"`
+	DeploymentID string `json:"deploymentId" example:"v25.10.27.0-prod"`
+}
+
+type _DeploymentInvalidProxySettings struct {
+	StatusCode int    `json:"statusCode" example:"400"`
+	Message    string `json:"message" example:"proxy settings must be non-negative and within the hypervisor's limits"`
+}
+
+type _DeploymentGatewayTimeout struct {
+	StatusCode   int    `json:"statusCode" example:"504"`
+	Message      string `json:"message" example:"deployment did not respond in time"`
+	DeploymentID string `json:"deploymentId" example:"v25.10.27.0-prod"`
+	Reason       string `json:"reason" example:"timeout"`
+}
+
+type _DeploymentBodyTooLarge struct {
+	StatusCode   int    `json:"statusCode" example:"413"`
+	Message      string `json:"message" example:"request body is too large for this deployment"`
+	DeploymentID string `json:"deploymentId" example:"v25.10.27.0-prod"`
+	Reason       string `json:"reason" example:"body is 8388608 bytes, limit is 4194304"`
+}
+
+type _DeploymentInvalidFault struct {
+	StatusCode int    `json:"statusCode" example:"400"`
+	Message    string `json:"message" example:"fault rules need an action, a percent from 1 to 100 and an expiry of at most 24h"`
+}
+
+type _DeploymentFaultNotFound struct {
+	StatusCode int    `json:"statusCode" example:"404"`
+	Message    string `json:"message" example:"fault rule not found"`
+}
+
+type _CannotPromoteUnhealthyDeployment struct {
+	StatusCode int    `json:"statusCode" example:"409"`
+	Message    string `json:"message" example:"deployment is failing health checks and cannot be promoted"`
+}
+
+type _PromotionInProgress struct {
+	StatusCode int    `json:"statusCode" example:"409"`
+	Message    string `json:"message" example:"another change of main is in progress - try again"`
+}
+
+type _NoRollbackTarget struct {
+	StatusCode int    `json:"statusCode" example:"409"`
+	Message    string `json:"message" example:"no earlier main deployment is still ready to roll back to"`
+}
+
+type _DeploymentRevisionInProgress struct {
+	StatusCode int    `json:"statusCode" example:"409"`
+	Message    string `json:"message" example:"a revision of this stage is still provisioning"`
+}
+
+type _DeploymentSuperseded struct {
+	StatusCode int    `json:"statusCode" example:"409"`
+	Message    string `json:"message" example:"deployment revision has been superseded by a newer one"`
+}
+
+type _DeploymentNoUnit struct {
+	StatusCode int    `json:"statusCode" example:"409"`
+	Message    string `json:"message" example:"deployment has no unit to start - deploy its stage again"`
+}
+
+type _DeploymentStartFailed struct {
+	StatusCode int    `json:"statusCode" example:"502"`
+	Message    string `json:"message" example:"deployment did not become ready after starting - see its log"`
+}
+
+type _DeploymentInvalidLogQuery struct {
+	StatusCode int    `json:"statusCode" example:"400"`
+	Message    string `json:"message" example:"invalid runtime log query"`
+}
+
+type _DeploymentInvalidUnitSettings struct {
+	StatusCode int    `json:"statusCode" example:"400"`
+	Message    string `json:"message" example:"unit settings must use systemd's syntax and absolute paths"`
+}
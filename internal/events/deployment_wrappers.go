@@ -63,6 +63,97 @@ func (e *Emitter) DeploymentPromoted(dep models.Deployment) {
 	e.Emit(evt)
 }
 
+// DeploymentRolledBack records main being rolled back from one deployment to an earlier one.
+func (e *Emitter) DeploymentRolledBack(from, to models.Deployment, reason models.PromotionReason) {
+	if e == nil {
+		return
+	}
+
+	evt := models.Event{
+		Action:     "deployment.rolled_back",
+		ActorID:    ActorSystem,
+		ActorRole:  ActorSystem,
+		TargetID:   to.ID,
+		TargetType: "deployment",
+		Props: map[string]any{
+			"stageId": to.StageID,
+			"fromId":  from.ID,
+			"reason":  string(reason),
+		},
+	}
+
+	e.Emit(evt)
+}
+
+// DeploymentMirrorUpdated records a change to the shadow traffic mirrored to a deployment.
+func (e *Emitter) DeploymentMirrorUpdated(dep models.Deployment) {
+	if e == nil {
+		return
+	}
+
+	percent := 0
+	if dep.Mirror != nil {
+		percent = dep.Mirror.Percent
+	}
+
+	evt := models.Event{
+		Action:     "deployment.mirror_updated",
+		ActorID:    ActorSystem,
+		ActorRole:  ActorSystem,
+		TargetID:   dep.ID,
+		TargetType: "deployment",
+		Props: map[string]any{
+			"stageId": dep.StageID,
+			"percent": percent,
+		},
+	}
+
+	e.Emit(evt)
+}
+
+// DeploymentCanaryUpdated records a change to a deployment's canary traffic weight.
+func (e *Emitter) DeploymentCanaryUpdated(dep models.Deployment) {
+	if e == nil {
+		return
+	}
+
+	evt := models.Event{
+		Action:     "deployment.canary_updated",
+		ActorID:    ActorSystem,
+		ActorRole:  ActorSystem,
+		TargetID:   dep.ID,
+		TargetType: "deployment",
+		Props: map[string]any{
+			"stageId": dep.StageID,
+			"weight":  dep.CanaryWeight,
+		},
+	}
+
+	e.Emit(evt)
+}
+
+// DeploymentDrained records the outcome of draining a deployment before it is stopped.
+func (e *Emitter) DeploymentDrained(dep models.Deployment) {
+	if e == nil || dep.Drain == nil {
+		return
+	}
+
+	evt := models.Event{
+		Action:     "deployment.drained",
+		ActorID:    ActorSystem,
+		ActorRole:  ActorSystem,
+		TargetID:   dep.ID,
+		TargetType: "deployment",
+		Props: map[string]any{
+			"stageId":  dep.StageID,
+			"inFlight": dep.Drain.InFlight,
+			"timedOut": dep.Drain.TimedOut,
+		},
+	}
+
+	e.Emit(evt)
+}
+
 // DeploymentStopped records a deployment being stopped.
 func (e *Emitter) DeploymentStopped(dep models.Deployment) {
 	if e == nil {
@@ -83,6 +174,56 @@ func (e *Emitter) DeploymentStopped(dep models.Deployment) {
 	e.Emit(evt)
 }
 
+// DeploymentSuperseded records a revision being retired after a newer revision of its stage took over.
+func (e *Emitter) DeploymentSuperseded(dep models.Deployment) {
+	if e == nil {
+		return
+	}
+
+	evt := models.Event{
+		Action:     "deployment.superseded",
+		ActorID:    ActorSystem,
+		ActorRole:  ActorSystem,
+		TargetID:   dep.ID,
+		TargetType: "deployment",
+		Props: map[string]any{
+			"stageId":      dep.StageID,
+			"revision":     dep.Revision,
+			"supersededBy": dep.SupersededBy,
+		},
+	}
+
+	e.Emit(evt)
+}
+
+// DeploymentCrashLoop records a ready deployment taken out of routing because its unit kept restarting.
+func (e *Emitter) DeploymentCrashLoop(dep models.Deployment) {
+	if e == nil {
+		return
+	}
+
+	props := map[string]any{
+		"stageId":  dep.StageID,
+		"revision": dep.Revision,
+		"promoted": dep.PromotedAt != nil,
+	}
+	if dep.CrashLoop != nil {
+		props["restarts"] = dep.CrashLoop.Restarts
+		props["window"] = dep.CrashLoop.Window
+	}
+
+	evt := models.Event{
+		Action:     "deployment.crashloop",
+		ActorID:    ActorSystem,
+		ActorRole:  ActorSystem,
+		TargetID:   dep.ID,
+		TargetType: "deployment",
+		Props:      props,
+	}
+
+	e.Emit(evt)
+}
+
 // DeploymentDeleted records a deployment being deleted.
 func (e *Emitter) DeploymentDeleted(dep models.Deployment) {
 	if e == nil {
@@ -102,3 +243,30 @@ func (e *Emitter) DeploymentDeleted(dep models.Deployment) {
 
 	e.Emit(evt)
 }
+
+// DeploymentFaultsUpdated records a change to the fault rules injected into a deployment's traffic.
+func (e *Emitter) DeploymentFaultsUpdated(dep models.Deployment, actor string) {
+	if e == nil {
+		return
+	}
+
+	ruleIDs := make([]string, 0, len(dep.Faults))
+	for _, rule := range dep.Faults {
+		ruleIDs = append(ruleIDs, rule.ID)
+	}
+
+	evt := models.Event{
+		Action:     "deployment.faults_updated",
+		ActorID:    ActorSystem,
+		ActorRole:  ActorSystem,
+		TargetID:   dep.ID,
+		TargetType: "deployment",
+		Props: map[string]any{
+			"stageId": dep.StageID,
+			"rules":   ruleIDs,
+			"actor":   actor,
+		},
+	}
+
+	e.Emit(evt)
+}
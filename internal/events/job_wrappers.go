@@ -0,0 +1,26 @@
+package events
+
+import "hypervisor/internal/models"
+
+// JobFailed records a background job failing for good, after its last attempt.
+func (e *Emitter) JobFailed(job models.Job) {
+	if e == nil {
+		return
+	}
+
+	evt := models.Event{
+		Action:     "job.failed",
+		ActorID:    ActorSystem,
+		ActorRole:  ActorSystem,
+		TargetID:   job.ID,
+		TargetType: "job",
+		Props: map[string]any{
+			"kind":     string(job.Kind),
+			"targetId": job.TargetID,
+			"attempts": job.Attempts,
+			"error":    job.LastError,
+		},
+	}
+
+	e.Emit(evt)
+}
@@ -0,0 +1,25 @@
+package events
+
+import "hypervisor/internal/models"
+
+// MaintenanceUpdated records maintenance mode being switched on, off or reconfigured.
+func (e *Emitter) MaintenanceUpdated(m models.Maintenance) {
+	if e == nil {
+		return
+	}
+
+	evt := models.Event{
+		Action:     "maintenance.updated",
+		ActorID:    ActorSystem,
+		ActorRole:  ActorSystem,
+		TargetID:   "maintenance",
+		TargetType: "proxy",
+		Props: map[string]any{
+			"enabled":    m.Enabled,
+			"retryAfter": m.RetryAfter,
+			"updatedBy":  m.UpdatedBy,
+		},
+	}
+
+	e.Emit(evt)
+}
@@ -0,0 +1,36 @@
+package events
+
+import "hypervisor/internal/models"
+
+// ReconcileCompleted records a reconciliation pass that found deployments out of line.
+func (e *Emitter) ReconcileCompleted(report models.ReconcileReport) {
+	if e == nil {
+		return
+	}
+
+	fixed, flagged := 0, 0
+	for _, finding := range report.Findings {
+		if finding.Action == models.ReconcileActionFixed {
+			fixed++
+		} else {
+			flagged++
+		}
+	}
+
+	evt := models.Event{
+		Action:     "reconcile.completed",
+		ActorID:    ActorSystem,
+		ActorRole:  ActorSystem,
+		TargetID:   "reconcile",
+		TargetType: "hypervisor",
+		Props: map[string]any{
+			"ranBy":    report.RanBy,
+			"checked":  report.Checked,
+			"fixed":    fixed,
+			"flagged":  flagged,
+			"findings": report.Findings,
+		},
+	}
+
+	e.Emit(evt)
+}
@@ -0,0 +1,28 @@
+package events
+
+import "hypervisor/internal/models"
+
+// UnitDefaultsUpdated records the per env tag unit defaults being replaced.
+func (e *Emitter) UnitDefaultsUpdated(d models.UnitDefaults) {
+	if e == nil {
+		return
+	}
+
+	tags := make([]string, 0, len(d.Tags))
+	for tag := range d.Tags {
+		tags = append(tags, tag)
+	}
+
+	evt := models.Event{
+		Action:     "units.defaults_updated",
+		ActorID:    d.UpdatedBy,
+		ActorRole:  ActorHyperUser,
+		TargetID:   "unit_defaults",
+		TargetType: "hypervisor",
+		Props: map[string]any{
+			"tags": tags,
+		},
+	}
+
+	e.Emit(evt)
+}
@@ -1,17 +1,30 @@
 package commands
 
 import (
+	"flag"
 	"fmt"
 	"hypervisor/internal/hyperctl/health"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
 )
 
 // RunInterstate handles the `hyperctl interstate` subcommand.
 // It shows the current routing map by calling the hypervisor API.
+// With --json the structured routing map is printed instead of the text view,
+// and with --resolve it explains which deployment a request path would hit.
 func RunInterstate(args []string) error {
-	if len(args) > 0 {
+	fs := flag.NewFlagSet("interstate", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	asJSON := fs.Bool("json", false, "Print the routing map as JSON")
+	resolve := fs.String("resolve", "", "Explain which deployment serves this request path")
+	resolveHost := fs.String("host", "", "Host header to use with --resolve")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
 		return fmt.Errorf("interstate: unexpected arguments")
 	}
 
@@ -31,13 +44,35 @@ func RunInterstate(args []string) error {
 	}
 
 	// Make API call to get routing map
-	url := fmt.Sprintf("http://%s/hypervisor/routing", host)
-	resp, err := http.Get(url)
+	endpoint := fmt.Sprintf("http://%s/hypervisor/routing", host)
+	if *resolve != "" {
+		query := url.Values{"path": {*resolve}}
+		if *resolveHost != "" {
+			query.Set("host", *resolveHost)
+		}
+		endpoint += "/resolve?" + query.Encode()
+	} else if *asJSON {
+		endpoint += "?format=json"
+	}
+
+	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	// The routing map exposes access policies and fault rules, so it takes a hyperuser token
+	if token := os.Getenv("HYPERVISOR_TOKEN"); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return fmt.Errorf("failed to connect to hypervisor API: %w", err)
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusUnauthorized {
+		return fmt.Errorf("hypervisor API requires a hyperuser token - set HYPERVISOR_TOKEN")
+	}
 	if resp.StatusCode != http.StatusOK {
 		return fmt.Errorf("hypervisor API returned status %d", resp.StatusCode)
 	}
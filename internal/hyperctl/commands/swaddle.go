@@ -1,9 +1,12 @@
 package commands
 
 import (
+	"flag"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
+	"strings"
 
 	"hypervisor/internal/hyperctl/health"
 	"hypervisor/internal/hyperctl/nginx"
@@ -12,7 +15,25 @@ import (
 // RunSwaddle handles the `hyperctl swaddle` subcommand.
 // It installs the nginx configuration for blue-green deployments.
 // The config supports drain mode: when blue returns 503, nginx routes to green.
+// The server_name includes a wildcard for the base domain so stage subdomains
+// (matching the daemon's PROXY_BASE_DOMAIN) reach the hypervisor.
 func RunSwaddle(args []string) error {
+	fs := flag.NewFlagSet("swaddle", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	domain := fs.String("domain", nginx.DefaultDomain, "Base domain; *.<domain> is routed to stage deployments")
+	hosts := fs.String("hosts", "", "Comma-separated custom deployment hostnames outside the base domain")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cfg := nginx.Config{Domain: strings.TrimSpace(*domain)}
+	for _, host := range strings.Split(*hosts, ",") {
+		if host = strings.TrimSpace(host); host != "" {
+			cfg.ExtraHosts = append(cfg.ExtraHosts, host)
+		}
+	}
+
 	fmt.Println("Installing nginx configuration for hypervisor...")
 
 	// Check if hypervisor service is running
@@ -25,10 +46,10 @@ func RunSwaddle(args []string) error {
 	}
 
 	// Install embedded nginx config
-	if err := nginx.InstallConfig(""); err != nil {
+	if err := nginx.InstallConfig(cfg); err != nil {
 		return fmt.Errorf("failed to install nginx config: %w", err)
 	}
-	fmt.Printf("Nginx configuration installed to %s\n", nginx.DefaultNginxConfigPath)
+	fmt.Printf("Nginx configuration installed to %s (server_name %s *.%s)\n", nginx.DefaultNginxConfigPath, cfg.Domain, cfg.Domain)
 
 	// Remove default nginx configurations
 	defaultConfigs := []string{
@@ -11,9 +11,9 @@ func PrintUsage() {
 	fmt.Println("  nagasaki   Stop the running hypervisor service")
 	fmt.Println("  hiroshima  Completely remove all hypervisor and OpenHack directories (destructive)")
 	fmt.Println("  ping       Ping the hypervisor health endpoint")
-	fmt.Println("  interstate Show the current routing map")
+	fmt.Println("  interstate Show the current routing map [--json] [--resolve <path> [--host <host>]]")
 	fmt.Println("  trinity    Update hypervisor by cloning, testing, and building new version")
-	fmt.Println("  swaddle    Generate nginx configuration for the hypervisor")
+	fmt.Println("  swaddle    Generate nginx configuration for the hypervisor [--domain <base>] [--hosts <a,b>]")
 	fmt.Println("  grimhilde  Update hyperctl to the latest version")
 	fmt.Println("  knox       Secure nginx with SSL certificate using certbot")
 	fmt.Println("  version    Show the currently installed hypervisor build")
@@ -8,6 +8,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"text/template"
 )
 
 //go:embed nginx.conf
@@ -17,14 +18,48 @@ const (
 	// DefaultNginxConfigPath is the path where the nginx config will be written
 	// We use conf.d/app.conf which is the common location for drop-in site configs.
 	DefaultNginxConfigPath = "/etc/nginx/conf.d/app.conf"
+
+	// DefaultDomain is the public API domain served by nginx.
+	DefaultDomain = "api.openhack.ro"
 )
 
-// InstallConfig installs the nginx configuration and enables it
-func InstallConfig(configPath string) error {
-	// Read embedded nginx.conf and write to sites-available using sudo if necessary
-	data, err := nginxConf.ReadFile("nginx.conf")
+// Config carries values rendered into the embedded nginx.conf template.
+type Config struct {
+	// Domain is the base domain; *.Domain is also served so stage subdomains reach the hypervisor.
+	Domain string
+	// ExtraHosts are custom deployment hostnames outside the base domain.
+	ExtraHosts []string
+}
+
+// RenderConfig renders the embedded nginx.conf template with cfg.
+func RenderConfig(cfg Config) ([]byte, error) {
+	if cfg.Domain == "" {
+		cfg.Domain = DefaultDomain
+	}
+
+	raw, err := nginxConf.ReadFile("nginx.conf")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read embedded nginx.conf: %w", err)
+	}
+
+	tmpl, err := template.New("nginx.conf").Parse(string(raw))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse nginx.conf template: %w", err)
+	}
+
+	var rendered bytes.Buffer
+	if err := tmpl.Execute(&rendered, cfg); err != nil {
+		return nil, fmt.Errorf("failed to render nginx.conf template: %w", err)
+	}
+	return rendered.Bytes(), nil
+}
+
+// InstallConfig renders the nginx configuration and enables it
+func InstallConfig(cfg Config) error {
+	// Render embedded nginx.conf and write to conf.d using sudo if necessary
+	data, err := RenderConfig(cfg)
 	if err != nil {
-		return fmt.Errorf("failed to read embedded nginx.conf: %w", err)
+		return err
 	}
 
 	// Ensure output directory exists
@@ -51,7 +86,7 @@ func InstallConfig(configPath string) error {
 	return nil
 }
 
-// EmbeddedConfig returns the raw embedded nginx.conf contents.
+// EmbeddedConfig returns the raw embedded nginx.conf template.
 func EmbeddedConfig() (string, error) {
 	data, err := nginxConf.ReadFile("nginx.conf")
 	if err != nil {
@@ -0,0 +1,292 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"hypervisor/internal/env"
+	"hypervisor/internal/events"
+	"hypervisor/internal/models"
+)
+
+// Handler runs one attempt of a job. Handlers must be safe to run again for the same
+// target: a job is retried after an error, and resumed from the start when the
+// instance running it stopped before finishing.
+type Handler func(ctx context.Context, job models.Job) error
+
+// Kind describes how jobs of one kind are run.
+type Kind struct {
+	Handler Handler
+	// MaxAttempts bounds how often a job is run, counting resumed attempts.
+	MaxAttempts int
+	// Backoff is the pause before a retry, multiplied by the attempts made so far.
+	Backoff time.Duration
+	// OnFail is called once a job has failed for good, so its target can be marked failed.
+	OnFail func(ctx context.Context, job models.Job, err error)
+}
+
+var (
+	kindsMu sync.RWMutex
+	kinds   = make(map[models.JobKind]Kind)
+)
+
+// owner identifies this process as the holder of job leases.
+var owner = fmt.Sprintf("%s-%d", hostname(), os.Getpid())
+
+func hostname() string {
+	name, err := os.Hostname()
+	if err != nil {
+		return "unknown"
+	}
+	return name
+}
+
+// The job store calls made while a job runs; tests replace them.
+var (
+	finishJob     = models.FinishJob
+	retryJob      = models.RetryJob
+	renewJobLease = models.RenewJobLease
+)
+
+// wake lets Enqueue start a job right away instead of at the next poll.
+var wake = make(chan struct{}, 1)
+
+// permanentError marks a failure that retrying cannot fix.
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// Permanent wraps err so the job fails without being retried.
+func Permanent(err error) error {
+	return permanentError{err: err}
+}
+
+// IsPermanent reports whether err was wrapped by Permanent.
+func IsPermanent(err error) bool {
+	var permanent permanentError
+	return errors.As(err, &permanent)
+}
+
+// Register sets how jobs of a kind are run. Kinds must be registered before Start.
+func Register(kind models.JobKind, k Kind) {
+	kindsMu.Lock()
+	defer kindsMu.Unlock()
+
+	if k.MaxAttempts <= 0 {
+		k.MaxAttempts = 1
+	}
+	kinds[kind] = k
+}
+
+func registered() []models.JobKind {
+	kindsMu.RLock()
+	defer kindsMu.RUnlock()
+
+	registered := make([]models.JobKind, 0, len(kinds))
+	for kind := range kinds {
+		registered = append(registered, kind)
+	}
+	return registered
+}
+
+func kindOf(kind models.JobKind) (Kind, bool) {
+	kindsMu.RLock()
+	defer kindsMu.RUnlock()
+
+	k, exists := kinds[kind]
+	return k, exists
+}
+
+// Enqueue persists a job for targetID and wakes a worker to run it.
+func Enqueue(ctx context.Context, kind models.JobKind, targetID string) (*models.Job, error) {
+	k, exists := kindOf(kind)
+	if !exists {
+		return nil, fmt.Errorf("unknown job kind %q", kind)
+	}
+
+	now := time.Now()
+	job := models.Job{
+		ID:          models.NewJobID(),
+		Kind:        kind,
+		TargetID:    targetID,
+		State:       models.JobStateQueued,
+		MaxAttempts: k.MaxAttempts,
+		RunAfter:    now,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if err := models.CreateJob(ctx, job); err != nil {
+		return nil, err
+	}
+
+	select {
+	case wake <- struct{}{}:
+	default:
+	}
+	return &job, nil
+}
+
+// Start runs JOB_WORKERS workers until ctx is done. Jobs left running by an instance
+// that stopped are resumed, or failed once out of attempts, when their lease expires.
+func Start(ctx context.Context) {
+	for i := 0; i < env.JOB_WORKERS; i++ {
+		go worker(ctx)
+	}
+}
+
+func worker(ctx context.Context) {
+	ticker := time.NewTicker(env.JOB_POLL_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		// Work through everything that is due before waiting again
+		for runNext(ctx) {
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-wake:
+		}
+	}
+}
+
+// runNext claims and runs one due job, and reports whether there was one.
+func runNext(ctx context.Context) bool {
+	// An instance being drained leaves new work to the one replacing it
+	if env.DRAIN_MODE || ctx.Err() != nil {
+		return false
+	}
+
+	job, err := models.ClaimJob(ctx, registered(), owner, env.JOB_LEASE_DURATION, time.Now())
+	if err != nil {
+		log.Printf("failed to claim job: %v", err)
+		return false
+	}
+	if job == nil {
+		return false
+	}
+
+	run(ctx, job)
+	return true
+}
+
+func run(ctx context.Context, job *models.Job) {
+	k, _ := kindOf(job.Kind)
+
+	if job.Attempts > job.MaxAttempts {
+		// The lease of its last attempt expired, most likely with the instance running it
+		fail(job, k, fmt.Errorf("abandoned after %d attempts", job.MaxAttempts))
+		return
+	}
+	if job.Attempts > 1 {
+		log.Printf("running job %s (%s %s), attempt %d of %d", job.ID, job.Kind, job.TargetID, job.Attempts, job.MaxAttempts)
+	}
+
+	jobCtx, cancel := context.WithCancelCause(ctx)
+	renewed := make(chan struct{})
+	go func() {
+		defer close(renewed)
+		renewLease(jobCtx, cancel, job)
+	}()
+	err := k.Handler(jobCtx, *job)
+	lost := errors.Is(context.Cause(jobCtx), errLeaseLost)
+	cancel(nil)
+	// No renewal may land after the outcome is recorded
+	<-renewed
+
+	// Another instance has claimed the job since; its outcome is for that one to record
+	if lost {
+		log.Printf("job %s (%s %s) stopped after its lease was lost", job.ID, job.Kind, job.TargetID)
+		return
+	}
+
+	switch {
+	case err == nil:
+		held, err := finishJob(context.Background(), job.ID, owner, models.JobStateSucceeded, "")
+		if err != nil {
+			log.Printf("failed to record job %s as succeeded: %v", job.ID, err)
+		} else if !held {
+			log.Printf("job %s succeeded after its lease was lost", job.ID)
+		}
+	case IsPermanent(err) || job.Attempts >= job.MaxAttempts:
+		fail(job, k, err)
+	default:
+		runAfter := time.Now().Add(time.Duration(job.Attempts) * k.Backoff)
+		log.Printf("job %s (%s %s) failed, retrying after %s: %v", job.ID, job.Kind, job.TargetID, runAfter.Format(time.RFC3339), err)
+		held, err := retryJob(context.Background(), job.ID, owner, runAfter, err.Error())
+		if err != nil {
+			log.Printf("failed to requeue job %s: %v", job.ID, err)
+		} else if !held {
+			log.Printf("job %s not requeued: its lease was lost", job.ID)
+		}
+	}
+}
+
+// fail records a job as failed for good and lets its kind clean up the target. A job
+// whose lease was lost is left to the instance holding it now.
+func fail(job *models.Job, k Kind, err error) {
+	log.Printf("job %s (%s %s) failed: %v", job.ID, job.Kind, job.TargetID, err)
+
+	ctx := context.Background()
+	held, finishErr := finishJob(ctx, job.ID, owner, models.JobStateFailed, err.Error())
+	if finishErr != nil {
+		log.Printf("failed to record job %s as failed: %v", job.ID, finishErr)
+	} else if !held {
+		log.Printf("job %s not recorded as failed: its lease was lost", job.ID)
+		return
+	}
+	if k.OnFail != nil {
+		k.OnFail(ctx, *job, err)
+	}
+
+	job.State = models.JobStateFailed
+	job.LastError = err.Error()
+	if events.Em != nil {
+		events.Em.JobFailed(*job)
+	}
+}
+
+// errLeaseLost cancels a job's context once another instance may have claimed it.
+var errLeaseLost = errors.New("job lease lost")
+
+// renewLease keeps the job leased to this instance while its handler runs, and
+// cancels the handler if the lease is lost.
+func renewLease(ctx context.Context, cancel context.CancelCauseFunc, job *models.Job) {
+	ticker := time.NewTicker(env.JOB_LEASE_DURATION / 3)
+	defer ticker.Stop()
+
+	leasedUntil := time.Now().Add(env.JOB_LEASE_DURATION)
+	if job.LeaseExpiresAt != nil {
+		leasedUntil = *job.LeaseExpiresAt
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			expires := time.Now().Add(env.JOB_LEASE_DURATION)
+			held, err := renewJobLease(context.Background(), job.ID, owner, expires)
+			switch {
+			case err != nil && time.Now().Before(leasedUntil):
+				log.Printf("failed to renew lease on job %s: %v", job.ID, err)
+			case err != nil || !held:
+				// Past its expiry the lease may be claimed even if no renewal said so
+				log.Printf("lost lease on job %s", job.ID)
+				cancel(errLeaseLost)
+				return
+			default:
+				leasedUntil = expires
+			}
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"hypervisor/internal/env"
+	"hypervisor/internal/models"
+)
+
+const testKind models.JobKind = "jobs_test"
+
+// fakeStore records the job store calls made by run.
+type fakeStore struct {
+	mu       sync.Mutex
+	held     bool // what finish and retry report
+	leased   bool // what lease renewals report
+	states   []models.JobState
+	errors   []string
+	retries  []time.Time
+	renewals int
+}
+
+func useFakeStore(t *testing.T, leaseDuration time.Duration) *fakeStore {
+	t.Helper()
+	store := &fakeStore{held: true, leased: true}
+
+	finish, retry, renew, lease := finishJob, retryJob, renewJobLease, env.JOB_LEASE_DURATION
+	t.Cleanup(func() {
+		finishJob, retryJob, renewJobLease, env.JOB_LEASE_DURATION = finish, retry, renew, lease
+	})
+
+	env.JOB_LEASE_DURATION = leaseDuration
+	finishJob = func(ctx context.Context, id, owner string, state models.JobState, lastError string) (bool, error) {
+		store.mu.Lock()
+		defer store.mu.Unlock()
+		store.states = append(store.states, state)
+		store.errors = append(store.errors, lastError)
+		return store.held, nil
+	}
+	retryJob = func(ctx context.Context, id, owner string, runAfter time.Time, lastError string) (bool, error) {
+		store.mu.Lock()
+		defer store.mu.Unlock()
+		store.retries = append(store.retries, runAfter)
+		store.errors = append(store.errors, lastError)
+		return store.held, nil
+	}
+	renewJobLease = func(ctx context.Context, id, owner string, expires time.Time) (bool, error) {
+		store.mu.Lock()
+		defer store.mu.Unlock()
+		store.renewals++
+		return store.leased, nil
+	}
+	return store
+}
+
+// registerTestKind registers testKind with handler and counts OnFail calls.
+func registerTestKind(t *testing.T, handler Handler) *int {
+	t.Helper()
+	failed := new(int)
+	Register(testKind, Kind{
+		Handler:     handler,
+		MaxAttempts: 3,
+		Backoff:     time.Minute,
+		OnFail: func(ctx context.Context, job models.Job, err error) {
+			*failed++
+		},
+	})
+	t.Cleanup(func() {
+		kindsMu.Lock()
+		delete(kinds, testKind)
+		kindsMu.Unlock()
+	})
+	return failed
+}
+
+func testJob(attempts int) *models.Job {
+	return &models.Job{ID: "job", Kind: testKind, TargetID: "target", Attempts: attempts, MaxAttempts: 3}
+}
+
+func TestRunSucceeds(t *testing.T) {
+	store := useFakeStore(t, time.Minute)
+	failed := registerTestKind(t, func(ctx context.Context, job models.Job) error { return nil })
+
+	run(context.Background(), testJob(1))
+
+	if len(store.states) != 1 || store.states[0] != models.JobStateSucceeded {
+		t.Errorf("Expected the job to be recorded as succeeded, got %v", store.states)
+	}
+	if len(store.retries) != 0 || *failed != 0 {
+		t.Errorf("Expected no retry and no OnFail, got %d retries and %d failures", len(store.retries), *failed)
+	}
+}
+
+func TestRunRetriesWithBackoff(t *testing.T) {
+	store := useFakeStore(t, time.Minute)
+	failed := registerTestKind(t, func(ctx context.Context, job models.Job) error { return errors.New("git fetch failed") })
+
+	before := time.Now()
+	run(context.Background(), testJob(2))
+
+	if len(store.retries) != 1 {
+		t.Fatalf("Expected the job to be requeued once, got %d", len(store.retries))
+	}
+	if runAfter := store.retries[0]; runAfter.Before(before.Add(2*time.Minute)) || runAfter.After(time.Now().Add(2*time.Minute)) {
+		t.Errorf("Expected the retry after two backoffs, got %v", runAfter)
+	}
+	if store.errors[0] != "git fetch failed" {
+		t.Errorf("Expected the error to be recorded, got %q", store.errors[0])
+	}
+	if len(store.states) != 0 || *failed != 0 {
+		t.Errorf("Expected the job not to be finished, got %v and %d failures", store.states, *failed)
+	}
+}
+
+func TestRunFailsOnLastAttempt(t *testing.T) {
+	store := useFakeStore(t, time.Minute)
+	failed := registerTestKind(t, func(ctx context.Context, job models.Job) error { return errors.New("still failing") })
+
+	run(context.Background(), testJob(3))
+
+	if len(store.states) != 1 || store.states[0] != models.JobStateFailed {
+		t.Errorf("Expected the job to be recorded as failed, got %v", store.states)
+	}
+	if len(store.retries) != 0 || *failed != 1 {
+		t.Errorf("Expected no retry and one OnFail, got %d retries and %d failures", len(store.retries), *failed)
+	}
+}
+
+func TestRunFailsPermanentErrors(t *testing.T) {
+	store := useFakeStore(t, time.Minute)
+	failed := registerTestKind(t, func(ctx context.Context, job models.Job) error {
+		return Permanent(errors.New("build failed"))
+	})
+
+	run(context.Background(), testJob(1))
+
+	if len(store.states) != 1 || store.states[0] != models.JobStateFailed {
+		t.Errorf("Expected a permanent error to fail the job, got %v", store.states)
+	}
+	if len(store.retries) != 0 || *failed != 1 {
+		t.Errorf("Expected no retry and one OnFail, got %d retries and %d failures", len(store.retries), *failed)
+	}
+}
+
+func TestRunFailsAbandonedJobs(t *testing.T) {
+	store := useFakeStore(t, time.Minute)
+	ran := false
+	failed := registerTestKind(t, func(ctx context.Context, job models.Job) error {
+		ran = true
+		return nil
+	})
+
+	run(context.Background(), testJob(4))
+
+	if ran {
+		t.Errorf("Expected a job out of attempts not to run again")
+	}
+	if len(store.states) != 1 || store.states[0] != models.JobStateFailed || *failed != 1 {
+		t.Errorf("Expected the job to fail, got %v and %d failures", store.states, *failed)
+	}
+}
+
+func TestRunSkipsOnFailWithoutLease(t *testing.T) {
+	store := useFakeStore(t, time.Minute)
+	store.held = false
+	failed := registerTestKind(t, func(ctx context.Context, job models.Job) error {
+		return Permanent(errors.New("build failed"))
+	})
+
+	run(context.Background(), testJob(1))
+
+	if *failed != 0 {
+		t.Errorf("Expected OnFail to be left to the lease holder, got %d calls", *failed)
+	}
+}
+
+func TestRunStopsWhenLeaseIsLost(t *testing.T) {
+	store := useFakeStore(t, 30*time.Millisecond)
+	store.leased = false
+	failed := registerTestKind(t, func(ctx context.Context, job models.Job) error {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(5 * time.Second):
+			return nil
+		}
+	})
+
+	start := time.Now()
+	run(context.Background(), testJob(1))
+
+	if time.Since(start) >= 5*time.Second {
+		t.Fatalf("Expected the handler to be cancelled once the lease was lost")
+	}
+	if store.renewals == 0 {
+		t.Errorf("Expected the lease to be renewed")
+	}
+	if len(store.states) != 0 || len(store.retries) != 0 || *failed != 0 {
+		t.Errorf("Expected nothing to be recorded, got %v, %d retries and %d failures", store.states, len(store.retries), *failed)
+	}
+}
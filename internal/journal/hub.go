@@ -0,0 +1,167 @@
+package journal
+
+import (
+	"context"
+	"log"
+	"os/exec"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// subscriberBuffer is how many entries a slow subscriber may fall behind before
+// entries are dropped for it.
+const subscriberBuffer = 256
+
+// restartDelay spaces attempts to restart a follower whose journalctl exited.
+const restartDelay = time.Second
+
+// Followers shares one journalctl follower per unit among all its subscribers.
+var Followers = NewHub(FollowJournal)
+
+// Source follows the log of a unit, handing every new entry to emit, until ctx is
+// done or it fails.
+type Source func(ctx context.Context, unit string, emit func(Entry)) error
+
+// Hub runs one Source per unit for as long as the unit has subscribers.
+type Hub struct {
+	source    Source
+	mu        sync.Mutex
+	followers map[string]*follower
+}
+
+// NewHub returns a hub with no followers that reads entries from source.
+func NewHub(source Source) *Hub {
+	return &Hub{source: source, followers: make(map[string]*follower)}
+}
+
+type follower struct {
+	cancel      context.CancelFunc
+	subscribers map[*Subscription]struct{}
+}
+
+// Subscription receives the entries a unit writes to the journal after it was created.
+type Subscription struct {
+	C <-chan Entry
+
+	hub     *Hub
+	unit    string
+	ch      chan Entry
+	dropped atomic.Int64
+	once    sync.Once
+}
+
+// Dropped returns how many entries were dropped since the last call because the
+// subscriber fell behind.
+func (s *Subscription) Dropped() int64 {
+	return s.dropped.Swap(0)
+}
+
+// Close unsubscribes; the unit's follower stops with its last subscriber.
+func (s *Subscription) Close() {
+	s.once.Do(func() {
+		s.hub.unsubscribe(s)
+	})
+}
+
+// Subscribe starts following unit, unless it is already followed, and returns a
+// subscription to its new entries.
+func (h *Hub) Subscribe(unit string) *Subscription {
+	ch := make(chan Entry, subscriberBuffer)
+	sub := &Subscription{C: ch, hub: h, unit: unit, ch: ch}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	f, exists := h.followers[unit]
+	if !exists {
+		ctx, cancel := context.WithCancel(context.Background())
+		f = &follower{cancel: cancel, subscribers: make(map[*Subscription]struct{})}
+		h.followers[unit] = f
+		go h.follow(ctx, unit, f)
+	}
+	f.subscribers[sub] = struct{}{}
+	return sub
+}
+
+func (h *Hub) unsubscribe(sub *Subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	f, exists := h.followers[sub.unit]
+	if !exists {
+		return
+	}
+	delete(f.subscribers, sub)
+	if len(f.subscribers) == 0 {
+		f.cancel()
+		delete(h.followers, sub.unit)
+	}
+}
+
+// follow runs the source for unit until ctx is done, restarting it if it exits.
+func (h *Hub) follow(ctx context.Context, unit string, f *follower) {
+	emit := func(entry Entry) {
+		h.broadcast(f, entry)
+	}
+
+	for {
+		if err := h.source(ctx, unit, emit); err != nil && ctx.Err() == nil {
+			log.Printf("log follower for %s exited: %v", unit, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(restartDelay):
+		}
+	}
+}
+
+// FollowJournal is the Source running `journalctl --follow` for a systemd unit.
+func FollowJournal(ctx context.Context, unit string, emit func(Entry)) error {
+	// Also stops journalctl when reading its output fails
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "sudo", "journalctl", "--unit", unit, "--output", "json", "--no-pager", "--follow", "--lines", "0")
+	// sudo relays SIGTERM to journalctl, but cannot relay the default SIGKILL
+	cmd.Cancel = func() error {
+		return cmd.Process.Signal(syscall.SIGTERM)
+	}
+	cmd.WaitDelay = 5 * time.Second
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	scanErr := scan(stdout, func(entry Entry) bool {
+		emit(entry)
+		return true
+	})
+	cancel()
+	waitErr := cmd.Wait()
+	if scanErr != nil {
+		return scanErr
+	}
+	return waitErr
+}
+
+// broadcast hands entry to every subscriber of f without waiting for slow ones.
+func (h *Hub) broadcast(f *follower, entry Entry) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for sub := range f.subscribers {
+		select {
+		case sub.ch <- entry:
+		default:
+			sub.dropped.Add(1)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package journal
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// maxLineSize bounds a single JSON entry read from journalctl.
+const maxLineSize = 1 << 20
+
+// Entry is one line a unit wrote to the journal.
+type Entry struct {
+	Time     time.Time `json:"time"`
+	Message  string    `json:"message"`
+	Priority int       `json:"priority"` // syslog level, 0 (emerg) to 7 (debug)
+	PID      string    `json:"pid,omitempty"`
+	Cursor   string    `json:"-"` // identifies the entry in the journal
+}
+
+// Query selects entries of a unit from the journal.
+type Query struct {
+	Since time.Time // zero reads from the start of the journal
+	Lines int       // most recent entries returned; required
+}
+
+// Filter is a grep-style match on entry messages. A nil Pattern matches everything.
+type Filter struct {
+	Pattern *regexp.Regexp
+	Invert  bool // keep the entries that do not match, like grep -v
+}
+
+// Matches reports whether the filter keeps entry.
+func (f Filter) Matches(entry Entry) bool {
+	if f.Pattern == nil {
+		return true
+	}
+	return f.Pattern.MatchString(entry.Message) != f.Invert
+}
+
+// Read returns the most recent entries of a unit matching q, oldest first.
+func Read(ctx context.Context, unit string, q Query) ([]Entry, error) {
+	args := []string{"journalctl", "--unit", unit, "--output", "json", "--no-pager", "--lines", strconv.Itoa(q.Lines)}
+	if !q.Since.IsZero() {
+		args = append(args, "--since", fmt.Sprintf("@%d", q.Since.Unix()))
+	}
+
+	output, err := exec.CommandContext(ctx, "sudo", args...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("journalctl failed: %w", err)
+	}
+
+	entries := []Entry{}
+	err = scan(bytes.NewReader(output), func(entry Entry) bool {
+		entries = append(entries, entry)
+		return true
+	})
+	return entries, err
+}
+
+// scan parses journalctl's JSON output, one entry per line, until fn returns false.
+// Lines that cannot be parsed are skipped.
+func scan(r io.Reader, fn func(Entry) bool) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxLineSize)
+	for scanner.Scan() {
+		entry, ok := parse(scanner.Bytes())
+		if !ok {
+			continue
+		}
+		if !fn(entry) {
+			return nil
+		}
+	}
+	return scanner.Err()
+}
+
+// parse decodes one line of `journalctl --output json`.
+func parse(line []byte) (Entry, bool) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(line, &fields); err != nil {
+		return Entry{}, false
+	}
+
+	var entry Entry
+	entry.Cursor = stringField(fields["__CURSOR"])
+	if usec, err := strconv.ParseInt(stringField(fields["__REALTIME_TIMESTAMP"]), 10, 64); err == nil {
+		entry.Time = time.UnixMicro(usec)
+	}
+	entry.Priority = 6 // info, for entries without a priority
+	if priority, err := strconv.Atoi(stringField(fields["PRIORITY"])); err == nil {
+		entry.Priority = priority
+	}
+	entry.PID = stringField(fields["_PID"])
+	entry.Message = messageField(fields["MESSAGE"])
+	return entry, true
+}
+
+func stringField(raw json.RawMessage) string {
+	var s string
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return ""
+	}
+	return s
+}
+
+// messageField decodes MESSAGE, which journalctl writes as an array of bytes when
+// the message is not valid UTF-8.
+func messageField(raw json.RawMessage) string {
+	if s := stringField(raw); s != "" {
+		return s
+	}
+
+	var b []int
+	if err := json.Unmarshal(raw, &b); err != nil {
+		return ""
+	}
+	message := make([]byte, len(b))
+	for i, c := range b {
+		message[i] = byte(c)
+	}
+	return string(message)
+}
@@ -0,0 +1,108 @@
+package journal
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	line := `{"__CURSOR":"s=abc;i=1","__REALTIME_TIMESTAMP":"1700000000123456","PRIORITY":"3","_PID":"4242","MESSAGE":"listening on :8080"}`
+
+	entry, ok := parse([]byte(line))
+	if !ok {
+		t.Fatalf("Expected the line to parse")
+	}
+	if entry.Cursor != "s=abc;i=1" {
+		t.Errorf("Expected cursor s=abc;i=1, got %q", entry.Cursor)
+	}
+	if !entry.Time.Equal(time.UnixMicro(1700000000123456)) {
+		t.Errorf("Expected time %v, got %v", time.UnixMicro(1700000000123456), entry.Time)
+	}
+	if entry.Priority != 3 {
+		t.Errorf("Expected priority 3, got %d", entry.Priority)
+	}
+	if entry.PID != "4242" {
+		t.Errorf("Expected PID 4242, got %q", entry.PID)
+	}
+	if entry.Message != "listening on :8080" {
+		t.Errorf("Expected the message, got %q", entry.Message)
+	}
+}
+
+func TestParseDefaults(t *testing.T) {
+	entry, ok := parse([]byte(`{"MESSAGE":"no metadata"}`))
+	if !ok {
+		t.Fatalf("Expected the line to parse")
+	}
+	if entry.Priority != 6 {
+		t.Errorf("Expected the default priority 6, got %d", entry.Priority)
+	}
+	if !entry.Time.IsZero() || entry.PID != "" || entry.Cursor != "" {
+		t.Errorf("Expected empty metadata, got %+v", entry)
+	}
+
+	if _, ok := parse([]byte("-- No entries --")); ok {
+		t.Errorf("Expected a non-JSON line to be skipped")
+	}
+}
+
+func TestMessageField(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want string
+	}{
+		{"string", `"hello"`, "hello"},
+		{"bytes", `[104,105,255]`, "hi\xff"},
+		{"null", `null`, ""},
+		{"number", `42`, ""},
+	}
+
+	for _, tt := range tests {
+		if got := messageField(json.RawMessage(tt.raw)); got != tt.want {
+			t.Errorf("%s: expected %q, got %q", tt.name, tt.want, got)
+		}
+	}
+
+	if got := messageField(nil); got != "" {
+		t.Errorf("Expected a missing message to be empty, got %q", got)
+	}
+}
+
+func TestScanSkipsBadLines(t *testing.T) {
+	input := strings.Join([]string{
+		`{"MESSAGE":"first"}`,
+		`not json`,
+		`{"MESSAGE":"second"}`,
+		`{"MESSAGE":"third"}`,
+	}, "\n")
+
+	var messages []string
+	err := scan(strings.NewReader(input), func(entry Entry) bool {
+		messages = append(messages, entry.Message)
+		return len(messages) < 2
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if strings.Join(messages, ",") != "first,second" {
+		t.Errorf("Expected to stop after first,second, got %v", messages)
+	}
+}
+
+func TestFilterMatches(t *testing.T) {
+	entry := Entry{Message: "panic: runtime error"}
+
+	if !(Filter{}).Matches(entry) {
+		t.Errorf("Expected an empty filter to match")
+	}
+	if !(Filter{Pattern: regexp.MustCompile("panic")}).Matches(entry) {
+		t.Errorf("Expected the pattern to match")
+	}
+	if (Filter{Pattern: regexp.MustCompile("panic"), Invert: true}).Matches(entry) {
+		t.Errorf("Expected the inverted pattern not to match")
+	}
+}
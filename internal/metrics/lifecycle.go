@@ -0,0 +1,42 @@
+package metrics
+
+import (
+	"context"
+	"io"
+
+	"hypervisor/internal/models"
+)
+
+// writeLifecycle renders stage, test and deployment counts by status, and the
+// number of lifecycle events recorded per action, straight from MongoDB.
+func writeLifecycle(ctx context.Context, w io.Writer) error {
+	stages, err := models.CountStagesByStatus(ctx)
+	if err != nil {
+		return err
+	}
+	writeHeader(w, "hypervisor_stages", "gauge", "Number of stages by status.")
+	writeCounts(w, "hypervisor_stages", "status", stages)
+
+	tests, err := models.CountTestsByStatus(ctx)
+	if err != nil {
+		return err
+	}
+	writeHeader(w, "hypervisor_tests", "gauge", "Number of test runs by status.")
+	writeCounts(w, "hypervisor_tests", "status", tests)
+
+	deployments, err := models.CountDeploymentsByStatus(ctx)
+	if err != nil {
+		return err
+	}
+	writeHeader(w, "hypervisor_deployments", "gauge", "Number of deployments by status.")
+	writeCounts(w, "hypervisor_deployments", "status", deployments)
+
+	events, err := models.CountEventsByAction(ctx)
+	if err != nil {
+		return err
+	}
+	writeHeader(w, "hypervisor_events_total", "counter", "Lifecycle events recorded, by action.")
+	writeCounts(w, "hypervisor_events_total", "action", events)
+
+	return nil
+}
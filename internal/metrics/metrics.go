@@ -0,0 +1,75 @@
+// Package metrics collects proxy, process and lifecycle metrics and renders them
+// in the Prometheus text exposition format (version 0.0.4).
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ContentType is the media type of the Prometheus text exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// label is a single name="value" pair attached to a sample.
+type label struct {
+	name  string
+	value string
+}
+
+// Write renders every metric family to w.
+func Write(ctx context.Context, w io.Writer) {
+	Proxy.write(w)
+	writeProcess(w)
+	if err := writeLifecycle(ctx, w); err != nil {
+		// Keep serving proxy and process metrics when MongoDB is unavailable
+		log.Printf("failed to collect lifecycle metrics: %v", err)
+	}
+}
+
+// writeHeader writes the HELP and TYPE lines that introduce a metric family.
+func writeHeader(w io.Writer, name, metricType, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, metricType)
+}
+
+// writeSample writes a single sample line.
+func writeSample(w io.Writer, name string, labels []label, value float64) {
+	fmt.Fprintf(w, "%s%s %s\n", name, formatLabels(labels), formatValue(value))
+}
+
+func formatLabels(labels []label) string {
+	if len(labels) == 0 {
+		return ""
+	}
+
+	parts := make([]string, 0, len(labels))
+	for _, l := range labels {
+		parts = append(parts, l.name+`="`+labelEscaper.Replace(l.value)+`"`)
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// labelEscaper escapes label values as required by the text format.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatValue(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// writeCounts writes one gauge sample per status, sorted for stable output.
+func writeCounts(w io.Writer, name string, labelName string, counts map[string]int64) {
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		writeSample(w, name, []label{{labelName, key}}, float64(counts[key]))
+	}
+}
@@ -0,0 +1,57 @@
+package metrics
+
+import (
+	"io"
+	"os"
+	"runtime"
+	"syscall"
+	"time"
+
+	"hypervisor/internal/env"
+)
+
+// startTime is when the hypervisor process started serving metrics.
+var startTime = time.Now()
+
+// writeProcess renders hypervisor build, Go runtime and process resource metrics.
+func writeProcess(w io.Writer) {
+	writeHeader(w, "hypervisor_build_info", "gauge", "Hypervisor build information.")
+	writeSample(w, "hypervisor_build_info", []label{{"version", env.VERSION}, {"goversion", runtime.Version()}}, 1)
+
+	writeHeader(w, "process_start_time_seconds", "gauge", "Start time of the process since unix epoch in seconds.")
+	writeSample(w, "process_start_time_seconds", nil, float64(startTime.Unix()))
+
+	var usage syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &usage); err == nil {
+		cpu := time.Duration(usage.Utime.Nano() + usage.Stime.Nano())
+		writeHeader(w, "process_cpu_seconds_total", "counter", "Total user and system CPU time spent in seconds.")
+		writeSample(w, "process_cpu_seconds_total", nil, cpu.Seconds())
+
+		// Maxrss is reported in kilobytes on Linux
+		writeHeader(w, "process_max_resident_memory_bytes", "gauge", "Peak resident memory size in bytes.")
+		writeSample(w, "process_max_resident_memory_bytes", nil, float64(usage.Maxrss)*1024)
+	}
+
+	if fds, err := os.ReadDir("/proc/self/fd"); err == nil {
+		writeHeader(w, "process_open_fds", "gauge", "Number of open file descriptors.")
+		writeSample(w, "process_open_fds", nil, float64(len(fds)))
+	}
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	writeHeader(w, "go_goroutines", "gauge", "Number of goroutines that currently exist.")
+	writeSample(w, "go_goroutines", nil, float64(runtime.NumGoroutine()))
+
+	writeHeader(w, "go_memstats_alloc_bytes", "gauge", "Number of bytes allocated and still in use.")
+	writeSample(w, "go_memstats_alloc_bytes", nil, float64(mem.Alloc))
+
+	writeHeader(w, "go_memstats_heap_inuse_bytes", "gauge", "Number of heap bytes that are in use.")
+	writeSample(w, "go_memstats_heap_inuse_bytes", nil, float64(mem.HeapInuse))
+
+	writeHeader(w, "go_memstats_sys_bytes", "gauge", "Number of bytes obtained from the system.")
+	writeSample(w, "go_memstats_sys_bytes", nil, float64(mem.Sys))
+
+	writeHeader(w, "go_gc_cycles_total", "counter", "Number of completed GC cycles.")
+	writeSample(w, "go_gc_cycles_total", nil, float64(mem.NumGC))
+}
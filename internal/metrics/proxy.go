@@ -0,0 +1,153 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// latencyBuckets are the upper bounds, in seconds, of the request duration histogram.
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// proxyKey identifies the series a proxied request is recorded under.
+type proxyKey struct {
+	deployment string
+	stage      string
+	route      string
+}
+
+// proxySeries accumulates the metrics for one proxyKey.
+type proxySeries struct {
+	requests      map[string]uint64 // status class (2xx, 5xx, ...) -> count
+	buckets       []uint64          // non-cumulative counts per latencyBuckets entry
+	durationSum   float64
+	durationCount uint64
+	bytesIn       uint64
+	bytesOut      uint64
+}
+
+// ProxyMetrics records traffic flowing through the reverse proxy.
+type ProxyMetrics struct {
+	mu     sync.Mutex
+	series map[proxyKey]*proxySeries
+}
+
+// Proxy is the process-wide proxy metrics recorder.
+var Proxy = &ProxyMetrics{series: make(map[proxyKey]*proxySeries)}
+
+// ObserveRequest records one proxied request. deploymentID and stageID are empty
+// when no deployment matched; route is the kind of route that handled it (main,
+// stage, host, override or none).
+func (m *ProxyMetrics) ObserveRequest(deploymentID, stageID, route string, status int, duration time.Duration, bytesIn, bytesOut int) {
+	key := proxyKey{deployment: deploymentID, stage: stageID, route: route}
+	seconds := duration.Seconds()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	series, exists := m.series[key]
+	if !exists {
+		series = &proxySeries{
+			requests: make(map[string]uint64),
+			buckets:  make([]uint64, len(latencyBuckets)),
+		}
+		m.series[key] = series
+	}
+
+	series.requests[statusClass(status)]++
+	for i, bound := range latencyBuckets {
+		if seconds <= bound {
+			series.buckets[i]++
+			break
+		}
+	}
+	series.durationSum += seconds
+	series.durationCount++
+	series.bytesIn += uint64(max(bytesIn, 0))
+	series.bytesOut += uint64(max(bytesOut, 0))
+}
+
+// Forget drops the series of a deployment that left routing, so revisions that
+// were replaced do not keep their series forever.
+func (m *ProxyMetrics) Forget(deploymentID string) {
+	if deploymentID == "" {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for key := range m.series {
+		if key.deployment == deploymentID {
+			delete(m.series, key)
+		}
+	}
+}
+
+// statusClass buckets an HTTP status code into 1xx..5xx.
+func statusClass(status int) string {
+	if status < 100 || status > 599 {
+		return "unknown"
+	}
+	return fmt.Sprintf("%dxx", status/100)
+}
+
+// write renders the proxy metric families.
+func (m *ProxyMetrics) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	keys := make([]proxyKey, 0, len(m.series))
+	for key := range m.series {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].deployment != keys[j].deployment {
+			return keys[i].deployment < keys[j].deployment
+		}
+		return keys[i].route < keys[j].route
+	})
+
+	writeHeader(w, "hypervisor_proxy_requests_total", "counter", "Requests handled by the proxy, by deployment, route kind and status class.")
+	for _, key := range keys {
+		series := m.series[key]
+		classes := make([]string, 0, len(series.requests))
+		for class := range series.requests {
+			classes = append(classes, class)
+		}
+		sort.Strings(classes)
+
+		for _, class := range classes {
+			writeSample(w, "hypervisor_proxy_requests_total", append(key.labels(), label{"code", class}), float64(series.requests[class]))
+		}
+	}
+
+	writeHeader(w, "hypervisor_proxy_request_duration_seconds", "histogram", "Time spent proxying requests, including the backend's response time.")
+	for _, key := range keys {
+		series := m.series[key]
+		var cumulative uint64
+		for i, bound := range latencyBuckets {
+			cumulative += series.buckets[i]
+			writeSample(w, "hypervisor_proxy_request_duration_seconds_bucket", append(key.labels(), label{"le", formatValue(bound)}), float64(cumulative))
+		}
+		writeSample(w, "hypervisor_proxy_request_duration_seconds_bucket", append(key.labels(), label{"le", "+Inf"}), float64(series.durationCount))
+		writeSample(w, "hypervisor_proxy_request_duration_seconds_sum", key.labels(), series.durationSum)
+		writeSample(w, "hypervisor_proxy_request_duration_seconds_count", key.labels(), float64(series.durationCount))
+	}
+
+	writeHeader(w, "hypervisor_proxy_request_bytes_total", "counter", "Request body bytes received from clients.")
+	for _, key := range keys {
+		writeSample(w, "hypervisor_proxy_request_bytes_total", key.labels(), float64(m.series[key].bytesIn))
+	}
+
+	writeHeader(w, "hypervisor_proxy_response_bytes_total", "counter", "Response body bytes sent to clients.")
+	for _, key := range keys {
+		writeSample(w, "hypervisor_proxy_response_bytes_total", key.labels(), float64(m.series[key].bytesOut))
+	}
+}
+
+func (k proxyKey) labels() []label {
+	return []label{{"deployment", k.deployment}, {"stage", k.stage}, {"route", k.route}}
+}
@@ -0,0 +1,54 @@
+package models
+
+import (
+	"context"
+	"hypervisor/internal/db"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func CountStagesByStatus(ctx context.Context) (map[string]int64, error) {
+	return countBy(ctx, db.Stages, "status")
+}
+
+func CountTestsByStatus(ctx context.Context) (map[string]int64, error) {
+	return countBy(ctx, db.Tests, "status")
+}
+
+func CountDeploymentsByStatus(ctx context.Context) (map[string]int64, error) {
+	return countBy(ctx, db.Deployments, "status")
+}
+
+func CountEventsByAction(ctx context.Context) (map[string]int64, error) {
+	return countBy(ctx, db.Events, "action")
+}
+
+// countBy groups a collection's documents by field and counts each group.
+func countBy(ctx context.Context, coll *mongo.Collection, field string) (map[string]int64, error) {
+	counts := make(map[string]int64)
+	if coll == nil {
+		return counts, nil
+	}
+
+	cursor, err := coll.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$group", Value: bson.M{"_id": "$" + field, "count": bson.M{"$sum": 1}}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var groups []struct {
+		ID    string `bson:"_id"`
+		Count int64  `bson:"count"`
+	}
+	if err := cursor.All(ctx, &groups); err != nil {
+		return nil, err
+	}
+
+	for _, group := range groups {
+		counts[group.ID] += group.Count
+	}
+	return counts, nil
+}
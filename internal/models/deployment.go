@@ -2,10 +2,14 @@ package models
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"hypervisor/internal/db"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type DeploymentStatus string
@@ -13,24 +17,204 @@ type DeploymentStatus string
 const (
 	DeploymentStatusProvisioning    DeploymentStatus = "provisioning"
 	DeploymentStatusReady           DeploymentStatus = "ready"
+	DeploymentStatusDraining        DeploymentStatus = "draining"
 	DeploymentStatusStopped         DeploymentStatus = "stopped"
 	DeploymentStatusBuildFailed     DeploymentStatus = "build_failed"
 	DeploymentStatusProvisionFailed DeploymentStatus = "provision_failed"
+	DeploymentStatusSuperseded      DeploymentStatus = "superseded"
+	DeploymentStatusStartFailed     DeploymentStatus = "start_failed" // the unit did not stay active after starting
+	DeploymentStatusUnhealthy       DeploymentStatus = "unhealthy"    // the unit ran but never answered its health path
+	DeploymentStatusDrifted         DeploymentStatus = "drifted"      // its unit no longer matches the recorded status; see Drift
+	DeploymentStatusCrashLooping    DeploymentStatus = "crashlooping" // its unit kept restarting after it was ready; see CrashLoop
 )
 
-// Deployment represents a running (or staged) instance of a release.
+// RevisionID returns the deployment ID of a stage's nth deployment revision.
+func RevisionID(stageID string, revision int) string {
+	return fmt.Sprintf("%s-rev-%d", stageID, revision)
+}
+
+// Deployment represents a running (or staged) instance of a release. Every deploy
+// of a stage creates a new revision; earlier revisions are kept for audit.
 type Deployment struct {
 	ID         string           `bson:"id" json:"id"`
 	StageID    string           `bson:"stageId" json:"stageId"`
+	Revision   int              `bson:"revision,omitempty" json:"revision,omitempty"`
 	Version    string           `bson:"version" json:"version"`
 	EnvTag     string           `bson:"envTag" json:"envTag"`
 	Port       *int             `bson:"port,omitempty" json:"port,omitempty"`
 	Status     DeploymentStatus `bson:"status" json:"status"`
 	LogPath    string           `bson:"logPath,omitempty" json:"logPath,omitempty"`
+	HealthPath string           `bson:"healthPath,omitempty" json:"healthPath,omitempty"` // copied from the stage; empty uses DEPLOYMENT_HEALTH_PATH
 	CreatedAt  time.Time        `bson:"createdAt" json:"createdAt"`
 	PromotedAt *time.Time       `bson:"promotedAt,omitempty" json:"promotedAt,omitempty"`
+
+	// Supersedes is the revision of the same stage this one replaced, and SupersededBy
+	// the revision that replaced this one once it was up and healthy.
+	Supersedes   string     `bson:"supersedes,omitempty" json:"supersedes,omitempty"`
+	SupersededBy string     `bson:"supersededBy,omitempty" json:"supersededBy,omitempty"`
+	SupersededAt *time.Time `bson:"supersededAt,omitempty" json:"supersededAt,omitempty"`
+
+	// CanaryWeight is the percentage (1-100) of root traffic routed to this
+	// deployment instead of main. Zero means the deployment is not a canary.
+	CanaryWeight int `bson:"canaryWeight,omitempty" json:"canaryWeight,omitempty"`
+
+	// Mirror configures shadow traffic replayed from main to this deployment.
+	Mirror *MirrorConfig `bson:"mirror,omitempty" json:"mirror,omitempty"`
+
+	// Policy restricts which clients the proxy lets through to this deployment.
+	Policy *AccessPolicy `bson:"policy,omitempty" json:"policy,omitempty"`
+
+	// Faults are injected by the proxy into this deployment's traffic for resilience testing.
+	Faults []FaultRule `bson:"faults,omitempty" json:"faults,omitempty"`
+
+	// Proxy tunes how the proxy talks to this deployment's backend. Nil uses the defaults.
+	Proxy *ProxySettings `bson:"proxy,omitempty" json:"proxy,omitempty"`
+
+	// Unit holds the resource limits and sandboxing its systemd unit was written with,
+	// resolved from the env tag's defaults and the overrides given at deploy time.
+	Unit *UnitSettings `bson:"unit,omitempty" json:"unit,omitempty"`
+
+	// Drain tracks the most recent connection drain before the deployment was stopped.
+	Drain *DrainState `bson:"drain,omitempty" json:"drain,omitempty"`
+
+	// Hostnames are extra Host header values routed to this deployment without a path prefix.
+	Hostnames []string `bson:"hostnames,omitempty" json:"hostnames,omitempty"`
+
+	// Health is the last health state observed by the proxy's prober.
+	Health *DeploymentHealth `bson:"health,omitempty" json:"health,omitempty"`
+
+	// CrashLoop records the restarts that took the deployment out of routing.
+	CrashLoop *CrashLoopState `bson:"crashLoop,omitempty" json:"crashLoop,omitempty"`
+
+	// Drift lists what the reconciler found out of line and could not fix safely.
+	Drift *DriftState `bson:"drift,omitempty" json:"drift,omitempty"`
+
+	// UpdatedAt and UpdatedBy record the last change affecting how the deployment is routed.
+	UpdatedAt *time.Time `bson:"updatedAt,omitempty" json:"updatedAt,omitempty"`
+	UpdatedBy string     `bson:"updatedBy,omitempty" json:"updatedBy,omitempty"`
+}
+
+// Touch marks the deployment as changed now by actor (a hyperuser name or "system").
+func (d *Deployment) Touch(actor string) {
+	now := time.Now()
+	d.UpdatedAt = &now
+	d.UpdatedBy = actor
+}
+
+// CrashLoopState records a ready deployment whose unit systemd restarted Restarts
+// times within Window. It stays crashlooping until it is stopped or started again.
+type CrashLoopState struct {
+	Restarts   int       `bson:"restarts" json:"restarts"`
+	Window     string    `bson:"window" json:"window"` // Go duration the restarts were counted over
+	DetectedAt time.Time `bson:"detectedAt" json:"detectedAt"`
+}
+
+// DriftState records issues the reconciler found with a deployment and could not fix
+// safely. Unless the deployment is still serving, its status becomes drifted, which
+// takes it out of the route map; the reconciler restores From once the issues are gone.
+type DriftState struct {
+	From       DeploymentStatus `bson:"from" json:"from"` // status recorded before the drift was found
+	Issues     []string         `bson:"issues" json:"issues"`
+	DetectedAt time.Time        `bson:"detectedAt" json:"detectedAt"`
+}
+
+// DriftIssues returns the issues the reconciler left on the deployment, if any.
+func (d *Deployment) DriftIssues() []string {
+	if d.Drift == nil {
+		return nil
+	}
+	return d.Drift.Issues
+}
+
+// MirrorConfig describes shadow traffic: a copy of Percent percent of the requests
+// served by main is replayed against the deployment and its responses are discarded.
+type MirrorConfig struct {
+	Percent       int       `bson:"percent" json:"percent"`
+	IncludeUnsafe bool      `bson:"includeUnsafe,omitempty" json:"includeUnsafe,omitempty"` // also mirror non-idempotent methods
+	StartedAt     time.Time `bson:"startedAt" json:"startedAt"`
+
+	// Diff compares the candidate's responses to mirrored GET requests with main's,
+	// skipping IgnoreFields (JSON keys or dotted paths such as "data.updatedAt").
+	Diff         bool     `bson:"diff,omitempty" json:"diff,omitempty"`
+	IgnoreFields []string `bson:"ignoreFields,omitempty" json:"ignoreFields,omitempty"`
+}
+
+// AccessPolicy is enforced by the proxy before a request reaches the deployment.
+// Requests carrying a valid hyperuser token bypass it.
+type AccessPolicy struct {
+	// RateLimit caps requests per client IP with a token bucket.
+	RateLimit *RateLimit `bson:"rateLimit,omitempty" json:"rateLimit,omitempty"`
+	// Allow, when non-empty, admits only clients inside one of these CIDRs.
+	Allow []string `bson:"allow,omitempty" json:"allow,omitempty"`
+	// Deny rejects clients inside any of these CIDRs; it takes precedence over Allow.
+	Deny []string `bson:"deny,omitempty" json:"deny,omitempty"`
+	// HyperusersOnly hides a non-promoted deployment from everyone but hyperusers.
+	HyperusersOnly bool `bson:"hyperusersOnly,omitempty" json:"hyperusersOnly,omitempty"`
 }
 
+// ProxySettings overrides the proxy's defaults for one deployment. Zero fields
+// fall back to the PROXY_* environment defaults.
+type ProxySettings struct {
+	// ConnectTimeoutMs bounds dialing the backend.
+	ConnectTimeoutMs int `bson:"connectTimeoutMs,omitempty" json:"connectTimeoutMs,omitempty"`
+	// ReadTimeoutMs bounds waiting for the backend's full response.
+	ReadTimeoutMs int `bson:"readTimeoutMs,omitempty" json:"readTimeoutMs,omitempty"`
+	// MaxBodyBytes is the largest request body forwarded; larger requests get a 413.
+	MaxBodyBytes int `bson:"maxBodyBytes,omitempty" json:"maxBodyBytes,omitempty"`
+	// ConnectRetries is how many times a failed dial is retried. Nothing has been sent
+	// to the backend at that point, so retrying is safe for every method.
+	ConnectRetries int `bson:"connectRetries,omitempty" json:"connectRetries,omitempty"`
+	// MaxConns caps the keep-alive connection pool to the backend.
+	MaxConns int `bson:"maxConns,omitempty" json:"maxConns,omitempty"`
+}
+
+// RateLimit refills RequestsPerSecond tokens per second up to Burst.
+type RateLimit struct {
+	RequestsPerSecond float64 `bson:"requestsPerSecond" json:"requestsPerSecond"`
+	Burst             int     `bson:"burst" json:"burst"`
+}
+
+type HealthStatus string
+
+const (
+	HealthStatusUnknown   HealthStatus = "unknown"
+	HealthStatusHealthy   HealthStatus = "healthy"
+	HealthStatusUnhealthy HealthStatus = "unhealthy"
+)
+
+// DeploymentHealth records the outcome of active and passive health checks.
+type DeploymentHealth struct {
+	Status              HealthStatus `bson:"status" json:"status"`
+	ConsecutiveFailures int          `bson:"consecutiveFailures" json:"consecutiveFailures"`
+	LastError           string       `bson:"lastError,omitempty" json:"lastError,omitempty"`
+	CheckedAt           time.Time    `bson:"checkedAt" json:"checkedAt"`
+	ChangedAt           time.Time    `bson:"changedAt" json:"changedAt"`
+}
+
+// DrainState records the progress of draining in-flight requests from a deployment.
+type DrainState struct {
+	StartedAt   time.Time  `bson:"startedAt" json:"startedAt"`
+	Deadline    time.Time  `bson:"deadline" json:"deadline"`
+	InFlight    int64      `bson:"inFlight" json:"inFlight"`
+	CompletedAt *time.Time `bson:"completedAt,omitempty" json:"completedAt,omitempty"`
+	TimedOut    bool       `bson:"timedOut,omitempty" json:"timedOut,omitempty"`
+}
+
+// EnsureDeploymentIndexes creates the unique index that lets only one revision of a
+// stage be provisioning at a time, whichever instance creates it.
+func EnsureDeploymentIndexes(ctx context.Context) error {
+	_, err := db.Deployments.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "stageId", Value: 1}},
+		Options: options.Index().
+			SetName("stage_provisioning_revision").
+			SetUnique(true).
+			SetPartialFilterExpression(bson.M{"status": DeploymentStatusProvisioning}),
+	})
+	return err
+}
+
+// CreateDeployment inserts the deployment. Inserting a provisioning revision of a
+// stage that already has one fails with a duplicate key error.
 func CreateDeployment(ctx context.Context, dep Deployment) error {
 	_, err := db.Deployments.InsertOne(ctx, dep)
 	return err
@@ -45,6 +229,42 @@ func GetDeploymentByID(ctx context.Context, id string) (*Deployment, error) {
 	return &d, nil
 }
 
+// GetPromotedDeployment returns the deployment recorded as main, or nil if there is
+// none. Should several carry a promotion, the latest one is returned.
+func GetPromotedDeployment(ctx context.Context) (*Deployment, error) {
+	var d Deployment
+	opts := options.FindOne().SetSort(bson.M{"promotedAt": -1})
+	err := db.Deployments.FindOne(ctx, bson.M{"promotedAt": bson.M{"$exists": true}}, opts).Decode(&d)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// MarkDeploymentCrashLooping moves a ready deployment to crashlooping and reports
+// whether this caller made the change. Every hypervisor instance watches the units,
+// but only one may act on a crash loop.
+func MarkDeploymentCrashLooping(ctx context.Context, id string, crashLoop CrashLoopState, actor string) (bool, error) {
+	result, err := db.Deployments.UpdateOne(ctx, bson.M{
+		"id":     id,
+		"status": DeploymentStatusReady,
+	}, bson.M{
+		"$set": bson.M{
+			"status":    DeploymentStatusCrashLooping,
+			"crashLoop": crashLoop,
+			"updatedAt": crashLoop.DetectedAt,
+			"updatedBy": actor,
+		},
+	})
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount == 1, nil
+}
+
 func GetAllDeployments(ctx context.Context) ([]Deployment, error) {
 	cursor, err := db.Deployments.Find(ctx, bson.M{})
 	if err != nil {
@@ -59,12 +279,96 @@ func GetAllDeployments(ctx context.Context) ([]Deployment, error) {
 	return deployments, nil
 }
 
+// GetStageDeployments returns every revision deployed from a stage, newest first.
+func GetStageDeployments(ctx context.Context, stageID string) ([]Deployment, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "revision", Value: -1}, {Key: "createdAt", Value: -1}})
+	cursor, err := db.Deployments.Find(ctx, bson.M{"stageId": stageID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	deployments := []Deployment{}
+	if err := cursor.All(ctx, &deployments); err != nil {
+		return nil, err
+	}
+	return deployments, nil
+}
+
+// CountDeploymentsByVersion counts the deployments other than exceptID built from a
+// version, which share its binary.
+func CountDeploymentsByVersion(ctx context.Context, version string, exceptID string) (int64, error) {
+	return db.Deployments.CountDocuments(ctx, bson.M{
+		"id":      bson.M{"$ne": exceptID},
+		"version": version,
+	})
+}
+
 func UpdateDeployment(ctx context.Context, dep Deployment) error {
 	_, err := db.Deployments.ReplaceOne(ctx, bson.M{"id": dep.ID}, dep)
 	return err
 }
 
+// ClearMirrors stops mirroring to every deployment except exceptID.
+func ClearMirrors(ctx context.Context, exceptID string) error {
+	_, err := db.Deployments.UpdateMany(ctx, bson.M{
+		"id":     bson.M{"$ne": exceptID},
+		"mirror": bson.M{"$exists": true},
+	}, bson.M{
+		"$unset": bson.M{"mirror": ""},
+	})
+	return err
+}
+
+// UpdateDeploymentDrain persists drain progress without touching the rest of the document.
+func UpdateDeploymentDrain(ctx context.Context, id string, drain DrainState) error {
+	_, err := db.Deployments.UpdateOne(ctx, bson.M{"id": id}, bson.M{
+		"$set": bson.M{"drain": drain},
+	})
+	return err
+}
+
+// UpdateDeploymentHealth persists the health state without touching the rest of the document.
+func UpdateDeploymentHealth(ctx context.Context, id string, health DeploymentHealth) error {
+	_, err := db.Deployments.UpdateOne(ctx, bson.M{"id": id}, bson.M{
+		"$set": bson.M{"health": health},
+	})
+	return err
+}
+
+// FindDeploymentByHostname returns the deployment that claims the given hostname.
+func FindDeploymentByHostname(ctx context.Context, hostname string) (*Deployment, error) {
+	var d Deployment
+	err := db.Deployments.FindOne(ctx, bson.M{"hostnames": hostname}).Decode(&d)
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
 func DeleteDeployment(ctx context.Context, id string) error {
 	_, err := db.Deployments.DeleteOne(ctx, bson.M{"id": id})
 	return err
 }
+
+// ClearCanaryWeights resets the canary weight on every deployment except exceptID.
+func ClearCanaryWeights(ctx context.Context, exceptID string) error {
+	_, err := db.Deployments.UpdateMany(ctx, bson.M{
+		"id":           bson.M{"$ne": exceptID},
+		"canaryWeight": bson.M{"$gt": 0},
+	}, bson.M{
+		"$unset": bson.M{"canaryWeight": ""},
+	})
+	return err
+}
+
+// DemoteDeployments clears PromotedAt on every deployment except exceptID.
+func DemoteDeployments(ctx context.Context, exceptID string) error {
+	_, err := db.Deployments.UpdateMany(ctx, bson.M{
+		"id":         bson.M{"$ne": exceptID},
+		"promotedAt": bson.M{"$exists": true},
+	}, bson.M{
+		"$unset": bson.M{"promotedAt": ""},
+	})
+	return err
+}
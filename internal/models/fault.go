@@ -0,0 +1,70 @@
+package models
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+	"time"
+)
+
+// FaultRule makes the proxy misbehave on purpose for a share of a deployment's
+// requests, so clients can be tested against a slow or failing backend. Every
+// rule expires; expired rules are ignored and pruned on the next change.
+type FaultRule struct {
+	ID         string   `bson:"id" json:"id"`
+	PathPrefix string   `bson:"pathPrefix,omitempty" json:"pathPrefix,omitempty"` // upstream path; empty matches every path
+	Methods    []string `bson:"methods,omitempty" json:"methods,omitempty"`       // empty matches every method
+	Percent    int      `bson:"percent" json:"percent"`                           // share of matching requests faulted, 1-100
+
+	// DelayMs holds the request before it is answered; with DelayJitterMs the delay
+	// is picked at random from [DelayMs, DelayMs+DelayJitterMs].
+	DelayMs       int `bson:"delayMs,omitempty" json:"delayMs,omitempty"`
+	DelayJitterMs int `bson:"delayJitterMs,omitempty" json:"delayJitterMs,omitempty"`
+	// Status answers the request with this status instead of proxying it.
+	Status int `bson:"status,omitempty" json:"status,omitempty"`
+	// Drop closes the client connection without any response.
+	Drop bool `bson:"drop,omitempty" json:"drop,omitempty"`
+
+	ExpiresAt time.Time `bson:"expiresAt" json:"expiresAt"`
+	CreatedAt time.Time `bson:"createdAt" json:"createdAt"`
+	CreatedBy string    `bson:"createdBy,omitempty" json:"createdBy,omitempty"`
+}
+
+// NewFaultID returns a short random identifier for a fault rule.
+func NewFaultID() string {
+	var b [4]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// Active reports whether the rule has not expired yet.
+func (r FaultRule) Active(now time.Time) bool {
+	return now.Before(r.ExpiresAt)
+}
+
+// Matches reports whether a request falls under the rule.
+func (r FaultRule) Matches(method, path string) bool {
+	if r.PathPrefix != "" && !strings.HasPrefix(path, r.PathPrefix) {
+		return false
+	}
+	if len(r.Methods) == 0 {
+		return true
+	}
+	for _, m := range r.Methods {
+		if strings.EqualFold(m, method) {
+			return true
+		}
+	}
+	return false
+}
+
+// ActiveFaults returns the deployment's rules that have not expired.
+func (d *Deployment) ActiveFaults(now time.Time) []FaultRule {
+	var active []FaultRule
+	for _, rule := range d.Faults {
+		if rule.Active(now) {
+			active = append(active, rule)
+		}
+	}
+	return active
+}
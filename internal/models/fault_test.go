@@ -0,0 +1,45 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFaultRuleMatches(t *testing.T) {
+	tests := []struct {
+		name   string
+		rule   FaultRule
+		method string
+		path   string
+		want   bool
+	}{
+		{"empty rule", FaultRule{}, "GET", "/anything", true},
+		{"prefix match", FaultRule{PathPrefix: "/api/"}, "GET", "/api/users", true},
+		{"prefix mismatch", FaultRule{PathPrefix: "/api/"}, "GET", "/static/app.js", false},
+		{"method match", FaultRule{Methods: []string{"POST", "PUT"}}, "PUT", "/", true},
+		{"method case", FaultRule{Methods: []string{"POST"}}, "post", "/", true},
+		{"method mismatch", FaultRule{Methods: []string{"POST"}}, "GET", "/", false},
+		{"both match", FaultRule{PathPrefix: "/api", Methods: []string{"GET"}}, "GET", "/api", true},
+		{"path matches, method does not", FaultRule{PathPrefix: "/api", Methods: []string{"GET"}}, "DELETE", "/api", false},
+	}
+
+	for _, tt := range tests {
+		if got := tt.rule.Matches(tt.method, tt.path); got != tt.want {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestActiveFaults(t *testing.T) {
+	now := time.Now()
+	dep := Deployment{Faults: []FaultRule{
+		{ID: "expired", ExpiresAt: now.Add(-time.Second)},
+		{ID: "active", ExpiresAt: now.Add(time.Minute)},
+		{ID: "at expiry", ExpiresAt: now},
+	}}
+
+	active := dep.ActiveFaults(now)
+	if len(active) != 1 || active[0].ID != "active" {
+		t.Errorf("Expected only the active rule, got %+v", active)
+	}
+}
@@ -0,0 +1,176 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"hypervisor/internal/db"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type JobKind string
+
+const (
+	JobKindProvisionDeployment JobKind = "provision_deployment"
+	JobKindRunTest             JobKind = "run_test"
+)
+
+type JobState string
+
+const (
+	JobStateQueued    JobState = "queued"
+	JobStateRunning   JobState = "running"
+	JobStateSucceeded JobState = "succeeded"
+	JobStateFailed    JobState = "failed"
+)
+
+// Job is a unit of background work persisted so that it survives a hypervisor
+// restart. A running job is leased by one instance, which must keep renewing the
+// lease; a job whose lease expired is picked up again by any instance.
+type Job struct {
+	ID          string   `bson:"id" json:"id"`
+	Kind        JobKind  `bson:"kind" json:"kind"`
+	TargetID    string   `bson:"targetId" json:"targetId"` // deployment or test the job works on
+	State       JobState `bson:"state" json:"state"`
+	Attempts    int      `bson:"attempts" json:"attempts"`
+	MaxAttempts int      `bson:"maxAttempts" json:"maxAttempts"`
+	LastError   string   `bson:"lastError,omitempty" json:"lastError,omitempty"`
+
+	// LeaseOwner is the instance running the job until LeaseExpiresAt.
+	LeaseOwner     string     `bson:"leaseOwner,omitempty" json:"leaseOwner,omitempty"`
+	LeaseExpiresAt *time.Time `bson:"leaseExpiresAt,omitempty" json:"leaseExpiresAt,omitempty"`
+
+	// RunAfter delays a retried job until its backoff has passed.
+	RunAfter   time.Time  `bson:"runAfter" json:"runAfter"`
+	CreatedAt  time.Time  `bson:"createdAt" json:"createdAt"`
+	UpdatedAt  time.Time  `bson:"updatedAt" json:"updatedAt"`
+	StartedAt  *time.Time `bson:"startedAt,omitempty" json:"startedAt,omitempty"`
+	FinishedAt *time.Time `bson:"finishedAt,omitempty" json:"finishedAt,omitempty"`
+}
+
+// NewJobID returns a random identifier for a job.
+func NewJobID() string {
+	var b [8]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+func CreateJob(ctx context.Context, job Job) error {
+	_, err := db.Jobs.InsertOne(ctx, job)
+	return err
+}
+
+// ClaimJob leases the oldest job of one of kinds that is due, either queued or
+// running under a lease that expired, to owner. It returns nil when there is nothing
+// to run. The claim counts as an attempt.
+func ClaimJob(ctx context.Context, kinds []JobKind, owner string, lease time.Duration, now time.Time) (*Job, error) {
+	expires := now.Add(lease)
+	filter := bson.M{
+		"kind": bson.M{"$in": kinds},
+		"$or": []bson.M{
+			{"state": JobStateQueued, "runAfter": bson.M{"$lte": now}},
+			{"state": JobStateRunning, "leaseExpiresAt": bson.M{"$lt": now}},
+		},
+	}
+	update := bson.M{
+		"$set": bson.M{
+			"state":          JobStateRunning,
+			"leaseOwner":     owner,
+			"leaseExpiresAt": expires,
+			"startedAt":      now,
+			"updatedAt":      now,
+		},
+		"$inc": bson.M{"attempts": 1},
+	}
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.M{"createdAt": 1}).
+		SetReturnDocument(options.After)
+
+	var job Job
+	if err := db.Jobs.FindOneAndUpdate(ctx, filter, update, opts).Decode(&job); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &job, nil
+}
+
+// RenewJobLease extends owner's lease on a running job and reports whether owner
+// still holds it.
+func RenewJobLease(ctx context.Context, id, owner string, expires time.Time) (bool, error) {
+	result, err := db.Jobs.UpdateOne(ctx, leasedBy(id, owner), bson.M{
+		"$set": bson.M{"leaseExpiresAt": expires, "updatedAt": time.Now()},
+	})
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount == 1, nil
+}
+
+// FinishJob records the final state of a job leased by owner. It reports false when
+// the lease was lost, in which case nothing is recorded.
+func FinishJob(ctx context.Context, id, owner string, state JobState, lastError string) (bool, error) {
+	now := time.Now()
+	result, err := db.Jobs.UpdateOne(ctx, leasedBy(id, owner), bson.M{
+		"$set":   bson.M{"state": state, "lastError": lastError, "finishedAt": now, "updatedAt": now},
+		"$unset": bson.M{"leaseOwner": "", "leaseExpiresAt": ""},
+	})
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount == 1, nil
+}
+
+// RetryJob releases a job leased by owner back to the queue, to run again after
+// runAfter. It reports false when the lease was lost.
+func RetryJob(ctx context.Context, id, owner string, runAfter time.Time, lastError string) (bool, error) {
+	result, err := db.Jobs.UpdateOne(ctx, leasedBy(id, owner), bson.M{
+		"$set":   bson.M{"state": JobStateQueued, "lastError": lastError, "runAfter": runAfter, "updatedAt": time.Now()},
+		"$unset": bson.M{"leaseOwner": "", "leaseExpiresAt": ""},
+	})
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount == 1, nil
+}
+
+func leasedBy(id, owner string) bson.M {
+	return bson.M{"id": id, "state": JobStateRunning, "leaseOwner": owner}
+}
+
+// JobQuery selects jobs for listing. Zero fields match everything.
+type JobQuery struct {
+	Kind  JobKind
+	State JobState
+	Limit int64
+}
+
+// ListJobs returns the jobs matching the query, newest first.
+func ListJobs(ctx context.Context, q JobQuery) ([]Job, error) {
+	filter := bson.M{}
+	if q.Kind != "" {
+		filter["kind"] = q.Kind
+	}
+	if q.State != "" {
+		filter["state"] = q.State
+	}
+
+	cursor, err := db.Jobs.Find(ctx, filter, options.Find().SetSort(bson.M{"createdAt": -1}).SetLimit(q.Limit))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	jobs := []Job{}
+	if err := cursor.All(ctx, &jobs); err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
@@ -0,0 +1,49 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"hypervisor/internal/db"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const maintenanceKey = "maintenance"
+
+// Maintenance is the proxy's maintenance mode. While enabled, root traffic gets a
+// 503 maintenance response; hyperusers and stage routes are unaffected.
+type Maintenance struct {
+	Key        string     `bson:"key" json:"-"`
+	Enabled    bool       `bson:"enabled" json:"enabled"`
+	Message    string     `bson:"message,omitempty" json:"message,omitempty"`
+	HTML       string     `bson:"html,omitempty" json:"html,omitempty"`             // custom page served to browsers
+	RetryAfter int        `bson:"retryAfter,omitempty" json:"retryAfter,omitempty"` // seconds
+	StartedAt  *time.Time `bson:"startedAt,omitempty" json:"startedAt,omitempty"`
+	UpdatedAt  time.Time  `bson:"updatedAt" json:"updatedAt"`
+	UpdatedBy  string     `bson:"updatedBy,omitempty" json:"updatedBy,omitempty"`
+}
+
+// GetMaintenance returns the stored maintenance settings, or disabled settings if
+// maintenance mode has never been configured.
+func GetMaintenance(ctx context.Context) (*Maintenance, error) {
+	var m Maintenance
+	err := db.Settings.FindOne(ctx, bson.M{"key": maintenanceKey}).Decode(&m)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return &Maintenance{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// SaveMaintenance stores the maintenance settings, creating the document if needed.
+// The document is replaced so cleared fields do not linger.
+func SaveMaintenance(ctx context.Context, m Maintenance) error {
+	m.Key = maintenanceKey
+	_, err := db.Settings.ReplaceOne(ctx, bson.M{"key": maintenanceKey}, m, options.Replace().SetUpsert(true))
+	return err
+}
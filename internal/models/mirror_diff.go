@@ -0,0 +1,113 @@
+package models
+
+import (
+	"context"
+	"hypervisor/internal/db"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// maxMirrorDiffSamples is the number of recent differing responses kept per endpoint.
+const maxMirrorDiffSamples = 5
+
+// MirrorDiff summarises the responses compared between main and a candidate for
+// one endpoint. There is one document per (main, candidate, method, endpoint).
+// Mirrored requests the candidate failed to answer count as compared.
+type MirrorDiff struct {
+	MainID          string             `bson:"mainId" json:"mainId"`
+	CandidateID     string             `bson:"candidateId" json:"candidateId"`
+	Method          string             `bson:"method" json:"method"`
+	Endpoint        string             `bson:"endpoint" json:"endpoint"`
+	Compared        int64              `bson:"compared" json:"compared"`
+	Matched         int64              `bson:"matched" json:"matched"`
+	StatusDiffs     int64              `bson:"statusDiffs" json:"statusDiffs"`
+	BodyDiffs       int64              `bson:"bodyDiffs" json:"bodyDiffs"`
+	CandidateErrors int64              `bson:"candidateErrors" json:"candidateErrors"` // transport failures and timeouts
+	Samples         []MirrorDiffSample `bson:"samples,omitempty" json:"samples,omitempty"`
+	FirstSeenAt     time.Time          `bson:"firstSeenAt" json:"firstSeenAt"`
+	LastSeenAt      time.Time          `bson:"lastSeenAt" json:"lastSeenAt"`
+}
+
+// MirrorDiffOutcome is how one mirrored response compared with main's.
+type MirrorDiffOutcome string
+
+const (
+	MirrorDiffMatched        MirrorDiffOutcome = "matched"
+	MirrorDiffStatus         MirrorDiffOutcome = "status"          // the status codes differ
+	MirrorDiffBody           MirrorDiffOutcome = "body"            // same status, the bodies differ
+	MirrorDiffCandidateError MirrorDiffOutcome = "candidate_error" // the candidate did not answer
+)
+
+// MirrorDiffSample is one response pair that differed.
+type MirrorDiffSample struct {
+	Path            string    `bson:"path" json:"path"`
+	MainStatus      int       `bson:"mainStatus" json:"mainStatus"`
+	CandidateStatus int       `bson:"candidateStatus" json:"candidateStatus"`
+	Fields          []string  `bson:"fields,omitempty" json:"fields,omitempty"` // JSON paths that differ; empty for non-JSON bodies
+	Error           string    `bson:"error,omitempty" json:"error,omitempty"`   // why the candidate did not answer
+	At              time.Time `bson:"at" json:"at"`
+}
+
+// RecordMirrorDiff folds one comparison into the endpoint's summary. sample is nil
+// when the responses matched.
+func RecordMirrorDiff(ctx context.Context, mainID, candidateID, method, endpoint string, outcome MirrorDiffOutcome, sample *MirrorDiffSample) error {
+	now := time.Now()
+
+	inc := bson.M{"compared": 1}
+	switch outcome {
+	case MirrorDiffStatus:
+		inc["statusDiffs"] = 1
+	case MirrorDiffBody:
+		inc["bodyDiffs"] = 1
+	case MirrorDiffCandidateError:
+		inc["candidateErrors"] = 1
+	default:
+		inc["matched"] = 1
+	}
+
+	update := bson.M{
+		"$inc":         inc,
+		"$set":         bson.M{"lastSeenAt": now},
+		"$setOnInsert": bson.M{"firstSeenAt": now},
+	}
+	if sample != nil {
+		update["$push"] = bson.M{"samples": bson.M{"$each": []MirrorDiffSample{*sample}, "$slice": -maxMirrorDiffSamples}}
+	}
+
+	_, err := db.MirrorDiffs.UpdateOne(ctx, bson.M{
+		"mainId":      mainID,
+		"candidateId": candidateID,
+		"method":      method,
+		"endpoint":    endpoint,
+	}, update, options.Update().SetUpsert(true))
+	return err
+}
+
+// GetMirrorDiffs returns the endpoint summaries for a deployment pair. An empty
+// mainID returns the candidate's summaries against every main.
+func GetMirrorDiffs(ctx context.Context, mainID, candidateID string) ([]MirrorDiff, error) {
+	filter := bson.M{"candidateId": candidateID}
+	if mainID != "" {
+		filter["mainId"] = mainID
+	}
+
+	cursor, err := db.MirrorDiffs.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "method", Value: 1}, {Key: "endpoint", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	diffs := []MirrorDiff{}
+	if err := cursor.All(ctx, &diffs); err != nil {
+		return nil, err
+	}
+	return diffs, nil
+}
+
+// DeleteMirrorDiffs removes every summary involving the candidate.
+func DeleteMirrorDiffs(ctx context.Context, candidateID string) error {
+	_, err := db.MirrorDiffs.DeleteMany(ctx, bson.M{"candidateId": candidateID})
+	return err
+}
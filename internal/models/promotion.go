@@ -0,0 +1,132 @@
+package models
+
+import (
+	"context"
+	"hypervisor/internal/db"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// promotionLockID is the _id of the settings document that serialises changes of
+// main across hypervisor instances.
+const promotionLockID = "promotion_lock"
+
+type PromotionReason string
+
+const (
+	PromotionReasonPromote      PromotionReason = "promote"
+	PromotionReasonRollback     PromotionReason = "rollback"
+	PromotionReasonAutoRollback PromotionReason = "auto_rollback"
+	PromotionReasonRedeploy     PromotionReason = "redeploy" // a new revision of main's stage took over
+)
+
+// Promotion records one deployment's time as main. The current main's promotion
+// has no DemotedAt.
+type Promotion struct {
+	DeploymentID string          `bson:"deploymentId" json:"deploymentId"`
+	StageID      string          `bson:"stageId" json:"stageId"`
+	PreviousID   string          `bson:"previousId,omitempty" json:"previousId,omitempty"` // main before this promotion
+	Reason       PromotionReason `bson:"reason" json:"reason"`
+	PromotedBy   string          `bson:"promotedBy" json:"promotedBy"`
+	PromotedAt   time.Time       `bson:"promotedAt" json:"promotedAt"`
+	DemotedBy    string          `bson:"demotedBy,omitempty" json:"demotedBy,omitempty"`
+	DemotedAt    *time.Time      `bson:"demotedAt,omitempty" json:"demotedAt,omitempty"`
+
+	// AutoRollbackUntil is the end of the grace window in which failing health
+	// checks roll main back to PreviousID. It is cleared once a rollback is claimed.
+	AutoRollbackUntil *time.Time `bson:"autoRollbackUntil,omitempty" json:"autoRollbackUntil,omitempty"`
+}
+
+// RecordPromotion closes the current main's promotion and opens a new one.
+func RecordPromotion(ctx context.Context, p Promotion) error {
+	_, err := db.Promotions.UpdateMany(ctx, bson.M{
+		"demotedAt": bson.M{"$exists": false},
+	}, bson.M{
+		"$set":   bson.M{"demotedAt": p.PromotedAt, "demotedBy": p.PromotedBy},
+		"$unset": bson.M{"autoRollbackUntil": ""},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Promotions.InsertOne(ctx, p)
+	return err
+}
+
+// ClosePromotion marks the deployment's open promotion as ended, for a main that
+// stops serving without another deployment taking over.
+func ClosePromotion(ctx context.Context, deploymentID, actor string, at time.Time) error {
+	_, err := db.Promotions.UpdateMany(ctx, bson.M{
+		"deploymentId": deploymentID,
+		"demotedAt":    bson.M{"$exists": false},
+	}, bson.M{
+		"$set":   bson.M{"demotedAt": at, "demotedBy": actor},
+		"$unset": bson.M{"autoRollbackUntil": ""},
+	})
+	return err
+}
+
+// GetPromotions returns the promotion history, newest first.
+func GetPromotions(ctx context.Context, limit int64) ([]Promotion, error) {
+	cursor, err := db.Promotions.Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"promotedAt": -1}).SetLimit(limit))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	promotions := []Promotion{}
+	if err := cursor.All(ctx, &promotions); err != nil {
+		return nil, err
+	}
+	return promotions, nil
+}
+
+// ClaimAutoRollback clears the grace window of the deployment's open promotion if
+// it is still running, and reports whether this caller won it. Only one hypervisor
+// instance may act on a failing main.
+func ClaimAutoRollback(ctx context.Context, deploymentID string, now time.Time) (bool, error) {
+	result, err := db.Promotions.UpdateOne(ctx, bson.M{
+		"deploymentId":      deploymentID,
+		"demotedAt":         bson.M{"$exists": false},
+		"autoRollbackUntil": bson.M{"$gt": now},
+	}, bson.M{
+		"$unset": bson.M{"autoRollbackUntil": ""},
+	})
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount == 1, nil
+}
+
+// AcquirePromotionLock takes the lock on changes of main for owner until it is
+// released or lease has passed, and reports whether owner holds it. The lock is
+// one document with a fixed _id, so two instances claiming a free lock at once
+// collide on the key instead of both getting it.
+func AcquirePromotionLock(ctx context.Context, owner string, lease time.Duration, now time.Time) (bool, error) {
+	_, err := db.Settings.UpdateOne(ctx, bson.M{
+		"_id": promotionLockID,
+		"$or": []bson.M{
+			{"owner": owner},
+			{"lockedUntil": bson.M{"$lte": now}},
+		},
+	}, bson.M{
+		"$set": bson.M{"owner": owner, "lockedUntil": now.Add(lease)},
+	}, options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		// Another owner holds the lock, so the upsert tried to create it again
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// ReleasePromotionLock releases the lock if owner still holds it.
+func ReleasePromotionLock(ctx context.Context, owner string) error {
+	_, err := db.Settings.DeleteOne(ctx, bson.M{"_id": promotionLockID, "owner": owner})
+	return err
+}
@@ -0,0 +1,62 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"hypervisor/internal/db"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const reconcileKey = "reconcile"
+
+type ReconcileAction string
+
+const (
+	ReconcileActionFixed   ReconcileAction = "fixed"   // the reconciler brought the unit or route map in line
+	ReconcileActionFlagged ReconcileAction = "flagged" // left for an operator; the deployment is marked drifted
+)
+
+// ReconcileFinding is one difference between a deployment's recorded state and what
+// systemd, the unit file, the builds directory or the route map show.
+type ReconcileFinding struct {
+	DeploymentID string          `bson:"deploymentId,omitempty" json:"deploymentId,omitempty"`
+	Unit         string          `bson:"unit,omitempty" json:"unit,omitempty"`
+	Issue        string          `bson:"issue" json:"issue"`
+	Detail       string          `bson:"detail,omitempty" json:"detail,omitempty"`
+	Action       ReconcileAction `bson:"action" json:"action"`
+}
+
+// ReconcileReport is the outcome of the most recent reconciliation pass.
+type ReconcileReport struct {
+	Key        string             `bson:"key" json:"-"`
+	StartedAt  time.Time          `bson:"startedAt" json:"startedAt"`
+	FinishedAt time.Time          `bson:"finishedAt" json:"finishedAt"`
+	RanBy      string             `bson:"ranBy" json:"ranBy"` // instance that ran the pass
+	Checked    int                `bson:"checked" json:"checked"`
+	Findings   []ReconcileFinding `bson:"findings" json:"findings"`
+}
+
+// GetReconcileReport returns the last stored reconciliation report, or nil if no
+// pass has completed yet.
+func GetReconcileReport(ctx context.Context) (*ReconcileReport, error) {
+	var report ReconcileReport
+	err := db.Settings.FindOne(ctx, bson.M{"key": reconcileKey}).Decode(&report)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+// SaveReconcileReport replaces the stored reconciliation report.
+func SaveReconcileReport(ctx context.Context, report ReconcileReport) error {
+	report.Key = reconcileKey
+	_, err := db.Settings.ReplaceOne(ctx, bson.M{"key": reconcileKey}, report, options.Replace().SetUpsert(true))
+	return err
+}
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
@@ -24,8 +25,13 @@ type Stage struct {
 	EnvTag       string      `bson:"envTag" json:"envTag"`
 	Status       StageStatus `bson:"status" json:"status"`
 	TestSequence int         `bson:"testSequence,omitempty" json:"testSequence,omitempty"`
-	CreatedAt    time.Time   `bson:"createdAt" json:"createdAt"`
-	UpdatedAt    time.Time   `bson:"updatedAt" json:"updatedAt"`
+	// HealthPath is the backend path polled before a deployment of the stage is marked
+	// ready and by the proxy's health checker. Empty uses DEPLOYMENT_HEALTH_PATH.
+	HealthPath string `bson:"healthPath,omitempty" json:"healthPath,omitempty"`
+	// DeploymentSequence numbers the deployment revisions created from the stage.
+	DeploymentSequence int       `bson:"deploymentSequence,omitempty" json:"deploymentSequence,omitempty"`
+	CreatedAt          time.Time `bson:"createdAt" json:"createdAt"`
+	UpdatedAt          time.Time `bson:"updatedAt" json:"updatedAt"`
 }
 
 func CreateStage(ctx context.Context, stage Stage) error {
@@ -65,6 +71,28 @@ func UpdateStage(ctx context.Context, stage Stage) error {
 	return err
 }
 
+// UpdateStageHealthPath sets the stage's health path; an empty path restores the default.
+func UpdateStageHealthPath(ctx context.Context, stageID string, healthPath string) error {
+	update := bson.M{
+		"$set": bson.M{"healthPath": healthPath, "updatedAt": time.Now().UTC()},
+	}
+	if healthPath == "" {
+		update = bson.M{
+			"$set":   bson.M{"updatedAt": time.Now().UTC()},
+			"$unset": bson.M{"healthPath": ""},
+		}
+	}
+
+	result, err := db.Stages.UpdateOne(ctx, bson.M{"id": stageID}, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
 func DeleteStage(ctx context.Context, stageID string) error {
 	_, err := db.Stages.DeleteOne(ctx, bson.M{"id": stageID})
 	return err
@@ -85,6 +113,21 @@ func NextTestSequence(ctx context.Context, stageID string) (int, error) {
 	return stage.TestSequence, nil
 }
 
+// NextDeploymentSequence increments the stage deployment revision counter and returns the new value.
+func NextDeploymentSequence(ctx context.Context, stageID string) (int, error) {
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	update := bson.M{
+		"$inc": bson.M{"deploymentSequence": 1},
+	}
+
+	var stage Stage
+	if err := db.Stages.FindOneAndUpdate(ctx, bson.M{"id": stageID}, update, opts).Decode(&stage); err != nil {
+		return 0, err
+	}
+
+	return stage.DeploymentSequence, nil
+}
+
 func ListStages(ctx context.Context) ([]Stage, error) {
 	cursor, err := db.Stages.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}}))
 	if err != nil {
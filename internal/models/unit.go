@@ -0,0 +1,84 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"hypervisor/internal/db"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const unitDefaultsKey = "unit_defaults"
+
+// UnitSettings are the resource limits and sandboxing written into a deployment's
+// systemd unit. Values use systemd's syntax; empty fields leave systemd's defaults.
+type UnitSettings struct {
+	MemoryMax       string   `bson:"memoryMax,omitempty" json:"memoryMax,omitempty"`             // e.g. "512M", "infinity"
+	CPUQuota        string   `bson:"cpuQuota,omitempty" json:"cpuQuota,omitempty"`               // e.g. "50%", "200%" for two cores
+	TasksMax        string   `bson:"tasksMax,omitempty" json:"tasksMax,omitempty"`               // e.g. "512", "infinity"
+	ProtectSystem   string   `bson:"protectSystem,omitempty" json:"protectSystem,omitempty"`     // "yes", "no", "full" or "strict"
+	PrivateTmp      *bool    `bson:"privateTmp,omitempty" json:"privateTmp,omitempty"`           // private /tmp and /var/tmp
+	NoNewPrivileges *bool    `bson:"noNewPrivileges,omitempty" json:"noNewPrivileges,omitempty"` // no privilege gain through setuid binaries
+	ReadWritePaths  []string `bson:"readWritePaths,omitempty" json:"readWritePaths,omitempty"`   // absolute paths left writable under ProtectSystem
+}
+
+// Merge returns s with the fields set in override replacing its own.
+func (s UnitSettings) Merge(override UnitSettings) UnitSettings {
+	if override.MemoryMax != "" {
+		s.MemoryMax = override.MemoryMax
+	}
+	if override.CPUQuota != "" {
+		s.CPUQuota = override.CPUQuota
+	}
+	if override.TasksMax != "" {
+		s.TasksMax = override.TasksMax
+	}
+	if override.ProtectSystem != "" {
+		s.ProtectSystem = override.ProtectSystem
+	}
+	if override.PrivateTmp != nil {
+		s.PrivateTmp = override.PrivateTmp
+	}
+	if override.NoNewPrivileges != nil {
+		s.NoNewPrivileges = override.NoNewPrivileges
+	}
+	if override.ReadWritePaths != nil {
+		s.ReadWritePaths = override.ReadWritePaths
+	}
+	return s
+}
+
+// UnitDefaults holds the unit settings new deployments start from, per env tag.
+// Fields a tag leaves empty fall back to the hypervisor's built-in defaults.
+type UnitDefaults struct {
+	Key       string                  `bson:"key" json:"-"`
+	Tags      map[string]UnitSettings `bson:"tags" json:"tags"`
+	UpdatedAt time.Time               `bson:"updatedAt" json:"updatedAt"`
+	UpdatedBy string                  `bson:"updatedBy,omitempty" json:"updatedBy,omitempty"`
+}
+
+// GetUnitDefaults returns the stored unit defaults, or empty defaults if none were set.
+func GetUnitDefaults(ctx context.Context) (*UnitDefaults, error) {
+	var d UnitDefaults
+	err := db.Settings.FindOne(ctx, bson.M{"key": unitDefaultsKey}).Decode(&d)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return &UnitDefaults{Tags: map[string]UnitSettings{}}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if d.Tags == nil {
+		d.Tags = map[string]UnitSettings{}
+	}
+	return &d, nil
+}
+
+// SaveUnitDefaults replaces the stored unit defaults.
+func SaveUnitDefaults(ctx context.Context, d UnitDefaults) error {
+	d.Key = unitDefaultsKey
+	_, err := db.Settings.ReplaceOne(ctx, bson.M{"key": unitDefaultsKey}, d, options.Replace().SetUpsert(true))
+	return err
+}
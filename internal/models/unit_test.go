@@ -0,0 +1,53 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestUnitSettingsMergeKeepsUnsetFields(t *testing.T) {
+	yes, no := true, false
+	base := UnitSettings{
+		MemoryMax:       "512M",
+		CPUQuota:        "100%",
+		TasksMax:        "512",
+		ProtectSystem:   "full",
+		PrivateTmp:      &yes,
+		NoNewPrivileges: &yes,
+		ReadWritePaths:  []string{"/var/lib/openhack"},
+	}
+
+	merged := base.Merge(UnitSettings{})
+	if !reflect.DeepEqual(merged, base) {
+		t.Errorf("Expected an empty override to keep %+v, got %+v", base, merged)
+	}
+
+	merged = base.Merge(UnitSettings{CPUQuota: "200%", PrivateTmp: &no})
+	if merged.CPUQuota != "200%" {
+		t.Errorf("Expected CPUQuota 200%%, got %q", merged.CPUQuota)
+	}
+	if merged.PrivateTmp == nil || *merged.PrivateTmp {
+		t.Errorf("Expected PrivateTmp to be overridden to false")
+	}
+	if merged.MemoryMax != "512M" || merged.TasksMax != "512" || merged.ProtectSystem != "full" {
+		t.Errorf("Expected unset fields to keep their values, got %+v", merged)
+	}
+	if merged.NoNewPrivileges == nil || !*merged.NoNewPrivileges {
+		t.Errorf("Expected NoNewPrivileges to stay true")
+	}
+}
+
+func TestUnitSettingsMergeReadWritePaths(t *testing.T) {
+	base := UnitSettings{ReadWritePaths: []string{"/var/lib/openhack"}}
+
+	// An empty but non-nil list clears the paths; nil keeps them
+	merged := base.Merge(UnitSettings{ReadWritePaths: []string{}})
+	if merged.ReadWritePaths == nil || len(merged.ReadWritePaths) != 0 {
+		t.Errorf("Expected an empty list to clear the paths, got %v", merged.ReadWritePaths)
+	}
+
+	merged = base.Merge(UnitSettings{ReadWritePaths: []string{"/srv/data"}})
+	if !reflect.DeepEqual(merged.ReadWritePaths, []string{"/srv/data"}) {
+		t.Errorf("Expected the override's paths, got %v", merged.ReadWritePaths)
+	}
+}
@@ -38,6 +38,10 @@ const (
 	// OpenHackRuntimeLogsDir persists stage test logs for inspection and streaming.
 	OpenHackRuntimeLogsDir = OpenHackRuntimeDir + "/logs"
 
+	// OpenHackSupervisorDir holds the process definitions and output of deployments run
+	// by the native supervisor instead of systemd.
+	OpenHackSupervisorDir = OpenHackRuntimeDir + "/supervisor"
+
 	// SystemdUnitDir is the directory where systemd unit files are stored.
 	SystemdUnitDir = "/lib/systemd/system"
 )
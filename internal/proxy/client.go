@@ -0,0 +1,161 @@
+package proxy
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"hypervisor/internal/env"
+	"hypervisor/internal/models"
+
+	"github.com/valyala/fasthttp"
+)
+
+// connectRetryBackoff is the pause before each retried dial, multiplied by the attempt.
+const connectRetryBackoff = 50 * time.Millisecond
+
+// upstreamSettings are a deployment's proxy settings with the environment defaults applied.
+type upstreamSettings struct {
+	connectTimeout time.Duration
+	readTimeout    time.Duration
+	maxBody        int
+	connectRetries int
+	maxConns       int
+}
+
+// settingsFor resolves the effective proxy settings of a deployment.
+func settingsFor(dep *models.Deployment) upstreamSettings {
+	settings := upstreamSettings{
+		connectTimeout: env.PROXY_CONNECT_TIMEOUT,
+		readTimeout:    env.PROXY_READ_TIMEOUT,
+		maxBody:        env.PROXY_BODY_LIMIT,
+		connectRetries: env.PROXY_CONNECT_RETRIES,
+		maxConns:       env.PROXY_MAX_CONNS,
+	}
+
+	if custom := dep.Proxy; custom != nil {
+		if custom.ConnectTimeoutMs > 0 {
+			settings.connectTimeout = time.Duration(custom.ConnectTimeoutMs) * time.Millisecond
+		}
+		if custom.ReadTimeoutMs > 0 {
+			settings.readTimeout = time.Duration(custom.ReadTimeoutMs) * time.Millisecond
+		}
+		if custom.MaxBodyBytes > 0 {
+			settings.maxBody = custom.MaxBodyBytes
+		}
+		if custom.ConnectRetries > 0 {
+			settings.connectRetries = custom.ConnectRetries
+		}
+		if custom.MaxConns > 0 {
+			settings.maxConns = custom.MaxConns
+		}
+	}
+	return settings
+}
+
+type upstreamClient struct {
+	settings upstreamSettings
+	client   *fasthttp.Client
+}
+
+// clientCache holds one HTTP client per deployment so each backend gets its own
+// keep-alive pool and timeouts.
+type clientCache struct {
+	mu      sync.Mutex
+	clients map[string]*upstreamClient // deploymentID -> client
+}
+
+func newClientCache() *clientCache {
+	return &clientCache{clients: make(map[string]*upstreamClient)}
+}
+
+// get returns the client for a deployment, replacing it when its settings changed.
+func (cc *clientCache) get(dep *models.Deployment) (*fasthttp.Client, upstreamSettings) {
+	settings := settingsFor(dep)
+
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	if cached, exists := cc.clients[dep.ID]; exists {
+		if cached.settings == settings {
+			return cached.client, settings
+		}
+		cached.client.CloseIdleConnections()
+	}
+
+	client := newUpstreamClient(settings)
+	cc.clients[dep.ID] = &upstreamClient{settings: settings, client: client}
+	return client, settings
+}
+
+// forget closes the pooled connections of a deployment that left routing.
+func (cc *clientCache) forget(deploymentID string) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	if cached, exists := cc.clients[deploymentID]; exists {
+		cached.client.CloseIdleConnections()
+		delete(cc.clients, deploymentID)
+	}
+}
+
+func newUpstreamClient(settings upstreamSettings) *fasthttp.Client {
+	return &fasthttp.Client{
+		NoDefaultUserAgentHeader: true,
+		DisablePathNormalizing:   true,
+		MaxConnsPerHost:          settings.maxConns,
+		// Wait for a pooled connection to free up rather than failing immediately
+		MaxConnWaitTimeout: settings.connectTimeout,
+		ReadTimeout:        settings.readTimeout,
+		WriteTimeout:       settings.readTimeout,
+		Dial: func(addr string) (net.Conn, error) {
+			return dialUpstream(addr, settings)
+		},
+		// fasthttp retries idempotent requests by itself. Dials are already retried
+		// by dialUpstream and waiting out a timeout again would only multiply it, so
+		// only failures on stale keep-alive connections are retried.
+		RetryIfErr: func(req *fasthttp.Request, _ int, err error) (bool, bool) {
+			idempotent := req.Header.IsGet() || req.Header.IsHead() || req.Header.IsPut()
+			return false, idempotent && !isDialError(err) && !isUpstreamTimeout(err)
+		},
+	}
+}
+
+// dialUpstream connects to a backend, retrying failed dials. Nothing has been sent
+// yet, so a retry is safe whatever the request method.
+func dialUpstream(addr string, settings upstreamSettings) (net.Conn, error) {
+	var err error
+	for attempt := 0; attempt <= settings.connectRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(attempt) * connectRetryBackoff)
+		}
+
+		var conn net.Conn
+		if conn, err = fasthttp.DialTimeout(addr, settings.connectTimeout); err == nil {
+			return conn, nil
+		}
+	}
+	return nil, err
+}
+
+// isUpstreamTimeout reports whether the backend was reachable but did not answer
+// in time, or every pooled connection stayed busy. Neither says anything about the
+// backend's health, unlike a failed dial.
+func isUpstreamTimeout(err error) bool {
+	if isDialError(err) {
+		return false
+	}
+	if errors.Is(err, fasthttp.ErrTimeout) || errors.Is(err, fasthttp.ErrNoFreeConns) {
+		return true
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// isDialError reports whether the backend could not be connected to at all.
+func isDialError(err error) bool {
+	var opErr *net.OpError
+	return errors.Is(err, fasthttp.ErrDialTimeout) || (errors.As(err, &opErr) && opErr.Op == "dial")
+}
@@ -0,0 +1,97 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"hypervisor/internal/env"
+	"hypervisor/internal/models"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestSettingsFor(t *testing.T) {
+	connectTimeout, readTimeout, bodyLimit := env.PROXY_CONNECT_TIMEOUT, env.PROXY_READ_TIMEOUT, env.PROXY_BODY_LIMIT
+	connectRetries, maxConns := env.PROXY_CONNECT_RETRIES, env.PROXY_MAX_CONNS
+	t.Cleanup(func() {
+		env.PROXY_CONNECT_TIMEOUT, env.PROXY_READ_TIMEOUT, env.PROXY_BODY_LIMIT = connectTimeout, readTimeout, bodyLimit
+		env.PROXY_CONNECT_RETRIES, env.PROXY_MAX_CONNS = connectRetries, maxConns
+	})
+
+	env.PROXY_CONNECT_TIMEOUT = 5 * time.Second
+	env.PROXY_READ_TIMEOUT = 60 * time.Second
+	env.PROXY_BODY_LIMIT = 4 << 20
+	env.PROXY_CONNECT_RETRIES = 1
+	env.PROXY_MAX_CONNS = 512
+
+	defaults := upstreamSettings{
+		connectTimeout: 5 * time.Second,
+		readTimeout:    60 * time.Second,
+		maxBody:        4 << 20,
+		connectRetries: 1,
+		maxConns:       512,
+	}
+
+	tests := []struct {
+		name  string
+		proxy *models.ProxySettings
+		want  upstreamSettings
+	}{
+		{"no settings", nil, defaults},
+		{"empty settings", &models.ProxySettings{}, defaults},
+		{
+			"every setting",
+			&models.ProxySettings{ConnectTimeoutMs: 250, ReadTimeoutMs: 120000, MaxBodyBytes: 50 << 20, ConnectRetries: 3, MaxConns: 64},
+			upstreamSettings{connectTimeout: 250 * time.Millisecond, readTimeout: 2 * time.Minute, maxBody: 50 << 20, connectRetries: 3, maxConns: 64},
+		},
+		{
+			"some settings",
+			&models.ProxySettings{ReadTimeoutMs: 1500},
+			upstreamSettings{connectTimeout: 5 * time.Second, readTimeout: 1500 * time.Millisecond, maxBody: 4 << 20, connectRetries: 1, maxConns: 512},
+		},
+		{"negative values keep the defaults", &models.ProxySettings{ConnectTimeoutMs: -1, MaxConns: -5}, defaults},
+	}
+
+	for _, tt := range tests {
+		if got := settingsFor(&models.Deployment{ID: "dep", Proxy: tt.proxy}); got != tt.want {
+			t.Errorf("%s: expected %+v, got %+v", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestIsUpstreamTimeout(t *testing.T) {
+	refused := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	dialTimeout := &net.OpError{Op: "dial", Net: "tcp", Err: os.ErrDeadlineExceeded}
+	readTimeout := &net.OpError{Op: "read", Net: "tcp", Err: os.ErrDeadlineExceeded}
+	reset := &net.OpError{Op: "read", Net: "tcp", Err: errors.New("connection reset by peer")}
+
+	tests := []struct {
+		name    string
+		err     error
+		timeout bool
+		dial    bool
+	}{
+		{"response timeout", fasthttp.ErrTimeout, true, false},
+		{"wrapped response timeout", fmt.Errorf("proxy: %w", fasthttp.ErrTimeout), true, false},
+		{"pool exhausted", fasthttp.ErrNoFreeConns, true, false},
+		{"read deadline", readTimeout, true, false},
+		{"dial timeout", fasthttp.ErrDialTimeout, false, true},
+		{"dial deadline", dialTimeout, false, true},
+		{"connection refused", refused, false, true},
+		{"connection reset", reset, false, false},
+		{"other error", errors.New("malformed response"), false, false},
+	}
+
+	for _, tt := range tests {
+		if got := isUpstreamTimeout(tt.err); got != tt.timeout {
+			t.Errorf("%s: expected timeout %v, got %v", tt.name, tt.timeout, got)
+		}
+		if got := isDialError(tt.err); got != tt.dial {
+			t.Errorf("%s: expected dial error %v, got %v", tt.name, tt.dial, got)
+		}
+	}
+}
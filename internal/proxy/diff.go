@@ -0,0 +1,186 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"reflect"
+	"regexp"
+	"sort"
+	"time"
+
+	"hypervisor/internal/env"
+	"hypervisor/internal/models"
+)
+
+// maxDiffFields bounds the number of differing JSON paths reported per response pair.
+const maxDiffFields = 20
+
+// arrayIndex matches the index part of a JSON path segment such as "items[3]".
+var arrayIndex = regexp.MustCompile(`\[\d+\]`)
+
+// mainResponse is main's side of a mirrored exchange.
+type mainResponse struct {
+	status  int
+	latency time.Duration
+	body    []byte // only captured when the mirror diffs responses
+}
+
+// ignoreSet decides which JSON fields are too noisy to compare.
+type ignoreSet map[string]bool
+
+func newIgnoreSet(fields []string) ignoreSet {
+	ignore := make(ignoreSet, len(fields)+len(env.MIRROR_DIFF_IGNORE_FIELDS))
+	for _, field := range env.MIRROR_DIFF_IGNORE_FIELDS {
+		ignore[field] = true
+	}
+	for _, field := range fields {
+		ignore[field] = true
+	}
+	return ignore
+}
+
+// matches reports whether a field is ignored, either by its key alone, its full
+// path, or its path with array indexes removed ("items.updatedAt").
+func (s ignoreSet) matches(path, key string) bool {
+	return s[key] || s[path] || s[arrayIndex.ReplaceAllString(path, "")]
+}
+
+// diffBodies compares two response bodies. JSON bodies are compared structurally
+// and the differing paths are returned; anything else is compared byte for byte.
+func diffBodies(mainBody, candidateBody []byte, ignore ignoreSet) (bool, []string) {
+	mainValue, mainErr := decodeJSON(mainBody)
+	candidateValue, candidateErr := decodeJSON(candidateBody)
+	if mainErr != nil || candidateErr != nil {
+		return !bytes.Equal(mainBody, candidateBody), nil
+	}
+
+	var fields []string
+	diffValues("", "", mainValue, candidateValue, ignore, &fields)
+	return len(fields) > 0, fields
+}
+
+func decodeJSON(body []byte) (any, error) {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// diffValues walks two decoded JSON values and appends the paths that differ.
+func diffValues(path, key string, a, b any, ignore ignoreSet, fields *[]string) {
+	if len(*fields) >= maxDiffFields || (path != "" && ignore.matches(path, key)) {
+		return
+	}
+
+	switch av := a.(type) {
+	case map[string]any:
+		bv, ok := b.(map[string]any)
+		if !ok {
+			*fields = append(*fields, displayPath(path))
+			return
+		}
+
+		keys := make([]string, 0, len(av)+len(bv))
+		for k := range av {
+			keys = append(keys, k)
+		}
+		for k := range bv {
+			if _, exists := av[k]; !exists {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			child := k
+			if path != "" {
+				child = path + "." + k
+			}
+
+			aChild, aExists := av[k]
+			bChild, bExists := bv[k]
+			if aExists != bExists {
+				if !ignore.matches(child, k) && len(*fields) < maxDiffFields {
+					*fields = append(*fields, child)
+				}
+				continue
+			}
+			diffValues(child, k, aChild, bChild, ignore, fields)
+		}
+
+	case []any:
+		bv, ok := b.([]any)
+		if !ok || len(av) != len(bv) {
+			*fields = append(*fields, displayPath(path))
+			return
+		}
+
+		for i := range av {
+			diffValues(fmt.Sprintf("%s[%d]", path, i), key, av[i], bv[i], ignore, fields)
+		}
+
+	default:
+		if !reflect.DeepEqual(a, b) {
+			*fields = append(*fields, displayPath(path))
+		}
+	}
+}
+
+func displayPath(path string) string {
+	if path == "" {
+		return "$"
+	}
+	return path
+}
+
+// recordDiff compares a mirrored response with main's and stores the outcome for
+// the deployment pair.
+func recordDiff(m *mirrorRequest, main mainResponse, candidateStatus int, candidateBody []byte) {
+	outcome := models.MirrorDiffMatched
+	var fields []string
+	if main.status != candidateStatus {
+		outcome = models.MirrorDiffStatus
+	} else if differs, differing := diffBodies(main.body, candidateBody, m.ignore); differs {
+		outcome = models.MirrorDiffBody
+		fields = differing
+	}
+
+	var sample *models.MirrorDiffSample
+	if outcome != models.MirrorDiffMatched {
+		sample = &models.MirrorDiffSample{
+			Path:            m.path,
+			MainStatus:      main.status,
+			CandidateStatus: candidateStatus,
+			Fields:          fields,
+			At:              time.Now(),
+		}
+	}
+	storeDiff(m, outcome, sample)
+}
+
+// recordCandidateError stores a mirrored request the candidate failed to answer,
+// which a clean report must not hide.
+func recordCandidateError(m *mirrorRequest, main mainResponse, err error) {
+	storeDiff(m, models.MirrorDiffCandidateError, &models.MirrorDiffSample{
+		Path:       m.path,
+		MainStatus: main.status,
+		Error:      err.Error(),
+		At:         time.Now(),
+	})
+}
+
+func storeDiff(m *mirrorRequest, outcome models.MirrorDiffOutcome, sample *models.MirrorDiffSample) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := models.RecordMirrorDiff(ctx, m.mainID, m.candidate.ID, m.method, m.endpoint, outcome, sample); err != nil {
+		log.Printf("failed to record mirror diff for %s %s: %v", m.method, m.endpoint, err)
+	}
+}
@@ -0,0 +1,61 @@
+package proxy
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestDiffBodies(t *testing.T) {
+	tests := []struct {
+		name      string
+		main      string
+		candidate string
+		ignore    []string
+		differs   bool
+		fields    []string
+	}{
+		{"identical JSON", `{"a":1,"b":[1,2]}`, `{"a":1,"b":[1,2]}`, nil, false, nil},
+		{"key order and whitespace", `{"a":1,"b":2}`, "{ \"b\": 2,\n \"a\": 1 }", nil, false, nil},
+		{"changed value", `{"a":1,"b":2}`, `{"a":1,"b":3}`, nil, true, []string{"b"}},
+		{"nested value", `{"user":{"name":"a","team":{"id":1}}}`, `{"user":{"name":"a","team":{"id":2}}}`, nil, true, []string{"user.team.id"}},
+		{"missing and extra keys", `{"a":1,"b":2}`, `{"a":1,"c":2}`, nil, true, []string{"b", "c"}},
+		{"array element", `{"items":[{"id":1},{"id":2}]}`, `{"items":[{"id":1},{"id":3}]}`, nil, true, []string{"items[1].id"}},
+		{"array length", `{"items":[1,2]}`, `{"items":[1]}`, nil, true, []string{"items"}},
+		{"type change", `{"a":{"b":1}}`, `{"a":[1]}`, nil, true, []string{"a"}},
+		{"top-level value", `[1,2]`, `{"a":1}`, nil, true, []string{"$"}},
+		{"ignored key anywhere", `{"at":1,"user":{"at":2}}`, `{"at":3,"user":{"at":4}}`, []string{"at"}, false, nil},
+		{"ignored full path", `{"meta":{"at":1},"at":1}`, `{"meta":{"at":2},"at":2}`, []string{"meta.at"}, true, []string{"at"}},
+		{"ignored path across array elements", `{"items":[{"at":1,"id":1}]}`, `{"items":[{"at":2,"id":1}]}`, []string{"items.at"}, false, nil},
+		{"ignored missing key", `{"a":1,"requestId":"x"}`, `{"a":1}`, []string{"requestId"}, false, nil},
+		{"equal plain text", "pong", "pong", nil, false, nil},
+		{"different plain text", "pong", "PONG", nil, true, nil},
+		{"JSON against plain text", `{"a":1}`, "oops", nil, true, nil},
+		{"both empty", "", "", nil, false, nil},
+	}
+
+	for _, tt := range tests {
+		differs, fields := diffBodies([]byte(tt.main), []byte(tt.candidate), newIgnoreSet(tt.ignore))
+		if differs != tt.differs {
+			t.Errorf("%s: expected differs %v, got %v", tt.name, tt.differs, differs)
+		}
+		if strings.Join(fields, ",") != strings.Join(tt.fields, ",") {
+			t.Errorf("%s: expected fields %v, got %v", tt.name, tt.fields, fields)
+		}
+	}
+}
+
+func TestDiffBodiesCapsFields(t *testing.T) {
+	var mainFields, candidateFields []string
+	for i := 0; i < maxDiffFields*2; i++ {
+		mainFields = append(mainFields, fmt.Sprintf(`"f%02d":1`, i))
+		candidateFields = append(candidateFields, fmt.Sprintf(`"f%02d":2`, i))
+	}
+	main := "{" + strings.Join(mainFields, ",") + "}"
+	candidate := "{" + strings.Join(candidateFields, ",") + "}"
+
+	differs, fields := diffBodies([]byte(main), []byte(candidate), newIgnoreSet(nil))
+	if !differs || len(fields) != maxDiffFields {
+		t.Errorf("Expected %d reported fields, got %d", maxDiffFields, len(fields))
+	}
+}
@@ -0,0 +1,198 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"hypervisor/internal/db"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	// inflightReportInterval is how often every instance publishes its in-flight
+	// counts, so a drain started on one instance also waits for the others.
+	inflightReportInterval = time.Second
+
+	// inflightReportTTL drops the counts of an instance that stopped reporting.
+	inflightReportTTL = 3 * inflightReportInterval
+)
+
+// inflightKey is the Redis hash holding an instance's in-flight count per deployment.
+func inflightKey(instance string) string {
+	return fmt.Sprintf("hypervisor:%s:inflight:%s", db.Name, instance)
+}
+
+// inflightInstancesKey is the Redis sorted set of reporting instances, scored by the
+// time of their last report in milliseconds.
+func inflightInstancesKey() string {
+	return fmt.Sprintf("hypervisor:%s:inflight", db.Name)
+}
+
+// beginRequest marks a request to the deployment as in-flight.
+func (rm *RouteMap) beginRequest(deploymentID string) {
+	rm.inflightMu.Lock()
+	defer rm.inflightMu.Unlock()
+	rm.inflight[deploymentID]++
+}
+
+// endRequest marks an in-flight request to the deployment as finished.
+func (rm *RouteMap) endRequest(deploymentID string) {
+	rm.inflightMu.Lock()
+	defer rm.inflightMu.Unlock()
+
+	rm.inflight[deploymentID]--
+	if rm.inflight[deploymentID] <= 0 {
+		delete(rm.inflight, deploymentID)
+	}
+}
+
+// InFlight returns the number of requests this instance is currently proxying to
+// the deployment.
+func (rm *RouteMap) InFlight(deploymentID string) int64 {
+	rm.inflightMu.Lock()
+	defer rm.inflightMu.Unlock()
+	return rm.inflight[deploymentID]
+}
+
+// StartInFlightReporter publishes this instance's in-flight counts to Redis every
+// inflightReportInterval until ctx is done.
+func (rm *RouteMap) StartInFlightReporter(ctx context.Context) {
+	if db.RDB == nil {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(inflightReportInterval)
+		defer ticker.Stop()
+
+		for {
+			rm.reportInFlight(ctx)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (rm *RouteMap) reportInFlight(ctx context.Context) {
+	rm.inflightMu.Lock()
+	counts := make(map[string]interface{}, len(rm.inflight))
+	for deploymentID, count := range rm.inflight {
+		counts[deploymentID] = count
+	}
+	rm.inflightMu.Unlock()
+
+	now := time.Now()
+	key := inflightKey(instanceID)
+	_, err := db.RDB.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, key)
+		if len(counts) > 0 {
+			pipe.HSet(ctx, key, counts)
+			pipe.Expire(ctx, key, inflightReportTTL)
+		}
+		pipe.ZAdd(ctx, inflightInstancesKey(), &redis.Z{Score: float64(now.UnixMilli()), Member: instanceID})
+		pipe.ZRemRangeByScore(ctx, inflightInstancesKey(), "-inf", strconv.FormatInt(now.Add(-inflightReportTTL).UnixMilli(), 10))
+		return nil
+	})
+	if err != nil && ctx.Err() == nil {
+		log.Printf("failed to report in-flight requests: %v", err)
+	}
+}
+
+// peerInFlight returns the requests the other instances are proxying to the
+// deployment, and whether every one of them has reported since the given time.
+func (rm *RouteMap) peerInFlight(ctx context.Context, deploymentID string, since time.Time) (int64, bool, error) {
+	if db.RDB == nil {
+		return 0, true, nil
+	}
+
+	cutoff := time.Now().Add(-inflightReportTTL)
+	peers, err := db.RDB.ZRangeByScoreWithScores(ctx, inflightInstancesKey(), &redis.ZRangeBy{
+		Min: strconv.FormatInt(cutoff.UnixMilli(), 10),
+		Max: "+inf",
+	}).Result()
+	if err != nil {
+		return 0, false, err
+	}
+
+	var total int64
+	current := true
+	for _, peer := range peers {
+		peerID, _ := peer.Member.(string)
+		if peerID == instanceID {
+			continue
+		}
+		if int64(peer.Score) < since.UnixMilli() {
+			current = false
+		}
+
+		count, err := db.RDB.HGet(ctx, inflightKey(peerID), deploymentID).Int64()
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
+		if err != nil {
+			return 0, false, err
+		}
+		total += count
+	}
+	return total, current, nil
+}
+
+// WaitForDrain blocks until no hypervisor instance has in-flight requests to the
+// deployment, or ctx is done. onProgress is called with the total in-flight count
+// every time it changes. The deployment must already be out of the route map so no
+// new requests arrive. Counts from other instances are only trusted once they were
+// reported a full interval after the drain started, by when those instances have
+// taken the deployment out of routing too; until then the drain keeps waiting.
+func (rm *RouteMap) WaitForDrain(ctx context.Context, deploymentID string, onProgress func(inFlight int64)) error {
+	ticker := time.NewTicker(250 * time.Millisecond)
+	defer ticker.Stop()
+
+	reportedSince := time.Now().Add(inflightReportInterval)
+	last := int64(-1)
+	for {
+		current := rm.InFlight(deploymentID)
+		peers, settled, err := rm.peerInFlight(ctx, deploymentID, reportedSince)
+		if err != nil {
+			// Without the other instances' counts, keep waiting rather than cut them off
+			log.Printf("failed to read in-flight requests of other instances: %v", err)
+			settled = false
+		}
+		current += peers
+
+		if current != last {
+			last = current
+			if onProgress != nil {
+				onProgress(current)
+			}
+		}
+
+		if current == 0 && settled {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// TotalInFlight returns the requests all instances are proxying to the deployment,
+// as far as their last reports tell.
+func (rm *RouteMap) TotalInFlight(ctx context.Context, deploymentID string) int64 {
+	peers, _, err := rm.peerInFlight(ctx, deploymentID, time.Time{})
+	if err != nil {
+		log.Printf("failed to read in-flight requests of other instances: %v", err)
+	}
+	return rm.InFlight(deploymentID) + peers
+}
@@ -0,0 +1,180 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"hypervisor/internal/models"
+
+	"github.com/fasthttp/websocket"
+)
+
+func TestWaitForDrainWithoutRequests(t *testing.T) {
+	rm := NewRouteMap()
+
+	var progress []int64
+	err := rm.WaitForDrain(context.Background(), "dep", func(inFlight int64) {
+		progress = append(progress, inFlight)
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(progress) != 1 || progress[0] != 0 {
+		t.Errorf("Expected a single progress report of 0, got %v", progress)
+	}
+}
+
+func TestWaitForDrainWaitsForRequests(t *testing.T) {
+	rm := NewRouteMap()
+	rm.beginRequest("dep")
+	rm.beginRequest("dep")
+	rm.beginRequest("other")
+
+	go func() {
+		time.Sleep(300 * time.Millisecond)
+		rm.endRequest("dep")
+		time.Sleep(300 * time.Millisecond)
+		rm.endRequest("dep")
+	}()
+
+	var mu sync.Mutex
+	var progress []int64
+	start := time.Now()
+	err := rm.WaitForDrain(context.Background(), "dep", func(inFlight int64) {
+		mu.Lock()
+		defer mu.Unlock()
+		progress = append(progress, inFlight)
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 600*time.Millisecond {
+		t.Errorf("Expected the drain to wait for both requests, returned after %s", elapsed)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(progress) != 3 || progress[0] != 2 || progress[1] != 1 || progress[2] != 0 {
+		t.Errorf("Expected progress 2, 1, 0, got %v", progress)
+	}
+	if rm.InFlight("other") != 1 {
+		t.Errorf("Expected other deployments' requests to be left alone")
+	}
+}
+
+func TestWaitForDrainDeadline(t *testing.T) {
+	rm := NewRouteMap()
+	rm.beginRequest("dep")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+
+	err := rm.WaitForDrain(ctx, "dep", nil)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the deadline to end the drain, got %v", err)
+	}
+	if rm.InFlight("dep") != 1 {
+		t.Errorf("Expected the request to still be in flight")
+	}
+}
+
+func TestEndRequestClearsCount(t *testing.T) {
+	rm := NewRouteMap()
+	rm.beginRequest("dep")
+	rm.endRequest("dep")
+
+	rm.inflightMu.Lock()
+	_, exists := rm.inflight["dep"]
+	rm.inflightMu.Unlock()
+	if exists {
+		t.Errorf("Expected a drained deployment to leave no count behind")
+	}
+	if rm.TotalInFlight(context.Background(), "dep") != 0 {
+		t.Errorf("Expected no in-flight requests without other instances")
+	}
+}
+
+func TestDrainKeepsSocketsOpen(t *testing.T) {
+	rm := NewRouteMap()
+	port := 20001
+	dep := &models.Deployment{ID: "dep", StageID: "stage", Status: models.DeploymentStatusReady, Port: &port}
+	rm.UpdateDeployment(dep)
+
+	client, clientPeer := socketConns(t)
+	backend, _ := socketConns(t)
+	rm.trackSocket(dep.ID, &socketPair{client: client, backend: backend})
+	closed := watchClose(clientPeer)
+
+	draining := *dep
+	draining.Status = models.DeploymentStatusDraining
+	rm.UpdateDeployment(&draining)
+
+	if _, routed := rm.GetDeployment("stage"); routed {
+		t.Fatalf("Expected a draining deployment to leave routing")
+	}
+	select {
+	case <-closed:
+		t.Fatalf("Expected the WebSocket to stay open while the deployment drains")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	stopped := *dep
+	stopped.Status = models.DeploymentStatusStopped
+	rm.UpdateDeployment(&stopped)
+
+	select {
+	case <-closed:
+	case <-time.After(2 * time.Second):
+		t.Errorf("Expected the WebSocket to close once the deployment stopped")
+	}
+}
+
+// socketConns returns the server end of a WebSocket connection and the client end
+// talking to it.
+func socketConns(t *testing.T) (*websocket.Conn, *websocket.Conn) {
+	t.Helper()
+
+	accepted := make(chan *websocket.Conn, 1)
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		accepted <- conn
+	}))
+	t.Cleanup(server.Close)
+
+	dialed, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	t.Cleanup(func() { dialed.Close() })
+
+	remote := <-accepted
+	t.Cleanup(func() { remote.Close() })
+	return remote, dialed
+}
+
+// watchClose returns a channel closed once conn receives a close frame.
+func watchClose(conn *websocket.Conn) <-chan struct{} {
+	closed := make(chan struct{})
+	go func() {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				var closeErr *websocket.CloseError
+				if errors.As(err, &closeErr) {
+					close(closed)
+				}
+				return
+			}
+		}
+	}()
+	return closed
+}
@@ -0,0 +1,73 @@
+package proxy
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"time"
+
+	"hypervisor/internal/errmsg"
+	"hypervisor/internal/models"
+
+	"github.com/gofiber/fiber/v3"
+)
+
+// FaultHeader names the fault rule applied to a response.
+const FaultHeader = "X-Hypervisor-Fault"
+
+// statusConnectionDropped is logged for requests whose connection a fault dropped,
+// following nginx's 444.
+const statusConnectionDropped = 444
+
+// injectFault applies the first active fault rule matching the request. It returns
+// false when the rule answered or dropped the request, so it must not be proxied.
+func injectFault(c fiber.Ctx, dep *models.Deployment, upstreamPath string) (bool, error) {
+	if len(dep.Faults) == 0 {
+		return true, nil
+	}
+
+	now := time.Now()
+	for _, rule := range dep.Faults {
+		if !rule.Active(now) || !rule.Matches(c.Method(), upstreamPath) {
+			continue
+		}
+		// The first matching rule decides, whether or not this request is sampled
+		if rand.Intn(100) >= rule.Percent {
+			return true, nil
+		}
+
+		c.Set(FaultHeader, rule.ID)
+		if delay := faultDelay(rule); delay > 0 {
+			time.Sleep(delay)
+		}
+
+		switch {
+		case rule.Drop:
+			dropConnection(c)
+			return false, nil
+		case rule.Status > 0:
+			serr := errmsg.DeploymentFaultInjected
+			serr.StatusCode = rule.Status
+			return false, proxyError(c, serr, dep, fmt.Sprintf("fault %s", rule.ID))
+		}
+		return true, nil
+	}
+
+	return true, nil
+}
+
+func faultDelay(rule models.FaultRule) time.Duration {
+	delay := rule.DelayMs
+	if rule.DelayJitterMs > 0 {
+		delay += rand.Intn(rule.DelayJitterMs + 1)
+	}
+	return time.Duration(delay) * time.Millisecond
+}
+
+// dropConnection closes the client connection once the handler returns, without
+// writing a response.
+func dropConnection(c fiber.Ctx) {
+	c.Status(statusConnectionDropped)
+	c.RequestCtx().HijackSetNoResponse(true)
+	c.RequestCtx().Hijack(func(net.Conn) {})
+}
@@ -0,0 +1,72 @@
+package proxy
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/gofiber/fiber/v3"
+)
+
+// RequestIDHeader correlates a request across nginx, the hypervisor and the backend.
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds request IDs accepted from clients or nginx.
+const maxRequestIDLength = 128
+
+// ensureRequestID keeps a well-formed incoming X-Request-ID or generates one, and
+// sets it on both the request (so the backend sees it) and the response.
+func ensureRequestID(c fiber.Ctx) string {
+	id := c.Get(RequestIDHeader)
+	if !validRequestID(id) {
+		id = newRequestID()
+		c.Request().Header.Set(RequestIDHeader, id)
+	}
+	c.Set(RequestIDHeader, id)
+	return id
+}
+
+// RequestID returns the ID assigned to the request by the proxy.
+func RequestID(c fiber.Ctx) string {
+	return c.Get(RequestIDHeader)
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// setForwardedHeaders tells the backend who the client is and how it reached us.
+// nginx on loopback has already set X-Forwarded-For to the real peer and set
+// the host and scheme, so only missing values are filled in.
+func setForwardedHeaders(c fiber.Ctx) {
+	header := &c.Request().Header
+	remote := c.RequestCtx().RemoteIP()
+
+	forwardedFor := c.Get(fiber.HeaderXForwardedFor)
+	switch {
+	case forwardedFor == "":
+		header.Set(fiber.HeaderXForwardedFor, remote.String())
+	case !remote.IsLoopback():
+		header.Set(fiber.HeaderXForwardedFor, forwardedFor+", "+remote.String())
+	}
+
+	if c.Get(fiber.HeaderXForwardedHost) == "" {
+		header.Set(fiber.HeaderXForwardedHost, string(c.Request().Host()))
+	}
+	if c.Get(fiber.HeaderXForwardedProto) == "" {
+		header.Set(fiber.HeaderXForwardedProto, c.Scheme())
+	}
+}
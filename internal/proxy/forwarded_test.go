@@ -0,0 +1,101 @@
+package proxy
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v3"
+)
+
+func TestValidRequestID(t *testing.T) {
+	tests := []struct {
+		name string
+		id   string
+		want bool
+	}{
+		{"nginx request ID", "4f2a9c1e0b7d4e3f8a6b5c4d3e2f1a0b", true},
+		{"UUID", "123e4567-e89b-12d3-a456-426614174000", true},
+		{"empty", "", false},
+		{"space", "abc def", false},
+		{"control character", "abc\x01", false},
+		{"non-ASCII", "abcé", false},
+		{"longest accepted", strings.Repeat("a", maxRequestIDLength), true},
+		{"too long", strings.Repeat("a", maxRequestIDLength+1), false},
+	}
+
+	for _, tt := range tests {
+		if got := validRequestID(tt.id); got != tt.want {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestEnsureRequestID(t *testing.T) {
+	tests := []struct {
+		name     string
+		incoming string
+		keep     bool
+	}{
+		{"from nginx", "4f2a9c1e0b7d4e3f8a6b5c4d3e2f1a0b", true},
+		{"missing", "", false},
+		{"malformed", "bad id", false},
+	}
+
+	for _, tt := range tests {
+		c := policyCtx(t, "127.0.0.1", "")
+		if tt.incoming != "" {
+			c.Request().Header.Set(RequestIDHeader, tt.incoming)
+		}
+
+		id := ensureRequestID(c)
+		if tt.keep && id != tt.incoming {
+			t.Errorf("%s: expected %q kept, got %q", tt.name, tt.incoming, id)
+		}
+		if !tt.keep && (id == tt.incoming || len(id) != 32 || !validRequestID(id)) {
+			t.Errorf("%s: expected a generated ID, got %q", tt.name, id)
+		}
+		if got := RequestID(c); got != id {
+			t.Errorf("%s: expected the request to carry %q, got %q", tt.name, id, got)
+		}
+		if got := string(c.Response().Header.Peek(RequestIDHeader)); got != id {
+			t.Errorf("%s: expected the response to carry %q, got %q", tt.name, id, got)
+		}
+	}
+}
+
+func TestSetForwardedHeaders(t *testing.T) {
+	tests := []struct {
+		name         string
+		remote       string
+		forwardedFor string
+		want         string
+	}{
+		{"direct client", "203.0.113.7", "", "203.0.113.7"},
+		{"direct client with a header", "203.0.113.7", "10.0.0.1", "10.0.0.1, 203.0.113.7"},
+		{"nginx on loopback", "127.0.0.1", "198.51.100.4", "198.51.100.4"},
+		{"loopback without the header", "127.0.0.1", "", "127.0.0.1"},
+	}
+
+	for _, tt := range tests {
+		c := policyCtx(t, tt.remote, tt.forwardedFor)
+		setForwardedHeaders(c)
+
+		if got := c.Get(fiber.HeaderXForwardedFor); got != tt.want {
+			t.Errorf("%s: expected X-Forwarded-For %q, got %q", tt.name, tt.want, got)
+		}
+		if got := c.Get(fiber.HeaderXForwardedProto); got != "http" {
+			t.Errorf("%s: expected X-Forwarded-Proto http, got %q", tt.name, got)
+		}
+	}
+
+	c := policyCtx(t, "127.0.0.1", "")
+	c.Request().Header.Set(fiber.HeaderXForwardedHost, "api.openhack.ro")
+	c.Request().Header.Set(fiber.HeaderXForwardedProto, "https")
+	setForwardedHeaders(c)
+	if got := c.Get(fiber.HeaderXForwardedHost); got != "api.openhack.ro" {
+		t.Errorf("Expected X-Forwarded-Host from nginx kept, got %q", got)
+	}
+	if got := c.Get(fiber.HeaderXForwardedProto); got != "https" {
+		t.Errorf("Expected X-Forwarded-Proto from nginx kept, got %q", got)
+	}
+}
@@ -0,0 +1,220 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"hypervisor/internal/env"
+	"hypervisor/internal/errmsg"
+	"hypervisor/internal/models"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/valyala/fasthttp"
+)
+
+// healthClient is used by the prober so probes never share connections with proxied traffic.
+var healthClient = &fasthttp.Client{
+	NoDefaultUserAgentHeader: true,
+}
+
+// proxyErrorResponse is the body returned when the proxy cannot serve a request.
+type proxyErrorResponse struct {
+	StatusCode   int    `json:"statusCode"`
+	Message      string `json:"message"`
+	DeploymentID string `json:"deploymentId,omitempty"`
+	Reason       string `json:"reason,omitempty"`
+	RequestID    string `json:"requestId,omitempty"`
+}
+
+// proxyError writes a structured error naming the deployment that could not serve the request.
+func proxyError(c fiber.Ctx, serr errmsg.StatusError, dep *models.Deployment, reason string) error {
+	if serr.StatusCode == fiber.StatusServiceUnavailable {
+		c.Set(fiber.HeaderRetryAfter, fmt.Sprintf("%d", int(env.DEPLOYMENT_HEALTH_INTERVAL.Seconds())+1))
+	}
+
+	body := proxyErrorResponse{
+		StatusCode: serr.StatusCode,
+		Message:    serr.Message,
+		Reason:     reason,
+		RequestID:  RequestID(c),
+	}
+	if dep != nil {
+		body.DeploymentID = dep.ID
+	}
+	return c.Status(serr.StatusCode).JSON(body)
+}
+
+// GetHealth returns the last observed health of a deployment.
+// Deployments that have not been probed yet report HealthStatusUnknown.
+func (rm *RouteMap) GetHealth(deploymentID string) models.DeploymentHealth {
+	rm.healthMu.RLock()
+	defer rm.healthMu.RUnlock()
+
+	if health, exists := rm.health[deploymentID]; exists {
+		return *health
+	}
+	return models.DeploymentHealth{Status: models.HealthStatusUnknown}
+}
+
+// isHealthy reports whether a deployment may receive traffic. Unknown counts as healthy
+// so a freshly provisioned deployment is routable before its first probe.
+func (rm *RouteMap) isHealthy(deploymentID string) bool {
+	return rm.GetHealth(deploymentID).Status != models.HealthStatusUnhealthy
+}
+
+// updateDeploymentHealth persists health transitions; tests replace it.
+var updateDeploymentHealth = models.UpdateDeploymentHealth
+
+// recordHealth folds a probe or proxy result into the deployment's health. After
+// DEPLOYMENT_HEALTH_THRESHOLD consecutive failures the deployment is ejected from
+// routing; a single success restores it. Transitions are persisted on the deployment.
+// Results for a deployment that has left routing meanwhile are dropped, so a probe
+// finishing after forgetHealth does not bring its state back.
+func (rm *RouteMap) recordHealth(dep *models.Deployment, checkErr error) {
+	now := time.Now()
+
+	// rm.mu is held throughout so applyUpdate cannot forget the health in between
+	rm.mu.RLock()
+	current, routed := rm.deployments[dep.StageID]
+	if !routed || current.ID != dep.ID {
+		rm.mu.RUnlock()
+		return
+	}
+
+	rm.healthMu.Lock()
+	health, exists := rm.health[dep.ID]
+	if !exists {
+		health = &models.DeploymentHealth{Status: models.HealthStatusUnknown, ChangedAt: now}
+		rm.health[dep.ID] = health
+	}
+
+	previous := health.Status
+	health.CheckedAt = now
+	if checkErr == nil {
+		health.ConsecutiveFailures = 0
+		health.LastError = ""
+		health.Status = models.HealthStatusHealthy
+	} else {
+		health.ConsecutiveFailures++
+		health.LastError = checkErr.Error()
+		if health.ConsecutiveFailures >= env.DEPLOYMENT_HEALTH_THRESHOLD {
+			health.Status = models.HealthStatusUnhealthy
+		}
+	}
+
+	changed := health.Status != previous
+	if changed {
+		health.ChangedAt = now
+	}
+	snapshot := *health
+	rm.healthMu.Unlock()
+	rm.mu.RUnlock()
+
+	if !changed {
+		return
+	}
+
+	log.Printf("deployment %s health changed: %s -> %s %s", dep.ID, previous, snapshot.Status, snapshot.LastError)
+	if err := updateDeploymentHealth(context.Background(), dep.ID, snapshot); err != nil {
+		log.Printf("failed to record health for deployment %s: %v", dep.ID, err)
+	}
+
+	rm.hooksMu.RLock()
+	defer rm.hooksMu.RUnlock()
+	for _, hook := range rm.healthHooks {
+		go hook(*dep, snapshot)
+	}
+}
+
+// HealthHook is called with a deployment's new health whenever it changes.
+type HealthHook func(dep models.Deployment, health models.DeploymentHealth)
+
+// OnHealthChange registers a hook run, in its own goroutine, on every health transition.
+func (rm *RouteMap) OnHealthChange(hook HealthHook) {
+	rm.hooksMu.Lock()
+	defer rm.hooksMu.Unlock()
+	rm.healthHooks = append(rm.healthHooks, hook)
+}
+
+// forgetHealth drops health state for a deployment that is no longer routed.
+func (rm *RouteMap) forgetHealth(deploymentID string) {
+	rm.healthMu.Lock()
+	defer rm.healthMu.Unlock()
+	delete(rm.health, deploymentID)
+}
+
+// Probe performs one active health check against the deployment's backend.
+func Probe(dep *models.Deployment) error {
+	if dep.Port == nil {
+		return fmt.Errorf("no port assigned")
+	}
+
+	req := fasthttp.AcquireRequest()
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseRequest(req)
+	defer fasthttp.ReleaseResponse(resp)
+
+	req.SetRequestURI(fmt.Sprintf("http://localhost:%d%s", *dep.Port, HealthPath(dep)))
+	req.Header.SetMethod(fasthttp.MethodGet)
+
+	if err := healthClient.DoTimeout(req, resp, env.DEPLOYMENT_HEALTH_TIMEOUT); err != nil {
+		return err
+	}
+
+	if status := resp.StatusCode(); status < 200 || status >= 400 {
+		return fmt.Errorf("health check returned status %d", status)
+	}
+	return nil
+}
+
+// HealthPath is the path a deployment is probed on.
+func HealthPath(dep *models.Deployment) string {
+	if dep.HealthPath != "" {
+		return dep.HealthPath
+	}
+	return env.DEPLOYMENT_HEALTH_PATH
+}
+
+// checkAll probes every routable deployment concurrently.
+func (rm *RouteMap) checkAll() {
+	rm.mu.RLock()
+	targets := make([]*models.Deployment, 0, len(rm.deployments))
+	for _, dep := range rm.deployments {
+		targets = append(targets, dep)
+	}
+	rm.mu.RUnlock()
+
+	var wg sync.WaitGroup
+	for _, dep := range targets {
+		wg.Add(1)
+		go func(dep *models.Deployment) {
+			defer wg.Done()
+			rm.recordHealth(dep, Probe(dep))
+		}(dep)
+	}
+	wg.Wait()
+}
+
+// StartHealthChecker probes each routable deployment every DEPLOYMENT_HEALTH_INTERVAL.
+func (rm *RouteMap) StartHealthChecker(ctx context.Context) {
+	if env.DEPLOYMENT_HEALTH_INTERVAL <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(env.DEPLOYMENT_HEALTH_INTERVAL)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				rm.checkAll()
+			}
+		}
+	}()
+}
@@ -0,0 +1,108 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"hypervisor/internal/env"
+	"hypervisor/internal/models"
+)
+
+// stubHealthUpdates replaces the persistence of health transitions for the test
+// and returns the transitions it received.
+func stubHealthUpdates(t *testing.T) *[]models.HealthStatus {
+	t.Helper()
+
+	var transitions []models.HealthStatus
+	previous := updateDeploymentHealth
+	updateDeploymentHealth = func(_ context.Context, _ string, health models.DeploymentHealth) error {
+		transitions = append(transitions, health.Status)
+		return nil
+	}
+	t.Cleanup(func() { updateDeploymentHealth = previous })
+	return &transitions
+}
+
+func TestRecordHealthThreshold(t *testing.T) {
+	previousThreshold := env.DEPLOYMENT_HEALTH_THRESHOLD
+	env.DEPLOYMENT_HEALTH_THRESHOLD = 3
+	t.Cleanup(func() { env.DEPLOYMENT_HEALTH_THRESHOLD = previousThreshold })
+
+	failed := errors.New("connection refused")
+
+	tests := []struct {
+		name        string
+		results     []error
+		want        models.HealthStatus
+		failures    int
+		transitions int
+	}{
+		{"not probed", nil, models.HealthStatusUnknown, 0, 0},
+		{"first success", []error{nil}, models.HealthStatusHealthy, 0, 1},
+		{"failures below the threshold", []error{nil, failed, failed}, models.HealthStatusHealthy, 2, 1},
+		{"failures below the threshold before any success", []error{failed, failed}, models.HealthStatusUnknown, 2, 0},
+		{"failures reaching the threshold", []error{nil, failed, failed, failed}, models.HealthStatusUnhealthy, 3, 2},
+		{"failures past the threshold", []error{failed, failed, failed, failed, failed}, models.HealthStatusUnhealthy, 5, 1},
+		{"a success restores it", []error{failed, failed, failed, nil}, models.HealthStatusHealthy, 0, 2},
+		{"a success resets the count", []error{nil, failed, failed, nil, failed, failed}, models.HealthStatusHealthy, 2, 1},
+	}
+
+	for _, tt := range tests {
+		transitions := stubHealthUpdates(t)
+		rm := NewRouteMap()
+		dep := &models.Deployment{ID: "dep", StageID: "stage", Status: models.DeploymentStatusReady}
+		rm.applyUpdate(dep)
+
+		for _, result := range tt.results {
+			rm.recordHealth(dep, result)
+		}
+
+		health := rm.GetHealth(dep.ID)
+		if health.Status != tt.want {
+			t.Errorf("%s: expected status %s, got %s", tt.name, tt.want, health.Status)
+		}
+		if health.ConsecutiveFailures != tt.failures {
+			t.Errorf("%s: expected %d consecutive failures, got %d", tt.name, tt.failures, health.ConsecutiveFailures)
+		}
+		if len(*transitions) != tt.transitions {
+			t.Errorf("%s: expected %d persisted transitions, got %v", tt.name, tt.transitions, *transitions)
+		}
+		routable := tt.want != models.HealthStatusUnhealthy
+		if healthy := rm.isHealthy(dep.ID); healthy != routable {
+			t.Errorf("%s: expected routable %v, got %v", tt.name, routable, healthy)
+		}
+	}
+}
+
+func TestRecordHealthAfterLeavingRouting(t *testing.T) {
+	transitions := stubHealthUpdates(t)
+	rm := NewRouteMap()
+
+	dep := &models.Deployment{ID: "stage-r1", StageID: "stage", Revision: 1, Status: models.DeploymentStatusReady}
+	rm.applyUpdate(dep)
+	rm.recordHealth(dep, nil)
+
+	// A probe started while the deployment was routed finishes after it was stopped
+	stopped := *dep
+	stopped.Status = models.DeploymentStatusStopped
+	rm.applyUpdate(&stopped)
+	rm.recordHealth(dep, errors.New("connection refused"))
+
+	if health := rm.GetHealth(dep.ID); health.Status != models.HealthStatusUnknown {
+		t.Errorf("Expected no health state for a stopped deployment, got %s", health.Status)
+	}
+
+	// Or after a newer revision took over the stage
+	next := &models.Deployment{ID: "stage-r2", StageID: "stage", Revision: 2, Status: models.DeploymentStatusReady}
+	rm.applyUpdate(dep)
+	rm.applyUpdate(next)
+	rm.recordHealth(dep, nil)
+
+	if health := rm.GetHealth(dep.ID); health.Status != models.HealthStatusUnknown {
+		t.Errorf("Expected no health state for a replaced revision, got %s", health.Status)
+	}
+	if len(*transitions) != 1 {
+		t.Errorf("Expected only the transition while routed to be persisted, got %v", *transitions)
+	}
+}
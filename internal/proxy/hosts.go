@@ -0,0 +1,68 @@
+package proxy
+
+import (
+	"net"
+	"strings"
+
+	"hypervisor/internal/env"
+	"hypervisor/internal/models"
+)
+
+// normalizeHost lowercases a Host header value and strips any port.
+func normalizeHost(host string) string {
+	host = strings.ToLower(strings.TrimSpace(host))
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.TrimSuffix(host, ".")
+}
+
+// stageFromHost extracts the stage ID from a `<stageId>.<PROXY_BASE_DOMAIN>` host.
+func stageFromHost(host string) (string, bool) {
+	base := normalizeHost(env.PROXY_BASE_DOMAIN)
+	if base == "" || !strings.HasSuffix(host, "."+base) {
+		return "", false
+	}
+
+	stageID := strings.TrimSuffix(host, "."+base)
+	return stageID, stageID != ""
+}
+
+// GetDeploymentByHost returns the routable deployment serving the given Host header,
+// either through one of its custom hostnames or a `<stageId>.<PROXY_BASE_DOMAIN>` subdomain.
+func (rm *RouteMap) GetDeploymentByHost(host string) (*models.Deployment, bool) {
+	rm.mu.RLock()
+	defer rm.mu.RUnlock()
+
+	return rm.findByHost(host)
+}
+
+// findByHost looks up a routable deployment by Host header. Callers must hold rm.mu.
+func (rm *RouteMap) findByHost(host string) (*models.Deployment, bool) {
+	host = normalizeHost(host)
+	if host == "" {
+		return nil, false
+	}
+
+	for _, dep := range rm.deployments {
+		for _, hostname := range dep.Hostnames {
+			if hostname == host {
+				return dep, true
+			}
+		}
+	}
+
+	stageID, ok := stageFromHost(host)
+	if !ok {
+		return nil, false
+	}
+
+	// Hostnames are case-insensitive while stage IDs are not
+	for id, dep := range rm.deployments {
+		if strings.EqualFold(id, stageID) {
+			return dep, true
+		}
+	}
+
+	return nil, false
+}
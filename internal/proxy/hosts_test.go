@@ -0,0 +1,79 @@
+package proxy
+
+import (
+	"testing"
+
+	"hypervisor/internal/env"
+	"hypervisor/internal/models"
+)
+
+func withBaseDomain(t *testing.T, domain string) {
+	t.Helper()
+
+	previous := env.PROXY_BASE_DOMAIN
+	env.PROXY_BASE_DOMAIN = domain
+	t.Cleanup(func() { env.PROXY_BASE_DOMAIN = previous })
+}
+
+func TestStageFromHost(t *testing.T) {
+	tests := []struct {
+		name   string
+		base   string
+		host   string
+		stage  string
+		wantOK bool
+	}{
+		{"stage subdomain", "api.openhack.ro", "v25.10.27.0-dev.api.openhack.ro", "v25.10.27.0-dev", true},
+		{"base domain in another case with a trailing dot", "API.openhack.ro.", "stage.api.openhack.ro", "stage", true},
+		{"base domain itself", "api.openhack.ro", "api.openhack.ro", "", false},
+		{"empty subdomain", "api.openhack.ro", ".api.openhack.ro", "", false},
+		{"other domain", "api.openhack.ro", "stage.example.com", "", false},
+		{"suffix without a dot", "api.openhack.ro", "stageapi.openhack.ro", "", false},
+		{"no base domain", "", "stage.api.openhack.ro", "", false},
+	}
+
+	for _, tt := range tests {
+		withBaseDomain(t, tt.base)
+		stage, ok := stageFromHost(tt.host)
+		if ok != tt.wantOK || stage != tt.stage {
+			t.Errorf("%s: expected (%q, %v), got (%q, %v)", tt.name, tt.stage, tt.wantOK, stage, ok)
+		}
+	}
+}
+
+func TestFindByHost(t *testing.T) {
+	withBaseDomain(t, "api.openhack.ro")
+
+	rm := NewRouteMap()
+	rm.applyUpdate(&models.Deployment{ID: "v1-dev-r1", StageID: "v1-dev", Status: models.DeploymentStatusReady})
+	rm.applyUpdate(&models.Deployment{ID: "v2-prod-r1", StageID: "v2-prod", Status: models.DeploymentStatusReady, Hostnames: []string{"openhack.ro"}})
+	rm.applyUpdate(&models.Deployment{ID: "V3-Dev-r1", StageID: "V3-Dev", Status: models.DeploymentStatusReady})
+	rm.applyUpdate(&models.Deployment{ID: "v4-dev-r1", StageID: "v4-dev", Status: models.DeploymentStatusStopped, Hostnames: []string{"stopped.example.com"}})
+
+	tests := []struct {
+		name string
+		host string
+		want string
+	}{
+		{"stage subdomain", "v1-dev.api.openhack.ro", "v1-dev-r1"},
+		{"stage subdomain with a port", "v1-dev.api.openhack.ro:443", "v1-dev-r1"},
+		{"custom hostname", "openhack.ro", "v2-prod-r1"},
+		{"custom hostname in another case", "OpenHack.RO.", "v2-prod-r1"},
+		{"stage ID in another case", "v3-dev.api.openhack.ro", "V3-Dev-r1"},
+		{"unknown stage", "v9-dev.api.openhack.ro", ""},
+		{"unrouted deployment's hostname", "stopped.example.com", ""},
+		{"base domain", "api.openhack.ro", ""},
+		{"empty host", "", ""},
+	}
+
+	for _, tt := range tests {
+		dep, ok := rm.GetDeploymentByHost(tt.host)
+		got := ""
+		if ok {
+			got = dep.ID
+		}
+		if got != tt.want {
+			t.Errorf("%s: expected %q, got %q", tt.name, tt.want, got)
+		}
+	}
+}
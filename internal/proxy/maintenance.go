@@ -0,0 +1,108 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+
+	"hypervisor/internal/errmsg"
+	"hypervisor/internal/models"
+
+	"github.com/gofiber/fiber/v3"
+)
+
+// defaultMaintenanceRetryAfter is sent when the maintenance settings do not set one.
+const defaultMaintenanceRetryAfter = 300
+
+// maintenancePage is served to browsers when no custom HTML is configured.
+var maintenancePage = template.Must(template.New("maintenance").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Down for maintenance</title>
+<style>body{font-family:system-ui,sans-serif;max-width:36rem;margin:20vh auto;padding:0 1rem;color:#222}</style>
+</head>
+<body>
+<h1>Down for maintenance</h1>
+<p>{{.}}</p>
+</body>
+</html>
+`))
+
+// maintenanceResponse is the JSON body returned for root traffic during maintenance.
+type maintenanceResponse struct {
+	StatusCode int    `json:"statusCode"`
+	Message    string `json:"message"`
+	RetryAfter int    `json:"retryAfter"`
+}
+
+// GetMaintenance returns the maintenance settings this instance is enforcing.
+func (rm *RouteMap) GetMaintenance() models.Maintenance {
+	rm.mu.RLock()
+	defer rm.mu.RUnlock()
+	return rm.maintenance
+}
+
+// SetMaintenance applies new maintenance settings and broadcasts them to the
+// other hypervisor instances.
+func (rm *RouteMap) SetMaintenance(m models.Maintenance) {
+	rm.applyMaintenance(m)
+	rm.publish(routeMessage{Kind: routeMessageMaintenance, Maintenance: &m})
+}
+
+func (rm *RouteMap) applyMaintenance(m models.Maintenance) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	rm.maintenance = m
+	rm.recordChange(&m.UpdatedAt, m.UpdatedBy)
+	rm.printRoutingMap()
+}
+
+// inMaintenance reports whether root traffic for this request is held back by
+// maintenance mode. Hyperusers are let through to main.
+func (rm *RouteMap) inMaintenance(hyperuser bool) bool {
+	if !rm.GetMaintenance().Enabled {
+		return false
+	}
+
+	return !hyperuser
+}
+
+// serveMaintenance writes the maintenance response, as HTML for browsers and JSON otherwise.
+func (rm *RouteMap) serveMaintenance(c fiber.Ctx) error {
+	m := rm.GetMaintenance()
+
+	retryAfter := m.RetryAfter
+	if retryAfter <= 0 {
+		retryAfter = defaultMaintenanceRetryAfter
+	}
+	message := m.Message
+	if message == "" {
+		message = errmsg.MaintenanceMode.Message
+	}
+
+	c.Set(fiber.HeaderRetryAfter, fmt.Sprintf("%d", retryAfter))
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	c.Status(errmsg.MaintenanceMode.StatusCode)
+
+	if c.Accepts(fiber.MIMEApplicationJSON, fiber.MIMETextHTML) == fiber.MIMETextHTML {
+		c.Set(fiber.HeaderContentType, fiber.MIMETextHTMLCharsetUTF8)
+		if m.HTML != "" {
+			return c.SendString(m.HTML)
+		}
+
+		var page bytes.Buffer
+		if err := maintenancePage.Execute(&page, message); err != nil {
+			return err
+		}
+		return c.Send(page.Bytes())
+	}
+
+	return c.JSON(maintenanceResponse{
+		StatusCode: errmsg.MaintenanceMode.StatusCode,
+		Message:    message,
+		RetryAfter: retryAfter,
+	})
+}
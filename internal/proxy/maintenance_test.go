@@ -0,0 +1,84 @@
+package proxy
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"hypervisor/internal/errmsg"
+	"hypervisor/internal/models"
+
+	"github.com/gofiber/fiber/v3"
+)
+
+func TestInMaintenance(t *testing.T) {
+	tests := []struct {
+		name      string
+		enabled   bool
+		hyperuser bool
+		want      bool
+	}{
+		{"disabled", false, false, false},
+		{"disabled for a hyperuser", false, true, false},
+		{"enabled", true, false, true},
+		{"enabled for a hyperuser", true, true, false},
+	}
+
+	for _, tt := range tests {
+		rm := NewRouteMap()
+		rm.applyMaintenance(models.Maintenance{Enabled: tt.enabled})
+		if got := rm.inMaintenance(tt.hyperuser); got != tt.want {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestServeMaintenance(t *testing.T) {
+	tests := []struct {
+		name        string
+		maintenance models.Maintenance
+		accept      string
+		retryAfter  string
+		contentType string
+		contains    string
+	}{
+		{"JSON default", models.Maintenance{Enabled: true}, "application/json", "300", fiber.MIMEApplicationJSON, errmsg.MaintenanceMode.Message},
+		{"JSON with a message", models.Maintenance{Enabled: true, Message: "Back at 10:00", RetryAfter: 60}, "", "60", fiber.MIMEApplicationJSON, "Back at 10:00"},
+		{"browser default page", models.Maintenance{Enabled: true, Message: "Back <soon>"}, "text/html,application/xhtml+xml", "300", fiber.MIMETextHTML, "<p>Back &lt;soon&gt;</p>"},
+		{"browser custom page", models.Maintenance{Enabled: true, HTML: "<h1>Custom</h1>"}, "text/html", "300", fiber.MIMETextHTML, "<h1>Custom</h1>"},
+	}
+
+	for _, tt := range tests {
+		rm := NewRouteMap()
+		rm.applyMaintenance(tt.maintenance)
+
+		c := policyCtx(t, "203.0.113.7", "")
+		if tt.accept != "" {
+			c.Request().Header.Set(fiber.HeaderAccept, tt.accept)
+		}
+		if err := rm.serveMaintenance(c); err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.name, err)
+		}
+
+		resp := c.Response()
+		if resp.StatusCode() != errmsg.MaintenanceMode.StatusCode {
+			t.Errorf("%s: expected status %d, got %d", tt.name, errmsg.MaintenanceMode.StatusCode, resp.StatusCode())
+		}
+		if got := string(resp.Header.Peek(fiber.HeaderRetryAfter)); got != tt.retryAfter {
+			t.Errorf("%s: expected Retry-After %s, got %s", tt.name, tt.retryAfter, got)
+		}
+		if got := string(resp.Header.ContentType()); !strings.HasPrefix(got, tt.contentType) {
+			t.Errorf("%s: expected content type %s, got %s", tt.name, tt.contentType, got)
+		}
+		if body := string(resp.Body()); !strings.Contains(body, tt.contains) {
+			t.Errorf("%s: expected body to contain %q, got %s", tt.name, tt.contains, body)
+		}
+
+		if tt.contentType == fiber.MIMEApplicationJSON {
+			var body maintenanceResponse
+			if err := json.Unmarshal(resp.Body(), &body); err != nil || body.StatusCode != errmsg.MaintenanceMode.StatusCode {
+				t.Errorf("%s: expected a maintenance JSON body, got %s", tt.name, resp.Body())
+			}
+		}
+	}
+}
@@ -0,0 +1,350 @@
+package proxy
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+
+	"hypervisor/internal/env"
+	"hypervisor/internal/models"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/valyala/fasthttp"
+)
+
+// MirrorHeader is set on mirrored requests to the main deployment's ID so the
+// candidate can tell shadow traffic apart from real requests.
+const MirrorHeader = "X-Hypervisor-Mirror"
+
+// maxMirrorEndpoints bounds the number of endpoints tracked per candidate; further
+// endpoints are folded into otherEndpoint.
+const maxMirrorEndpoints = 200
+
+const otherEndpoint = "(other)"
+
+// mirrorClient replays shadow traffic. It never shares connections with proxied traffic.
+var mirrorClient = &fasthttp.Client{
+	NoDefaultUserAgentHeader: true,
+	DisablePathNormalizing:   true,
+}
+
+// mirrorRequest is a copy of a request served by main, waiting to be replayed.
+type mirrorRequest struct {
+	candidate *models.Deployment
+	mainID    string
+	method    string
+	endpoint  string
+	path      string // upstream path and query, kept for diff samples
+	req       *fasthttp.Request
+
+	diff   bool // compare the candidate's response body with main's
+	ignore ignoreSet
+}
+
+// MirrorEndpointStats compares main and the candidate for one endpoint.
+type MirrorEndpointStats struct {
+	Method             string           `json:"method"`
+	Endpoint           string           `json:"endpoint"`
+	Requests           int64            `json:"requests"`
+	StatusMatches      int64            `json:"statusMatches"`
+	StatusMismatches   int64            `json:"statusMismatches"`
+	CandidateErrors    int64            `json:"candidateErrors"` // transport failures and timeouts
+	StatusPairs        map[string]int64 `json:"statusPairs"`     // "<main>-><candidate>", e.g. "200->500"
+	MainLatencyMs      float64          `json:"mainLatencyMs"`
+	CandidateLatencyMs float64          `json:"candidateLatencyMs"`
+	LatencyDeltaMs     float64          `json:"latencyDeltaMs"` // mean candidate minus main
+	MaxLatencyDeltaMs  float64          `json:"maxLatencyDeltaMs"`
+	LastMismatchAt     *time.Time       `json:"lastMismatchAt,omitempty"`
+	LastError          string           `json:"lastError,omitempty"`
+
+	mainLatency      time.Duration
+	candidateLatency time.Duration
+	compared         int64 // requests where the candidate answered
+}
+
+// MirrorReport summarises the shadow traffic replayed to a candidate deployment.
+type MirrorReport struct {
+	CandidateID string                `json:"candidateId"`
+	MainID      string                `json:"mainId,omitempty"`
+	StartedAt   time.Time             `json:"startedAt"`
+	Mirrored    int64                 `json:"mirrored"`
+	Dropped     int64                 `json:"dropped"` // skipped because MIRROR_MAX_CONCURRENCY was reached
+	Endpoints   []MirrorEndpointStats `json:"endpoints"`
+}
+
+// mirrorRun accumulates the stats for one candidate.
+type mirrorRun struct {
+	report    MirrorReport
+	endpoints map[string]*MirrorEndpointStats // "<method> <endpoint>" -> stats
+}
+
+// GetMirrorDeployment returns the deployment receiving shadow traffic, if any
+func (rm *RouteMap) GetMirrorDeployment() (*models.Deployment, bool) {
+	rm.mu.RLock()
+	defer rm.mu.RUnlock()
+
+	return rm.findByID(rm.mirrorID)
+}
+
+// sampleMirror decides whether a request served by main should also be replayed to
+// the mirror candidate and, if so, copies it before it is forwarded.
+func (rm *RouteMap) sampleMirror(c fiber.Ctx, main *models.Deployment, upstreamPath string) *mirrorRequest {
+	candidate, exists := rm.GetMirrorDeployment()
+	if !exists || candidate.Mirror == nil || candidate.Port == nil || !rm.isHealthy(candidate.ID) {
+		return nil
+	}
+
+	method := c.Method()
+	if !candidate.Mirror.IncludeUnsafe && !isIdempotent(method) {
+		return nil
+	}
+	if isWebSocketUpgrade(c) || rand.Intn(100) >= candidate.Mirror.Percent {
+		return nil
+	}
+
+	req := fasthttp.AcquireRequest()
+	c.Request().CopyTo(req)
+
+	uri := fmt.Sprintf("http://localhost:%d%s", *candidate.Port, upstreamPath)
+	if queryString := string(c.Request().URI().QueryString()); queryString != "" {
+		uri += "?" + queryString
+	}
+	req.SetRequestURI(uri)
+	req.Header.Set(MirrorHeader, main.ID)
+	// The main request was stripped already; the candidate must not see an override either way
+	req.Header.Del(OverrideHeader)
+	req.Header.Del(OverrideAuthHeader)
+	req.Header.DelCookie(OverrideCookie)
+	req.Header.DelCookie(OverrideAuthCookie)
+
+	m := &mirrorRequest{
+		candidate: candidate,
+		mainID:    main.ID,
+		method:    method,
+		endpoint:  normalizeEndpoint(upstreamPath),
+		path:      strings.TrimPrefix(uri, fmt.Sprintf("http://localhost:%d", *candidate.Port)),
+		req:       req,
+	}
+	if candidate.Mirror.Diff && method == fiber.MethodGet {
+		m.diff = true
+		m.ignore = newIgnoreSet(candidate.Mirror.IgnoreFields)
+	}
+	return m
+}
+
+// captureMain records main's side of a mirrored exchange once it has been served.
+// Bodies are only kept for diffing, and only up to MIRROR_DIFF_MAX_BODY bytes.
+func captureMain(c fiber.Ctx, m *mirrorRequest, latency time.Duration) mainResponse {
+	main := mainResponse{status: c.Response().StatusCode(), latency: latency}
+	if !m.diff {
+		return main
+	}
+
+	body, err := c.Response().BodyUncompressed()
+	if err != nil || len(body) > env.MIRROR_DIFF_MAX_BODY {
+		m.diff = false
+		return main
+	}
+	main.body = append([]byte(nil), body...)
+	return main
+}
+
+// dispatchMirror replays a sampled request against the candidate in the background
+// and compares the outcome with main's. The candidate's response is discarded.
+func (rm *RouteMap) dispatchMirror(m *mirrorRequest, main mainResponse) {
+	select {
+	case rm.mirrorSlots <- struct{}{}:
+	default:
+		fasthttp.ReleaseRequest(m.req)
+		rm.recordMirrorDropped(m.candidate.ID)
+		return
+	}
+
+	go func() {
+		defer func() { <-rm.mirrorSlots }()
+		defer fasthttp.ReleaseRequest(m.req)
+
+		resp := fasthttp.AcquireResponse()
+		defer fasthttp.ReleaseResponse(resp)
+
+		start := time.Now()
+		err := mirrorClient.DoTimeout(m.req, resp, env.MIRROR_TIMEOUT)
+		rm.recordMirror(m, main.status, main.latency, resp.StatusCode(), time.Since(start), err)
+
+		if m.diff {
+			if err != nil {
+				recordCandidateError(m, main, err)
+				return
+			}
+			body, err := resp.BodyUncompressed()
+			if err != nil {
+				recordCandidateError(m, main, fmt.Errorf("unreadable response body: %w", err))
+			} else if len(body) <= env.MIRROR_DIFF_MAX_BODY {
+				recordDiff(m, main, resp.StatusCode(), body)
+			}
+		}
+	}()
+}
+
+// mirrorRunFor returns the stats for a candidate, creating them if needed. Callers must hold rm.mirrorMu.
+func (rm *RouteMap) mirrorRunFor(candidateID string) *mirrorRun {
+	run, exists := rm.mirrorRuns[candidateID]
+	if !exists {
+		run = &mirrorRun{
+			report:    MirrorReport{CandidateID: candidateID, StartedAt: time.Now()},
+			endpoints: make(map[string]*MirrorEndpointStats),
+		}
+		rm.mirrorRuns[candidateID] = run
+	}
+	return run
+}
+
+func (rm *RouteMap) recordMirrorDropped(candidateID string) {
+	rm.mirrorMu.Lock()
+	defer rm.mirrorMu.Unlock()
+
+	rm.mirrorRunFor(candidateID).report.Dropped++
+}
+
+func (rm *RouteMap) recordMirror(m *mirrorRequest, mainStatus int, mainLatency time.Duration, candidateStatus int, candidateLatency time.Duration, err error) {
+	rm.mirrorMu.Lock()
+	defer rm.mirrorMu.Unlock()
+
+	run := rm.mirrorRunFor(m.candidate.ID)
+	run.report.MainID = m.mainID
+	run.report.Mirrored++
+
+	endpoint := m.endpoint
+	key := m.method + " " + endpoint
+	stats, exists := run.endpoints[key]
+	if !exists && len(run.endpoints) >= maxMirrorEndpoints {
+		endpoint = otherEndpoint
+		key = m.method + " " + endpoint
+		stats, exists = run.endpoints[key]
+	}
+	if !exists {
+		stats = &MirrorEndpointStats{Method: m.method, Endpoint: endpoint, StatusPairs: make(map[string]int64)}
+		run.endpoints[key] = stats
+	}
+
+	stats.Requests++
+	if err != nil {
+		stats.CandidateErrors++
+		stats.LastError = err.Error()
+		return
+	}
+
+	now := time.Now()
+	stats.StatusPairs[fmt.Sprintf("%d->%d", mainStatus, candidateStatus)]++
+	if mainStatus == candidateStatus {
+		stats.StatusMatches++
+	} else {
+		stats.StatusMismatches++
+		stats.LastMismatchAt = &now
+	}
+
+	stats.compared++
+	stats.mainLatency += mainLatency
+	stats.candidateLatency += candidateLatency
+	if delta := milliseconds(candidateLatency - mainLatency); delta > stats.MaxLatencyDeltaMs {
+		stats.MaxLatencyDeltaMs = delta
+	}
+}
+
+// GetMirrorReport returns the shadow traffic stats collected for a candidate,
+// busiest endpoints first.
+func (rm *RouteMap) GetMirrorReport(candidateID string) MirrorReport {
+	rm.mirrorMu.Lock()
+	defer rm.mirrorMu.Unlock()
+
+	run, exists := rm.mirrorRuns[candidateID]
+	if !exists {
+		return MirrorReport{CandidateID: candidateID, Endpoints: []MirrorEndpointStats{}}
+	}
+
+	report := run.report
+	report.Endpoints = make([]MirrorEndpointStats, 0, len(run.endpoints))
+	for _, stats := range run.endpoints {
+		entry := *stats
+		entry.StatusPairs = make(map[string]int64, len(stats.StatusPairs))
+		for pair, count := range stats.StatusPairs {
+			entry.StatusPairs[pair] = count
+		}
+		if entry.compared > 0 {
+			entry.MainLatencyMs = milliseconds(entry.mainLatency) / float64(entry.compared)
+			entry.CandidateLatencyMs = milliseconds(entry.candidateLatency) / float64(entry.compared)
+			entry.LatencyDeltaMs = entry.CandidateLatencyMs - entry.MainLatencyMs
+		}
+		report.Endpoints = append(report.Endpoints, entry)
+	}
+	sort.Slice(report.Endpoints, func(i, j int) bool {
+		if report.Endpoints[i].Requests != report.Endpoints[j].Requests {
+			return report.Endpoints[i].Requests > report.Endpoints[j].Requests
+		}
+		return report.Endpoints[i].Method+report.Endpoints[i].Endpoint < report.Endpoints[j].Method+report.Endpoints[j].Endpoint
+	})
+
+	return report
+}
+
+// ResetMirrorReport discards the shadow traffic stats collected for a candidate.
+func (rm *RouteMap) ResetMirrorReport(candidateID string) {
+	rm.mirrorMu.Lock()
+	defer rm.mirrorMu.Unlock()
+
+	delete(rm.mirrorRuns, candidateID)
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// isIdempotent reports whether a request can be replayed without side effects.
+func isIdempotent(method string) bool {
+	switch method {
+	case fiber.MethodGet, fiber.MethodHead, fiber.MethodOptions:
+		return true
+	}
+	return false
+}
+
+// normalizeEndpoint collapses path segments that look like identifiers so requests
+// for different resources of the same kind are grouped under one endpoint.
+func normalizeEndpoint(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if looksLikeID(segment) {
+			segments[i] = ":id"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+func looksLikeID(segment string) bool {
+	if segment == "" {
+		return false
+	}
+
+	digits, hex, other := 0, 0, 0
+	for _, r := range segment {
+		switch {
+		case r >= '0' && r <= '9':
+			digits++
+			hex++
+		case (r >= 'a' && r <= 'f') || (r >= 'A' && r <= 'F'):
+			hex++
+		case r == '-':
+		default:
+			other++
+		}
+	}
+
+	if other > 0 {
+		// Long tokens mixing digits and letters, e.g. prefixed or base62 IDs
+		return digits > 0 && len(segment) >= 20
+	}
+	// Numeric IDs, UUIDs and hex object IDs
+	return digits == len(segment) || (digits > 0 && hex >= 16)
+}
@@ -0,0 +1,49 @@
+package proxy
+
+import "testing"
+
+func TestLooksLikeID(t *testing.T) {
+	tests := []struct {
+		segment string
+		want    bool
+	}{
+		{"", false},
+		{"users", false},
+		{"v2", false},
+		{"42", true},
+		{"1234567890", true},
+		{"3f2504e0-4f89-11d3-9a0c-0305e82c3301", true},
+		{"65a1f0c2e4b0a1b2c3d4e5f6", true},
+		{"deadbeefdeadbeef", false}, // hex without digits reads as a word
+		{"abc-123", false},
+		{"order_1234567890123456789", true},
+		{"settings-page", false},
+		{"a-very-long-segment-without-digits", false},
+	}
+
+	for _, tt := range tests {
+		if got := looksLikeID(tt.segment); got != tt.want {
+			t.Errorf("looksLikeID(%q): expected %v, got %v", tt.segment, tt.want, got)
+		}
+	}
+}
+
+func TestNormalizeEndpoint(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/", "/"},
+		{"/api/users", "/api/users"},
+		{"/api/users/42", "/api/users/:id"},
+		{"/api/users/42/orders/65a1f0c2e4b0a1b2c3d4e5f6", "/api/users/:id/orders/:id"},
+		{"/api/teams/3f2504e0-4f89-11d3-9a0c-0305e82c3301/", "/api/teams/:id/"},
+		{"/v2/items", "/v2/items"},
+	}
+
+	for _, tt := range tests {
+		if got := normalizeEndpoint(tt.path); got != tt.want {
+			t.Errorf("normalizeEndpoint(%q): expected %q, got %q", tt.path, tt.want, got)
+		}
+	}
+}
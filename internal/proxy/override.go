@@ -0,0 +1,102 @@
+package proxy
+
+import (
+	"strings"
+
+	"hypervisor/internal/errmsg"
+	"hypervisor/internal/models"
+	"hypervisor/internal/utils"
+
+	"github.com/gofiber/fiber/v3"
+)
+
+const (
+	// OverrideHeader names the deployment that should serve root-path traffic for this request.
+	OverrideHeader = "X-Hypervisor-Deployment"
+	// OverrideCookie is the cookie equivalent of OverrideHeader, for browser sessions.
+	OverrideCookie = "hypervisor_deployment"
+
+	// OverrideAuthHeader carries the hyperuser token authorising an override. The regular
+	// Authorization header is left alone because it belongs to the backend.
+	OverrideAuthHeader = "X-Hypervisor-Authorization"
+	// OverrideAuthCookie is the cookie equivalent of OverrideAuthHeader.
+	OverrideAuthCookie = "hypervisor_authorization"
+)
+
+// GetDeploymentByID returns a routable deployment by its ID.
+func (rm *RouteMap) GetDeploymentByID(deploymentID string) (*models.Deployment, bool) {
+	rm.mu.RLock()
+	defer rm.mu.RUnlock()
+	return rm.findByID(deploymentID)
+}
+
+// overrideRequest is what a request asked of the proxy through the override headers
+// and cookies, read before they are stripped.
+type overrideRequest struct {
+	deploymentID string // deployment to serve root-path traffic, if any
+	hyperuser    bool   // whether a valid hyperuser token came with the request
+}
+
+// takeOverride reads the override headers and cookies and strips them, so neither
+// the requested deployment nor the hyperuser token ever reaches a backend.
+func takeOverride(c fiber.Ctx) overrideRequest {
+	override := overrideRequest{deploymentID: requestedOverride(c)}
+	_, override.hyperuser = overrideHyperUser(c)
+	stripOverride(c)
+	return override
+}
+
+// requestedOverride returns the deployment named by the override header or cookie.
+func requestedOverride(c fiber.Ctx) string {
+	if id := strings.TrimSpace(c.Get(OverrideHeader)); id != "" {
+		return id
+	}
+	return strings.TrimSpace(c.Cookies(OverrideCookie))
+}
+
+// overrideHyperUser authenticates the hyperuser token sent alongside an override.
+func overrideHyperUser(c fiber.Ctx) (*models.HyperUser, bool) {
+	token := strings.TrimSpace(c.Get(OverrideAuthHeader))
+	if token == "" {
+		token = strings.TrimSpace(c.Cookies(OverrideAuthCookie))
+	}
+	token = strings.TrimSpace(strings.TrimPrefix(token, "Bearer "))
+	if token == "" {
+		return nil, false
+	}
+
+	var hyperuser models.HyperUser
+	if err := hyperuser.ParseToken(token); err != nil {
+		return nil, false
+	}
+	return &hyperuser, true
+}
+
+// stripOverride removes the override headers and cookies so they never reach the backend.
+func stripOverride(c fiber.Ctx) {
+	c.Request().Header.Del(OverrideHeader)
+	c.Request().Header.Del(OverrideAuthHeader)
+	c.Request().Header.DelCookie(OverrideCookie)
+	c.Request().Header.DelCookie(OverrideAuthCookie)
+}
+
+// serveOverride routes a root-path request to the deployment named by the override,
+// exactly as it would be served if that deployment were main.
+func (rm *RouteMap) serveOverride(c fiber.Ctx, override overrideRequest) (*models.Deployment, error) {
+	if !override.hyperuser {
+		return nil, utils.StatusError(c, errmsg.HyperUserNoToken)
+	}
+
+	dep, exists := rm.GetDeploymentByID(override.deploymentID)
+	if !exists {
+		// Fall back to the stage ID so QA can name the stage they are testing
+		dep, exists = rm.GetDeployment(override.deploymentID)
+	}
+	if !exists || dep.Port == nil {
+		return nil, utils.StatusError(c, errmsg.OverrideDeploymentNotFound)
+	}
+
+	c.Set(OverrideHeader, dep.ID)
+
+	return dep, rm.forward(c, dep, c.Path())
+}
@@ -0,0 +1,218 @@
+package proxy
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"hypervisor/internal/errmsg"
+	"hypervisor/internal/models"
+
+	"github.com/gofiber/fiber/v3"
+)
+
+// bucketIdleTTL is how long an unused rate limit bucket is kept before it is swept.
+const bucketIdleTTL = 10 * time.Minute
+
+// compiledPolicy is an AccessPolicy with its CIDRs parsed.
+type compiledPolicy struct {
+	source *models.AccessPolicy
+	allow  []*net.IPNet
+	deny   []*net.IPNet
+}
+
+// bucketKey identifies a client's token bucket for one deployment.
+type bucketKey struct {
+	deploymentID string
+	ip           string
+}
+
+type bucket struct {
+	tokens   float64
+	lastSeen time.Time
+}
+
+// policyCache holds compiled policies and rate limit state for every deployment.
+type policyCache struct {
+	mu        sync.Mutex
+	compiled  map[string]*compiledPolicy // deploymentID -> compiled policy
+	buckets   map[bucketKey]*bucket
+	lastSweep time.Time
+}
+
+func newPolicyCache() *policyCache {
+	return &policyCache{
+		compiled: make(map[string]*compiledPolicy),
+		buckets:  make(map[bucketKey]*bucket),
+	}
+}
+
+// ParseCIDR accepts a CIDR or a bare IP address, which is treated as a single-host network.
+func ParseCIDR(value string) (*net.IPNet, error) {
+	value = strings.TrimSpace(value)
+	if !strings.Contains(value, "/") {
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IP address %q", value)
+		}
+		bits := 128
+		if ip.To4() != nil {
+			ip, bits = ip.To4(), 32
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+
+	_, network, err := net.ParseCIDR(value)
+	return network, err
+}
+
+// compile returns the parsed form of a deployment's policy, re-parsing only when
+// the policy itself has been replaced.
+func (pc *policyCache) compile(dep *models.Deployment) *compiledPolicy {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	if cached, exists := pc.compiled[dep.ID]; exists && cached.source == dep.Policy {
+		return cached
+	}
+
+	compiled := &compiledPolicy{source: dep.Policy}
+	for _, cidr := range dep.Policy.Allow {
+		if network, err := ParseCIDR(cidr); err == nil {
+			compiled.allow = append(compiled.allow, network)
+		}
+	}
+	for _, cidr := range dep.Policy.Deny {
+		if network, err := ParseCIDR(cidr); err == nil {
+			compiled.deny = append(compiled.deny, network)
+		}
+	}
+	pc.compiled[dep.ID] = compiled
+	return compiled
+}
+
+// take removes one token from the client's bucket. When the bucket is empty it
+// returns false and how long until the next token is available.
+func (pc *policyCache) take(deploymentID, ip string, limit models.RateLimit) (bool, time.Duration) {
+	now := time.Now()
+	key := bucketKey{deploymentID: deploymentID, ip: ip}
+	burst := float64(max(limit.Burst, 1))
+
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	pc.sweep(now)
+
+	b, exists := pc.buckets[key]
+	if !exists {
+		b = &bucket{tokens: burst, lastSeen: now}
+		pc.buckets[key] = b
+	}
+
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.lastSeen).Seconds()*limit.RequestsPerSecond)
+	b.lastSeen = now
+
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / limit.RequestsPerSecond * float64(time.Second))
+		return false, wait
+	}
+
+	b.tokens--
+	return true, 0
+}
+
+// sweep drops idle buckets at most once a minute. Callers must hold pc.mu.
+func (pc *policyCache) sweep(now time.Time) {
+	if now.Sub(pc.lastSweep) < time.Minute {
+		return
+	}
+	pc.lastSweep = now
+
+	for key, b := range pc.buckets {
+		if now.Sub(b.lastSeen) > bucketIdleTTL {
+			delete(pc.buckets, key)
+		}
+	}
+}
+
+// forget drops the compiled policy and rate limit state of a deployment that left routing.
+func (pc *policyCache) forget(deploymentID string) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	delete(pc.compiled, deploymentID)
+	for key := range pc.buckets {
+		if key.deploymentID == deploymentID {
+			delete(pc.buckets, key)
+		}
+	}
+}
+
+// clientIP returns the address of the client behind nginx. nginx must overwrite
+// X-Forwarded-For with the peer it saw rather than append to what the client sent;
+// only the last entry is trusted either way, and only when the request itself came
+// from a loopback address.
+func clientIP(c fiber.Ctx) net.IP {
+	remote := c.RequestCtx().RemoteIP()
+	if !remote.IsLoopback() {
+		return remote
+	}
+
+	forwarded := strings.Split(c.Get(fiber.HeaderXForwardedFor), ",")
+	if ip := net.ParseIP(strings.TrimSpace(forwarded[len(forwarded)-1])); ip != nil {
+		return ip
+	}
+	return remote
+}
+
+// enforcePolicy applies the deployment's access policy to the request. It returns
+// false after writing the rejection response when the request must not be proxied.
+// Hyperusers bypass the policy.
+func (rm *RouteMap) enforcePolicy(c fiber.Ctx, dep *models.Deployment, hyperuser bool) (bool, error) {
+	if dep.Policy == nil {
+		return true, nil
+	}
+
+	// Hyperusers are operators, not clients - let them through
+	if hyperuser {
+		return true, nil
+	}
+
+	policy := rm.policies.compile(dep)
+	ip := clientIP(c)
+
+	for _, network := range policy.deny {
+		if network.Contains(ip) {
+			return false, proxyError(c, errmsg.DeploymentForbidden, dep, fmt.Sprintf("%s matches deny rule %s", ip, network))
+		}
+	}
+
+	if len(policy.allow) > 0 {
+		allowed := false
+		for _, network := range policy.allow {
+			if network.Contains(ip) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return false, proxyError(c, errmsg.DeploymentForbidden, dep, fmt.Sprintf("%s is not in the allow list", ip))
+		}
+	}
+
+	if dep.Policy.HyperusersOnly && dep.PromotedAt == nil {
+		return false, proxyError(c, errmsg.DeploymentHyperusersOnly, dep, "")
+	}
+
+	if limit := dep.Policy.RateLimit; limit != nil && limit.RequestsPerSecond > 0 {
+		if ok, wait := rm.policies.take(dep.ID, ip.String(), *limit); !ok {
+			c.Set(fiber.HeaderRetryAfter, fmt.Sprintf("%d", int(math.Ceil(wait.Seconds()))))
+			return false, proxyError(c, errmsg.DeploymentRateLimited, dep, "")
+		}
+	}
+
+	return true, nil
+}
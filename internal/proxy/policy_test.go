@@ -0,0 +1,157 @@
+package proxy
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"hypervisor/internal/models"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/valyala/fasthttp"
+)
+
+// policyCtx builds a request context as if it came from remote with the given
+// X-Forwarded-For header.
+func policyCtx(t *testing.T, remote string, forwardedFor string) fiber.Ctx {
+	t.Helper()
+
+	var req fasthttp.Request
+	req.SetRequestURI("/")
+	if forwardedFor != "" {
+		req.Header.Set(fiber.HeaderXForwardedFor, forwardedFor)
+	}
+
+	fctx := &fasthttp.RequestCtx{}
+	fctx.Init(&req, &net.TCPAddr{IP: net.ParseIP(remote), Port: 40000}, nil)
+
+	app := fiber.New()
+	c := app.AcquireCtx(fctx)
+	t.Cleanup(func() { app.ReleaseCtx(c) })
+	return c
+}
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name         string
+		remote       string
+		forwardedFor string
+		want         string
+	}{
+		{"direct client", "203.0.113.7", "", "203.0.113.7"},
+		{"direct client spoofing the header", "203.0.113.7", "10.0.0.1", "203.0.113.7"},
+		{"nginx on loopback", "127.0.0.1", "198.51.100.4", "198.51.100.4"},
+		{"nginx on IPv6 loopback", "::1", "198.51.100.4", "198.51.100.4"},
+		{"nginx appending to a spoofed header", "127.0.0.1", "10.0.0.1, 198.51.100.4", "198.51.100.4"},
+		{"loopback without the header", "127.0.0.1", "", "127.0.0.1"},
+		{"loopback with a malformed header", "127.0.0.1", "not-an-ip", "127.0.0.1"},
+	}
+
+	for _, tt := range tests {
+		if got := clientIP(policyCtx(t, tt.remote, tt.forwardedFor)); !got.Equal(net.ParseIP(tt.want)) {
+			t.Errorf("%s: expected %s, got %s", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestEnforcePolicy(t *testing.T) {
+	promotedAt := time.Now()
+
+	tests := []struct {
+		name      string
+		policy    *models.AccessPolicy
+		promoted  bool
+		hyperuser bool
+		client    string
+		want      int
+	}{
+		{"no policy", nil, false, false, "203.0.113.7", fiber.StatusOK},
+		{"allowed", &models.AccessPolicy{Allow: []string{"203.0.113.0/24"}}, false, false, "203.0.113.7", fiber.StatusOK},
+		{"not in the allow list", &models.AccessPolicy{Allow: []string{"198.51.100.0/24"}}, false, false, "203.0.113.7", fiber.StatusForbidden},
+		{"bare IP allow entry", &models.AccessPolicy{Allow: []string{"203.0.113.7"}}, false, false, "203.0.113.7", fiber.StatusOK},
+		{"denied", &models.AccessPolicy{Deny: []string{"203.0.113.7/32"}}, false, false, "203.0.113.7", fiber.StatusForbidden},
+		{"deny wins over allow", &models.AccessPolicy{Allow: []string{"203.0.113.0/24"}, Deny: []string{"203.0.113.7"}}, false, false, "203.0.113.7", fiber.StatusForbidden},
+		{"deny of another client", &models.AccessPolicy{Allow: []string{"203.0.113.0/24"}, Deny: []string{"203.0.113.8"}}, false, false, "203.0.113.7", fiber.StatusOK},
+		{"hyperusers only", &models.AccessPolicy{HyperusersOnly: true}, false, false, "203.0.113.7", fiber.StatusUnauthorized},
+		{"hyperusers only when promoted", &models.AccessPolicy{HyperusersOnly: true}, true, false, "203.0.113.7", fiber.StatusOK},
+		{"hyperuser", &models.AccessPolicy{HyperusersOnly: true}, false, true, "203.0.113.7", fiber.StatusOK},
+		{"hyperuser bypasses deny", &models.AccessPolicy{Deny: []string{"203.0.113.7"}}, false, true, "203.0.113.7", fiber.StatusOK},
+		{"denied before hyperusers only", &models.AccessPolicy{Deny: []string{"203.0.113.7"}, HyperusersOnly: true}, false, false, "203.0.113.7", fiber.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		dep := &models.Deployment{ID: "dep", Policy: tt.policy}
+		if tt.promoted {
+			dep.PromotedAt = &promotedAt
+		}
+
+		rm := NewRouteMap()
+		c := policyCtx(t, tt.client, "")
+		ok, err := rm.enforcePolicy(c, dep, tt.hyperuser)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.name, err)
+		}
+
+		got := fiber.StatusOK
+		if !ok {
+			got = c.Response().StatusCode()
+		}
+		if got != tt.want {
+			t.Errorf("%s: expected %d, got %d", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestTakeRefillsBucket(t *testing.T) {
+	pc := newPolicyCache()
+	limit := models.RateLimit{RequestsPerSecond: 2, Burst: 3}
+
+	for i := 0; i < 3; i++ {
+		if ok, _ := pc.take("dep", "203.0.113.7", limit); !ok {
+			t.Fatalf("Expected request %d within the burst to pass", i+1)
+		}
+	}
+
+	ok, wait := pc.take("dep", "203.0.113.7", limit)
+	if ok {
+		t.Fatalf("Expected the request after the burst to be limited")
+	}
+	if wait <= 0 || wait > 500*time.Millisecond {
+		t.Errorf("Expected to wait up to 500ms for the next token, got %v", wait)
+	}
+
+	if ok, _ := pc.take("dep", "203.0.113.8", limit); !ok {
+		t.Errorf("Expected another client to have its own bucket")
+	}
+	if ok, _ := pc.take("other", "203.0.113.7", limit); !ok {
+		t.Errorf("Expected another deployment to have its own bucket")
+	}
+
+	tests := []struct {
+		name    string
+		elapsed time.Duration
+		allowed int
+	}{
+		{"half a token", 250 * time.Millisecond, 0},
+		{"one token", 500 * time.Millisecond, 1},
+		{"two tokens", time.Second, 2},
+		{"refill capped at the burst", time.Minute, 3},
+	}
+
+	key := bucketKey{deploymentID: "dep", ip: "203.0.113.7"}
+	for _, tt := range tests {
+		pc.buckets[key].tokens = 0
+		pc.buckets[key].lastSeen = time.Now().Add(-tt.elapsed)
+
+		allowed := 0
+		for {
+			if ok, _ := pc.take("dep", "203.0.113.7", limit); !ok {
+				break
+			}
+			allowed++
+		}
+		if allowed != tt.allowed {
+			t.Errorf("%s: expected %d requests allowed, got %d", tt.name, tt.allowed, allowed)
+		}
+	}
+}
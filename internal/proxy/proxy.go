@@ -3,11 +3,17 @@ package proxy
 import (
 	"context"
 	"fmt"
+	"log"
 	"strings"
 	"sync"
 	"time"
 
+	"hypervisor/internal/accesslog"
+	"hypervisor/internal/env"
+	"hypervisor/internal/errmsg"
+	"hypervisor/internal/metrics"
 	"hypervisor/internal/models"
+	"hypervisor/internal/utils"
 
 	"github.com/gofiber/fiber/v3"
 	"github.com/gofiber/fiber/v3/middleware/proxy"
@@ -18,40 +24,119 @@ type RouteMap struct {
 	mu          sync.RWMutex
 	deployments map[string]*models.Deployment // stageID -> deployment
 	mainID      string                        // ID of main deployment
+	canaryID    string                        // ID of canary deployment sharing root traffic
+	mirrorID    string                        // ID of deployment receiving shadow traffic from main
+	changedAt   time.Time                     // time of the last route map change
+	changedBy   string                        // actor responsible for the last route map change
+	maintenance models.Maintenance            // maintenance mode settings for root traffic
+
+	inflightMu sync.Mutex
+	inflight   map[string]int64 // deploymentID -> requests currently being proxied
+
+	healthMu sync.RWMutex
+	health   map[string]*models.DeploymentHealth // deploymentID -> last observed health
+
+	hooksMu     sync.RWMutex
+	healthHooks []HealthHook // called on health transitions
+
+	socketsMu sync.Mutex
+	sockets   map[string]map[*socketPair]struct{} // deploymentID -> proxied WebSocket sessions
+
+	mirrorMu    sync.Mutex
+	mirrorRuns  map[string]*mirrorRun // candidate deploymentID -> shadow traffic stats
+	mirrorSlots chan struct{}         // bounds concurrent mirrored requests
+
+	policies *policyCache // compiled access policies and rate limit buckets
+	clients  *clientCache // per-deployment upstream HTTP clients
 }
 
 // NewRouteMap creates a new route map
 func NewRouteMap() *RouteMap {
 	return &RouteMap{
 		deployments: make(map[string]*models.Deployment),
+		inflight:    make(map[string]int64),
+		health:      make(map[string]*models.DeploymentHealth),
+		sockets:     make(map[string]map[*socketPair]struct{}),
+		mirrorRuns:  make(map[string]*mirrorRun),
+		mirrorSlots: make(chan struct{}, env.MIRROR_MAX_CONCURRENCY),
+		policies:    newPolicyCache(),
+		clients:     newClientCache(),
 	}
 }
 
-// UpdateDeployment updates or adds a deployment to the route map
+// UpdateDeployment updates or adds a deployment to the route map and
+// broadcasts the change to the other hypervisor instances
 func (rm *RouteMap) UpdateDeployment(dep *models.Deployment) {
+	rm.applyUpdate(dep)
+	rm.publish(routeMessage{Kind: routeMessageUpdate, Deployment: dep})
+}
+
+// applyUpdate updates or adds a deployment to this instance's route map
+func (rm *RouteMap) applyUpdate(dep *models.Deployment) {
 	rm.mu.Lock()
 	defer rm.mu.Unlock()
 
-	if dep.Status == models.DeploymentStatusReady {
+	// A stage routes to one revision at a time. Once a newer revision holds the
+	// stage's route, updates to the revision it replaced no longer affect routing.
+	current, exists := rm.deployments[dep.StageID]
+	routed := exists && current.ID == dep.ID
+	retired := exists && !routed && current.Revision > dep.Revision
+	ready := dep.Status == models.DeploymentStatusReady && !retired
+
+	if ready {
 		rm.deployments[dep.StageID] = dep
 	} else {
-		delete(rm.deployments, dep.StageID)
+		if routed {
+			delete(rm.deployments, dep.StageID)
+		}
+		rm.forgetHealth(dep.ID)
+		rm.policies.forget(dep.ID)
+		rm.clients.forget(dep.ID)
+		// A draining deployment keeps serving the requests and WebSockets it has until
+		// the drain ends and it is stopped, which removes it for good
+		if dep.Status != models.DeploymentStatusDraining {
+			metrics.Proxy.Forget(dep.ID)
+			go rm.closeSockets(dep.ID)
+		}
 	}
 
 	// Check if this is the main deployment
-	if dep.PromotedAt != nil {
+	if dep.PromotedAt != nil && !retired {
 		rm.mainID = dep.ID
 	} else if rm.mainID == dep.ID {
 		// This was the main deployment but is no longer promoted
 		rm.mainID = ""
 	}
 
+	// Check if this is the canary deployment
+	if ready && dep.PromotedAt == nil && dep.CanaryWeight > 0 {
+		rm.canaryID = dep.ID
+	} else if rm.canaryID == dep.ID {
+		rm.canaryID = ""
+	}
+
+	// Check if this is the mirror candidate
+	if ready && dep.PromotedAt == nil && dep.Mirror != nil && dep.Mirror.Percent > 0 {
+		rm.mirrorID = dep.ID
+	} else if rm.mirrorID == dep.ID {
+		rm.mirrorID = ""
+	}
+
+	rm.recordChange(dep.UpdatedAt, dep.UpdatedBy)
+
 	// Print updated routing map for monitoring
 	rm.printRoutingMap()
 }
 
-// RemoveDeployment removes a deployment from the route map
-func (rm *RouteMap) RemoveDeployment(deploymentID string) {
+// RemoveDeployment removes a deployment from the route map and
+// broadcasts the change to the other hypervisor instances
+func (rm *RouteMap) RemoveDeployment(deploymentID string, actor string) {
+	rm.applyRemove(deploymentID, actor)
+	rm.publish(routeMessage{Kind: routeMessageRemove, DeploymentID: deploymentID, Actor: actor})
+}
+
+// applyRemove removes a deployment from this instance's route map
+func (rm *RouteMap) applyRemove(deploymentID string, actor string) {
 	rm.mu.Lock()
 	defer rm.mu.Unlock()
 
@@ -66,6 +151,23 @@ func (rm *RouteMap) RemoveDeployment(deploymentID string) {
 		rm.mainID = ""
 	}
 
+	if rm.canaryID == deploymentID {
+		rm.canaryID = ""
+	}
+
+	if rm.mirrorID == deploymentID {
+		rm.mirrorID = ""
+	}
+
+	rm.forgetHealth(deploymentID)
+	rm.policies.forget(deploymentID)
+	rm.clients.forget(deploymentID)
+	metrics.Proxy.Forget(deploymentID)
+	go rm.closeSockets(deploymentID)
+
+	now := time.Now()
+	rm.recordChange(&now, actor)
+
 	// Print updated routing map for monitoring
 	rm.printRoutingMap()
 }
@@ -83,12 +185,25 @@ func (rm *RouteMap) GetMainDeployment() (*models.Deployment, bool) {
 	rm.mu.RLock()
 	defer rm.mu.RUnlock()
 
-	if rm.mainID == "" {
+	return rm.findByID(rm.mainID)
+}
+
+// GetCanaryDeployment returns the canary deployment, if one is configured
+func (rm *RouteMap) GetCanaryDeployment() (*models.Deployment, bool) {
+	rm.mu.RLock()
+	defer rm.mu.RUnlock()
+
+	return rm.findByID(rm.canaryID)
+}
+
+// findByID looks up a routable deployment by ID. Callers must hold rm.mu.
+func (rm *RouteMap) findByID(deploymentID string) (*models.Deployment, bool) {
+	if deploymentID == "" {
 		return nil, false
 	}
 
 	for _, dep := range rm.deployments {
-		if dep.ID == rm.mainID {
+		if dep.ID == deploymentID {
 			return dep, true
 		}
 	}
@@ -96,135 +211,177 @@ func (rm *RouteMap) GetMainDeployment() (*models.Deployment, bool) {
 	return nil, false
 }
 
-// printRoutingMap prints the current routing configuration for debugging/monitoring
+// recordChange notes when and by whom the route map last changed. Changes older
+// than the current one are ignored, so replayed or reloaded state cannot rewind it.
+// Callers must hold rm.mu.
+func (rm *RouteMap) recordChange(at *time.Time, actor string) {
+	if at == nil || !at.After(rm.changedAt) {
+		return
+	}
+	rm.changedAt = *at
+	rm.changedBy = actor
+}
+
+// printRoutingMap prints the current routing configuration for debugging/monitoring.
+// Callers must hold rm.mu.
 func (rm *RouteMap) printRoutingMap() {
-	fmt.Println("=== Routing Map ===")
+	fmt.Println(formatSnapshot(rm.snapshotLocked()))
+}
 
-	if rm.mainID != "" {
-		if mainDep, exists := rm.deployments[rm.mainID]; exists && mainDep.Port != nil {
-			fmt.Printf("Main (/): %s (stage: %s) -> localhost:%d\n", rm.mainID, mainDep.StageID, *mainDep.Port)
-		} else {
-			fmt.Printf("Main (/): %s (no port assigned)\n", rm.mainID)
+// GetRoutingMap returns the current routing configuration as a formatted string
+func (rm *RouteMap) GetRoutingMap() string {
+	return formatSnapshot(rm.Snapshot())
+}
+
+// SetupRoutes sets up the proxy routes on the Fiber app
+func (rm *RouteMap) SetupRoutes(app *fiber.App) {
+	// Single middleware that handles all proxy routing
+	app.Use("/*", func(c fiber.Ctx) error {
+		ensureRequestID(c)
+		resolution := rm.Resolve(c.Hostname(), c.Path())
+
+		// Skip API routes
+		if resolution.Kind == RouteKindAPI {
+			return c.Next()
 		}
-	} else {
-		fmt.Println("Main (/): none")
-	}
 
-	if len(rm.deployments) > 0 {
-		fmt.Println("Stages:")
-		for stageID, dep := range rm.deployments {
-			if dep.Port != nil {
-				fmt.Printf("  /%s/* -> localhost:%d\n", stageID, *dep.Port)
-			} else {
-				fmt.Printf("  /%s/* -> no port assigned\n", stageID)
-			}
+		setForwardedHeaders(c)
+		override := takeOverride(c)
+		entry := newAccessEntry(c, time.Now())
+		dep, route, err := rm.serve(c, resolution, override)
+		observe(c, entry, dep, route)
+		return err
+	})
+}
+
+// serve proxies a request according to its resolution and reports which deployment
+// (if any) handled it and through which kind of route.
+func (rm *RouteMap) serve(c fiber.Ctx, resolution Resolution, override overrideRequest) (*models.Deployment, RouteKind, error) {
+	switch resolution.Kind {
+	case RouteKindHost, RouteKindStage:
+		// Stage subdomains, custom hostnames and /<stageId> prefixes
+		dep := resolution.target
+		if ok, err := rm.enforcePolicy(c, dep, override.hyperuser); !ok {
+			return dep, resolution.Kind, err
 		}
-	} else {
-		fmt.Println("Stages: none")
+		if !rm.isHealthy(dep.ID) {
+			return dep, resolution.Kind, proxyError(c, errmsg.DeploymentUnavailable, dep, rm.GetHealth(dep.ID).LastError)
+		}
+
+		return dep, resolution.Kind, rm.forward(c, dep, resolution.UpstreamPath)
 	}
 
-	fmt.Println("==================")
-}
+	// Hyperusers can pin root-path traffic to any ready deployment
+	if override.deploymentID != "" {
+		dep, err := rm.serveOverride(c, override)
+		return dep, RouteKindOverride, err
+	}
 
-// GetRoutingMap returns the current routing configuration as a formatted string
-func (rm *RouteMap) GetRoutingMap() string {
-	rm.mu.RLock()
-	defer rm.mu.RUnlock()
+	// Maintenance mode holds back root traffic, except for hyperusers
+	if rm.inMaintenance(override.hyperuser) {
+		return nil, RouteKindMaintenance, rm.serveMaintenance(c)
+	}
 
-	result := "=== Routing Map ===\n"
+	// Check for main deployment (root path), splitting traffic with the canary if one is set
+	if rootDep := resolution.choose(rm); rootDep != nil && rootDep.Port != nil {
+		if ok, err := rm.enforcePolicy(c, rootDep, override.hyperuser); !ok {
+			return rootDep, RouteKindMain, err
+		}
+		if !rm.isHealthy(rootDep.ID) {
+			return rootDep, RouteKindMain, proxyError(c, errmsg.DeploymentUnavailable, rootDep, rm.GetHealth(rootDep.ID).LastError)
+		}
 
-	if rm.mainID != "" {
-		if mainDep, exists := rm.deployments[rm.mainID]; exists && mainDep.Port != nil {
-			result += fmt.Sprintf("Main (/): %s (stage: %s) -> localhost:%d\n", rm.mainID, mainDep.StageID, *mainDep.Port)
-		} else {
-			result += fmt.Sprintf("Main (/): %s (no port assigned)\n", rm.mainID)
+		// Replay a sample of main's traffic to the mirror candidate, if one is set
+		var mirror *mirrorRequest
+		if resolution.target != nil && rootDep.ID == resolution.target.ID {
+			mirror = rm.sampleMirror(c, rootDep, resolution.UpstreamPath)
 		}
-	} else {
-		result += "Main (/): none\n"
-	}
 
-	if len(rm.deployments) > 0 {
-		result += "Stages:\n"
-		for stageID, dep := range rm.deployments {
-			if dep.Port != nil {
-				result += fmt.Sprintf("  /%s/* -> localhost:%d\n", stageID, *dep.Port)
-			} else {
-				result += fmt.Sprintf("  /%s/* -> no port assigned\n", stageID)
-			}
+		start := time.Now()
+		err := rm.forward(c, rootDep, resolution.UpstreamPath)
+		if mirror != nil {
+			rm.dispatchMirror(mirror, captureMain(c, mirror, time.Since(start)))
 		}
-	} else {
-		result += "Stages: none\n"
+		return rootDep, RouteKindMain, err
 	}
 
-	result += "=================="
-	return result
+	// No deployment found - return error directly
+	return nil, RouteKindNone, utils.StatusError(c, errmsg.NoDeploymentFound)
 }
 
-// SetupRoutes sets up the proxy routes on the Fiber app
-func (rm *RouteMap) SetupRoutes(app *fiber.App) {
-	// Single middleware that handles all proxy routing
-	app.Use("/*", func(c fiber.Ctx) error {
-		path := c.Path()
+// newAccessEntry captures the request as the client sent it, before forwarding
+// rewrites its URI for the backend. Fiber's strings point into the request
+// buffer, so they are copied.
+func newAccessEntry(c fiber.Ctx, start time.Time) accesslog.Entry {
+	return accesslog.Entry{
+		Time:      start,
+		RequestID: strings.Clone(RequestID(c)),
+		Method:    strings.Clone(c.Method()),
+		Host:      strings.Clone(c.Hostname()),
+		Path:      strings.Clone(c.OriginalURL()),
+		ClientIP:  clientIP(c).String(),
+	}
+}
 
-		// Skip API routes
-		if len(path) >= 11 && path[:11] == "/hypervisor" {
-			return c.Next()
+// observe completes a request's access log entry and records it in the proxy
+// metrics and the access log. WebSocket upgrades are counted once, when the
+// handshake completes.
+func observe(c fiber.Ctx, entry accesslog.Entry, dep *models.Deployment, route RouteKind) {
+	latency := time.Since(entry.Time)
+	entry.Status = c.Response().StatusCode()
+	entry.LatencyMs = milliseconds(latency)
+	entry.BytesIn = len(c.Request().Body())
+	entry.BytesOut = len(c.Response().Body())
+	entry.Route = string(route)
+	entry.Fault = string(c.Response().Header.Peek(FaultHeader))
+	if dep != nil {
+		entry.DeploymentID, entry.StageID = dep.ID, dep.StageID
+		if dep.Port != nil {
+			entry.UpstreamPort = *dep.Port
 		}
+	}
 
-		// Check if this looks like a stage request (has segments)
-		pathParts := strings.Split(strings.Trim(path, "/"), "/")
-		if len(pathParts) > 0 && pathParts[0] != "" {
-			stageID := pathParts[0]
+	metrics.Proxy.ObserveRequest(entry.DeploymentID, entry.StageID, entry.Route, entry.Status,
+		latency, entry.BytesIn, entry.BytesOut)
+	accesslog.Default.Write(entry)
+}
 
-			// Skip API routes that might match
-			if stageID == "hypervisor" || stageID == "ws" {
-				return c.Next()
-			}
+// forward proxies the request to the deployment's backend, counting it as in-flight
+// for the duration so the deployment can be drained before it is stopped.
+func (rm *RouteMap) forward(c fiber.Ctx, dep *models.Deployment, upstreamPath string) error {
+	if isWebSocketUpgrade(c) {
+		return rm.forwardWebSocket(c, dep, upstreamPath)
+	}
 
-			// Check if this stage exists
-			if dep, exists := rm.GetDeployment(stageID); exists && dep.Port != nil {
-				target := fmt.Sprintf("http://localhost:%d", *dep.Port)
+	if ok, err := injectFault(c, dep, upstreamPath); !ok {
+		return err
+	}
 
-				// Remove stage prefix from path
-				remainingPath := "/"
-				stagePrefix := "/" + stageID
-				if len(path) > len(stagePrefix) && path[len(stagePrefix)] == '/' {
-					remainingPath = path[len(stagePrefix):]
-				}
+	client, settings := rm.clients.get(dep)
+	if size := len(c.Request().Body()); size > settings.maxBody {
+		return proxyError(c, errmsg.DeploymentBodyTooLarge, dep, fmt.Sprintf("body is %d bytes, limit is %d", size, settings.maxBody))
+	}
 
-				finalURL := target + remainingPath
-				// Append query string if present
-				if queryString := string(c.Request().URI().QueryString()); queryString != "" {
-					finalURL += "?" + queryString
-				}
-				err := proxy.Do(c, finalURL)
-				if err != nil {
-					// Backend service is unreachable - return our custom error
-					return c.Status(404).SendString(`{"message":"no deployment found for this request - check that a deployment exists and is promoted to main"}`)
-				}
-				return nil
-			}
-		}
+	rm.beginRequest(dep.ID)
+	defer rm.endRequest(dep.ID)
 
-		// Check for main deployment (root path)
-		if mainDep, exists := rm.GetMainDeployment(); exists && mainDep.Port != nil {
-			target := fmt.Sprintf("http://localhost:%d", *mainDep.Port)
-			finalURL := target + path
-			// Append query string if present
-			if queryString := string(c.Request().URI().QueryString()); queryString != "" {
-				finalURL += "?" + queryString
-			}
-			err := proxy.Do(c, finalURL)
-			if err != nil {
-				// Backend service is unreachable - return our custom error
-				return c.Status(404).SendString(`{"message":"no deployment found for this request - check that a deployment exists and is promoted to main"}`)
-			}
-			return nil
+	finalURL := fmt.Sprintf("http://localhost:%d%s", *dep.Port, upstreamPath)
+	// Append query string if present
+	if queryString := string(c.Request().URI().QueryString()); queryString != "" {
+		finalURL += "?" + queryString
+	}
+
+	if err := proxy.Do(c, finalURL, client); err != nil {
+		// A slow endpoint is not an unhealthy backend
+		if isUpstreamTimeout(err) {
+			return proxyError(c, errmsg.DeploymentGatewayTimeout, dep, err.Error())
 		}
 
-		// No deployment found - return error directly
-		return c.Status(404).SendString(`{"message":"no deployment found for this request - check that a deployment exists and is promoted to main"}`)
-	})
+		// Backend service is unreachable - count it against the deployment's health
+		rm.recordHealth(dep, err)
+		return proxyError(c, errmsg.DeploymentBadGateway, dep, err.Error())
+	}
+	return nil
 }
 
 // LoadFromDatabase loads all current deployments from the database
@@ -234,31 +391,80 @@ func (rm *RouteMap) LoadFromDatabase(ctx context.Context) error {
 		return fmt.Errorf("failed to load deployments: %w", err)
 	}
 
+	maintenance, err := models.GetMaintenance(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load maintenance settings: %w", err)
+	}
+
 	rm.mu.Lock()
 	defer rm.mu.Unlock()
 
+	rm.rebuild(deployments)
+	rm.maintenance = *maintenance
+	rm.recordChange(&maintenance.UpdatedAt, maintenance.UpdatedBy)
+
+	// Print initial routing map on startup
+	rm.printRoutingMap()
+	return nil
+}
+
+// rebuild replaces the routes with the given deployments. Like applyUpdate, a
+// revision that a newer ready revision of its stage has replaced no longer routes,
+// nor stays main, canary or mirror candidate. rm.mu must be held.
+func (rm *RouteMap) rebuild(deployments []models.Deployment) {
+	previous := rm.deployments
 	rm.deployments = make(map[string]*models.Deployment)
 	rm.mainID = ""
-
-	for _, dep := range deployments {
-		dep := dep // copy
-		if dep.Status == models.DeploymentStatusReady {
-			rm.deployments[dep.StageID] = &dep
+	rm.canaryID = ""
+	rm.mirrorID = ""
+
+	// While a stage's revisions hand over, the newest ready one takes its route
+	for i := range deployments {
+		dep := &deployments[i]
+		if dep.Status != models.DeploymentStatusReady {
+			continue
+		}
+		if current, exists := rm.deployments[dep.StageID]; exists && current.Revision > dep.Revision {
+			continue
 		}
-		if dep.PromotedAt != nil {
+		rm.deployments[dep.StageID] = dep
+	}
+
+	for i := range deployments {
+		dep := &deployments[i]
+		current, exists := rm.deployments[dep.StageID]
+		routed := exists && current.ID == dep.ID
+		retired := exists && !routed && current.Revision > dep.Revision
+
+		if dep.PromotedAt != nil && !retired {
 			rm.mainID = dep.ID
 		}
+		if routed && dep.PromotedAt == nil && dep.CanaryWeight > 0 {
+			rm.canaryID = dep.ID
+		}
+		if routed && dep.PromotedAt == nil && dep.Mirror != nil && dep.Mirror.Percent > 0 {
+			rm.mirrorID = dep.ID
+		}
+		rm.recordChange(dep.UpdatedAt, dep.UpdatedBy)
 	}
 
-	// Print initial routing map on startup
-	rm.printRoutingMap()
-	return nil
+	// Deployments the reload dropped from routing lose their metrics series
+	for stageID, dep := range previous {
+		if current, exists := rm.deployments[stageID]; !exists || current.ID != dep.ID {
+			metrics.Proxy.Forget(dep.ID)
+		}
+	}
 }
 
-// StartWatcher starts a goroutine that watches for deployment changes
+// StartWatcher starts a goroutine that periodically reloads the route map from the
+// database, as a fallback for route changes missed on the pub/sub channel
 func (rm *RouteMap) StartWatcher(ctx context.Context) {
+	if env.ROUTE_RELOAD_INTERVAL <= 0 {
+		return
+	}
+
 	go func() {
-		ticker := time.NewTicker(30 * time.Second) // Poll every 30 seconds
+		ticker := time.NewTicker(env.ROUTE_RELOAD_INTERVAL)
 		defer ticker.Stop()
 
 		for {
@@ -267,7 +473,7 @@ func (rm *RouteMap) StartWatcher(ctx context.Context) {
 				return
 			case <-ticker.C:
 				if err := rm.LoadFromDatabase(ctx); err != nil {
-					// Error updating route map - could add proper error handling here
+					log.Printf("periodic route map reload failed: %v", err)
 				}
 			}
 		}
@@ -285,6 +491,9 @@ func InitProxy(ctx context.Context) error {
 		return err
 	}
 
-	// GlobalRouteMap.StartWatcher(ctx) // TODO: implement signal-based updates from deployments
+	GlobalRouteMap.StartHealthChecker(ctx)
+	GlobalRouteMap.StartSubscriber(ctx)
+	GlobalRouteMap.StartWatcher(ctx)
+	GlobalRouteMap.StartInFlightReporter(ctx)
 	return nil
 }
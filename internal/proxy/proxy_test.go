@@ -0,0 +1,116 @@
+package proxy
+
+import (
+	"testing"
+	"time"
+
+	"hypervisor/internal/models"
+)
+
+func TestRebuild(t *testing.T) {
+	promoted := time.Now()
+	mirror := &models.MirrorConfig{Percent: 10}
+
+	tests := []struct {
+		name        string
+		deployments []models.Deployment
+		routes      map[string]string
+		main        string
+		canary      string
+		mirror      string
+	}{
+		{
+			name: "single main",
+			deployments: []models.Deployment{
+				{ID: "a-r1", StageID: "a", Revision: 1, Status: models.DeploymentStatusReady, PromotedAt: &promoted},
+			},
+			routes: map[string]string{"a": "a-r1"},
+			main:   "a-r1",
+		},
+		{
+			name: "newer revision routes the stage in either order",
+			deployments: []models.Deployment{
+				{ID: "a-r2", StageID: "a", Revision: 2, Status: models.DeploymentStatusReady},
+				{ID: "a-r1", StageID: "a", Revision: 1, Status: models.DeploymentStatusReady},
+			},
+			routes: map[string]string{"a": "a-r2"},
+		},
+		{
+			name: "retired revision does not stay main",
+			deployments: []models.Deployment{
+				{ID: "a-r1", StageID: "a", Revision: 1, Status: models.DeploymentStatusReady, PromotedAt: &promoted},
+				{ID: "a-r2", StageID: "a", Revision: 2, Status: models.DeploymentStatusReady},
+			},
+			routes: map[string]string{"a": "a-r2"},
+		},
+		{
+			name: "retired revision is not canary or mirror candidate",
+			deployments: []models.Deployment{
+				{ID: "a-r1", StageID: "a", Revision: 1, Status: models.DeploymentStatusReady, CanaryWeight: 20, Mirror: mirror},
+				{ID: "a-r2", StageID: "a", Revision: 2, Status: models.DeploymentStatusReady},
+			},
+			routes: map[string]string{"a": "a-r2"},
+		},
+		{
+			name: "routed revision is canary and mirror candidate",
+			deployments: []models.Deployment{
+				{ID: "a-r1", StageID: "a", Revision: 1, Status: models.DeploymentStatusReady, PromotedAt: &promoted},
+				{ID: "b-r1", StageID: "b", Revision: 1, Status: models.DeploymentStatusReady, CanaryWeight: 20, Mirror: mirror},
+			},
+			routes: map[string]string{"a": "a-r1", "b": "b-r1"},
+			main:   "a-r1",
+			canary: "b-r1",
+			mirror: "b-r1",
+		},
+		{
+			name: "promoted revision not ready stays main",
+			deployments: []models.Deployment{
+				{ID: "a-r1", StageID: "a", Revision: 1, Status: models.DeploymentStatusStopped, PromotedAt: &promoted},
+			},
+			routes: map[string]string{},
+			main:   "a-r1",
+		},
+		{
+			name: "draining previous revision does not take main back",
+			deployments: []models.Deployment{
+				{ID: "a-r1", StageID: "a", Revision: 1, Status: models.DeploymentStatusDraining, PromotedAt: &promoted},
+				{ID: "a-r2", StageID: "a", Revision: 2, Status: models.DeploymentStatusReady, PromotedAt: &promoted},
+			},
+			routes: map[string]string{"a": "a-r2"},
+			main:   "a-r2",
+		},
+		{
+			name: "unready newer revision leaves the older one routed",
+			deployments: []models.Deployment{
+				{ID: "a-r1", StageID: "a", Revision: 1, Status: models.DeploymentStatusReady, PromotedAt: &promoted},
+				{ID: "a-r2", StageID: "a", Revision: 2, Status: models.DeploymentStatusProvisioning},
+			},
+			routes: map[string]string{"a": "a-r1"},
+			main:   "a-r1",
+		},
+	}
+
+	for _, tt := range tests {
+		rm := NewRouteMap()
+		rm.mainID = "stale"
+		rm.rebuild(tt.deployments)
+
+		if len(rm.deployments) != len(tt.routes) {
+			t.Errorf("%s: expected %d routes, got %d", tt.name, len(tt.routes), len(rm.deployments))
+		}
+		for stageID, want := range tt.routes {
+			if got, exists := rm.deployments[stageID]; !exists || got.ID != want {
+				t.Errorf("%s: expected stage %s routed to %s, got %v", tt.name, stageID, want, got)
+			}
+		}
+		if rm.mainID != tt.main {
+			t.Errorf("%s: expected main %q, got %q", tt.name, tt.main, rm.mainID)
+		}
+		if rm.canaryID != tt.canary {
+			t.Errorf("%s: expected canary %q, got %q", tt.name, tt.canary, rm.canaryID)
+		}
+		if rm.mirrorID != tt.mirror {
+			t.Errorf("%s: expected mirror candidate %q, got %q", tt.name, tt.mirror, rm.mirrorID)
+		}
+	}
+}
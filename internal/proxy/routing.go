@@ -0,0 +1,299 @@
+package proxy
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+
+	"hypervisor/internal/env"
+	"hypervisor/internal/models"
+)
+
+type RouteKind string
+
+const (
+	RouteKindAPI   RouteKind = "api"   // served by the hypervisor itself
+	RouteKindHost  RouteKind = "host"  // matched by Host header, no path rewrite
+	RouteKindStage RouteKind = "stage" // matched by /<stageId> prefix, prefix stripped
+	RouteKindMain  RouteKind = "main"  // root path, main deployment (and canary share)
+	RouteKindNone  RouteKind = "none"  // nothing can serve the request
+
+	// RouteKindOverride is never returned by Resolve; it labels root requests
+	// pinned to a deployment by a hyperuser override.
+	RouteKindOverride RouteKind = "override"
+
+	// RouteKindMaintenance is never returned by Resolve; it labels root requests
+	// answered with the maintenance response.
+	RouteKindMaintenance RouteKind = "maintenance"
+)
+
+// RouteEntry describes one routable deployment in the route map.
+type RouteEntry struct {
+	DeploymentID string                  `json:"deploymentId"`
+	StageID      string                  `json:"stageId"`
+	Prefix       string                  `json:"prefix"`
+	Hostnames    []string                `json:"hostnames,omitempty"`
+	Port         *int                    `json:"port,omitempty"`
+	Status       models.DeploymentStatus `json:"status"`
+	Health       models.DeploymentHealth `json:"health"`
+	Weight       int                     `json:"weight"`           // percent of root traffic; 0 if the deployment only serves its stage
+	Mirror       int                     `json:"mirror,omitempty"` // percent of main's traffic replayed to it as shadow traffic
+	InFlight     int64                   `json:"inFlight"`
+	Policy       *models.AccessPolicy    `json:"policy,omitempty"`
+	Proxy        *models.ProxySettings   `json:"proxy,omitempty"`
+	Faults       []models.FaultRule      `json:"faults,omitempty"` // active rules only
+	UpdatedAt    *time.Time              `json:"updatedAt,omitempty"`
+	UpdatedBy    string                  `json:"updatedBy,omitempty"`
+}
+
+// RouteSnapshot is a point-in-time, machine-readable copy of the route map.
+type RouteSnapshot struct {
+	Main        *RouteEntry  `json:"main"`
+	Canary      *RouteEntry  `json:"canary,omitempty"`
+	Mirror      *RouteEntry  `json:"mirror,omitempty"`
+	Stages      []RouteEntry `json:"stages"`
+	BaseDomain  string       `json:"baseDomain,omitempty"`
+	Maintenance bool         `json:"maintenance"` // root traffic gets the maintenance response
+	ChangedAt   *time.Time   `json:"changedAt,omitempty"`
+	ChangedBy   string       `json:"changedBy,omitempty"`
+}
+
+// Resolution explains which deployment a request would be routed to and why.
+type Resolution struct {
+	Kind         RouteKind   `json:"kind"`
+	Reason       string      `json:"reason"`
+	UpstreamPath string      `json:"upstreamPath,omitempty"`
+	Target       *RouteEntry `json:"target,omitempty"`
+	Canary       *RouteEntry `json:"canary,omitempty"` // root only; receives Canary.Weight percent of requests
+
+	target *models.Deployment
+	canary *models.Deployment
+}
+
+// Snapshot returns a copy of the route map suitable for JSON encoding.
+func (rm *RouteMap) Snapshot() RouteSnapshot {
+	rm.mu.RLock()
+	defer rm.mu.RUnlock()
+	return rm.snapshotLocked()
+}
+
+// snapshotLocked builds a RouteSnapshot. Callers must hold rm.mu.
+func (rm *RouteMap) snapshotLocked() RouteSnapshot {
+	snapshot := RouteSnapshot{
+		Stages:      make([]RouteEntry, 0, len(rm.deployments)),
+		BaseDomain:  env.PROXY_BASE_DOMAIN,
+		Maintenance: rm.maintenance.Enabled,
+		ChangedBy:   rm.changedBy,
+	}
+	if !rm.changedAt.IsZero() {
+		changedAt := rm.changedAt
+		snapshot.ChangedAt = &changedAt
+	}
+
+	snapshot.Main, snapshot.Canary = rm.rootEntries()
+	if mirrorDep, exists := rm.findByID(rm.mirrorID); exists {
+		entry := rm.entry(mirrorDep, 0)
+		snapshot.Mirror = &entry
+	}
+
+	for _, dep := range rm.deployments {
+		weight := 0
+		switch {
+		case snapshot.Main != nil && dep.ID == snapshot.Main.DeploymentID:
+			weight = snapshot.Main.Weight
+		case snapshot.Canary != nil && dep.ID == snapshot.Canary.DeploymentID:
+			weight = snapshot.Canary.Weight
+		}
+		snapshot.Stages = append(snapshot.Stages, rm.entry(dep, weight))
+	}
+	sort.Slice(snapshot.Stages, func(i, j int) bool {
+		return snapshot.Stages[i].StageID < snapshot.Stages[j].StageID
+	})
+
+	return snapshot
+}
+
+// rootEntries describes the deployments sharing root traffic. An unhealthy canary
+// gets no share, matching choose. Callers must hold rm.mu.
+func (rm *RouteMap) rootEntries() (mainEntry, canaryEntry *RouteEntry) {
+	canaryWeight := 0
+	if canaryDep, exists := rm.findByID(rm.canaryID); exists {
+		if rm.isHealthy(canaryDep.ID) {
+			canaryWeight = canaryDep.CanaryWeight
+		}
+		entry := rm.entry(canaryDep, canaryWeight)
+		canaryEntry = &entry
+	}
+	if mainDep, exists := rm.findByID(rm.mainID); exists {
+		entry := rm.entry(mainDep, 100-canaryWeight)
+		mainEntry = &entry
+	}
+	return mainEntry, canaryEntry
+}
+
+// entry describes a single deployment for snapshots and resolutions.
+func (rm *RouteMap) entry(dep *models.Deployment, weight int) RouteEntry {
+	mirror := 0
+	if dep.ID == rm.mirrorID && dep.Mirror != nil {
+		mirror = dep.Mirror.Percent
+	}
+
+	return RouteEntry{
+		DeploymentID: dep.ID,
+		StageID:      dep.StageID,
+		Prefix:       "/" + dep.StageID,
+		Hostnames:    dep.Hostnames,
+		Port:         dep.Port,
+		Status:       dep.Status,
+		Health:       rm.GetHealth(dep.ID),
+		Weight:       weight,
+		Mirror:       mirror,
+		InFlight:     rm.InFlight(dep.ID),
+		Policy:       dep.Policy,
+		Proxy:        dep.Proxy,
+		Faults:       dep.ActiveFaults(time.Now()),
+		UpdatedAt:    dep.UpdatedAt,
+		UpdatedBy:    dep.UpdatedBy,
+	}
+}
+
+// Resolve determines which deployment would serve a request for host and path,
+// following the same precedence as the proxy middleware: hypervisor API, Host
+// header, /<stageId> prefix, then main (with the canary's weighted share).
+// The override header is not considered since it depends on the caller.
+func (rm *RouteMap) Resolve(host, path string) Resolution {
+	if path == "" {
+		path = "/"
+	}
+
+	if strings.HasPrefix(path, "/hypervisor") {
+		return Resolution{Kind: RouteKindAPI, Reason: "path is served by the hypervisor API"}
+	}
+
+	rm.mu.RLock()
+	defer rm.mu.RUnlock()
+
+	if dep, exists := rm.findByHost(host); exists && dep.Port != nil {
+		entry := rm.entry(dep, 0)
+		return Resolution{
+			Kind:         RouteKindHost,
+			Reason:       fmt.Sprintf("host %q is routed to deployment %s", normalizeHost(host), dep.ID),
+			UpstreamPath: path,
+			Target:       &entry,
+			target:       dep,
+		}
+	}
+
+	if stageID := strings.SplitN(strings.TrimPrefix(path, "/"), "/", 2)[0]; stageID != "" {
+		if dep, exists := rm.deployments[stageID]; exists && dep.Port != nil {
+			// Remove stage prefix from path
+			remainingPath := "/"
+			stagePrefix := "/" + stageID
+			if len(path) > len(stagePrefix) && path[len(stagePrefix)] == '/' {
+				remainingPath = path[len(stagePrefix):]
+			}
+
+			entry := rm.entry(dep, 0)
+			return Resolution{
+				Kind:         RouteKindStage,
+				Reason:       fmt.Sprintf("path prefix /%s matches ready stage deployment %s", stageID, dep.ID),
+				UpstreamPath: remainingPath,
+				Target:       &entry,
+				target:       dep,
+			}
+		}
+	}
+
+	resolution := Resolution{Kind: RouteKindMain, UpstreamPath: path}
+	resolution.Target, resolution.Canary = rm.rootEntries()
+	resolution.target, _ = rm.findByID(rm.mainID)
+	resolution.canary, _ = rm.findByID(rm.canaryID)
+
+	switch {
+	case resolution.target == nil && resolution.canary == nil:
+		return Resolution{Kind: RouteKindNone, Reason: "no host, stage prefix or main deployment matches the request"}
+	case resolution.canary != nil && resolution.target != nil:
+		resolution.Reason = fmt.Sprintf("root path is served by main deployment %s (%d%%) and canary %s (%d%%)",
+			resolution.target.ID, resolution.Target.Weight, resolution.canary.ID, resolution.Canary.Weight)
+	case resolution.canary != nil:
+		resolution.Reason = fmt.Sprintf("no main deployment; canary %s serves %d%% of root traffic and the rest is unrouted",
+			resolution.canary.ID, resolution.Canary.Weight)
+	default:
+		resolution.Reason = fmt.Sprintf("root path is served by main deployment %s", resolution.target.ID)
+	}
+	if rm.maintenance.Enabled {
+		resolution.Reason += "; maintenance mode is on, so only hyperusers reach it"
+	}
+
+	return resolution
+}
+
+// choose picks the deployment that serves this particular request, sending the
+// canary its weighted share of root traffic. An unhealthy canary is skipped so
+// its share falls back to main.
+func (r Resolution) choose(rm *RouteMap) *models.Deployment {
+	if r.canary != nil && rm.isHealthy(r.canary.ID) && rand.Intn(100) < r.canary.CanaryWeight {
+		return r.canary
+	}
+	return r.target
+}
+
+// formatSnapshot renders a snapshot as the human-readable routing map.
+func formatSnapshot(snapshot RouteSnapshot) string {
+	var b strings.Builder
+	b.WriteString("=== Routing Map ===\n")
+
+	if snapshot.Main != nil {
+		fmt.Fprintf(&b, "Main (/): %s (stage: %s, weight: %d%%)%s\n", snapshot.Main.DeploymentID, snapshot.Main.StageID, snapshot.Main.Weight, formatTarget(*snapshot.Main))
+	} else {
+		b.WriteString("Main (/): none\n")
+	}
+
+	if snapshot.Canary != nil {
+		fmt.Fprintf(&b, "Canary (/): %s (stage: %s, weight: %d%%)%s\n", snapshot.Canary.DeploymentID, snapshot.Canary.StageID, snapshot.Canary.Weight, formatTarget(*snapshot.Canary))
+	}
+
+	if snapshot.Maintenance {
+		b.WriteString("Maintenance (/): on\n")
+	}
+
+	if snapshot.Mirror != nil {
+		fmt.Fprintf(&b, "Mirror (/): %s (stage: %s, %d%% of main)%s\n", snapshot.Mirror.DeploymentID, snapshot.Mirror.StageID, snapshot.Mirror.Mirror, formatTarget(*snapshot.Mirror))
+	}
+
+	if len(snapshot.Stages) > 0 {
+		b.WriteString("Stages:\n")
+		for _, stage := range snapshot.Stages {
+			fmt.Fprintf(&b, "  %s/*%s\n", stage.Prefix, formatTarget(stage))
+			for _, hostname := range stage.Hostnames {
+				fmt.Fprintf(&b, "    host %s\n", hostname)
+			}
+		}
+	} else {
+		b.WriteString("Stages: none\n")
+	}
+
+	if snapshot.ChangedAt != nil {
+		fmt.Fprintf(&b, "Last change: %s by %s\n", snapshot.ChangedAt.Format(time.RFC3339), snapshot.ChangedBy)
+	}
+
+	b.WriteString("==================")
+	return b.String()
+}
+
+// formatTarget renders the upstream port and any abnormal health for a route line.
+func formatTarget(entry RouteEntry) string {
+	target := " -> no port assigned"
+	if entry.Port != nil {
+		target = fmt.Sprintf(" -> localhost:%d", *entry.Port)
+	}
+	if entry.Health.Status == models.HealthStatusUnhealthy {
+		target += " [unhealthy]"
+	}
+	if len(entry.Faults) > 0 {
+		target += fmt.Sprintf(" [%d fault rules]", len(entry.Faults))
+	}
+	return target
+}
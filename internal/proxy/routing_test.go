@@ -0,0 +1,112 @@
+package proxy
+
+import (
+	"testing"
+	"time"
+
+	"hypervisor/internal/models"
+)
+
+// canaryRouteMap routes main-r1 as main and, when weight is above 0, stage-c as its canary.
+func canaryRouteMap(weight int, canaryHealth models.HealthStatus) *RouteMap {
+	port := 20001
+	promotedAt := time.Now()
+
+	rm := NewRouteMap()
+	rm.applyUpdate(&models.Deployment{ID: "main-r1", StageID: "main", Revision: 1, Status: models.DeploymentStatusReady, Port: &port, PromotedAt: &promotedAt})
+	rm.applyUpdate(&models.Deployment{ID: "stage-c", StageID: "stage-c", Status: models.DeploymentStatusReady, Port: &port, CanaryWeight: weight})
+	rm.health["stage-c"] = &models.DeploymentHealth{Status: canaryHealth}
+	return rm
+}
+
+func TestResolveCanaryWeights(t *testing.T) {
+	tests := []struct {
+		name         string
+		weight       int
+		canaryHealth models.HealthStatus
+		mainWeight   int
+		canaryWeight int
+		canary       bool
+	}{
+		{"no canary", 0, models.HealthStatusHealthy, 100, 0, false},
+		{"healthy canary", 30, models.HealthStatusHealthy, 70, 30, true},
+		{"canary not probed yet", 30, models.HealthStatusUnknown, 70, 30, true},
+		{"canary taking all traffic", 100, models.HealthStatusHealthy, 0, 100, true},
+		{"unhealthy canary", 30, models.HealthStatusUnhealthy, 100, 0, true},
+	}
+
+	for _, tt := range tests {
+		resolution := canaryRouteMap(tt.weight, tt.canaryHealth).Resolve("", "/api/users")
+
+		if resolution.Kind != RouteKindMain {
+			t.Errorf("%s: expected kind main, got %s", tt.name, resolution.Kind)
+			continue
+		}
+		if resolution.UpstreamPath != "/api/users" {
+			t.Errorf("%s: expected the path kept, got %q", tt.name, resolution.UpstreamPath)
+		}
+		if resolution.Target == nil || resolution.Target.DeploymentID != "main-r1" || resolution.Target.Weight != tt.mainWeight {
+			t.Errorf("%s: expected main-r1 at %d%%, got %+v", tt.name, tt.mainWeight, resolution.Target)
+		}
+		if !tt.canary {
+			if resolution.Canary != nil {
+				t.Errorf("%s: expected no canary, got %+v", tt.name, resolution.Canary)
+			}
+			continue
+		}
+		if resolution.Canary == nil || resolution.Canary.DeploymentID != "stage-c" || resolution.Canary.Weight != tt.canaryWeight {
+			t.Errorf("%s: expected canary stage-c at %d%%, got %+v", tt.name, tt.canaryWeight, resolution.Canary)
+		}
+	}
+}
+
+func TestResolveWithoutMain(t *testing.T) {
+	port := 20001
+	rm := NewRouteMap()
+
+	if resolution := rm.Resolve("", "/"); resolution.Kind != RouteKindNone {
+		t.Errorf("Expected kind none for an empty route map, got %s", resolution.Kind)
+	}
+
+	rm.applyUpdate(&models.Deployment{ID: "stage-c", StageID: "stage-c", Status: models.DeploymentStatusReady, Port: &port, CanaryWeight: 40})
+	resolution := rm.Resolve("", "/")
+	if resolution.Kind != RouteKindMain || resolution.Target != nil || resolution.Canary == nil || resolution.Canary.Weight != 40 {
+		t.Errorf("Expected only the canary to serve its 40%% share, got %+v", resolution)
+	}
+}
+
+func TestChooseWeighting(t *testing.T) {
+	const requests = 10000
+
+	tests := []struct {
+		name         string
+		weight       int
+		canaryHealth models.HealthStatus
+		min, max     int // canary requests out of every 10000
+	}{
+		{"no canary", 0, models.HealthStatusHealthy, 0, 0},
+		{"ten percent", 10, models.HealthStatusHealthy, 800, 1200},
+		{"half", 50, models.HealthStatusHealthy, 4700, 5300},
+		{"all traffic", 100, models.HealthStatusHealthy, requests, requests},
+		{"unhealthy canary falls back to main", 50, models.HealthStatusUnhealthy, 0, 0},
+	}
+
+	for _, tt := range tests {
+		rm := canaryRouteMap(tt.weight, tt.canaryHealth)
+		resolution := rm.Resolve("", "/")
+
+		canary := 0
+		for i := 0; i < requests; i++ {
+			switch dep := resolution.choose(rm); dep.ID {
+			case "stage-c":
+				canary++
+			case "main-r1":
+			default:
+				t.Fatalf("%s: unexpected deployment %s", tt.name, dep.ID)
+			}
+		}
+		if canary < tt.min || canary > tt.max {
+			t.Errorf("%s: expected %d-%d canary requests, got %d", tt.name, tt.min, tt.max, canary)
+		}
+	}
+}
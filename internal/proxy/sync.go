@@ -0,0 +1,118 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"hypervisor/internal/db"
+	"hypervisor/internal/models"
+)
+
+type routeMessageKind string
+
+const (
+	routeMessageUpdate      routeMessageKind = "update"
+	routeMessageRemove      routeMessageKind = "remove"
+	routeMessageMaintenance routeMessageKind = "maintenance"
+)
+
+// routeMessage is a route map change broadcast between hypervisor instances (blue/green).
+type routeMessage struct {
+	Origin       string              `json:"origin"`
+	Kind         routeMessageKind    `json:"kind"`
+	Deployment   *models.Deployment  `json:"deployment,omitempty"`
+	DeploymentID string              `json:"deploymentId,omitempty"`
+	Actor        string              `json:"actor,omitempty"`
+	Maintenance  *models.Maintenance `json:"maintenance,omitempty"`
+}
+
+// instanceID identifies this process so it can ignore its own broadcasts.
+var instanceID = fmt.Sprintf("%s-%d-%d", hostname(), os.Getpid(), time.Now().UnixNano())
+
+func hostname() string {
+	name, err := os.Hostname()
+	if err != nil {
+		return "unknown"
+	}
+	return name
+}
+
+// routesChannel is the Redis pub/sub channel carrying route changes. Pub/sub channels
+// are not scoped to a logical Redis DB, so the channel is namespaced by database name.
+func routesChannel() string {
+	return fmt.Sprintf("hypervisor:%s:routes", db.Name)
+}
+
+// publish broadcasts a route change to the other hypervisor instances.
+func (rm *RouteMap) publish(msg routeMessage) {
+	if db.RDB == nil {
+		return
+	}
+
+	msg.Origin = instanceID
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("failed to encode route change: %v", err)
+		return
+	}
+
+	if err := db.RDB.Publish(context.Background(), routesChannel(), payload).Err(); err != nil {
+		log.Printf("failed to publish route change: %v", err)
+	}
+}
+
+// StartSubscriber applies route changes published by other hypervisor instances.
+func (rm *RouteMap) StartSubscriber(ctx context.Context) {
+	if db.RDB == nil {
+		return
+	}
+
+	sub := db.RDB.Subscribe(ctx, routesChannel())
+
+	go func() {
+		defer sub.Close()
+
+		messages := sub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case raw, ok := <-messages:
+				if !ok {
+					return
+				}
+				rm.handleMessage(raw.Payload)
+			}
+		}
+	}()
+}
+
+// handleMessage applies a single broadcast route change locally, without re-publishing it.
+func (rm *RouteMap) handleMessage(payload string) {
+	var msg routeMessage
+	if err := json.Unmarshal([]byte(payload), &msg); err != nil {
+		log.Printf("ignoring malformed route change: %v", err)
+		return
+	}
+
+	if msg.Origin == instanceID {
+		return
+	}
+
+	switch msg.Kind {
+	case routeMessageUpdate:
+		if msg.Deployment != nil {
+			rm.applyUpdate(msg.Deployment)
+		}
+	case routeMessageRemove:
+		rm.applyRemove(msg.DeploymentID, msg.Actor)
+	case routeMessageMaintenance:
+		if msg.Maintenance != nil {
+			rm.applyMaintenance(*msg.Maintenance)
+		}
+	}
+}
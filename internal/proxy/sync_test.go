@@ -0,0 +1,123 @@
+package proxy
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"hypervisor/internal/models"
+)
+
+// peerMessage encodes a route change as another hypervisor instance would publish it.
+func peerMessage(t *testing.T, msg routeMessage) string {
+	t.Helper()
+
+	if msg.Origin == "" {
+		msg.Origin = "peer-instance"
+	}
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		t.Fatalf("Failed to encode route change: %v", err)
+	}
+	return string(payload)
+}
+
+func TestHandleMessage(t *testing.T) {
+	port := 20001
+	promotedAt := time.Now()
+	routed := &models.Deployment{ID: "stage-a-r1", StageID: "stage-a", Revision: 1, Status: models.DeploymentStatusReady, Port: &port, PromotedAt: &promotedAt}
+	stopped := &models.Deployment{ID: "stage-a-r1", StageID: "stage-a", Revision: 1, Status: models.DeploymentStatusStopped, Port: &port}
+	fresh := &models.Deployment{ID: "stage-b-r1", StageID: "stage-b", Revision: 1, Status: models.DeploymentStatusReady, Port: &port, CanaryWeight: 20}
+
+	tests := []struct {
+		name        string
+		payload     func(t *testing.T) string
+		routes      []string
+		main        string
+		canary      string
+		maintenance bool
+	}{
+		{
+			name: "update adds a deployment",
+			payload: func(t *testing.T) string {
+				return peerMessage(t, routeMessage{Kind: routeMessageUpdate, Deployment: fresh})
+			},
+			routes: []string{"stage-a", "stage-b"},
+			main:   "stage-a-r1",
+			canary: "stage-b-r1",
+		},
+		{
+			name: "update takes a deployment out of routing",
+			payload: func(t *testing.T) string {
+				return peerMessage(t, routeMessage{Kind: routeMessageUpdate, Deployment: stopped})
+			},
+		},
+		{
+			name: "remove",
+			payload: func(t *testing.T) string {
+				return peerMessage(t, routeMessage{Kind: routeMessageRemove, DeploymentID: "stage-a-r1"})
+			},
+		},
+		{
+			name: "maintenance",
+			payload: func(t *testing.T) string {
+				return peerMessage(t, routeMessage{Kind: routeMessageMaintenance, Maintenance: &models.Maintenance{Enabled: true}})
+			},
+			routes:      []string{"stage-a"},
+			main:        "stage-a-r1",
+			maintenance: true,
+		},
+		{
+			name: "own broadcast is ignored",
+			payload: func(t *testing.T) string {
+				return peerMessage(t, routeMessage{Origin: instanceID, Kind: routeMessageRemove, DeploymentID: "stage-a-r1"})
+			},
+			routes: []string{"stage-a"},
+			main:   "stage-a-r1",
+		},
+		{
+			name:    "update without a deployment is ignored",
+			payload: func(t *testing.T) string { return peerMessage(t, routeMessage{Kind: routeMessageUpdate}) },
+			routes:  []string{"stage-a"},
+			main:    "stage-a-r1",
+		},
+		{
+			name: "unknown kind is ignored",
+			payload: func(t *testing.T) string {
+				return peerMessage(t, routeMessage{Kind: "rename", DeploymentID: "stage-a-r1"})
+			},
+			routes: []string{"stage-a"},
+			main:   "stage-a-r1",
+		},
+		{
+			name:    "malformed payload is ignored",
+			payload: func(t *testing.T) string { return `{"kind":` },
+			routes:  []string{"stage-a"},
+			main:    "stage-a-r1",
+		},
+	}
+
+	for _, tt := range tests {
+		rm := NewRouteMap()
+		rm.applyUpdate(routed)
+		rm.handleMessage(tt.payload(t))
+
+		if len(rm.deployments) != len(tt.routes) {
+			t.Errorf("%s: expected routes %v, got %d routes", tt.name, tt.routes, len(rm.deployments))
+		}
+		for _, stageID := range tt.routes {
+			if _, exists := rm.deployments[stageID]; !exists {
+				t.Errorf("%s: expected stage %s to be routed", tt.name, stageID)
+			}
+		}
+		if rm.mainID != tt.main {
+			t.Errorf("%s: expected main %q, got %q", tt.name, tt.main, rm.mainID)
+		}
+		if rm.canaryID != tt.canary {
+			t.Errorf("%s: expected canary %q, got %q", tt.name, tt.canary, rm.canaryID)
+		}
+		if rm.maintenance.Enabled != tt.maintenance {
+			t.Errorf("%s: expected maintenance %v, got %v", tt.name, tt.maintenance, rm.maintenance.Enabled)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"hypervisor/internal/errmsg"
+	"hypervisor/internal/models"
+	"hypervisor/internal/ws"
+
+	"github.com/fasthttp/websocket"
+	"github.com/gofiber/fiber/v3"
+)
+
+// websocketDialer connects to backend WebSocket endpoints.
+var websocketDialer = &websocket.Dialer{
+	HandshakeTimeout: 10 * time.Second,
+}
+
+// websocketSkipHeaders are negotiated by the dialer itself and must not be copied upstream.
+var websocketSkipHeaders = map[string]bool{
+	"Host":                     true,
+	"Upgrade":                  true,
+	"Connection":               true,
+	"Sec-Websocket-Key":        true,
+	"Sec-Websocket-Version":    true,
+	"Sec-Websocket-Extensions": true,
+	"Sec-Websocket-Protocol":   true,
+}
+
+// socketPair is one proxied WebSocket session between a client and a backend.
+type socketPair struct {
+	client  *websocket.Conn
+	backend *websocket.Conn
+	once    sync.Once
+}
+
+// close sends a close frame with the given code to both sides and tears the session down.
+func (p *socketPair) close(code int, reason string) {
+	p.once.Do(func() {
+		deadline := time.Now().Add(time.Second)
+		msg := websocket.FormatCloseMessage(code, reason)
+		_ = p.client.WriteControl(websocket.CloseMessage, msg, deadline)
+		_ = p.backend.WriteControl(websocket.CloseMessage, msg, deadline)
+		_ = p.client.Close()
+		_ = p.backend.Close()
+	})
+}
+
+// isWebSocketUpgrade reports whether the request asks to upgrade to a WebSocket.
+func isWebSocketUpgrade(c fiber.Ctx) bool {
+	return websocket.FastHTTPIsWebSocketUpgrade(c.RequestCtx())
+}
+
+// forwardWebSocket dials the backend, upgrades the client connection and pumps frames
+// in both directions. The session counts as in-flight until either side closes.
+func (rm *RouteMap) forwardWebSocket(c fiber.Ctx, dep *models.Deployment, upstreamPath string) error {
+	target := fmt.Sprintf("ws://localhost:%d%s", *dep.Port, upstreamPath)
+	if queryString := string(c.Request().URI().QueryString()); queryString != "" {
+		target += "?" + queryString
+	}
+
+	header := http.Header{}
+	c.Request().Header.VisitAll(func(key, value []byte) {
+		if name := http.CanonicalHeaderKey(string(key)); !websocketSkipHeaders[name] {
+			header.Add(name, string(value))
+		}
+	})
+	dialer := *websocketDialer
+	dialer.HandshakeTimeout = settingsFor(dep).connectTimeout
+	if protocols := strings.TrimSpace(c.Get(fiber.HeaderSecWebSocketProtocol)); protocols != "" {
+		for _, protocol := range strings.Split(protocols, ",") {
+			dialer.Subprotocols = append(dialer.Subprotocols, strings.TrimSpace(protocol))
+		}
+	}
+
+	backend, resp, err := dialer.Dial(target, header)
+	if err != nil {
+		if resp != nil {
+			// The backend answered but refused the upgrade - relay its response
+			body, _ := io.ReadAll(resp.Body)
+			return c.Status(resp.StatusCode).Send(body)
+		}
+
+		rm.recordHealth(dep, err)
+		return proxyError(c, errmsg.DeploymentBadGateway, dep, err.Error())
+	}
+
+	// Echo the subprotocol chosen by the backend back to the client
+	upgrader := ws.Upgrader
+	if protocol := backend.Subprotocol(); protocol != "" {
+		upgrader.Subprotocols = []string{protocol}
+	}
+
+	rm.beginRequest(dep.ID)
+	err = upgrader.Upgrade(c.RequestCtx(), func(client *websocket.Conn) {
+		defer rm.endRequest(dep.ID)
+
+		pair := &socketPair{client: client, backend: backend}
+		rm.trackSocket(dep.ID, pair)
+		defer rm.untrackSocket(dep.ID, pair)
+
+		done := make(chan struct{}, 2)
+		go pumpFrames(pair, client, backend, done)
+		go pumpFrames(pair, backend, client, done)
+		<-done
+	})
+	if err != nil {
+		// The upgrader has already written the error response (e.g. 503 in drain mode)
+		rm.endRequest(dep.ID)
+		_ = backend.Close()
+	}
+	return nil
+}
+
+// pumpFrames copies messages from src to dst until either side fails, then closes
+// the pair, relaying the close code received from src when there is one.
+func pumpFrames(pair *socketPair, src, dst *websocket.Conn, done chan<- struct{}) {
+	defer func() { done <- struct{}{} }()
+
+	for {
+		messageType, data, err := src.ReadMessage()
+		if err != nil {
+			var closeErr *websocket.CloseError
+			if errors.As(err, &closeErr) && closeErr.Code != websocket.CloseNoStatusReceived {
+				pair.close(closeErr.Code, closeErr.Text)
+			} else {
+				pair.close(websocket.CloseGoingAway, "")
+			}
+			return
+		}
+
+		if err := dst.WriteMessage(messageType, data); err != nil {
+			pair.close(websocket.CloseGoingAway, "")
+			return
+		}
+	}
+}
+
+func (rm *RouteMap) trackSocket(deploymentID string, pair *socketPair) {
+	rm.socketsMu.Lock()
+	defer rm.socketsMu.Unlock()
+
+	if rm.sockets[deploymentID] == nil {
+		rm.sockets[deploymentID] = make(map[*socketPair]struct{})
+	}
+	rm.sockets[deploymentID][pair] = struct{}{}
+}
+
+func (rm *RouteMap) untrackSocket(deploymentID string, pair *socketPair) {
+	rm.socketsMu.Lock()
+	defer rm.socketsMu.Unlock()
+
+	delete(rm.sockets[deploymentID], pair)
+	if len(rm.sockets[deploymentID]) == 0 {
+		delete(rm.sockets, deploymentID)
+	}
+}
+
+// closeSockets closes every proxied WebSocket to a deployment that has left the route
+// map, telling clients to reconnect so they land on whichever deployment now serves them.
+func (rm *RouteMap) closeSockets(deploymentID string) {
+	rm.socketsMu.Lock()
+	pairs := make([]*socketPair, 0, len(rm.sockets[deploymentID]))
+	for pair := range rm.sockets[deploymentID] {
+		pairs = append(pairs, pair)
+	}
+	rm.socketsMu.Unlock()
+
+	if len(pairs) == 0 {
+		return
+	}
+
+	log.Printf("closing %d websocket connection(s) to deployment %s", len(pairs), deploymentID)
+	for _, pair := range pairs {
+		pair.close(websocket.CloseGoingAway, "deployment removed from routing")
+	}
+}
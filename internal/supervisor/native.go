@@ -0,0 +1,446 @@
+package supervisor
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"hypervisor/internal/journal"
+	"hypervisor/internal/paths"
+)
+
+const (
+	// nativeMinBackoff and nativeMaxBackoff bound the pause before a process that
+	// exited is started again; the pause doubles with every quick exit.
+	nativeMinBackoff = time.Second
+	nativeMaxBackoff = 30 * time.Second
+
+	// nativeStableRun is how long a process must run for its exit not to count as
+	// a failed start.
+	nativeStableRun = 10 * time.Second
+
+	// nativeStartLimit failed starts in a row leave the process failed, like
+	// the unit's StartLimitBurst.
+	nativeStartLimit = 10
+
+	// nativeStopTimeout is how long a stopped process gets to exit before it is killed.
+	nativeStopTimeout = 10 * time.Second
+)
+
+// Native runs deployments as child processes of the hypervisor, for hosts without
+// systemd such as laptops, CI containers and integration tests. Definitions are kept
+// as JSON under paths.OpenHackSupervisorDir/units and each process's output is
+// appended to logs/<name>.log there. Resource limits and sandboxing are not applied,
+// and processes get the unit's minimal environment. Processes stop with the hypervisor;
+// enabled ones are started again by NewNative. Only one hypervisor on a host may use
+// it, which NewNative enforces with a lock on the directory.
+type Native struct {
+	dir       string
+	lock      *os.File // held for the life of the process
+	followers *journal.Hub
+
+	mu    sync.Mutex
+	procs map[string]*nativeProcess
+}
+
+// nativeProcess supervises one deployment's process until it is stopped.
+type nativeProcess struct {
+	cfg  Config
+	stop chan struct{} // closed by Stop
+	done chan struct{} // closed once supervision ends
+
+	mu       sync.Mutex
+	cmd      *exec.Cmd
+	active   string // ActiveState
+	sub      string // SubState
+	restarts int
+}
+
+// NewNative prepares the native supervisor's directories and starts the processes
+// of enabled definitions.
+func NewNative() (*Native, error) {
+	n := &Native{
+		dir:   paths.OpenHackSupervisorDir,
+		procs: make(map[string]*nativeProcess),
+	}
+	n.followers = journal.NewHub(n.followLog)
+
+	for _, dir := range []string{n.unitsDir(), n.logsDir()} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, err
+		}
+	}
+
+	// Every instance would start the enabled backends on the same ports
+	lock, err := lockNativeDir(filepath.Join(n.dir, "lock"))
+	if err != nil {
+		return nil, fmt.Errorf("native supervisor: %w", err)
+	}
+	n.lock = lock
+
+	names, err := n.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		cfg, err := n.load(name)
+		if err != nil {
+			log.Printf("native supervisor: cannot load %s: %v", name, err)
+			continue
+		}
+		if _, err := os.Stat(n.enabledPath(name)); err != nil {
+			continue
+		}
+		if err := n.Start(cfg.DeploymentID); err != nil {
+			log.Printf("native supervisor: cannot start %s: %v", name, err)
+		}
+	}
+	return n, nil
+}
+
+func (n *Native) unitsDir() string { return filepath.Join(n.dir, "units") }
+func (n *Native) logsDir() string  { return filepath.Join(n.dir, "logs") }
+
+func (n *Native) definitionPath(name string) string {
+	return filepath.Join(n.unitsDir(), name+".json")
+}
+
+func (n *Native) enabledPath(name string) string {
+	return filepath.Join(n.unitsDir(), name+".enabled")
+}
+
+func (n *Native) logPath(name string) string {
+	return filepath.Join(n.logsDir(), name+".log")
+}
+
+func (n *Native) Name() string {
+	return "native"
+}
+
+func (n *Native) ServiceName(deploymentID string) string {
+	return "openhack-backend-" + strings.ReplaceAll(deploymentID, "/", "-")
+}
+
+func (n *Native) Install(cfg Config, logWriter io.Writer) error {
+	name := n.ServiceName(cfg.DeploymentID)
+
+	fmt.Fprintf(logWriter, "[%s] Writing process definition %s...\n", time.Now().Format("2006-01-02 15:04:05"), n.definitionPath(name))
+	if err := n.Rewrite(cfg); err != nil {
+		return err
+	}
+	if err := n.Enable(cfg.DeploymentID); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(logWriter, "[%s] Restarting %s, output goes to %s\n", time.Now().Format("2006-01-02 15:04:05"), name, n.logPath(name))
+	if err := n.Stop(cfg.DeploymentID); err != nil {
+		return err
+	}
+	return n.Start(cfg.DeploymentID)
+}
+
+func (n *Native) Render(cfg Config) ([]byte, error) {
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+func (n *Native) Definition(deploymentID string) ([]byte, error) {
+	return os.ReadFile(n.definitionPath(n.ServiceName(deploymentID)))
+}
+
+func (n *Native) Rewrite(cfg Config) error {
+	data, err := n.Render(cfg)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(n.definitionPath(n.ServiceName(cfg.DeploymentID)), data, 0o644)
+}
+
+func (n *Native) List() ([]string, error) {
+	matches, err := filepath.Glob(filepath.Join(n.unitsDir(), "openhack-backend-*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(matches))
+	for _, match := range matches {
+		names = append(names, strings.TrimSuffix(filepath.Base(match), ".json"))
+	}
+	return names, nil
+}
+
+func (n *Native) Remove(deploymentID string) error {
+	name := n.ServiceName(deploymentID)
+
+	n.mu.Lock()
+	if proc, exists := n.procs[deploymentID]; exists && !proc.finished() {
+		n.mu.Unlock()
+		return fmt.Errorf("%s is still running", name)
+	}
+	delete(n.procs, deploymentID)
+	n.mu.Unlock()
+
+	for _, path := range []string{n.enabledPath(name), n.definitionPath(name)} {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (n *Native) load(name string) (Config, error) {
+	var cfg Config
+	data, err := os.ReadFile(n.definitionPath(name))
+	if err != nil {
+		return cfg, err
+	}
+	err = json.Unmarshal(data, &cfg)
+	return cfg, err
+}
+
+// Start starts supervising the deployment's process. Starting a running process
+// does nothing; starting one again resets its restart count.
+func (n *Native) Start(deploymentID string) error {
+	cfg, err := n.load(n.ServiceName(deploymentID))
+	if err != nil {
+		return fmt.Errorf("cannot load definition of %s: %w", deploymentID, err)
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if proc, exists := n.procs[deploymentID]; exists && !proc.finished() {
+		return nil
+	}
+
+	proc := &nativeProcess{
+		cfg:    cfg,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+		active: "activating",
+		sub:    "start",
+	}
+	n.procs[deploymentID] = proc
+	go n.supervise(proc)
+	return nil
+}
+
+// Stop terminates the process, killing it if it has not exited after nativeStopTimeout.
+func (n *Native) Stop(deploymentID string) error {
+	n.mu.Lock()
+	proc, exists := n.procs[deploymentID]
+	n.mu.Unlock()
+	if !exists || proc.finished() {
+		return nil
+	}
+
+	proc.mu.Lock()
+	select {
+	case <-proc.stop:
+	default:
+		close(proc.stop)
+	}
+	proc.signal(syscall.SIGTERM)
+	proc.mu.Unlock()
+
+	select {
+	case <-proc.done:
+		return nil
+	case <-time.After(nativeStopTimeout):
+	}
+
+	proc.mu.Lock()
+	proc.signal(syscall.SIGKILL)
+	proc.mu.Unlock()
+	<-proc.done
+	return nil
+}
+
+func (n *Native) Enable(deploymentID string) error {
+	return os.WriteFile(n.enabledPath(n.ServiceName(deploymentID)), nil, 0o644)
+}
+
+func (n *Native) State(deploymentID string) (State, error) {
+	name := n.ServiceName(deploymentID)
+	state := State{
+		LoadState:     "not-found",
+		ActiveState:   "inactive",
+		SubState:      "dead",
+		UnitFileState: "disabled",
+	}
+	if _, err := os.Stat(n.definitionPath(name)); err == nil {
+		state.LoadState = "loaded"
+	}
+	if _, err := os.Stat(n.enabledPath(name)); err == nil {
+		state.UnitFileState = "enabled"
+	}
+
+	n.mu.Lock()
+	proc, exists := n.procs[deploymentID]
+	n.mu.Unlock()
+	if exists {
+		proc.mu.Lock()
+		state.ActiveState = proc.active
+		state.SubState = proc.sub
+		state.NRestarts = proc.restarts
+		proc.mu.Unlock()
+	}
+	return state, nil
+}
+
+// supervise runs the process, restarting it with backoff whenever it exits, until
+// it is stopped or failed to start nativeStartLimit times in a row.
+func (n *Native) supervise(proc *nativeProcess) {
+	defer close(proc.done)
+
+	output, err := newNativeOutput(n.logPath(n.ServiceName(proc.cfg.DeploymentID)))
+	if err != nil {
+		log.Printf("native supervisor: cannot open output of %s: %v", proc.cfg.DeploymentID, err)
+		proc.setState("failed", "failed")
+		return
+	}
+	defer output.Close()
+
+	backoff := nativeMinBackoff
+	failures := 0
+	for {
+		started := time.Now()
+		err := proc.run(output)
+		if proc.stopped() {
+			proc.setState("inactive", "dead")
+			return
+		}
+
+		if time.Since(started) >= nativeStableRun {
+			backoff = nativeMinBackoff
+			failures = 0
+		}
+		failures++
+		if failures >= nativeStartLimit {
+			output.Notice("process failed %d times in a row, giving up: %v", failures, err)
+			proc.setState("failed", "failed")
+			return
+		}
+
+		output.Notice("process exited (%v), restarting in %s", err, backoff)
+		proc.mu.Lock()
+		proc.active, proc.sub = "activating", "auto-restart"
+		proc.restarts++
+		proc.mu.Unlock()
+
+		select {
+		case <-proc.stop:
+			proc.setState("inactive", "dead")
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, nativeMaxBackoff)
+	}
+}
+
+// run starts the backend with the same arguments as the systemd unit and waits for it.
+func (p *nativeProcess) run(output *nativeOutput) error {
+	cmd := exec.Command(p.cfg.BinaryPath,
+		"--deployment", p.cfg.EnvTag,
+		"--port", strconv.Itoa(p.cfg.Port),
+		"--env-root", p.cfg.EnvRoot,
+		"--app-version", p.cfg.Version,
+	)
+	if info, err := os.Stat(paths.OpenHackBaseDir); err == nil && info.IsDir() {
+		cmd.Dir = paths.OpenHackBaseDir
+	}
+	cmd.Env = nativeEnviron()
+	cmd.Stdout = output.Stream("stdout")
+	cmd.Stderr = output.Stream("stderr")
+	setParentDeathSignal(cmd)
+
+	p.mu.Lock()
+	if p.stopped() {
+		p.mu.Unlock()
+		return nil
+	}
+	if err := cmd.Start(); err != nil {
+		p.mu.Unlock()
+		return err
+	}
+	p.cmd = cmd
+	p.active, p.sub = "active", "running"
+	output.SetPID(cmd.Process.Pid)
+	p.mu.Unlock()
+
+	err := cmd.Wait()
+
+	p.mu.Lock()
+	p.cmd = nil
+	p.mu.Unlock()
+	return err
+}
+
+// nativeEnviron returns the environment the systemd unit gives the backend. The
+// hypervisor's own environment holds database URIs and secrets, so it is not passed on.
+func nativeEnviron() []string {
+	environ := []string{
+		"PATH=" + os.Getenv("PATH") + ":/usr/local/bin:/usr/bin:/bin",
+		"GODEBUG=madvdontneed=1",
+	}
+	// systemd sets these for the unit's user; here the backend runs as the hypervisor's
+	for _, key := range []string{"HOME", "USER", "LOGNAME"} {
+		if value, ok := os.LookupEnv(key); ok {
+			environ = append(environ, key+"="+value)
+		}
+	}
+	return environ
+}
+
+// signal sends sig to the running process, if any. Callers must hold p.mu.
+func (p *nativeProcess) signal(sig os.Signal) {
+	if p.cmd != nil && p.cmd.Process != nil {
+		_ = p.cmd.Process.Signal(sig)
+	}
+}
+
+func (p *nativeProcess) setState(active, sub string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.active, p.sub = active, sub
+}
+
+func (p *nativeProcess) stopped() bool {
+	select {
+	case <-p.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+func (p *nativeProcess) finished() bool {
+	select {
+	case <-p.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (n *Native) Logs(ctx context.Context, deploymentID string, q journal.Query) ([]journal.Entry, error) {
+	return readNativeOutput(n.logPath(n.ServiceName(deploymentID)), q)
+}
+
+func (n *Native) Follow(deploymentID string) *journal.Subscription {
+	return n.followers.Subscribe(n.ServiceName(deploymentID))
+}
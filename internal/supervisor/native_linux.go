@@ -0,0 +1,12 @@
+package supervisor
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setParentDeathSignal makes the kernel terminate the process when the hypervisor
+// exits, so a restarted hypervisor does not find its ports taken.
+func setParentDeathSignal(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Pdeathsig: syscall.SIGTERM}
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package supervisor
+
+import "os"
+
+// lockNativeDir cannot lock without flock; a single instance is up to the operator.
+func lockNativeDir(path string) (*os.File, error) {
+	return nil, nil
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package supervisor
+
+import "os/exec"
+
+// setParentDeathSignal is only supported on Linux; elsewhere processes outlive a
+// hypervisor that exits without stopping them.
+func setParentDeathSignal(cmd *exec.Cmd) {}
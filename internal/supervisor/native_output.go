@@ -0,0 +1,247 @@
+package supervisor
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"hypervisor/internal/journal"
+)
+
+const (
+	// nativeMaxLine bounds a single line of process output; longer lines are split.
+	nativeMaxLine = 1 << 20
+
+	// nativeFollowInterval is how often followed output files are checked for new lines.
+	nativeFollowInterval = 200 * time.Millisecond
+)
+
+// Output lines are written as "<RFC3339Nano time> <stream> <pid> <message>", with
+// the supervisor's own notices on the "supervisor" stream.
+var nativeStreamPriority = map[string]int{
+	"stdout":     6, // info
+	"stderr":     3, // err
+	"supervisor": 5, // notice
+}
+
+// nativeOutput appends the lines a process writes to its output file.
+type nativeOutput struct {
+	mu   sync.Mutex
+	file *os.File
+	pid  atomic.Int64
+}
+
+func newNativeOutput(path string) (*nativeOutput, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &nativeOutput{file: file}, nil
+}
+
+// SetPID sets the PID recorded with the lines that follow.
+func (o *nativeOutput) SetPID(pid int) {
+	o.pid.Store(int64(pid))
+}
+
+// Stream returns a writer splitting what a process writes to stream into lines.
+func (o *nativeOutput) Stream(stream string) io.Writer {
+	return &nativeStream{output: o, name: stream}
+}
+
+// Notice records a line from the supervisor itself.
+func (o *nativeOutput) Notice(format string, args ...any) {
+	o.writeLine("supervisor", "-", fmt.Sprintf(format, args...))
+}
+
+func (o *nativeOutput) Close() error {
+	return o.file.Close()
+}
+
+func (o *nativeOutput) writeLine(stream, pid, message string) {
+	line := time.Now().Format(time.RFC3339Nano) + " " + stream + " " + pid + " " + strings.TrimRight(message, "\r") + "\n"
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	// A full disk must not stall the process writing to the pipe
+	_, _ = o.file.WriteString(line)
+}
+
+type nativeStream struct {
+	output  *nativeOutput
+	name    string
+	partial []byte
+}
+
+// Write never fails, so the process is not cut off from its output pipe.
+func (s *nativeStream) Write(p []byte) (int, error) {
+	s.partial = append(s.partial, p...)
+	pid := strconv.FormatInt(s.output.pid.Load(), 10)
+	for {
+		i := bytes.IndexByte(s.partial, '\n')
+		if i < 0 {
+			break
+		}
+		s.output.writeLine(s.name, pid, string(s.partial[:i]))
+		s.partial = s.partial[i+1:]
+	}
+	if len(s.partial) >= nativeMaxLine {
+		s.output.writeLine(s.name, pid, string(s.partial))
+		s.partial = nil
+	}
+	return len(p), nil
+}
+
+// parseNativeLine decodes one output line. The cursor is the line's offset in the file.
+func parseNativeLine(line string, offset int64) (journal.Entry, bool) {
+	fields := strings.SplitN(line, " ", 4)
+	if len(fields) < 3 {
+		return journal.Entry{}, false
+	}
+	at, err := time.Parse(time.RFC3339Nano, fields[0])
+	if err != nil {
+		return journal.Entry{}, false
+	}
+
+	entry := journal.Entry{
+		Time:     at,
+		Priority: 6,
+		Cursor:   strconv.FormatInt(offset, 10),
+	}
+	if priority, ok := nativeStreamPriority[fields[1]]; ok {
+		entry.Priority = priority
+	}
+	if fields[2] != "-" {
+		entry.PID = fields[2]
+	}
+	if len(fields) == 4 {
+		entry.Message = fields[3]
+	}
+	return entry, true
+}
+
+// readNativeOutput returns the last q.Lines lines of an output file written since
+// q.Since, oldest first.
+func readNativeOutput(path string, q journal.Query) ([]journal.Entry, error) {
+	entries := []journal.Entry{}
+
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), nativeMaxLine+256)
+	var offset int64
+	for scanner.Scan() {
+		line := scanner.Text()
+		entry, ok := parseNativeLine(line, offset)
+		offset += int64(len(line)) + 1
+		if !ok || entry.Time.Before(q.Since) {
+			continue
+		}
+
+		entries = append(entries, entry)
+		// Keep at most twice the lines asked for while reading
+		if q.Lines > 0 && len(entries) >= 2*q.Lines {
+			entries = append(entries[:0], entries[len(entries)-q.Lines:]...)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if q.Lines > 0 && len(entries) > q.Lines {
+		entries = entries[len(entries)-q.Lines:]
+	}
+	return entries, nil
+}
+
+// followLog is the journal.Source for the native supervisor: it polls the output
+// file of unit and emits the lines appended after it started. A file that shrank
+// was recreated and is read again from the start.
+func (n *Native) followLog(ctx context.Context, unit string, emit func(journal.Entry)) error {
+	path := n.logPath(unit)
+
+	offset := int64(-1)
+	ticker := time.NewTicker(nativeFollowInterval)
+	defer ticker.Stop()
+	for {
+		var err error
+		offset, err = tailNativeOutput(path, offset, emit)
+		if err != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// tailNativeOutput emits the complete lines of path from offset on and returns the
+// offset after them. A negative offset skips to the end of the file.
+func tailNativeOutput(path string, offset int64, emit func(journal.Entry)) (int64, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return offset, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return offset, err
+	}
+	size := info.Size()
+	if offset < 0 {
+		return size, nil
+	}
+	if size < offset {
+		offset = 0
+	}
+	if size == offset {
+		return offset, nil
+	}
+
+	chunk := make([]byte, min(size-offset, 4*nativeMaxLine))
+	read, err := file.ReadAt(chunk, offset)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return offset, err
+	}
+	chunk = chunk[:read]
+
+	end := bytes.LastIndexByte(chunk, '\n')
+	if end < 0 {
+		if len(chunk) > nativeMaxLine {
+			// Not a line this file format produces; skip it
+			return offset + int64(len(chunk)), nil
+		}
+		return offset, nil
+	}
+
+	for _, line := range bytes.Split(chunk[:end], []byte{'\n'}) {
+		if entry, ok := parseNativeLine(string(line), offset); ok {
+			emit(entry)
+		}
+		offset += int64(len(line)) + 1
+	}
+	return offset, nil
+}
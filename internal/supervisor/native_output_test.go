@@ -0,0 +1,180 @@
+package supervisor
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"hypervisor/internal/journal"
+)
+
+func nativeLine(at time.Time, stream, pid, message string) string {
+	return at.Format(time.RFC3339Nano) + " " + stream + " " + pid + " " + message + "\n"
+}
+
+func TestParseNativeLine(t *testing.T) {
+	at := time.Date(2026, 1, 2, 3, 4, 5, 600, time.UTC)
+
+	entry, ok := parseNativeLine(strings.TrimSuffix(nativeLine(at, "stderr", "4242", "failed to connect: refused"), "\n"), 128)
+	if !ok {
+		t.Fatalf("Expected the line to parse")
+	}
+	if !entry.Time.Equal(at) {
+		t.Errorf("Expected time %v, got %v", at, entry.Time)
+	}
+	if entry.Priority != 3 {
+		t.Errorf("Expected stderr at priority 3, got %d", entry.Priority)
+	}
+	if entry.PID != "4242" {
+		t.Errorf("Expected PID 4242, got %q", entry.PID)
+	}
+	if entry.Message != "failed to connect: refused" {
+		t.Errorf("Expected the message with its spaces, got %q", entry.Message)
+	}
+	if entry.Cursor != "128" {
+		t.Errorf("Expected cursor 128, got %q", entry.Cursor)
+	}
+}
+
+func TestParseNativeLineStreams(t *testing.T) {
+	at := time.Now()
+
+	entry, ok := parseNativeLine(at.Format(time.RFC3339Nano)+" supervisor - started", 0)
+	if !ok || entry.Priority != 5 || entry.PID != "" || entry.Message != "started" {
+		t.Errorf("Expected a supervisor notice without PID, got %+v (ok=%v)", entry, ok)
+	}
+
+	entry, ok = parseNativeLine(at.Format(time.RFC3339Nano)+" other 1", 0)
+	if !ok || entry.Priority != 6 || entry.Message != "" {
+		t.Errorf("Expected an unknown stream at priority 6 with an empty message, got %+v (ok=%v)", entry, ok)
+	}
+
+	for _, line := range []string{"", "garbage", "yesterday stdout 1 hello"} {
+		if _, ok := parseNativeLine(line, 0); ok {
+			t.Errorf("Expected %q not to parse", line)
+		}
+	}
+}
+
+func TestReadNativeOutput(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "unit.log")
+	start := time.Now().Add(-time.Hour).UTC()
+
+	var content strings.Builder
+	for i := 0; i < 10; i++ {
+		content.WriteString(nativeLine(start.Add(time.Duration(i)*time.Minute), "stdout", "1", "line "+strconv.Itoa(i)))
+		if i == 4 {
+			content.WriteString("not an output line\n")
+		}
+	}
+	if err := os.WriteFile(path, []byte(content.String()), 0o644); err != nil {
+		t.Fatalf("Failed to write output: %v", err)
+	}
+
+	entries, err := readNativeOutput(path, journal.Query{Lines: 3})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got := messages(entries); got != "line 7,line 8,line 9" {
+		t.Errorf("Expected the last 3 lines, got %s", got)
+	}
+
+	entries, err = readNativeOutput(path, journal.Query{Since: start.Add(8 * time.Minute), Lines: 5})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got := messages(entries); got != "line 8,line 9" {
+		t.Errorf("Expected the lines since minute 8, got %s", got)
+	}
+
+	entries, err = readNativeOutput(filepath.Join(t.TempDir(), "missing.log"), journal.Query{Lines: 3})
+	if err != nil || entries == nil || len(entries) != 0 {
+		t.Errorf("Expected no entries for a missing file, got %v (err=%v)", entries, err)
+	}
+}
+
+func TestTailNativeOutput(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "unit.log")
+	at := time.Now().UTC()
+
+	var emitted []journal.Entry
+	emit := func(entry journal.Entry) { emitted = append(emitted, entry) }
+
+	// A missing file is read from its start once it appears
+	offset, err := tailNativeOutput(path, -1, emit)
+	if err != nil || offset != 0 {
+		t.Fatalf("Expected offset 0 for a missing file, got %d (err=%v)", offset, err)
+	}
+
+	first := nativeLine(at, "stdout", "1", "first")
+	if err := os.WriteFile(path, []byte(first), 0o644); err != nil {
+		t.Fatalf("Failed to write output: %v", err)
+	}
+
+	// A negative offset skips what is already there
+	offset, err = tailNativeOutput(path, -1, emit)
+	if err != nil || offset != int64(len(first)) || len(emitted) != 0 {
+		t.Fatalf("Expected to skip to %d without emitting, got %d and %d entries (err=%v)", len(first), offset, len(emitted), err)
+	}
+
+	// Only complete lines are emitted
+	second := nativeLine(at, "stderr", "1", "second")
+	appendFile(t, path, second+at.Format(time.RFC3339Nano)+" stdout 1 part")
+	offset, err = tailNativeOutput(path, offset, emit)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got := messages(emitted); got != "second" {
+		t.Errorf("Expected only the complete line, got %s", got)
+	}
+	if want := int64(len(first) + len(second)); offset != want {
+		t.Errorf("Expected offset %d, got %d", want, offset)
+	}
+	if emitted[0].Cursor != strconv.Itoa(len(first)) {
+		t.Errorf("Expected the cursor at the line's offset, got %q", emitted[0].Cursor)
+	}
+
+	appendFile(t, path, "ial\n")
+	offset, err = tailNativeOutput(path, offset, emit)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got := messages(emitted); got != "second,partial" {
+		t.Errorf("Expected the completed line, got %s", got)
+	}
+
+	// A file that shrank was recreated and is read from the start
+	emitted = nil
+	if err := os.WriteFile(path, []byte(nativeLine(at, "stdout", "2", "restarted")), 0o644); err != nil {
+		t.Fatalf("Failed to write output: %v", err)
+	}
+	if _, err := tailNativeOutput(path, offset, emit); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got := messages(emitted); got != "restarted" {
+		t.Errorf("Expected the recreated file's line, got %s", got)
+	}
+}
+
+func appendFile(t *testing.T, path, content string) {
+	t.Helper()
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		t.Fatalf("Failed to open output: %v", err)
+	}
+	defer file.Close()
+	if _, err := file.WriteString(content); err != nil {
+		t.Fatalf("Failed to append output: %v", err)
+	}
+}
+
+func messages(entries []journal.Entry) string {
+	var out []string
+	for _, entry := range entries {
+		out = append(out, entry.Message)
+	}
+	return strings.Join(out, ",")
+}
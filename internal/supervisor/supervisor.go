@@ -0,0 +1,71 @@
+package supervisor
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"hypervisor/internal/env"
+	"hypervisor/internal/journal"
+	"hypervisor/internal/systemd"
+)
+
+// Config describes the backend process of one deployment.
+type Config = systemd.BackendServiceConfig
+
+// State is what a supervisor reports about a deployment's process, in systemd's
+// vocabulary whichever supervisor runs it.
+type State = systemd.UnitState
+
+// Supervisor installs, runs and watches the backend processes of deployments.
+type Supervisor interface {
+	// Name is the SUPERVISOR value selecting the implementation.
+	Name() string
+	// ServiceName is how the supervisor names a deployment's process.
+	ServiceName(deploymentID string) string
+
+	// Install writes the process definition, enables it and (re)starts the process.
+	Install(cfg Config, logWriter io.Writer) error
+	// Render returns the definition Install would write for cfg.
+	Render(cfg Config) ([]byte, error)
+	// Definition returns the installed definition, or os.ErrNotExist.
+	Definition(deploymentID string) ([]byte, error)
+	// Rewrite replaces the definition without restarting the process; it takes
+	// effect on the next start.
+	Rewrite(cfg Config) error
+	// List returns the service names of every installed definition.
+	List() ([]string, error)
+	// Remove disables the process and deletes its definition. The process must be stopped.
+	Remove(deploymentID string) error
+
+	Start(deploymentID string) error
+	Stop(deploymentID string) error
+	// Enable makes the process start again when the host or hypervisor restarts.
+	Enable(deploymentID string) error
+	State(deploymentID string) (State, error)
+
+	// Logs returns the most recent output of the process matching q, oldest first.
+	Logs(ctx context.Context, deploymentID string, q journal.Query) ([]journal.Entry, error)
+	// Follow subscribes to the output the process writes from now on.
+	Follow(deploymentID string) *journal.Subscription
+}
+
+// Default runs every deployment; it is set by Init.
+var Default Supervisor
+
+// Init selects the supervisor named by SUPERVISOR.
+func Init() error {
+	switch env.SUPERVISOR {
+	case "", "systemd":
+		Default = Systemd{}
+	case "native":
+		native, err := NewNative()
+		if err != nil {
+			return err
+		}
+		Default = native
+	default:
+		return fmt.Errorf("unknown supervisor %q", env.SUPERVISOR)
+	}
+	return nil
+}
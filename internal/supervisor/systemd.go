@@ -0,0 +1,73 @@
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"hypervisor/internal/journal"
+	"hypervisor/internal/systemd"
+)
+
+// Systemd runs each deployment as an openhack-backend-<id>.service unit through
+// sudo systemctl, with its output in the journal.
+type Systemd struct{}
+
+func (Systemd) Name() string {
+	return "systemd"
+}
+
+func (Systemd) ServiceName(deploymentID string) string {
+	return systemd.ServiceName(deploymentID)
+}
+
+func (Systemd) Install(cfg Config, logWriter io.Writer) error {
+	return systemd.InstallBackendService(cfg, logWriter)
+}
+
+func (Systemd) Render(cfg Config) ([]byte, error) {
+	return systemd.RenderBackendUnit(cfg)
+}
+
+func (Systemd) Definition(deploymentID string) ([]byte, error) {
+	return systemd.ReadBackendUnit(deploymentID)
+}
+
+func (Systemd) Rewrite(cfg Config) error {
+	return systemd.RepairBackendUnit(cfg)
+}
+
+func (Systemd) List() ([]string, error) {
+	return systemd.ListBackendUnits()
+}
+
+// Remove disables the unit and deletes its file, trying both even if one fails.
+func (Systemd) Remove(deploymentID string) error {
+	disableErr := systemd.DisableBackendService(deploymentID)
+	removeErr := systemd.RemoveBackendServiceFile(deploymentID)
+	return errors.Join(disableErr, removeErr)
+}
+
+func (Systemd) Start(deploymentID string) error {
+	return systemd.StartBackendService(deploymentID)
+}
+
+func (Systemd) Stop(deploymentID string) error {
+	return systemd.StopBackendService(deploymentID)
+}
+
+func (Systemd) Enable(deploymentID string) error {
+	return systemd.EnableBackendService(deploymentID)
+}
+
+func (Systemd) State(deploymentID string) (State, error) {
+	return systemd.BackendServiceUnitState(deploymentID)
+}
+
+func (Systemd) Logs(ctx context.Context, deploymentID string, q journal.Query) ([]journal.Entry, error) {
+	return journal.Read(ctx, systemd.ServiceName(deploymentID), q)
+}
+
+func (Systemd) Follow(deploymentID string) *journal.Subscription {
+	return journal.Followers.Subscribe(systemd.ServiceName(deploymentID))
+}
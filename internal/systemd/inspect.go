@@ -0,0 +1,93 @@
+package systemd
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"hypervisor/internal/paths"
+)
+
+// backendUnitPattern matches the unit files of every backend deployment.
+const backendUnitPattern = "openhack-backend-*.service"
+
+// UnitState is the part of `systemctl show` the hypervisor cares about.
+type UnitState struct {
+	LoadState     string // "loaded", or "not-found" when there is no unit file
+	ActiveState   string // "active", "activating", "inactive", "failed", ...
+	SubState      string // "running", "auto-restart", "dead", ...
+	UnitFileState string // "enabled", "disabled", ...
+	NRestarts     int    // automatic restarts since the unit was last started
+}
+
+// Loaded reports whether systemd knows the unit.
+func (s UnitState) Loaded() bool {
+	return s.LoadState == "loaded"
+}
+
+// BackendServiceUnitState reads the state of a deployment's unit with systemctl show.
+func BackendServiceUnitState(deploymentID string) (UnitState, error) {
+	cmd := exec.Command("systemctl", "show", serviceName(deploymentID),
+		"--property=LoadState,ActiveState,SubState,UnitFileState,NRestarts")
+	output, err := cmd.Output()
+	if err != nil {
+		return UnitState{}, fmt.Errorf("systemctl show failed: %w", err)
+	}
+
+	var state UnitState
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), "=")
+		if !ok {
+			continue
+		}
+
+		switch key {
+		case "LoadState":
+			state.LoadState = value
+		case "ActiveState":
+			state.ActiveState = value
+		case "SubState":
+			state.SubState = value
+		case "UnitFileState":
+			state.UnitFileState = value
+		case "NRestarts":
+			state.NRestarts, _ = strconv.Atoi(value)
+		}
+	}
+	return state, nil
+}
+
+// ReadBackendUnit returns the installed unit file of a deployment, or os.ErrNotExist.
+func ReadBackendUnit(deploymentID string) ([]byte, error) {
+	return os.ReadFile(filepath.Join(paths.SystemdUnitDir, serviceName(deploymentID)))
+}
+
+// RepairBackendUnit rewrites a deployment's unit file and reloads systemd without
+// restarting the service, so the fixed unit takes effect on its next restart.
+func RepairBackendUnit(cfg BackendServiceConfig) error {
+	if err := writeBackendUnit(cfg, io.Discard); err != nil {
+		return err
+	}
+	return runSystemctlWithLog(io.Discard, "daemon-reload")
+}
+
+// ListBackendUnits returns the names of every installed backend unit file.
+func ListBackendUnits() ([]string, error) {
+	matches, err := filepath.Glob(filepath.Join(paths.SystemdUnitDir, backendUnitPattern))
+	if err != nil {
+		return nil, err
+	}
+
+	units := make([]string, 0, len(matches))
+	for _, match := range matches {
+		units = append(units, filepath.Base(match))
+	}
+	return units, nil
+}
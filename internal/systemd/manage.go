@@ -23,6 +23,16 @@ type BackendServiceConfig struct {
 	Port         int
 	EnvRoot      string
 	Version      string
+
+	// Resource limits and sandboxing, in systemd's syntax ("yes"/"no" for booleans).
+	// Empty values are left out of the unit.
+	MemoryMax       string
+	CPUQuota        string
+	TasksMax        string
+	ProtectSystem   string
+	PrivateTmp      string
+	NoNewPrivileges string
+	ReadWritePaths  []string
 }
 
 // InstallBackendService writes the backend unit file and reloads systemd.
@@ -64,24 +74,10 @@ func InstallBackendService(cfg BackendServiceConfig, logWriter io.Writer) error
 func writeBackendUnit(cfg BackendServiceConfig, logWriter io.Writer) error {
 	logger := &systemdLogger{writer: logWriter}
 
-	logger.Log("Reading embedded unit template...")
-	data, err := BackendService.ReadFile(BackendServiceName)
-	if err != nil {
-		return fmt.Errorf("failed to read embedded unit: %w", err)
-	}
-	logger.Log("Embedded unit template read successfully")
-
-	logger.Log("Parsing unit template...")
-	unitTemplate, err := template.New(BackendServiceName).Parse(string(data))
-	if err != nil {
-		return fmt.Errorf("failed to parse unit template: %w", err)
-	}
-	logger.Log("Unit template parsed successfully")
-
 	logger.Log("Rendering unit template with config...")
-	var rendered bytes.Buffer
-	if err := unitTemplate.Execute(&rendered, cfg); err != nil {
-		return fmt.Errorf("failed to render unit template: %w", err)
+	rendered, err := RenderBackendUnit(cfg)
+	if err != nil {
+		return err
 	}
 	logger.Log("Unit template rendered successfully")
 
@@ -95,7 +91,7 @@ func writeBackendUnit(cfg BackendServiceConfig, logWriter io.Writer) error {
 	logger.Log("Existing unit file removed (or not present)")
 
 	logger.Log("Writing new unit file to %s...", target)
-	if err := fs.WriteFileWithSudo(target, rendered.Bytes(), 0o644); err != nil {
+	if err := fs.WriteFileWithSudo(target, rendered, 0o644); err != nil {
 		return fmt.Errorf("failed to install unit file: %w", err)
 	}
 	logger.Log("Unit file written successfully")
@@ -103,6 +99,25 @@ func writeBackendUnit(cfg BackendServiceConfig, logWriter io.Writer) error {
 	return nil
 }
 
+// RenderBackendUnit renders the backend unit file for cfg.
+func RenderBackendUnit(cfg BackendServiceConfig) ([]byte, error) {
+	data, err := BackendService.ReadFile(BackendServiceName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read embedded unit: %w", err)
+	}
+
+	unitTemplate, err := template.New(BackendServiceName).Parse(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse unit template: %w", err)
+	}
+
+	var rendered bytes.Buffer
+	if err := unitTemplate.Execute(&rendered, cfg); err != nil {
+		return nil, fmt.Errorf("failed to render unit template: %w", err)
+	}
+	return rendered.Bytes(), nil
+}
+
 // ServiceName returns the systemd service name for a deployment.
 func ServiceName(deploymentID string) string {
 	safeID := strings.ReplaceAll(deploymentID, "/", "-")
@@ -138,6 +153,14 @@ func StartBackendService(deploymentID string) error {
 	return cmd.Run()
 }
 
+// EnableBackendService enables the backend service so it starts on boot.
+func EnableBackendService(deploymentID string) error {
+	cmd := exec.Command("sudo", "systemctl", "enable", serviceName(deploymentID))
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
 // DisableBackendService disables the backend service.
 func DisableBackendService(deploymentID string) error {
 	cmd := exec.Command("sudo", "systemctl", "disable", serviceName(deploymentID))
@@ -154,6 +177,30 @@ func RemoveBackendServiceFile(deploymentID string) error {
 	return cmd.Run()
 }
 
+// BackendServiceState returns the unit's state as reported by systemctl is-active,
+// such as "active", "activating" or "failed".
+func BackendServiceState(deploymentID string) (string, error) {
+	output, err := exec.Command("systemctl", "is-active", serviceName(deploymentID)).Output()
+	state := strings.TrimSpace(string(output))
+
+	// is-active exits non-zero for every state but active, still printing the state
+	var exitErr *exec.ExitError
+	if err != nil && (!errors.As(err, &exitErr) || state == "") {
+		return "", fmt.Errorf("systemctl is-active failed: %w", err)
+	}
+	return state, nil
+}
+
+// JournalTail returns the last lines the backend service wrote to the journal.
+func JournalTail(deploymentID string, lines int) ([]byte, error) {
+	cmd := exec.Command("sudo", "journalctl", "--unit", serviceName(deploymentID), "--lines", fmt.Sprintf("%d", lines), "--no-pager", "--output", "short-iso")
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("journalctl failed: %w", err)
+	}
+	return output, nil
+}
+
 // CheckBackendServiceStatus verifies that the backend service is active.
 func CheckBackendServiceStatus(deploymentID string) error {
 	cmd := exec.Command("systemctl", "is-active", serviceName(deploymentID))
@@ -5,6 +5,8 @@ import (
 	"errors"
 	"sync"
 
+	"hypervisor/internal/journal"
+
 	"github.com/fasthttp/websocket"
 	"github.com/gofiber/fiber/v3"
 	"github.com/valyala/fasthttp"
@@ -25,6 +27,14 @@ func (w *WebsocketLogWriter) Write(p []byte) (n int, err error) {
 	return len(p), nil
 }
 
+// WriteEntry sends a journal entry, with its time and priority, over the WebSocket.
+func (w *WebsocketLogWriter) WriteEntry(entry journal.Entry) error {
+	if err := WriteEntry(w.conn, entry); err != nil {
+		return errClientClosed
+	}
+	return nil
+}
+
 func (w *WebsocketLogWriter) WriteStatus(level, message string) {
 	_ = WriteStatus(w.conn, level, message)
 }
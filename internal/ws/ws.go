@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 
 	"hypervisor/internal/env"
+	"hypervisor/internal/journal"
 
 	githubws "github.com/fasthttp/websocket"
 	"github.com/valyala/fasthttp"
@@ -45,3 +46,15 @@ func WriteLog(conn *githubws.Conn, message []byte) error {
 	}
 	return conn.WriteMessage(githubws.TextMessage, payload)
 }
+
+// WriteEntry sends a journal entry to the websocket client.
+func WriteEntry(conn *githubws.Conn, entry journal.Entry) error {
+	payload, err := json.Marshal(struct {
+		Type string `json:"type"`
+		journal.Entry
+	}{Type: "log", Entry: entry})
+	if err != nil {
+		return err
+	}
+	return conn.WriteMessage(githubws.TextMessage, payload)
+}
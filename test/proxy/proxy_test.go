@@ -1,12 +1,16 @@
 package proxy
 
 import (
+	"encoding/json"
 	"flag"
+	"io"
 	"net/http"
 	"os"
+	"strings"
 	"testing"
 
 	"hypervisor/internal"
+	"hypervisor/test/helpers"
 
 	"github.com/gofiber/fiber/v3"
 )
@@ -62,3 +66,150 @@ func TestProxyForwardsQueryParameters(t *testing.T) {
 		t.Errorf("Expected status 404 for missing deployment, got %d", resp.StatusCode)
 	}
 }
+
+func TestProxyOverrideRequiresHyperUser(t *testing.T) {
+	// Test that the deployment override header is rejected without a hyperuser token
+	req, err := http.NewRequest("GET", "/", nil)
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	req.Header.Set("X-Hypervisor-Deployment", "v0.0.0.0-test")
+
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 for unauthenticated override, got %d", resp.StatusCode)
+	}
+}
+
+// hyperUserToken logs in as the test hyperuser.
+func hyperUserToken(t *testing.T) string {
+	body, statusCode := helpers.API_HyperUsersLogin(t, app, "testhyperuser", "testhyperuser")
+	if statusCode != http.StatusOK {
+		t.Fatalf("Expected status 200 for login, got %d", statusCode)
+	}
+
+	var payload struct {
+		Token string `json:"token"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		t.Fatalf("Failed to decode login response: %v", err)
+	}
+	return payload.Token
+}
+
+func TestRoutingMapRequiresHyperUser(t *testing.T) {
+	// Test that the routing map, which exposes access policies and fault rules, and the metrics are not public
+	for _, path := range []string{"/hypervisor/routing", "/hypervisor/routing/resolve", "/hypervisor/metrics"} {
+		req, err := http.NewRequest("GET", path, nil)
+		if err != nil {
+			t.Fatalf("Failed to create request: %v", err)
+		}
+
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("Expected status 401 for unauthenticated %s, got %d", path, resp.StatusCode)
+		}
+	}
+}
+
+func TestRoutingMapJSON(t *testing.T) {
+	// Test that the routing map is returned as JSON when requested
+	req, err := http.NewRequest("GET", "/hypervisor/routing", nil)
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Authorization", "Bearer "+hyperUserToken(t))
+
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200 for routing map, got %d", resp.StatusCode)
+	}
+
+	var snapshot struct {
+		Stages []map[string]any `json:"stages"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&snapshot); err != nil {
+		t.Fatalf("Failed to decode routing map: %v", err)
+	}
+	if snapshot.Stages == nil {
+		t.Errorf("Expected stages array in routing map")
+	}
+}
+
+func TestRoutingResolveAPIPath(t *testing.T) {
+	// Test that resolving a hypervisor path reports it as served by the API
+	req, err := http.NewRequest("GET", "/hypervisor/routing/resolve?path=/hypervisor/meta/ping", nil)
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+hyperUserToken(t))
+
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var resolution struct {
+		Kind string `json:"kind"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&resolution); err != nil {
+		t.Fatalf("Failed to decode resolution: %v", err)
+	}
+	if resolution.Kind != "api" {
+		t.Errorf("Expected kind api, got %q", resolution.Kind)
+	}
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	// Test that proxied requests show up in the Prometheus metrics
+	req, err := http.NewRequest("GET", "/", nil)
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	req, err = http.NewRequest("GET", "/hypervisor/metrics", nil)
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+hyperUserToken(t))
+
+	resp, err = app.Test(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200 for metrics, got %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Failed to read metrics: %v", err)
+	}
+	if !strings.Contains(string(body), `hypervisor_proxy_requests_total{deployment="",stage="",route="none",code="4xx"}`) {
+		t.Errorf("Expected unrouted request to be counted in metrics")
+	}
+}
@@ -1,6 +1,7 @@
 package staging
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
 	"testing"
@@ -9,6 +10,7 @@ import (
 	"hypervisor/test/helpers"
 
 	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 func TestCreateAndListStage(t *testing.T) {
@@ -83,3 +85,29 @@ func TestCreateAndListStage(t *testing.T) {
 	require.NoError(t, err)
 
 }
+
+func TestOneProvisioningRevisionPerStage(t *testing.T) {
+	// Test that the unique index rejects a second provisioning revision of a stage,
+	// which concurrent deploys on different instances would otherwise both create
+	ctx := context.Background()
+	stageID := "index-test-stage"
+
+	first := models.Deployment{ID: models.RevisionID(stageID, 1), StageID: stageID, Revision: 1, Status: models.DeploymentStatusProvisioning}
+	second := models.Deployment{ID: models.RevisionID(stageID, 2), StageID: stageID, Revision: 2, Status: models.DeploymentStatusProvisioning}
+	other := models.Deployment{ID: models.RevisionID("index-test-other", 1), StageID: "index-test-other", Revision: 1, Status: models.DeploymentStatusProvisioning}
+	for _, dep := range []models.Deployment{first, second, other} {
+		models.DeleteDeployment(ctx, dep.ID)
+		defer models.DeleteDeployment(ctx, dep.ID)
+	}
+
+	require.NoError(t, models.CreateDeployment(ctx, first))
+	require.NoError(t, models.CreateDeployment(ctx, other))
+
+	err := models.CreateDeployment(ctx, second)
+	require.True(t, mongo.IsDuplicateKeyError(err), "expected a duplicate key error, got %v", err)
+
+	// Once the first revision is no longer provisioning, the next one can be
+	first.Status = models.DeploymentStatusReady
+	require.NoError(t, models.UpdateDeployment(ctx, first))
+	require.NoError(t, models.CreateDeployment(ctx, second))
+}